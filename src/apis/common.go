@@ -29,6 +29,20 @@ type WdogFunctionRunResult struct {
 	Stderr		string		`json:"stderr"`
 	Time		uint		`json:"time"` /* usec */
 	Then		json.RawMessage	`json:"then,omitempty"`
+	CType		string		`json:"ctype,omitempty"`
+	Headers		map[string]string `json:"headers,omitempty"`
+	Raw		[]byte		`json:"raw,omitempty"`
+	Cookies		[]*FunctionCookie `json:"cookies,omitempty"`
+}
+
+type FunctionCookie struct {
+	Name		string		`json:"name"`
+	Value		string		`json:"value"`
+	Path		string		`json:"path,omitempty"`
+	Domain		string		`json:"domain,omitempty"`
+	MaxAge		int		`json:"max_age,omitempty"` /* sec */
+	Secure		bool		`json:"secure,omitempty"`
+	HttpOnly	bool		`json:"http_only,omitempty"`
 }
 
 type UserLogin struct {
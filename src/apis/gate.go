@@ -37,12 +37,37 @@ type FunctionStats struct {
 	Time		uint64			`json:"time"`
 	GBS		float64			`json:"gbs"`
 	BytesOut	uint64			`json:"bytesout"`
+	ColdStarts	uint64			`json:"coldstarts,omitempty"`
+	ColdTime	uint64			`json:"coldtime,omitempty"` /* usec */
 	Till		string			`json:"till,omitempty"`
 	From		string			`json:"from,omitempty"`
 }
 
 type FunctionStatsResp struct {
 	Stats		[]FunctionStats		`json:"stats"`
+	Scaling		*FunctionScalingStatus	`json:"scaling,omitempty"`
+	Concurrency	*FunctionConcStatus	`json:"concurrency,omitempty"`
+}
+
+type FunctionConcStatus struct {
+	Max		uint			`json:"max,omitempty"`
+	InFlight	uint			`json:"inflight"`
+	Queued		uint			`json:"queued"`
+	Throttled	uint64			`json:"throttled"`
+}
+
+type FunctionScalingStatus struct {
+	InFlight	uint32			`json:"inflight"`
+	Goal		uint32			`json:"goal"`
+	Replicas	uint32			`json:"replicas"`
+	Decisions	[]*FunctionScaleDecision `json:"decisions,omitempty"`
+}
+
+type FunctionScaleDecision struct {
+	Ts		string			`json:"ts"`
+	From		uint32			`json:"from"`
+	To		uint32			`json:"to"`
+	Why		string			`json:"why"`
 }
 
 type TenantStatsFn struct {
@@ -84,6 +109,8 @@ type FunctionInfo struct {
 	Size		*FunctionSize		`json:"size,omitempty"`
 	AuthCtx		string			`json:"authctx,omitempty"`
 	UserData	string			`json:"userdata,omitempty"`
+	Retry		*FunctionRetry		`json:"retry,omitempty"`
+	Aliases		map[string]string	`json:"aliases,omitempty"`
 	Id		string			`json:"id"`
 }
 
@@ -92,6 +119,7 @@ type FunctionMdat struct {
 	PodToken	string			`json:"pod_token"`
 	RL		[]uint			`json:"rl"`
 	BR		[]uint			`json:"br"`
+	Conc		*FunctionConcStatus	`json:"conc,omitempty"`
 	IPs		[]string		`json:"ips,omitempty"`
 	Hosts		[]string		`json:"hosts,omitempty"`
 	Dep		string			`json:"depname,omitempty"`
@@ -119,6 +147,119 @@ type FunctionSize struct {
 	Timeout		uint			`json:"timeout"` /* msec */
 	Rate		uint			`json:"rate,omitempty"`
 	Burst		uint			`json:"burst,omitempty"`
+	Idle		uint			`json:"idle,omitempty"` /* sec, scale to zero after */
+	Warm		uint			`json:"warm,omitempty"` /* pods kept running */
+	Warmup		bool			`json:"warmup,omitempty"`
+	Scale		*FunctionScaling	`json:"scale,omitempty"`
+	MaxConc		uint			`json:"max_concurrency,omitempty"` /* per gate replica */
+}
+
+/*
+ * Autoscaler keeps Target calls in flight per pod having from Min
+ * to Max replicas. It scales up when the need holds for UpWindow
+ * and down after the need is gone for DownWindow.
+ */
+type FunctionScaling struct {
+	Target		uint			`json:"target,omitempty"`
+	Min		uint			`json:"min,omitempty"`
+	Max		uint			`json:"max,omitempty"`
+	UpWindow	uint			`json:"up_window,omitempty"` /* msec */
+	DownWindow	uint			`json:"down_window,omitempty"` /* msec */
+}
+
+/*
+ * Policy for background (cron, s3, then, etc.) calls. Calls that
+ * fail on the gate or wdog side are always retried, those that
+ * return one of the Codes too. After Attempts tries the call goes
+ * to the function's dead-letter queue.
+ */
+type FunctionRetry struct {
+	Attempts	uint			`json:"attempts"`
+	Backoff		uint			`json:"backoff,omitempty"` /* msec, doubles each attempt */
+	MaxBackoff	uint			`json:"max_backoff,omitempty"` /* msec */
+	Codes		[]int			`json:"codes,omitempty"`
+}
+
+type FunctionDLQEntry struct {
+	Id		string			`json:"id"`
+	Event		string			`json:"event"`
+	Alias		string			`json:"alias,omitempty"`
+	Ts		string			`json:"ts"`
+	Attempts	uint			`json:"attempts"`
+	Reason		string			`json:"reason"`
+	Args		*FunctionRun		`json:"args,omitempty"`
+}
+
+/*
+ * One-shot call to happen either At the given time (RFC1123Z
+ * or RFC3339) or after the Delay (msec) from now
+ */
+type FunctionDelayed struct {
+	Id		string			`json:"id,omitempty"`
+	At		string			`json:"at,omitempty"`
+	Delay		uint			`json:"delay,omitempty"`
+	Alias		string			`json:"alias,omitempty"`
+	Args		map[string]string	`json:"args,omitempty"`
+	Status		string			`json:"status,omitempty"`
+}
+
+type FunctionInvocation struct {
+	Id		string			`json:"id"`
+	Function	string			`json:"function"`
+	Status		string			`json:"status"`
+	Created		string			`json:"created"`
+	Finished	string			`json:"finished,omitempty"`
+	Duration	uint			`json:"duration,omitempty"` /* usec */
+	Code		int			`json:"code,omitempty"`
+	Return		string			`json:"return,omitempty"`
+	Stdout		string			`json:"stdout,omitempty"`
+	Stderr		string			`json:"stderr,omitempty"`
+	Error		string			`json:"error,omitempty"`
+}
+
+/*
+ * Canary is a new version of fn running alongside the current
+ * one and getting Weight percent of calls. Calls can be bound
+ * to one of the versions by "header:$name" or "claim:$name".
+ */
+type FunctionCanary struct {
+	Version		string			`json:"version,omitempty"`
+	Sources		*FunctionSources	`json:"sources,omitempty"`
+	Weight		uint			`json:"weight"`
+	Sticky		string			`json:"sticky,omitempty"`
+	Auto		*FunctionCanaryAuto	`json:"auto,omitempty"`
+	Stats		*FunctionCanaryStats	`json:"stats,omitempty"`
+}
+
+/*
+ * After Calls calls to canary, roll it back if more than MaxErrors
+ * percent of them failed, or promote it if asked to.
+ */
+type FunctionCanaryAuto struct {
+	Calls		uint			`json:"calls"`
+	MaxErrors	uint			`json:"max_errors"`
+	Promote		bool			`json:"promote,omitempty"`
+}
+
+type FunctionCanaryStats struct {
+	Called		uint64			`json:"called"`
+	Errors		uint64			`json:"errors"`
+}
+
+/*
+ * Published versions are immutable, their sources and build
+ * results are kept till explicitly unpublished. Aliases point
+ * at them and can be referenced as fn@alias.
+ */
+type FunctionVersion struct {
+	Version		string			`json:"version,omitempty"`
+	Published	string			`json:"published,omitempty"`
+	Aliases		[]string		`json:"aliases,omitempty"`
+}
+
+type FunctionAlias struct {
+	Name		string			`json:"name"`
+	Version		string			`json:"version"`
 }
 
 type FunctionWait struct {
@@ -126,9 +267,22 @@ type FunctionWait struct {
 	Version		string			`json:"version,omitempty"`
 }
 
+/*
+ * Overlap is one of "allow" (default), "skip" or "queue". Jitter
+ * is the max random delay in msec, Catchup is how many runs missed
+ * while swifty was down to do on restart. The Last, Next and
+ * Result are read-only.
+ */
 type FunctionEventCron struct {
 	Tab		string			`json:"tab"`
 	Args		map[string]string	`json:"args"`
+	TZ		string			`json:"tz,omitempty"`
+	Overlap		string			`json:"overlap,omitempty"`
+	Jitter		uint			`json:"jitter,omitempty"`
+	Catchup		uint			`json:"catchup,omitempty"`
+	Last		string			`json:"last,omitempty"`
+	Next		string			`json:"next,omitempty"`
+	Result		string			`json:"result,omitempty"`
 }
 
 type FunctionEventS3 struct {
@@ -142,6 +296,14 @@ type FunctionEventWebsock struct {
 	MType		*int			`json:"mtype,omitempty"`
 }
 
+type FunctionEventMQ struct {
+	MwName		string			`json:"mware"`
+	Queue		string			`json:"queue"`
+	Prefetch	uint			`json:"prefetch,omitempty"`
+	Concurrency	uint			`json:"concurrency,omitempty"`
+	DLQ		string			`json:"dlq,omitempty"`
+}
+
 type FunctionEvent struct {
 	Id		string			`json:"id,omitempty"`
 	Name		string			`json:"name"`
@@ -150,6 +312,8 @@ type FunctionEvent struct {
 	S3		*FunctionEventS3	`json:"s3,omitempty"`
 	URL		string			`json:"url,omitempty"`
 	WS		*FunctionEventWebsock	`json:"websocket,omitempty" yaml:"websocket,omitempty"`
+	MQ		*FunctionEventMQ	`json:"mq,omitempty"`
+	Alias		string			`json:"alias,omitempty"`
 }
 
 type MwareAdd struct {
@@ -194,6 +358,19 @@ type S3Creds struct {
 	AccID		string			`json:"accid"`
 }
 
+/* Method is GET (default), PUT or HEAD */
+type S3Presign struct {
+	Bucket		string			`json:"bucket"`
+	Object		string			`json:"object"`
+	Method		string			`json:"method,omitempty"`
+	Expires		uint32			`json:"expires"` /* seconds */
+}
+
+type S3PresignedURL struct {
+	URL		string			`json:"url"`
+	Expires		string			`json:"expires"`
+}
+
 type FunctionAdd struct {
 	Name		string			`json:"name"`
 	Project		string			`json:"project,omitempty"`
@@ -205,6 +382,7 @@ type FunctionAdd struct {
 	Accounts	[]string		`json:"accounts,omitempty"`
 	UserData	string			`json:"userdata,omitempty"`
 	AuthCtx		string			`json:"authctx,omitempty"`
+	Retry		*FunctionRetry		`json:"retry,omitempty"`
 
 	Events		[]FunctionEvent		`json:"-" yaml:"events"` /* Deploy only */
 }
@@ -383,4 +561,21 @@ type FunctionRun struct {
 	Path		*string			`json:"path,omitempty"`
 	Key		string			`json:"key,omitempty"`
 	Src		*FunctionSources	`json:"src,omitempty"`
+	Stream		bool			`json:"stream,omitempty"`
+	Raw		[]byte			`json:"raw,omitempty"` // binary body, base64-ed
+	Form		map[string]string	`json:"form,omitempty"`
+	Files		[]*FunctionFile		`json:"files,omitempty"`
+	Headers		map[string]string	`json:"headers,omitempty"`
+	Query		map[string][]string	`json:"query,omitempty"`
+	Cookies		map[string]string	`json:"cookies,omitempty"`
+	Remote		string			`json:"remote,omitempty"`
+	ReqId		string			`json:"reqid,omitempty"`
+}
+
+/* File part of multipart/form-data request */
+type FunctionFile struct {
+	Field		string			`json:"field"`
+	Name		string			`json:"name"`
+	ContentType	string			`json:"content,omitempty"`
+	Data		[]byte			`json:"data"`
 }
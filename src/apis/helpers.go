@@ -91,6 +91,14 @@ func (cln *Client)Routers() *Collection {
 	return &Collection{cln, "routers"}
 }
 
+func (cln *Client)Workflows() *Collection {
+	return &Collection{cln, "workflows"}
+}
+
+func (cln *Client)WorkflowRuns(wid string) *Collection {
+	return cln.Workflows().sub(wid, "runs")
+}
+
 func (cln *Client)Accounts() *Collection {
 	return &Collection{cln, "accounts"}
 }
@@ -18,25 +18,26 @@ const (
 //
 // AnalyticsConfiguration
 // BucketLoggingStatus
+// CORSConfiguration				+
 // CompleteMultipartUploadResult		+
 // CopyObjectResult
-// Delete
-// DeleteResult
+// Delete					+
+// DeleteResult				+
 // Error					+
 // InitiateMultipartUploadResult		+
 // InventoryConfiguration
-// LifecycleConfiguration
+// LifecycleConfiguration			+
 // ListAllMyBucketsResult			+
 // ListBucketResult				+
 // ListInventoryConfigurationsResult
 // ListMultipartUploadsResult
 // ListPartsResult				+
-// ListVersionsResult
+// ListVersionsResult				+
 // LocationConstraint
 // MetricsConfiguration
 // ReplicationConfiguration
 // RequestPaymentConfiguration
-// Tagging
+// Tagging					+
 // WebsiteConfiguration
 
 const (
@@ -71,6 +72,19 @@ const (
 	S3PermFull				= "FULL_CONTROL"
 )
 
+const (
+	S3GroupAllUsers				= "http://acs.amazonaws.com/groups/global/AllUsers"
+	S3GroupAuthenticatedUsers		= "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+	S3GranteeCanonicalUser			= "CanonicalUser"
+	S3GranteeGroup				= "Group"
+)
+
+const (
+	S3VersioningEnabled			= "Enabled"
+	S3VersioningSuspended			= "Suspended"
+	S3VersionNull				= "null"
+)
+
 type S3Error struct {
 	XMLName			xml.Name			`xml:"Error"`
 	Code			string				`xml:"Code,omitempy"`
@@ -87,7 +101,7 @@ type S3Owner struct {
 type S3Object struct {
 	Key			string				`xml:"Key,omitempy"`
 	Size			int64				`xml:"Size,omitempy"`
-	Owner			S3Owner				`xml:"Owner,omitempy"`
+	Owner			*S3Owner			`xml:"Owner,omitempty"`
 	LastModified		string				`xml:"LastModified,omitempy"`
 	ETag			string				`xml:"ETag,omitempy"`
 	StorageClass		string				`xml:"StorageClass,omitempy"`
@@ -256,3 +270,166 @@ type S3MpuFini struct {
 	Key			string				`xml:"Key"`
 	ETag			string				`xml:"ETag"`
 }
+
+type S3VersioningConfig struct {
+	XMLName			xml.Name			`xml:"VersioningConfiguration"`
+	Status			string				`xml:"Status,omitempty"`
+	MfaDelete		string				`xml:"MfaDelete,omitempty"`
+}
+
+type S3ObjectVersion struct {
+	Key			string				`xml:"Key"`
+	VersionId		string				`xml:"VersionId"`
+	IsLatest		bool				`xml:"IsLatest"`
+	LastModified		string				`xml:"LastModified,omitempty"`
+	ETag			string				`xml:"ETag,omitempty"`
+	Size			int64				`xml:"Size"`
+	StorageClass		string				`xml:"StorageClass,omitempty"`
+	Owner			S3Owner				`xml:"Owner,omitempty"`
+}
+
+type S3DeleteMarker struct {
+	Key			string				`xml:"Key"`
+	VersionId		string				`xml:"VersionId"`
+	IsLatest		bool				`xml:"IsLatest"`
+	LastModified		string				`xml:"LastModified,omitempty"`
+	Owner			S3Owner				`xml:"Owner,omitempty"`
+}
+
+type S3VersionList struct {
+	XMLName			xml.Name			`xml:"ListVersionsResult"`
+	Name			string				`xml:"Name"`
+	Prefix			string				`xml:"Prefix"`
+	KeyMarker		string				`xml:"KeyMarker"`
+	VersionIdMarker		string				`xml:"VersionIdMarker"`
+	NextKeyMarker		string				`xml:"NextKeyMarker,omitempty"`
+	NextVersionIdMarker	string				`xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys			int64				`xml:"MaxKeys"`
+	Delimiter		string				`xml:"Delimiter,omitempty"`
+	IsTruncated		bool				`xml:"IsTruncated"`
+	Version			[]S3ObjectVersion		`xml:"Version,omitempty"`
+	DeleteMarker		[]S3DeleteMarker		`xml:"DeleteMarker,omitempty"`
+	CommonPrefixes		[]S3Prefix			`xml:"CommonPrefixes,omitempty"`
+}
+
+type S3Tag struct {
+	Key			string				`xml:"Key"`
+	Value			string				`xml:"Value"`
+}
+
+type S3TagSet struct {
+	Tag			[]S3Tag				`xml:"Tag"`
+}
+
+type S3Tagging struct {
+	XMLName			xml.Name			`xml:"Tagging"`
+	TagSet			S3TagSet			`xml:"TagSet"`
+}
+
+type S3LifecycleAnd struct {
+	Prefix			string				`xml:"Prefix,omitempty"`
+	Tag			[]S3Tag				`xml:"Tag,omitempty"`
+}
+
+type S3LifecycleFilter struct {
+	Prefix			string				`xml:"Prefix,omitempty"`
+	Tag			*S3Tag				`xml:"Tag,omitempty"`
+	And			*S3LifecycleAnd			`xml:"And,omitempty"`
+}
+
+type S3LifecycleExpiration struct {
+	Days			int				`xml:"Days,omitempty"`
+	Date			string				`xml:"Date,omitempty"`
+	ExpiredObjectDeleteMarker	bool			`xml:"ExpiredObjectDeleteMarker,omitempty"`
+}
+
+type S3NoncurrentExpiration struct {
+	NoncurrentDays		int				`xml:"NoncurrentDays"`
+}
+
+type S3AbortIncompleteMpu struct {
+	DaysAfterInitiation	int				`xml:"DaysAfterInitiation"`
+}
+
+type S3LifecycleRule struct {
+	ID			string				`xml:"ID,omitempty"`
+	Filter			*S3LifecycleFilter		`xml:"Filter,omitempty"`
+	Prefix			*string				`xml:"Prefix,omitempty"`
+	Status			string				`xml:"Status"`
+	Expiration		*S3LifecycleExpiration		`xml:"Expiration,omitempty"`
+	NoncurrentExpiration	*S3NoncurrentExpiration		`xml:"NoncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMpu	*S3AbortIncompleteMpu		`xml:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+type S3LifecycleConfig struct {
+	XMLName			xml.Name			`xml:"LifecycleConfiguration"`
+	Rule			[]S3LifecycleRule		`xml:"Rule"`
+}
+
+type S3CorsRule struct {
+	ID			string				`xml:"ID,omitempty"`
+	AllowedOrigin		[]string			`xml:"AllowedOrigin"`
+	AllowedMethod		[]string			`xml:"AllowedMethod"`
+	AllowedHeader		[]string			`xml:"AllowedHeader,omitempty"`
+	ExposeHeader		[]string			`xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds		int				`xml:"MaxAgeSeconds,omitempty"`
+}
+
+type S3CorsConfig struct {
+	XMLName			xml.Name			`xml:"CORSConfiguration"`
+	CORSRule		[]S3CorsRule			`xml:"CORSRule"`
+}
+
+type S3Grantee struct {
+	Type			string				`xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
+	ID			string				`xml:"ID,omitempty"`
+	DisplayName		string				`xml:"DisplayName,omitempty"`
+	URI			string				`xml:"URI,omitempty"`
+	EmailAddress		string				`xml:"EmailAddress,omitempty"`
+}
+
+type S3Grant struct {
+	Grantee			S3Grantee			`xml:"Grantee"`
+	Permission		string				`xml:"Permission"`
+}
+
+type S3AccessControlList struct {
+	Grant			[]S3Grant			`xml:"Grant"`
+}
+
+type S3AccessControlPolicy struct {
+	XMLName			xml.Name			`xml:"AccessControlPolicy"`
+	Owner			S3Owner				`xml:"Owner"`
+	AccessControlList	S3AccessControlList		`xml:"AccessControlList"`
+}
+
+type S3DeleteObject struct {
+	Key			string				`xml:"Key"`
+	VersionId		string				`xml:"VersionId,omitempty"`
+}
+
+type S3Delete struct {
+	XMLName			xml.Name			`xml:"Delete"`
+	Quiet			bool				`xml:"Quiet,omitempty"`
+	Object			[]S3DeleteObject		`xml:"Object"`
+}
+
+type S3Deleted struct {
+	Key			string				`xml:"Key"`
+	VersionId		string				`xml:"VersionId,omitempty"`
+	DeleteMarker		bool				`xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionId	string				`xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type S3DeleteError struct {
+	Key			string				`xml:"Key"`
+	VersionId		string				`xml:"VersionId,omitempty"`
+	Code			string				`xml:"Code"`
+	Message			string				`xml:"Message,omitempty"`
+}
+
+type S3DeleteResult struct {
+	XMLName			xml.Name			`xml:"DeleteResult"`
+	Deleted			[]S3Deleted			`xml:"Deleted,omitempty"`
+	Error			[]S3DeleteError			`xml:"Error,omitempty"`
+}
@@ -17,6 +17,20 @@ type KeyGenResult struct {
 	AccID			string		`json:"accid"`
 }
 
+/* Endpoint is the public S3 address the URL should point to */
+type Presign struct {
+	Namespace		string		`json:"namespace"`
+	Bucket			string		`json:"bucket"`
+	Object			string		`json:"object"`
+	Method			string		`json:"method"`
+	Expires			uint32		`json:"expires"`
+	Endpoint		string		`json:"endpoint"`
+}
+
+type PresignResult struct {
+	URL			string		`json:"url"`
+}
+
 type KeyDel struct {
 	AccessKeyID		string		`json:"access-key-id"`
 }
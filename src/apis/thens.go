@@ -7,6 +7,7 @@ package swyapi
 
 type Then struct {
 	Call		*ThenCall		`json:"call,omitempty"`
+	Delayed		[]*ThenDelayed		`json:"delayed,omitempty"`
 }
 
 /*
@@ -22,3 +23,15 @@ type ThenCall struct {
 	Args		map[string]string	`json:"args"`
 	Sync		bool			`json:"sync"`
 }
+
+/*
+ * These make gate schedule one-shot calls of the given fns (same
+ * project) with the given args. At is absolute time (RFC1123Z or
+ * RFC3339), Delay is the time from now in msec.
+ */
+type ThenDelayed struct {
+	Name		string			`json:"name"`
+	Args		map[string]string	`json:"args,omitempty"`
+	At		string			`json:"at,omitempty"`
+	Delay		uint			`json:"delay,omitempty"`
+}
@@ -15,6 +15,19 @@ type WdogFunctionBuild struct {
 	Packages	string		`json:"packages,omitempty"`
 }
 
+/*
+ * When the caller can accept a stream and the function produces
+ * chunks, wdog replies with this content type and a sequence of
+ * JSON-encoded chunks, the last one carrying the run result.
+ */
+const WdogStreamType = "application/x-swifty-stream"
+
+type WdogFunctionRunChunk struct {
+	Data		string			`json:"data,omitempty"`
+	CType		string			`json:"ctype,omitempty"`
+	Res		*WdogFunctionRunResult	`json:"res,omitempty"`
+}
+
 func (r *WdogFunctionRunResult)FnTime() time.Duration {
 	return time.Duration(r.Time) * time.Microsecond
 }
@@ -0,0 +1,104 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package swyapi
+
+/*
+ * Workflow is a state machine. Each state does its job and passes
+ * the output (JSON) as the input to the Next one, the run ends after
+ * the state with End set (or without Next).
+ *
+ * State types:
+ *  task     -- call the Function (fn@alias is OK) with the input as
+ *              body, the return value is the output
+ *  parallel -- run Branches with the same input, the output is the
+ *              array of their outputs
+ *  map      -- run Iterator for each element of the Items array of
+ *              the input (or of the input itself), the output is the
+ *              array of the results
+ *  choice   -- go to the Next of the first matching Choice or to
+ *              the Default state
+ *  wait     -- sleep for Seconds
+ *  succeed  -- end the run (or branch) successfully
+ *  fail     -- end the run (or branch) with the Error
+ *
+ * Task, parallel and map states may Retry on errors and go to the
+ * Catch state if they still fail, the error is its input then.
+ */
+type WorkflowDef struct {
+	Start		string				`json:"start" yaml:"start"`
+	States		map[string]*WorkflowState	`json:"states" yaml:"states"`
+}
+
+type WorkflowState struct {
+	Type		string			`json:"type" yaml:"type"`
+	Function	string			`json:"function,omitempty" yaml:"function,omitempty"`
+	Branches	[]*WorkflowDef		`json:"branches,omitempty" yaml:"branches,omitempty"`
+	Iterator	*WorkflowDef		`json:"iterator,omitempty" yaml:"iterator,omitempty"`
+	Items		string			`json:"items,omitempty" yaml:"items,omitempty"`
+	Choices		[]*WorkflowChoice	`json:"choices,omitempty" yaml:"choices,omitempty"`
+	Default		string			`json:"default,omitempty" yaml:"default,omitempty"`
+	Seconds		uint			`json:"seconds,omitempty" yaml:"seconds,omitempty"`
+	Retry		*WorkflowRetry		`json:"retry,omitempty" yaml:"retry,omitempty"`
+	Catch		string			`json:"catch,omitempty" yaml:"catch,omitempty"`
+	Error		string			`json:"error,omitempty" yaml:"error,omitempty"`
+	Next		string			`json:"next,omitempty" yaml:"next,omitempty"`
+	End		bool			`json:"end,omitempty" yaml:"end,omitempty"`
+}
+
+/*
+ * Var is the dot-separated path in the input (e.g. "order.total"),
+ * Op is one of "==", "!=", "<", "<=", ">", ">=" or "exists". Values
+ * are compared as numbers when both look like ones.
+ */
+type WorkflowChoice struct {
+	Var		string			`json:"var" yaml:"var"`
+	Op		string			`json:"op" yaml:"op"`
+	Value		string			`json:"value,omitempty" yaml:"value,omitempty"`
+	Next		string			`json:"next" yaml:"next"`
+}
+
+/* Interval (msec) doubles after each attempt */
+type WorkflowRetry struct {
+	Attempts	uint			`json:"attempts" yaml:"attempts"`
+	Interval	uint			`json:"interval,omitempty" yaml:"interval,omitempty"`
+}
+
+type WorkflowAdd struct {
+	Name		string			`json:"name" yaml:"name"`
+	Project		string			`json:"project,omitempty" yaml:"project,omitempty"`
+	WorkflowDef				`yaml:",inline"`
+}
+
+type WorkflowInfo struct {
+	Id		string			`json:"id"`
+	Name		string			`json:"name"`
+	Project		string			`json:"project"`
+	Labels		[]string		`json:"labels,omitempty"`
+	Def		*WorkflowDef		`json:"definition,omitempty"`
+}
+
+type WorkflowRunStart struct {
+	Input		string			`json:"input,omitempty"` /* JSON */
+}
+
+type WorkflowHistEntry struct {
+	Ts		string			`json:"ts"`
+	State		string			`json:"state"`
+	Event		string			`json:"event"`
+	Info		string			`json:"info,omitempty"`
+}
+
+type WorkflowRunInfo struct {
+	Id		string			`json:"id"`
+	Status		string			`json:"status"`
+	Started		string			`json:"started"`
+	Finished	string			`json:"finished,omitempty"`
+	State		string			`json:"state,omitempty"`
+	Input		string			`json:"input,omitempty"`
+	Output		string			`json:"output,omitempty"`
+	Error		string			`json:"error,omitempty"`
+	History		[]*WorkflowHistEntry	`json:"history,omitempty"`
+}
@@ -0,0 +1,413 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"strings"
+	"context"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/gate/mgo"
+	"swifty/common/xrest"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Published version is immutable -- its sources (and whatever was
+ * built in there) are not GC-ed till the version is unpublished.
+ * Aliases point at published versions, each aliased version runs
+ * in its own single-replica deployment, so that calls to fn@alias
+ * get there and re-pointing an alias is an instant rollback.
+ */
+type FnVersionDesc struct {
+	Src		FnSrcDesc	`bson:"src"`
+	Time		time.Time	`bson:"ts"`
+}
+
+var fnAliasesMax = 8
+
+func init() {
+	sysctl.AddIntSysctl("fn_aliases_max", &fnAliasesMax)
+}
+
+func splitAlias(name string) (string, string) {
+	x := strings.SplitN(name, "@", 2)
+	if len(x) == 1 {
+		return name, ""
+	}
+
+	return x[0], x[1]
+}
+
+func aliasNameOK(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if (c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9') ||
+			c == '_' || c == '-' {
+				continue
+			}
+
+		return false
+	}
+
+	return true
+}
+
+const verDepSuff = "-v"
+
+func (fn *FunctionDesc)VerDepName(ver string) string {
+	return fn.DepName() + verDepSuff + ver
+}
+
+func (fmd *FnMemData)isVerDep(depname string) bool {
+	return strings.HasPrefix(depname, fmd.depname + verDepSuff)
+}
+
+func (fn *FunctionDesc)findVersion(ver string) *FnVersionDesc {
+	for _, vd := range fn.Versions {
+		if vd.Src.Version == ver {
+			return vd
+		}
+	}
+
+	return nil
+}
+
+func (fn *FunctionDesc)isPublished(ver string) bool {
+	return fn.findVersion(ver) != nil
+}
+
+func (fn *FunctionDesc)verFn(ver string) *FunctionDesc {
+	vfn := *fn
+	vfn.Src = fn.findVersion(ver).Src
+	return &vfn
+}
+
+func (fn *FunctionDesc)versionAliases(ver string) []string {
+	var ret []string
+
+	for a, v := range fn.Aliases {
+		if v == ver {
+			ret = append(ret, a)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
+/* Versions that have aliases and thus -- the deployments */
+func (fn *FunctionDesc)aliasedVersions() []string {
+	var ret []string
+
+	for _, vd := range fn.Versions {
+		if len(fn.versionAliases(vd.Src.Version)) != 0 {
+			ret = append(ret, vd.Src.Version)
+		}
+	}
+
+	return ret
+}
+
+func aliasMemUpdate(fn *FunctionDesc) {
+	fdm := memdGetCond(fn.Cookie)
+	if fdm != nil {
+		fdm.aliases = fn.Aliases
+	}
+}
+
+func (vd *FnVersionDesc)toInfo(fn *FunctionDesc) *swyapi.FunctionVersion {
+	return &swyapi.FunctionVersion {
+		Version:	vd.Src.Version,
+		Published:	vd.Time.Format(time.RFC1123Z),
+		Aliases:	fn.versionAliases(vd.Src.Version),
+	}
+}
+
+func (fn *FunctionDesc)versionsInfo() []*swyapi.FunctionVersion {
+	ret := []*swyapi.FunctionVersion{}
+	for _, vd := range fn.Versions {
+		ret = append(ret, vd.toInfo(fn))
+	}
+
+	return ret
+}
+
+func (fn *FunctionDesc)aliasesInfo() []*swyapi.FunctionAlias {
+	var names []string
+	for a, _ := range fn.Aliases {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+
+	ret := []*swyapi.FunctionAlias{}
+	for _, a := range names {
+		ret = append(ret, &swyapi.FunctionAlias{Name: a, Version: fn.Aliases[a]})
+	}
+
+	return ret
+}
+
+func (fn *FunctionDesc)publishVersion(ctx context.Context, ver string) (*swyapi.FunctionVersion, *xrest.ReqErr) {
+	var src *FnSrcDesc
+
+	if ver == "" {
+		ver = fn.Src.Version
+	}
+
+	if fn.isPublished(ver) {
+		return nil, GateErrM(swyapi.GateDuplicate, "Version already published")
+	}
+
+	/* Older versions' sources are (being) GC-ed already */
+	switch {
+	case ver == fn.Src.Version:
+		src = &fn.Src
+	case fn.Canary != nil && ver == fn.Canary.Src.Version:
+		src = &fn.Canary.Src
+	default:
+		return nil, GateErrM(swyapi.GateNotFound, "Only current or canary version can be published")
+	}
+
+	if fn.State != DBFuncStateRdy && fn.State != DBFuncStateDea {
+		return nil, GateErrM(swyapi.GateNotAvail, "Function not built (yet)")
+	}
+
+	vd := &FnVersionDesc {
+		Src:	*src,
+		Time:	time.Now(),
+	}
+
+	err := dbFuncUpdate(ctx, bson.M{"_id": fn.ObjID, "versions.src.version": bson.M{"$ne": ver}},
+				bson.M{"$push": bson.M{"versions": vd}})
+	if err != nil {
+		if dbNF(err) {
+			return nil, GateErrM(swyapi.GateDuplicate, "Version already published")
+		}
+		return nil, GateErrD(err)
+	}
+
+	fn.Versions = append(fn.Versions, vd)
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("version %s published", ver))
+	return vd.toInfo(fn), nil
+}
+
+func (fn *FunctionDesc)unpublishVersion(ctx context.Context, ver string) *xrest.ReqErr {
+	if !fn.isPublished(ver) {
+		return GateErrM(swyapi.GateNotFound, "Version not published")
+	}
+
+	if len(fn.versionAliases(ver)) != 0 {
+		return GateErrM(swyapi.GateNotAvail, "Version has aliases")
+	}
+
+	err := dbFuncUpdate(ctx, bson.M{"_id": fn.ObjID},
+				bson.M{"$pull": bson.M{"versions": bson.M{"src.version": ver}}})
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	var vds []*FnVersionDesc
+	for _, vd := range fn.Versions {
+		if vd.Src.Version != ver {
+			vds = append(vds, vd)
+		}
+	}
+	fn.Versions = vds
+
+	if ver != fn.Src.Version && !(fn.Canary != nil && ver == fn.Canary.Src.Version) {
+		GCOldSources(ctx, fn, ver)
+	}
+
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("version %s unpublished", ver))
+	return nil
+}
+
+func (fn *FunctionDesc)setAlias(ctx context.Context, name, ver string, create bool) *xrest.ReqErr {
+	if !aliasNameOK(name) {
+		return GateErrM(swyapi.GateBadRequest, "Bad alias name")
+	}
+
+	if !fn.isPublished(ver) {
+		return GateErrM(swyapi.GateNotFound, "Version not published")
+	}
+
+	oldver, ok := fn.Aliases[name]
+	if create && ok {
+		return GateErrM(swyapi.GateDuplicate, "Alias already exists")
+	}
+	if !create && !ok {
+		return GateErrM(swyapi.GateNotFound, "No such alias")
+	}
+	if !ok && len(fn.Aliases) >= fnAliasesMax {
+		return GateErrM(swyapi.GateLimitHit, "Too many aliases")
+	}
+
+	if oldver == ver {
+		return nil
+	}
+
+	/*
+	 * The deployment for new version should be there by the time
+	 * alias points to it, so start one first
+	 */
+	run := fn.State == DBFuncStateRdy && len(fn.versionAliases(ver)) == 0
+	if run {
+		err := k8sRunDep(ctx, &conf, fn.verFn(ver), fn.VerDepName(ver), 1)
+		if err != nil {
+			return GateErrE(swyapi.GateGenErr, err)
+		}
+	}
+
+	err := dbUpdatePart2(ctx, fn, bson.M{"versions.src.version": ver}, bson.M{"aliases." + name: ver})
+	if err != nil {
+		if run {
+			k8sRemoveDep(ctx, &conf, fn, fn.VerDepName(ver))
+		}
+		if dbNF(err) {
+			return GateErrM(swyapi.GateNotFound, "Version not published")
+		}
+		return GateErrD(err)
+	}
+
+	/* Memdata may keep the old map, so make a new one */
+	als := make(map[string]string)
+	for a, v := range fn.Aliases {
+		als[a] = v
+	}
+	als[name] = ver
+	fn.Aliases = als
+	aliasMemUpdate(fn)
+
+	if oldver != "" {
+		fn.aliasVersionGone(ctx, oldver)
+	}
+
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("alias %s set to %s", name, ver))
+	return nil
+}
+
+func wfCalls(def *swyapi.WorkflowDef, fname string) bool {
+	if def == nil {
+		return false
+	}
+
+	for _, st := range def.States {
+		if st.Function == fname || wfCalls(st.Iterator, fname) {
+			return true
+		}
+		for _, b := range st.Branches {
+			if wfCalls(b, fname) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+ * Things that call the fn by fn@alias name. Calls from those would
+ * fail once the alias is gone, so it's not removed while they exist.
+ * Re-targeting is OK, they follow the alias to the new version.
+ */
+func (fn *FunctionDesc)aliasRefs(ctx context.Context, name string) ([]string, error) {
+	var refs []string
+	var evs []*FnEventDesc
+	var rts []*RouterDesc
+	var wfs []*WorkflowDesc
+
+	err := dbFindAll(ctx, bson.M{"fnid": fn.Cookie, "alias": name}, &evs)
+	if err != nil {
+		return nil, err
+	}
+	for _, ed := range evs {
+		refs = append(refs, "trigger " + ed.Name)
+	}
+
+	nr, err := dbCol(ctx, gmgo.DBColDelayed).Find(bson.M{"cookie": fn.Cookie, "alias": name}).Count()
+	if err != nil {
+		return nil, err
+	}
+	if nr != 0 {
+		refs = append(refs, fmt.Sprintf("%d delayed call(s)", nr))
+	}
+
+	call := fn.SwoId.Name + "@" + name
+	err = dbFindAll(ctx, bson.M{"tennant": fn.SwoId.Tennant, "project": fn.SwoId.Project,
+				"table.call": call}, &rts)
+	if err != nil {
+		return nil, err
+	}
+	for _, rt := range rts {
+		refs = append(refs, "router " + rt.SwoId.Name)
+	}
+
+	err = dbFindAll(ctx, bson.M{"tennant": fn.SwoId.Tennant, "project": fn.SwoId.Project}, &wfs)
+	if err != nil {
+		return nil, err
+	}
+	for _, wf := range wfs {
+		if wfCalls(wf.Def, call) {
+			refs = append(refs, "workflow " + wf.SwoId.Name)
+		}
+	}
+
+	return refs, nil
+}
+
+func (fn *FunctionDesc)delAlias(ctx context.Context, name string) *xrest.ReqErr {
+	ver, ok := fn.Aliases[name]
+	if !ok {
+		return GateErrM(swyapi.GateNotFound, "No such alias")
+	}
+
+	refs, err := fn.aliasRefs(ctx, name)
+	if err != nil {
+		return GateErrD(err)
+	}
+	if len(refs) != 0 {
+		return GateErrM(swyapi.GateNotAvail, "Alias is used by " + strings.Join(refs, ", "))
+	}
+
+	err = dbFuncUpdate(ctx, bson.M{"_id": fn.ObjID}, bson.M{"$unset": bson.M{"aliases." + name: ""}})
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	als := make(map[string]string)
+	for a, v := range fn.Aliases {
+		if a != name {
+			als[a] = v
+		}
+	}
+	fn.Aliases = als
+	aliasMemUpdate(fn)
+
+	fn.aliasVersionGone(ctx, ver)
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("alias %s removed", name))
+	return nil
+}
+
+func (fn *FunctionDesc)aliasVersionGone(ctx context.Context, ver string) {
+	if fn.State != DBFuncStateRdy || len(fn.versionAliases(ver)) != 0 {
+		return
+	}
+
+	err := k8sRemoveDep(ctx, &conf, fn, fn.VerDepName(ver))
+	if err != nil {
+		ctxlog(ctx).Errorf("Can't remove %s version %s dep: %s", fn.SwoId.Str(), ver, err.Error())
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"sync"
 	"sync/atomic"
 	"errors"
+	"fmt"
 	"context"
+	"time"
 
 	"swifty/common/xrest"
 	"swifty/apis"
@@ -20,6 +22,10 @@ type BalancerDat struct {
 	pods		[]*podConn
 	goal		uint32
 	wakeup		*sync.Cond
+	cold		*coldStart
+	upts		time.Time
+	scaled		uint32
+	decisions	[]*scaleDecision
 }
 
 func (bd *BalancerDat)Flush() {
@@ -41,6 +47,11 @@ func BalancerPodDel(ctx context.Context, pod *k8sPod) {
 func BalancerPodAdd(ctx context.Context, pod *k8sPod) {
 	podsAdd(ctx, pod)
 	balancerPodsFlush(pod.FnId)
+
+	fdm := memdGetCond(pod.FnId)
+	if fdm != nil && pod.DepName == fdm.depname {
+		scalerColdDone(fdm)
+	}
 }
 
 func BalancerDelete(ctx context.Context, fnid string) (error) {
@@ -58,6 +69,7 @@ func BalancerCreate(ctx context.Context, fnid string) (error) {
 }
 
 func BalancerInit() (error) {
+	go scalerIdler()
 	return nil
 }
 
@@ -76,7 +88,7 @@ func balancerGetConnExact(ctx context.Context, cookie, version string) (*podConn
 	return ap, xer
 }
 
-func balancerGetConnAny(ctx context.Context, fdm *FnMemData) (*podConn, error) {
+func balancerPods(ctx context.Context, fdm *FnMemData) ([]*podConn, error) {
 	var aps []*podConn
 
 	aps = fdm.bd.pods
@@ -95,6 +107,59 @@ func balancerGetConnAny(ctx context.Context, fdm *FnMemData) (*podConn, error) {
 		fdm.lock.Unlock()
 	}
 
+	return aps, nil
+}
+
+func balancerMainPods(ctx context.Context, fdm *FnMemData) []*podConn {
+	var aps []*podConn
+
+	paps, _ := balancerPods(ctx, fdm)
+
+	/* Aliased versions' pods only serve fn@alias calls */
+	for _, ap := range paps {
+		if !fdm.isVerDep(ap.DepName) {
+			aps = append(aps, ap)
+		}
+	}
+
+	return aps
+}
+
+/*
+ * Canary pods serve a share of the main calls, but do not tell
+ * whether the function is up, they're scaled to zero with it
+ */
+func balancerHasBasePods(ctx context.Context, fdm *FnMemData) bool {
+	paps, _ := balancerPods(ctx, fdm)
+
+	for _, ap := range paps {
+		if ap.DepName == fdm.depname {
+			return true
+		}
+	}
+
+	return false
+}
+
+func balancerGetConnAny(ctx context.Context, fdm *FnMemData, skey string, sopq *statsOpaque) (*podConn, error) {
+	if fdm.idle != 0 && !balancerHasBasePods(ctx, fdm) {
+		/* Scaled to zero, wake it up */
+		err := scalerColdStart(ctx, fdm, sopq)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	aps := balancerMainPods(ctx, fdm)
+
+	if len(aps) == 0 {
+		return nil, errors.New("No available PODs")
+	}
+
+	if cn := fdm.canary(); cn != nil {
+		aps = cn.filter(aps, skey)
+	}
+
 	/* Emulate simple RR balancing -- each next call picks next POD */
 	sc := atomic.AddUint32(&fdm.bd.rover[0], 1)
 	scalerSetGoal(ctx, fdm, sc - fdm.bd.rover[1])
@@ -102,6 +167,34 @@ func balancerGetConnAny(ctx context.Context, fdm *FnMemData) (*podConn, error) {
 	return aps[sc % uint32(len(aps))], nil
 }
 
+func balancerGetConnAlias(ctx context.Context, fdm *FnMemData, alias string) (*podConn, error) {
+	var aps []*podConn
+
+	ver, ok := fdm.aliases[alias]
+	if !ok {
+		return nil, fmt.Errorf("No alias %s", alias)
+	}
+
+	paps, err := balancerPods(ctx, fdm)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ap := range paps {
+		if ap.Version == ver {
+			aps = append(aps, ap)
+		}
+	}
+
+	if len(aps) == 0 {
+		return nil, fmt.Errorf("Version %s is not up (yet)", ver)
+	}
+
+	/* No scaling for aliased versions, just RR */
+	sc := atomic.AddUint32(&fdm.bd.rover[0], 1)
+	return aps[sc % uint32(len(aps))], nil
+}
+
 func balancerPutConn(fdm *FnMemData) {
 	atomic.AddUint32(&fdm.bd.rover[1], 1)
 }
@@ -0,0 +1,430 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"context"
+	"net/http"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/common/xrest"
+)
+
+/*
+ * Canary is the next version of the function that runs in a
+ * separate (single-replica) deployment next to the main one and
+ * gets some share of the calls. Once satisfied, the canary is
+ * promoted to become the main version or rolled back.
+ */
+type FnCanaryDesc struct {
+	Src		FnSrcDesc	`bson:"src"`
+	Weight		uint		`bson:"weight"`
+	Sticky		string		`bson:"sticky,omitempty"`
+	Auto		*FnCanaryAuto	`bson:"auto,omitempty"`
+}
+
+type FnCanaryAuto struct {
+	Calls		uint		`bson:"calls"`
+	MaxErrors	uint		`bson:"max_errors"`
+	Promote		bool		`bson:"promote"`
+}
+
+type canaryMemData struct {
+	ver	string
+	weight	uint32
+	skind	string
+	sname	string
+	auto	*FnCanaryAuto
+
+	called	uint64
+	errors	uint64
+	decided	int32
+}
+
+const canaryDepSuff = "-c"
+
+func (fn *FunctionDesc)CanaryDepName() string {
+	return fn.DepName() + canaryDepSuff
+}
+
+func (fmd *FnMemData)isCanaryDep(depname string) bool {
+	return depname == fmd.depname + canaryDepSuff
+}
+
+func (fn *FunctionDesc)canaryFn() *FunctionDesc {
+	cfn := *fn
+	cfn.Src = fn.Canary.Src
+	return &cfn
+}
+
+func (cd *FnCanaryDesc)toMem(old *canaryMemData) *canaryMemData {
+	if cd == nil {
+		return nil
+	}
+
+	cn := &canaryMemData {
+		ver:	cd.Src.Version,
+		weight:	uint32(cd.Weight),
+		auto:	cd.Auto,
+	}
+
+	if cd.Sticky != "" {
+		x := strings.SplitN(cd.Sticky, ":", 2)
+		cn.skind = x[0]
+		cn.sname = x[1]
+	}
+
+	/* Weight/auto change doesn't reset the stats collected so far */
+	if old != nil && old.ver == cn.ver {
+		cn.called = atomic.LoadUint64(&old.called)
+		cn.errors = atomic.LoadUint64(&old.errors)
+	}
+
+	return cn
+}
+
+/* Calls read the canary concurrently, so it's only swapped under the lock */
+func canaryMemUpdate(fn *FunctionDesc) {
+	fdm := memdGetCond(fn.Cookie)
+	if fdm != nil {
+		fdm.lock.Lock()
+		fdm.cn = fn.Canary.toMem(fdm.cn)
+		fdm.lock.Unlock()
+	}
+}
+
+func (fmd *FnMemData)canary() *canaryMemData {
+	fmd.lock.Lock()
+	cn := fmd.cn
+	fmd.lock.Unlock()
+	return cn
+}
+
+func (cn *canaryMemData)stickyKey(r *http.Request, args *swyapi.FunctionRun) string {
+	if cn == nil {
+		return ""
+	}
+
+	switch cn.skind {
+	case "header":
+		return r.Header.Get(cn.sname)
+	case "claim":
+		if v, ok := args.Claims[cn.sname]; ok {
+			return fmt.Sprintf("%v", v)
+		}
+	}
+
+	return ""
+}
+
+/*
+ * Leaves only pods of the version the call should go to. Calls
+ * with the same sticky key always get to the same version.
+ */
+func (cn *canaryMemData)filter(aps []*podConn, skey string) []*podConn {
+	var x uint32
+
+	if skey != "" {
+		h := fnv.New32a()
+		h.Write([]byte(skey))
+		x = h.Sum32()
+	} else {
+		x = rand.Uint32()
+	}
+
+	canary := (x % 100) < cn.weight
+
+	var ret []*podConn
+	for _, ap := range aps {
+		if (ap.Version == cn.ver) == canary {
+			ret = append(ret, ap)
+		}
+	}
+
+	if len(ret) == 0 {
+		/* The desired version is not up (yet) */
+		return aps
+	}
+
+	return ret
+}
+
+func (cn *canaryMemData)account(fmd *FnMemData, res *swyapi.WdogFunctionRunResult) {
+	nc := atomic.AddUint64(&cn.called, 1)
+	if res.Code < 0 || res.Code >= 500 {
+		atomic.AddUint64(&cn.errors, 1)
+	}
+
+	if cn.auto == nil || nc < uint64(cn.auto.Calls) {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&cn.decided, 0, 1) {
+		return
+	}
+
+	go canaryAutoDecide(fmd.fnid, cn)
+}
+
+func canaryAutoDecide(cookie string, cn *canaryMemData) {
+	ctx, done := mkContext("::canary")
+	defer done(ctx)
+
+	var fn FunctionDesc
+
+	err := dbFind(ctx, bson.M{"cookie": cookie}, &fn)
+	if err != nil {
+		ctxlog(ctx).Errorf("Can't find FN %s to decide on canary: %s", cookie, err.Error())
+		return
+	}
+
+	if fn.Canary == nil || fn.Canary.Src.Version != cn.ver {
+		return
+	}
+
+	gctx(ctx).tpush(fn.SwoId.Tennant)
+
+	called := atomic.LoadUint64(&cn.called)
+	erate := atomic.LoadUint64(&cn.errors) * 100 / called
+
+	var cerr *xrest.ReqErr
+	if erate > uint64(cn.auto.MaxErrors) {
+		logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("canary %s: %d%% errors, rolling back", cn.ver, erate))
+		cerr = fn.rollbackCanary(ctx)
+	} else if cn.auto.Promote {
+		logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("canary %s: %d%% errors, promoting", cn.ver, erate))
+		cerr = fn.promoteCanary(ctx)
+	}
+
+	if cerr != nil {
+		ctxlog(ctx).Errorf("Can't finish canary for %s: %s", fn.SwoId.Str(), cerr.Message)
+	}
+}
+
+func fnFixCanary(params *swyapi.FunctionCanary) error {
+	if params.Weight > 100 {
+		return errors.New("Weight should be in percents")
+	}
+
+	if params.Sticky != "" {
+		x := strings.SplitN(params.Sticky, ":", 2)
+		if len(x) != 2 || x[1] == "" || (x[0] != "header" && x[0] != "claim") {
+			return errors.New("Sticky should be header:$name or claim:$name")
+		}
+	}
+
+	if params.Auto != nil {
+		if params.Auto.Calls == 0 {
+			return errors.New("Auto needs non-zero number of calls")
+		}
+		if params.Auto.MaxErrors > 100 {
+			return errors.New("Max errors should be in percents")
+		}
+	}
+
+	return nil
+}
+
+func (fn *FunctionDesc)canaryInfo() *swyapi.FunctionCanary {
+	cd := fn.Canary
+	ci := &swyapi.FunctionCanary {
+		Version:	cd.Src.Version,
+		Weight:		cd.Weight,
+		Sticky:		cd.Sticky,
+	}
+
+	if cd.Auto != nil {
+		ci.Auto = &swyapi.FunctionCanaryAuto {
+			Calls:		cd.Auto.Calls,
+			MaxErrors:	cd.Auto.MaxErrors,
+			Promote:	cd.Auto.Promote,
+		}
+	}
+
+	fdm := memdGetCond(fn.Cookie)
+	if fdm != nil {
+		if cn := fdm.canary(); cn != nil && cn.ver == cd.Src.Version {
+			ci.Stats = &swyapi.FunctionCanaryStats {
+				Called:	atomic.LoadUint64(&cn.called),
+				Errors:	atomic.LoadUint64(&cn.errors),
+			}
+		}
+	}
+
+	return ci
+}
+
+func canaryAuto(params *swyapi.FunctionCanary) *FnCanaryAuto {
+	if params.Auto == nil {
+		return nil
+	}
+
+	return &FnCanaryAuto {
+		Calls:		params.Auto.Calls,
+		MaxErrors:	params.Auto.MaxErrors,
+		Promote:	params.Auto.Promote,
+	}
+}
+
+func (fn *FunctionDesc)startCanary(ctx context.Context, params *swyapi.FunctionCanary) *xrest.ReqErr {
+	if fn.State != DBFuncStateRdy {
+		return GateErrM(swyapi.GateNotAvail, "Function should be running")
+	}
+
+	if fn.Canary != nil {
+		return GateErrM(swyapi.GateDuplicate, "Canary is already running")
+	}
+
+	if params.Sources == nil {
+		return GateErrM(swyapi.GateBadRequest, "No canary sources")
+	}
+
+	err := fnFixCanary(params)
+	if err != nil {
+		return GateErrE(swyapi.GateBadRequest, err)
+	}
+
+	/* Canary sources are just the next version next to the current one */
+	cfn := *fn
+	err = updateSources(ctx, &cfn, params.Sources)
+	if err != nil {
+		return GateErrE(swyapi.GateGenErr, err)
+	}
+
+	err = tryBuildFunction(ctx, &cfn, "")
+	if err != nil {
+		GCOldSources(ctx, fn, cfn.Src.Version)
+		return GateErrE(swyapi.GateGenErr, err)
+	}
+
+	cd := &FnCanaryDesc {
+		Src:		cfn.Src,
+		Weight:		params.Weight,
+		Sticky:		params.Sticky,
+		Auto:		canaryAuto(params),
+	}
+
+	err = dbUpdatePart2(ctx, fn, bson.M{"canary": nil}, bson.M{"canary": cd})
+	if err != nil {
+		GCOldSources(ctx, fn, cd.Src.Version)
+		if dbNF(err) {
+			return GateErrM(swyapi.GateDuplicate, "Canary is already running")
+		}
+		return GateErrD(err)
+	}
+
+	fn.Canary = cd
+
+	err = k8sRunDep(ctx, &conf, fn.canaryFn(), fn.CanaryDepName(), 1)
+	if err != nil {
+		fn.Canary = nil
+		dbUpdatePart(ctx, fn, bson.M{"canary": nil})
+		GCOldSources(ctx, fn, cd.Src.Version)
+		return GateErrE(swyapi.GateGenErr, err)
+	}
+
+	canaryMemUpdate(fn)
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("canary %s started (%d%%)", cd.Src.Version, cd.Weight))
+	return nil
+}
+
+func (fn *FunctionDesc)updateCanary(ctx context.Context, params *swyapi.FunctionCanary) *xrest.ReqErr {
+	if fn.Canary == nil {
+		return GateErrM(swyapi.GateNotFound, "No canary running")
+	}
+
+	if params.Sources != nil {
+		return GateErrM(swyapi.GateBadRequest, "Canary sources cannot be changed, roll it back first")
+	}
+
+	err := fnFixCanary(params)
+	if err != nil {
+		return GateErrE(swyapi.GateBadRequest, err)
+	}
+
+	cd := *fn.Canary
+	cd.Weight = params.Weight
+	cd.Sticky = params.Sticky
+	cd.Auto = canaryAuto(params)
+
+	err = dbUpdatePart2(ctx, fn, bson.M{"canary.src.version": cd.Src.Version}, bson.M{"canary": &cd})
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	fn.Canary = &cd
+	canaryMemUpdate(fn)
+	return nil
+}
+
+func (fn *FunctionDesc)promoteCanary(ctx context.Context) *xrest.ReqErr {
+	if fn.Canary == nil {
+		return GateErrM(swyapi.GateNotFound, "No canary running")
+	}
+
+	cd := fn.Canary
+	oldver := fn.Src.Version
+
+	err := dbUpdatePart2(ctx, fn, bson.M{"canary.src.version": cd.Src.Version},
+			bson.M{"src": &cd.Src, "canary": nil})
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	fn.Src = cd.Src
+	fn.Canary = nil
+	canaryMemUpdate(fn)
+
+	/*
+	 * Main deployment rolls to the new version, the old pods
+	 * keep serving till then. Canary is not needed any longer.
+	 */
+	err = k8sUpdate(ctx, &conf, fn)
+	if err != nil {
+		return GateErrE(swyapi.GateGenErr, err)
+	}
+
+	err = k8sRemoveDep(ctx, &conf, fn, fn.CanaryDepName())
+	if err != nil {
+		ctxlog(ctx).Errorf("Can't remove canary dep for %s: %s", fn.SwoId.Str(), err.Error())
+	}
+
+	GCOldSources(ctx, fn, oldver)
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("canary %s promoted", fn.Src.Version))
+	return nil
+}
+
+func (fn *FunctionDesc)rollbackCanary(ctx context.Context) *xrest.ReqErr {
+	if fn.Canary == nil {
+		return GateErrM(swyapi.GateNotFound, "No canary running")
+	}
+
+	cd := fn.Canary
+
+	err := dbUpdatePart2(ctx, fn, bson.M{"canary.src.version": cd.Src.Version}, bson.M{"canary": nil})
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	fn.Canary = nil
+	canaryMemUpdate(fn)
+
+	err = k8sRemoveDep(ctx, &conf, fn, fn.CanaryDepName())
+	if err != nil {
+		return GateErrE(swyapi.GateGenErr, err)
+	}
+
+	GCOldSources(ctx, fn, cd.Src.Version)
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("canary %s rolled back", cd.Src.Version))
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"context"
+
+	"swifty/apis"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Caps the number of calls of a fn running at the same time. Calls
+ * above the limit wait in a FIFO queue, and each finishing call hands
+ * its slot over to the first waiter. With zero max the calls are just
+ * counted.
+ *
+ * The limit lives in gate memory, so each gate replica enforces it on
+ * its own and N gates let up to N * max calls run at once.
+ */
+type concLimit struct {
+	lock		sync.Mutex
+	max		uint
+	running		uint
+	waiters		[]chan bool
+	throttled	uint64
+}
+
+var concQueueMax int = 64
+var concWait time.Duration = 10 * time.Second
+var concRetryAfter int = 1
+
+var concFull = errors.New("Too many concurrent calls")
+
+func init() {
+	sysctl.AddIntSysctl("fn_concurrency_queue_max", &concQueueMax)
+	sysctl.AddTimeSysctl("fn_concurrency_wait", &concWait)
+	sysctl.AddIntSysctl("fn_concurrency_retry_after_sec", &concRetryAfter)
+}
+
+func (cl *concLimit)free() bool {
+	return cl.max == 0 || cl.running < cl.max
+}
+
+/* Called with the lock held */
+func (cl *concLimit)kick() {
+	for len(cl.waiters) > 0 && cl.free() {
+		w := cl.waiters[0]
+		cl.waiters = cl.waiters[1:]
+		cl.running++
+		w <- true
+	}
+}
+
+func (cl *concLimit)get(ctx context.Context) error {
+	cl.lock.Lock()
+	if len(cl.waiters) == 0 && cl.free() {
+		cl.running++
+		cl.lock.Unlock()
+		return nil
+	}
+
+	if len(cl.waiters) >= concQueueMax {
+		cl.throttled++
+		cl.lock.Unlock()
+		concThrottled.WithLabelValues("queue").Inc()
+		return concFull
+	}
+
+	w := make(chan bool, 1)
+	cl.waiters = append(cl.waiters, w)
+	cl.lock.Unlock()
+
+	t := time.NewTimer(concWait)
+	defer t.Stop()
+
+	select {
+	case <-w:
+		return nil
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	for i, x := range cl.waiters {
+		if x == w {
+			cl.waiters = append(cl.waiters[:i], cl.waiters[i+1:]...)
+			cl.throttled++
+			concThrottled.WithLabelValues("wait").Inc()
+			return concFull
+		}
+	}
+
+	/* The slot came right when we gave up waiting */
+	return nil
+}
+
+func (cl *concLimit)put() {
+	cl.lock.Lock()
+	cl.running--
+	cl.kick()
+	cl.lock.Unlock()
+}
+
+func (cl *concLimit)setMax(max uint) {
+	cl.lock.Lock()
+	cl.max = max
+	cl.kick()
+	cl.lock.Unlock()
+}
+
+func (cl *concLimit)status() *swyapi.FunctionConcStatus {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	return &swyapi.FunctionConcStatus {
+		Max:		cl.max,
+		InFlight:	cl.running,
+		Queued:		uint(len(cl.waiters)),
+		Throttled:	cl.throttled,
+	}
+}
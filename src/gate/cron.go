@@ -8,45 +8,248 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"math/rand"
 	"gopkg.in/robfig/cron.v2"
 	"gopkg.in/mgo.v2/bson"
 	"swifty/apis"
+	"swifty/common/xrest/sysctl"
 )
 
 type FnEventCron struct {
 	Tab		string			`bson:"tab"`
 	Args		map[string]string	`bson:"args"`
+	TZ		string			`bson:"tz,omitempty"`
+	Overlap		string			`bson:"overlap,omitempty"`
+	Jitter		uint			`bson:"jitter,omitempty"` /* msec */
+	Catchup		uint			`bson:"catchup,omitempty"`
 	JobID		int			`bson:"eid"`
+	Last		time.Time		`bson:"last,omitempty"`
+	Result		string			`bson:"result,omitempty"`
 }
 
+/*
+ * What to do when the time comes, but the previous run is
+ * still going on
+ */
+const (
+	CronOverlapAllow	= "allow"
+	CronOverlapSkip		= "skip"
+	CronOverlapQueue	= "queue"
+)
+
 var cronRunner *cron.Cron
+var cronQueueMax int = 8
+var cronCatchupMax int = 32
 
-func cronEventStart(ctx context.Context, _ *FunctionDesc, evt *FnEventDesc) error {
-	id, err := cronRunner.AddFunc(evt.Cron.Tab, func() {
-		cctx, done := mkContext("::cron")
-		defer done(cctx)
+func init() {
+	sysctl.AddIntSysctl("fn_cron_queue_max", &cronQueueMax)
+	sysctl.AddIntSysctl("fn_cron_catchup_max", &cronCatchupMax)
+}
 
-		var fn FunctionDesc
+func (c *FnEventCron)spec() string {
+	if c.TZ != "" {
+		return "TZ=" + c.TZ + " " + c.Tab
+	}
 
-		err := dbFind(cctx, bson.M{"cookie": evt.FnId}, &fn)
-		if err != nil {
-			danglingEvents.WithLabelValues("cron").Inc()
-			ctxlog(cctx).Errorf("Can't find FN %s to run Cron event", evt.FnId)
+	return c.Tab
+}
+
+func (c *FnEventCron)next() time.Time {
+	sched, err := cron.Parse(c.spec())
+	if err != nil {
+		return time.Time{}
+	}
+
+	return sched.Next(time.Now())
+}
+
+func (c *FnEventCron)toInfo() *swyapi.FunctionEventCron {
+	ret := &swyapi.FunctionEventCron {
+		Tab:		c.Tab,
+		Args:		c.Args,
+		TZ:		c.TZ,
+		Overlap:	c.Overlap,
+		Jitter:		c.Jitter,
+		Catchup:	c.Catchup,
+		Result:		c.Result,
+	}
+
+	if !c.Last.IsZero() {
+		ret.Last = c.Last.Format(time.RFC1123Z)
+	}
+
+	if n := c.next(); !n.IsZero() {
+		ret.Next = n.Format(time.RFC1123Z)
+	}
+
+	return ret
+}
+
+type cronJob struct {
+	evt		*FnEventDesc
+	lock		sync.Mutex
+	running		int
+	queued		int
+}
+
+func (cj *cronJob)Run() {
+	c := cj.evt.Cron
+
+	if c.Jitter != 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(c.Jitter))) * time.Millisecond)
+	}
+
+	cj.lock.Lock()
+	if cj.running != 0 {
+		switch c.Overlap {
+		case CronOverlapSkip:
+			cj.lock.Unlock()
+			cj.note(time.Now(), "skipped")
+			return
+		case CronOverlapQueue:
+			skip := cj.queued >= cronQueueMax
+			if !skip {
+				cj.queued++
+			}
+			cj.lock.Unlock()
+			if skip {
+				cj.note(time.Now(), "skipped, queue is full")
+			}
 			return
 		}
+	}
+	cj.running++
+	cj.lock.Unlock()
 
-		if fn.State != DBFuncStateRdy {
-			danglingEvents.WithLabelValues("cron").Inc()
-			return
+	for {
+		cj.fire()
+
+		cj.lock.Lock()
+		if cj.queued == 0 {
+			cj.running--
+			cj.lock.Unlock()
+			break
 		}
+		cj.queued--
+		cj.lock.Unlock()
+	}
+}
+
+func (cj *cronJob)fire() {
+	cctx, done := mkContext("::cron")
+	defer done(cctx)
+
+	var fn FunctionDesc
+
+	evt := cj.evt
+	err := dbFind(cctx, bson.M{"cookie": evt.FnId}, &fn)
+	if err != nil {
+		danglingEvents.WithLabelValues("cron").Inc()
+		ctxlog(cctx).Errorf("Can't find FN %s to run Cron event", evt.FnId)
+		return
+	}
+
+	if fn.State != DBFuncStateRdy {
+		danglingEvents.WithLabelValues("cron").Inc()
+		cj.note(time.Now(), "skipped, function not ready")
+		return
+	}
+
+	/*
+	 * Last time is saved before the run, so that the call that was
+	 * in progress when gate died is not re-done by catch-up
+	 */
+	cj.note(time.Now(), "running")
+
+	fn.alias = evt.Alias
+	why := doRunBg(cctx, &fn, "cron", &swyapi.FunctionRun{Args: evt.Cron.Args})
+	if why == "" {
+		why = "ok"
+	}
 
-		doRunBg(cctx, &fn, "cron", &swyapi.FunctionRun{Args: evt.Cron.Args})
-	})
+	cj.result(why)
+}
+
+func (cj *cronJob)note(last time.Time, res string) {
+	ctx, done := mkContext("::cron")
+	defer done(ctx)
+
+	err := dbUpdatePart(ctx, cj.evt, bson.M{"cron.last": last, "cron.result": res})
+	if err != nil && !dbNF(err) {
+		ctxlog(ctx).Errorf("Can't save cron %s state: %s", cj.evt.ObjID.Hex(), err.Error())
+	}
+}
+
+func (cj *cronJob)result(res string) {
+	ctx, done := mkContext("::cron")
+	defer done(ctx)
+
+	err := dbUpdatePart(ctx, cj.evt, bson.M{"cron.result": res})
+	if err != nil && !dbNF(err) {
+		ctxlog(ctx).Errorf("Can't save cron %s state: %s", cj.evt.ObjID.Hex(), err.Error())
+	}
+}
+
+/*
+ * Fires the runs that should have happened while gate was down,
+ * but not more than the trigger's catchup value (the latest ones)
+ */
+func (cj *cronJob)catchup(ctx context.Context) {
+	c := cj.evt.Cron
+	if c.Catchup == 0 {
+		return
+	}
+
+	sched, err := cron.Parse(c.spec())
+	if err != nil {
+		return
+	}
+
+	since := c.Last
+	if since.IsZero() {
+		since = cj.evt.ObjID.Time()
+	}
+
+	nr := 0
+	now := time.Now()
+	for t := sched.Next(since); !t.IsZero() && t.Before(now); t = sched.Next(t) {
+		nr++
+	}
+
+	if nr == 0 {
+		return
+	}
 
-	if err == nil {
-		evt.Cron.JobID = int(id)
+	if nr > int(c.Catchup) {
+		nr = int(c.Catchup)
 	}
 
+	logSaveEvent(ctx, cj.evt.FnId, fmt.Sprintf("cron %s: catching up %d missed runs", cj.evt.Name, nr))
+
+	go func() {
+		for i := 0; i < nr; i++ {
+			cj.Run()
+		}
+	}()
+}
+
+func cronJobStart(evt *FnEventDesc) (*cronJob, error) {
+	sched, err := cron.Parse(evt.Cron.spec())
+	if err != nil {
+		return nil, err
+	}
+
+	cj := &cronJob{evt: evt}
+	evt.Cron.JobID = int(cronRunner.Schedule(sched, cj))
+
+	return cj, nil
+}
+
+func cronEventStart(ctx context.Context, _ *FunctionDesc, evt *FnEventDesc) error {
+	_, err := cronJobStart(evt)
 	return err
 }
 
@@ -64,6 +267,35 @@ var cronOps = EventOps {
 		ed.Cron = &FnEventCron{
 			Tab: evt.Cron.Tab,
 			Args: evt.Cron.Args,
+			TZ: evt.Cron.TZ,
+			Overlap: evt.Cron.Overlap,
+			Jitter: evt.Cron.Jitter,
+			Catchup: evt.Cron.Catchup,
+		}
+
+		switch ed.Cron.Overlap {
+		case "":
+			ed.Cron.Overlap = CronOverlapAllow
+		case CronOverlapAllow, CronOverlapSkip, CronOverlapQueue:
+			;
+		default:
+			return errors.New("Bad overlap policy")
+		}
+
+		if ed.Cron.TZ != "" {
+			_, err := time.LoadLocation(ed.Cron.TZ)
+			if err != nil {
+				return errors.New("Bad time zone")
+			}
+		}
+
+		if ed.Cron.Catchup > uint(cronCatchupMax) {
+			return errors.New("Too many runs to catch up")
+		}
+
+		_, err := cron.Parse(ed.Cron.spec())
+		if err != nil {
+			return errors.New("Bad cron tab")
 		}
 
 		return nil
@@ -84,7 +316,7 @@ func cronInit(ctx context.Context) error {
 	}
 
 	for _, ed := range evs {
-		err = cronEventStart(ctx, nil, ed)
+		cj, err := cronJobStart(ed)
 		if err != nil {
 			return err
 		}
@@ -93,8 +325,9 @@ func cronInit(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+
+		cj.catchup(ctx)
 	}
 
 	return nil
 }
-
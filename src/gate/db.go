@@ -96,6 +96,10 @@ func init() {
 	dbColMap[reflect.TypeOf(&RouterDesc{})] = gmgo.DBColRouters
 	dbColMap[reflect.TypeOf([]*RouterDesc{})] = gmgo.DBColRouters
 	dbColMap[reflect.TypeOf(&[]*RouterDesc{})] = gmgo.DBColRouters
+	dbColMap[reflect.TypeOf(WorkflowDesc{})] = gmgo.DBColWorkflows
+	dbColMap[reflect.TypeOf(&WorkflowDesc{})] = gmgo.DBColWorkflows
+	dbColMap[reflect.TypeOf([]*WorkflowDesc{})] = gmgo.DBColWorkflows
+	dbColMap[reflect.TypeOf(&[]*WorkflowDesc{})] = gmgo.DBColWorkflows
 }
 
 func dbCol(ctx context.Context, col string) *mgo.Collection {
@@ -127,6 +131,8 @@ func objcni(o interface{}) (string, bson.ObjectId) {
 		return gmgo.DBColEvents, o.ObjID
 	case *RouterDesc:
 		return gmgo.DBColRouters, o.ObjID
+	case *WorkflowDesc:
+		return gmgo.DBColWorkflows, o.ObjID
 	default:
 		glog.Fatalf("Unmapped object %s", reflect.TypeOf(o).String())
 		return "", ""
@@ -501,12 +507,40 @@ func dbFnStatsUpdate(ctx context.Context, cookie string, delta *gmgo.FnStatValue
 				"bytesin":	delta.BytesIn,
 				"bytesout":	delta.BytesOut,
 				"runcost":	delta.RunCost,
+				"coldstarts":	delta.ColdStarts,
+				"coldtime":	delta.ColdTime,
 			},
 			"$max": bson.M{"lastcall": lastCall},
 		})
 	return err
 }
 
+func dbFnLastCall(ctx context.Context, cookie string) (time.Time, error) {
+	var st FnStats
+
+	err := dbCol(ctx, gmgo.DBColFnStats).Find(bson.M{"cookie": cookie}).Select(bson.M{"lastcall": 1}).One(&st)
+	if err != nil {
+		if dbNF(err) {
+			err = nil
+		}
+		return time.Time{}, err
+	}
+
+	return st.LastCall, nil
+}
+
+func dbFnStatsTouch(ctx context.Context, cookie string, ts time.Time) error {
+	if !dbMayUpdate(ctx) {
+		return dbNotAllowed
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColFnStats).Upsert(bson.M{"cookie": cookie}, bson.M{
+			"$set": bson.M{"cookie": cookie},
+			"$max": bson.M{"lastcall": ts},
+		})
+	return err
+}
+
 func dbFnStatsDrop(ctx context.Context, cookie string, st *FnStats) error {
 	if !dbMayRemove(ctx) {
 		return dbNotAllowed
@@ -645,6 +679,10 @@ func dbConnect() error {
 	if err != nil {
 		return fmt.Errorf("No cookie index for mware: %s", err.Error())
 	}
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColWorkflows).EnsureIndex(index)
+	if err != nil {
+		return fmt.Errorf("No cookie index for workflows: %s", err.Error())
+	}
 	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColTCache).EnsureIndex(index)
 	if err != nil {
 		return fmt.Errorf("No cookie index for ten cache: %s", err.Error())
@@ -671,6 +709,76 @@ func dbConnect() error {
 		return fmt.Errorf("No name index for repos: %s", err.Error())
 	}
 
+	index.Key = []string{"cookie"}
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColDLQ).EnsureIndex(index)
+	if err != nil {
+		return fmt.Errorf("No cookie index for dead letters: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColInvs).EnsureIndex(index)
+	if err != nil {
+		return fmt.Errorf("No cookie index for invocations: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColDelayed).EnsureIndex(index)
+	if err != nil {
+		return fmt.Errorf("No cookie index for delayed calls: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColRetries).EnsureIndex(index)
+	if err != nil {
+		return fmt.Errorf("No cookie index for pending retries: %s", err.Error())
+	}
+
+	index.Key = []string{"at"}
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColDelayed).EnsureIndex(index)
+	if err != nil {
+		return fmt.Errorf("No time index for delayed calls: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColRetries).EnsureIndex(index)
+	if err != nil {
+		return fmt.Errorf("No time index for pending retries: %s", err.Error())
+	}
+
+	/* Entries are removed by mongo once the expire time comes */
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColInvs).EnsureIndex(mgo.Index{
+			Key:		[]string{"expire"},
+			ExpireAfter:	time.Second,
+		})
+	if err != nil {
+		return fmt.Errorf("No expire index for invocations: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColWfRuns).EnsureIndex(mgo.Index{Key: []string{"cookie"}})
+	if err != nil {
+		return fmt.Errorf("No cookie index for workflow runs: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColWfRuns).EnsureIndex(mgo.Index{
+			Key:		[]string{"expire"},
+			ExpireAfter:	time.Second,
+		})
+	if err != nil {
+		return fmt.Errorf("No expire index for workflow runs: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColIdemp).EnsureIndex(mgo.Index{
+			Key:		[]string{"cookie", "key"},
+			Unique:		true,
+		})
+	if err != nil {
+		return fmt.Errorf("No key index for idempotency keys: %s", err.Error())
+	}
+
+	err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColIdemp).EnsureIndex(mgo.Index{
+			Key:		[]string{"expire"},
+			ExpireAfter:	time.Second,
+		})
+	if err != nil {
+		return fmt.Errorf("No expire index for idempotency keys: %s", err.Error())
+	}
+
 	_, err = dbs.DB(gmgo.DBStateDB).C(gmgo.DBColLogs).UpdateAll(bson.M{}, bson.M{"$rename":bson.M{"fnid":"cookie"}})
 	if err != nil {
 		return fmt.Errorf("Cannot update logs field fnid to cookie")
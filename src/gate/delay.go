@@ -0,0 +1,314 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"errors"
+	"time"
+	"context"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/gate/mgo"
+	"swifty/common/xrest"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Delayed call is a one-shot background call of a fn that should
+ * happen at some given time. They live in the DB, so that gate
+ * restarts don't lose them, and are picked up by a poller. When
+ * several gates run, each entry is first claimed by one of them
+ * by setting the owner and the lease time. Claimed entries are
+ * removed after the call, if the gate dies in between the lease
+ * expires and another gate re-does the call.
+ */
+type DelayedCall struct {
+	ObjID		bson.ObjectId		`bson:"_id,omitempty"`
+	Cookie		string			`bson:"cookie"`
+	Tennant		string			`bson:"tennant"`
+	Alias		string			`bson:"alias,omitempty"`
+	Created		time.Time		`bson:"ts"`
+	At		time.Time		`bson:"at"`
+	Args		map[string]string	`bson:"args,omitempty"`
+	Owner		string			`bson:"owner,omitempty"`
+	Lease		time.Time		`bson:"lease,omitempty"`
+}
+
+var delayPoll time.Duration = time.Second
+var delayLease time.Duration = 15 * time.Minute
+var delayMaxEntries int = 1000
+var delayMaxAhead time.Duration = 30 * 24 * time.Hour
+
+var delayFull = errors.New("Too many delayed calls")
+
+func init() {
+	sysctl.AddTimeSysctl("fn_delayed_poll_period", &delayPoll)
+	sysctl.AddTimeSysctl("fn_delayed_lease", &delayLease)
+	sysctl.AddIntSysctl("fn_delayed_max_entries", &delayMaxEntries)
+	sysctl.AddTimeSysctl("fn_delayed_max_ahead", &delayMaxAhead)
+}
+
+func (dc *DelayedCall)toInfo() *swyapi.FunctionDelayed {
+	ret := &swyapi.FunctionDelayed {
+		Id:		dc.ObjID.Hex(),
+		Alias:		dc.Alias,
+		At:		dc.At.Format(time.RFC1123Z),
+		Args:		dc.Args,
+		Status:		"pending",
+	}
+
+	if dc.Owner != "" {
+		ret.Status = "running"
+	}
+
+	return ret
+}
+
+func delayParseAt(at string) (time.Time, error) {
+	t, err := time.Parse(time.RFC1123Z, at)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, at)
+	}
+
+	return t, err
+}
+
+func delayWhen(at string, delay uint) (time.Time, error) {
+	now := time.Now()
+	var t time.Time
+
+	switch {
+	case at != "" && delay != 0:
+		return t, errors.New("Both time and delay specified")
+	case at != "":
+		var err error
+
+		t, err = delayParseAt(at)
+		if err != nil {
+			return t, errors.New("Bad time value")
+		}
+	default:
+		t = now.Add(time.Duration(delay) * time.Millisecond)
+	}
+
+	if t.Sub(now) > delayMaxAhead {
+		return t, errors.New("Too far in the future")
+	}
+
+	return t, nil
+}
+
+/*
+ * Delayed calls may come from fns themselves (via thens), so
+ * the entry is put on our own behalf
+ */
+func delayPut(fn *FunctionDesc, alias string, at time.Time, args map[string]string) (*DelayedCall, error) {
+	ctx, done := mkContext("::delay")
+	defer done(ctx)
+
+	col := dbCol(ctx, gmgo.DBColDelayed)
+	dc := &DelayedCall {
+		ObjID:		bson.NewObjectId(),
+		Cookie:		fn.Cookie,
+		Tennant:	fn.SwoId.Tennant,
+		Alias:		alias,
+		Created:	time.Now(),
+		At:		at,
+		Args:		args,
+	}
+
+	err := col.Insert(dc)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Same as for DLQ, concurrent puts can't overrun the cap this way */
+	nr, err := col.Find(bson.M{"cookie": fn.Cookie, "_id": bson.M{"$lte": dc.ObjID}}).Count()
+	if err == nil && nr > delayMaxEntries {
+		err = delayFull
+	}
+	if err != nil {
+		col.RemoveId(dc.ObjID)
+		return nil, err
+	}
+
+	delayedCalls.WithLabelValues("put").Inc()
+	return dc, nil
+}
+
+func (fn *FunctionDesc)delayAdd(ctx context.Context, params *swyapi.FunctionDelayed) (*DelayedCall, *xrest.ReqErr) {
+	at, err := delayWhen(params.At, params.Delay)
+	if err != nil {
+		return nil, GateErrE(swyapi.GateBadRequest, err)
+	}
+
+	if params.Alias != "" {
+		if _, ok := fn.Aliases[params.Alias]; !ok {
+			return nil, GateErrM(swyapi.GateNotFound, "No such alias")
+		}
+	}
+
+	dc, err := delayPut(fn, params.Alias, at, params.Args)
+	if err != nil {
+		if err == delayFull {
+			return nil, GateErrE(swyapi.GateLimitHit, err)
+		}
+		return nil, GateErrD(err)
+	}
+
+	return dc, nil
+}
+
+func (fn *FunctionDesc)delayList(ctx context.Context) ([]*swyapi.FunctionDelayed, *xrest.ReqErr) {
+	var dcs []*DelayedCall
+
+	err := dbCol(ctx, gmgo.DBColDelayed).Find(bson.M{"cookie": fn.Cookie}).Sort("at").All(&dcs)
+	if err != nil {
+		return nil, GateErrD(err)
+	}
+
+	ret := []*swyapi.FunctionDelayed{}
+	for _, dc := range dcs {
+		ret = append(ret, dc.toInfo())
+	}
+
+	return ret, nil
+}
+
+func (fn *FunctionDesc)delayFind(ctx context.Context, id string) (*DelayedCall, *xrest.ReqErr) {
+	var dc DelayedCall
+
+	if !bson.IsObjectIdHex(id) {
+		return nil, GateErrM(swyapi.GateBadRequest, "Bad ID value")
+	}
+
+	err := dbCol(ctx, gmgo.DBColDelayed).Find(bson.M{"_id": bson.ObjectIdHex(id),
+				"cookie": fn.Cookie}).One(&dc)
+	if err != nil {
+		return nil, GateErrD(err)
+	}
+
+	return &dc, nil
+}
+
+func delayDel(ctx context.Context, dc *DelayedCall) *xrest.ReqErr {
+	if !dbMayRemove(ctx) {
+		return GateErrD(dbNotAllowed)
+	}
+
+	err := dbCol(ctx, gmgo.DBColDelayed).RemoveId(dc.ObjID)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	delayedCalls.WithLabelValues("cancel").Inc()
+	return nil
+}
+
+func delayRemove(ctx context.Context, fn *FunctionDesc) error {
+	if !dbMayRemove(ctx) {
+		return dbNotAllowed
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColDelayed).RemoveAll(bson.M{"cookie": fn.Cookie})
+	return maybe(err)
+}
+
+/*
+ * Atomically marks one due entry as ours. Entries claimed by
+ * others and not yet expired are not touched.
+ */
+func leaseClaim(ctx context.Context, col string, res interface{}) error {
+	now := time.Now()
+	_, err := dbCol(ctx, col).Find(bson.M{
+			"at": bson.M{"$lte": now},
+			"$or": []bson.M{
+				bson.M{"owner": bson.M{"$exists": false}},
+				bson.M{"lease": bson.M{"$lt": now}},
+			}}).Sort("at").Apply(mgo.Change{
+				Update: bson.M{"$set": bson.M{"owner": gateInstance, "lease": now.Add(delayLease)}},
+				ReturnNew: true,
+			}, res)
+	return err
+}
+
+func delayClaim(ctx context.Context) (*DelayedCall, error) {
+	var dc DelayedCall
+
+	err := leaseClaim(ctx, gmgo.DBColDelayed, &dc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dc, nil
+}
+
+func (dc *DelayedCall)run() {
+	ctx, done := mkContext("::delayed")
+	defer done(ctx)
+
+	var fn FunctionDesc
+
+	err := dbFind(ctx, bson.M{"cookie": dc.Cookie}, &fn)
+	if err != nil {
+		danglingEvents.WithLabelValues("delayed").Inc()
+		ctxlog(ctx).Errorf("Can't find FN %s to run delayed call", dc.Cookie)
+		goto out
+	}
+
+	if fn.State != DBFuncStateRdy {
+		danglingEvents.WithLabelValues("delayed").Inc()
+		goto out
+	}
+
+	fn.alias = dc.Alias
+	doRunBg(ctx, &fn, "delayed", &swyapi.FunctionRun{Args: dc.Args})
+	delayedCalls.WithLabelValues("run").Inc()
+out:
+	err = dbCol(ctx, gmgo.DBColDelayed).Remove(bson.M{"_id": dc.ObjID, "owner": gateInstance})
+	if err != nil && !dbNF(err) {
+		ctxlog(ctx).Errorf("Can't remove delayed call %s: %s", dc.ObjID.Hex(), err.Error())
+	}
+}
+
+func delayPoller() {
+	for {
+		time.Sleep(delayPoll)
+
+		ctx, done := mkContext("::delaypoll")
+		for {
+			dc, err := delayClaim(ctx)
+			if err != nil {
+				if !dbNF(err) {
+					ctxlog(ctx).Errorf("Can't claim delayed call: %s", err.Error())
+				}
+				break
+			}
+
+			go dc.run()
+		}
+
+		for {
+			rt, err := retryClaim(ctx)
+			if err != nil {
+				if !dbNF(err) {
+					ctxlog(ctx).Errorf("Can't claim pending retry: %s", err.Error())
+				}
+				break
+			}
+
+			go rt.run()
+		}
+		done(ctx)
+	}
+}
+
+func delayInit(ctx context.Context) error {
+	go delayPoller()
+	return nil
+}
@@ -0,0 +1,227 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"context"
+	"net/url"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/gate/mgo"
+	"swifty/common/xrest"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Background calls that failed all the attempts from fn's retry
+ * policy are kept here until replayed or purged by user.
+ */
+type DLQEntry struct {
+	ObjID		bson.ObjectId		`bson:"_id,omitempty"`
+	Cookie		string			`bson:"cookie"`
+	Event		string			`bson:"event"`
+	Alias		string			`bson:"alias,omitempty"`
+	Time		time.Time		`bson:"ts"`
+	Attempts	uint			`bson:"attempts"`
+	Reason		string			`bson:"reason"`
+	Args		*swyapi.FunctionRun	`bson:"args"`
+}
+
+var dlqMaxEntries int = 1000
+
+func init() {
+	sysctl.AddIntSysctl("fn_dlq_max_entries", &dlqMaxEntries)
+}
+
+func (e *DLQEntry)toInfo() *swyapi.FunctionDLQEntry {
+	return &swyapi.FunctionDLQEntry {
+		Id:		e.ObjID.Hex(),
+		Event:		e.Event,
+		Alias:		e.Alias,
+		Ts:		e.Time.Format(time.RFC1123Z),
+		Attempts:	e.Attempts,
+		Reason:		e.Reason,
+		Args:		e.Args,
+	}
+}
+
+func dlqPut(fn *FunctionDesc, event string, args *swyapi.FunctionRun, attempts uint, why string) {
+	/*
+	 * Callers' contexts may be nobody-s (url calls, websockets),
+	 * so put the entry on our own behalf
+	 */
+	ctx, done := mkContext("::dlq")
+	defer done(ctx)
+
+	/* The entry is still worth keeping, just without the body */
+	if !argsStorable(args) {
+		a := *args
+		a.Body = ""
+		a.Raw = nil
+		a.Form = nil
+		a.Files = nil
+		args = &a
+		why += " (body too big to keep, dropped)"
+	}
+
+	/*
+	 * Insert first and then check how many entries are ahead of
+	 * this one, so that concurrent puts never overrun the cap
+	 */
+	id := bson.NewObjectId()
+	col := dbCol(ctx, gmgo.DBColDLQ)
+	err := col.Insert(&DLQEntry{
+		ObjID:		id,
+		Cookie:		fn.Cookie,
+		Event:		event,
+		Alias:		fn.alias,
+		Time:		time.Now(),
+		Attempts:	attempts,
+		Reason:		why,
+		Args:		args,
+	})
+	if err == nil {
+		var nr int
+
+		nr, err = col.Find(bson.M{"cookie": fn.Cookie, "_id": bson.M{"$lte": id}}).Count()
+		if err == nil && nr > dlqMaxEntries {
+			err = errors.New("DLQ is full")
+		}
+		if err != nil {
+			col.RemoveId(id)
+		}
+	}
+	if err != nil {
+		ctxlog(ctx).Errorf("Can't put %s event to %s DLQ: %s", event, fn.SwoId.Str(), err.Error())
+		return
+	}
+
+	deadLetters.WithLabelValues(event).Inc()
+	logSaveEvent(ctx, fn.Cookie, fmt.Sprintf("%s call moved to DLQ after %d attempts: %s", event, attempts, why))
+}
+
+func dlqRemove(ctx context.Context, fn *FunctionDesc) error {
+	if !dbMayRemove(ctx) {
+		return dbNotAllowed
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColDLQ).RemoveAll(bson.M{"cookie": fn.Cookie})
+	return maybe(err)
+}
+
+func (fn *FunctionDesc)dlqReq(q url.Values) (bson.M, *xrest.ReqErr) {
+	dq := bson.M{"cookie": fn.Cookie}
+
+	if id := q.Get("id"); id != "" {
+		if !bson.IsObjectIdHex(id) {
+			return nil, GateErrM(swyapi.GateBadRequest, "Bad ID value")
+		}
+		dq["_id"] = bson.ObjectIdHex(id)
+	}
+
+	return dq, nil
+}
+
+func (fn *FunctionDesc)dlqFind(ctx context.Context, q url.Values) ([]*DLQEntry, *xrest.ReqErr) {
+	var ents []*DLQEntry
+
+	dq, cerr := fn.dlqReq(q)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	err := dbCol(ctx, gmgo.DBColDLQ).Find(dq).Sort("ts").All(&ents)
+	if err != nil {
+		return nil, GateErrD(err)
+	}
+
+	return ents, nil
+}
+
+func (fn *FunctionDesc)dlqList(ctx context.Context, q url.Values) ([]*swyapi.FunctionDLQEntry, *xrest.ReqErr) {
+	ents, cerr := fn.dlqFind(ctx, q)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	ret := []*swyapi.FunctionDLQEntry{}
+	for _, e := range ents {
+		ret = append(ret, e.toInfo())
+	}
+
+	return ret, nil
+}
+
+func (fn *FunctionDesc)dlqPurge(ctx context.Context, q url.Values) *xrest.ReqErr {
+	dq, cerr := fn.dlqReq(q)
+	if cerr != nil {
+		return cerr
+	}
+
+	if !dbMayRemove(ctx) {
+		return GateErrD(dbNotAllowed)
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColDLQ).RemoveAll(dq)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	return nil
+}
+
+func (fn *FunctionDesc)dlqReplay(ctx context.Context, q url.Values) *xrest.ReqErr {
+	if fn.State != DBFuncStateRdy {
+		return GateErrM(swyapi.GateNotAvail, "Function not ready (yet)")
+	}
+
+	ents, cerr := fn.dlqFind(ctx, q)
+	if cerr != nil {
+		return cerr
+	}
+
+	if !dbMayRemove(ctx) {
+		return GateErrD(dbNotAllowed)
+	}
+
+	/*
+	 * Entries are removed before the call, so that concurrent
+	 * replays don't fire one twice. If the call fails again it
+	 * gets back to DLQ as a new entry.
+	 */
+	var rents []*DLQEntry
+	for _, e := range ents {
+		err := dbCol(ctx, gmgo.DBColDLQ).RemoveId(e.ObjID)
+		if err != nil {
+			if dbNF(err) {
+				continue
+			}
+			return GateErrD(err)
+		}
+
+		if e.Args == nil {
+			e.Args = &swyapi.FunctionRun{}
+		}
+		rents = append(rents, e)
+	}
+
+	go func() {
+		rctx, done := mkContext("::replay")
+		defer done(rctx)
+
+		for _, e := range rents {
+			efn := *fn
+			efn.alias = e.Alias
+			doRunBg(rctx, &efn, e.Event, e.Args)
+		}
+	}()
+
+	return nil
+}
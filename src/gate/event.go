@@ -27,6 +27,7 @@ var evtHandlers = map[string]*EventOps {
 	"s3":	&s3EOps,
 	"url":	&urlEOps,
 	"websocket": &wsEOps,
+	"mq":	&mqEOps,
 }
 
 type FnEventDesc struct {
@@ -38,6 +39,8 @@ type FnEventDesc struct {
 	Cron		*FnEventCron	`bson:"cron,omitempty"`
 	S3		*FnEventS3	`bson:"s3,omitempty"`
 	WS		*FnEventWebsock	`bson:"ws,omitempty"`
+	MQ		*FnEventMQ	`bson:"mq,omitempty"`
+	Alias		string		`bson:"alias,omitempty"`
 }
 
 type Trigger struct {
@@ -60,7 +63,17 @@ func (t *Trigger)Info(ctx context.Context, q url.Values, details bool) (interfac
 func (t *Trigger)Upd(context.Context, interface{}) *xrest.ReqErr { return GateErrC(swyapi.GateNotAvail) }
 
 func eventsInit(ctx context.Context) error {
-	return cronInit(ctx)
+	err := cronInit(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = delayInit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return mqEventsInit(ctx)
 }
 
 type Triggers struct {
@@ -74,6 +87,16 @@ func (ts Triggers)Create(ctx context.Context, p interface{}) (xrest.Obj, *xrest.
 		return nil, cerr
 	}
 
+	if ed.Alias != "" {
+		if ed.Source == "url" {
+			return nil, GateErrM(swyapi.GateBadRequest, "URL trigger can't call alias, use router")
+		}
+
+		if _, ok := ts.fn.Aliases[ed.Alias]; !ok {
+			return nil, GateErrM(swyapi.GateNotFound, "No such alias")
+		}
+	}
+
 	return &Trigger{ed, ts.fn}, nil
 }
 
@@ -139,6 +162,7 @@ func (e *FnEventDesc)toInfo(fn *FunctionDesc) *swyapi.FunctionEvent {
 		Id:	e.ObjID.Hex(),
 		Name:	e.Name,
 		Source:	e.Source,
+		Alias:	e.Alias,
 	}
 
 	if e.Source == "url" {
@@ -146,10 +170,7 @@ func (e *FnEventDesc)toInfo(fn *FunctionDesc) *swyapi.FunctionEvent {
 	}
 
 	if e.Cron != nil {
-		ae.Cron = &swyapi.FunctionEventCron {
-			Tab: e.Cron.Tab,
-			Args: e.Cron.Args,
-		}
+		ae.Cron = e.Cron.toInfo()
 	}
 
 	if e.S3 != nil {
@@ -160,6 +181,16 @@ func (e *FnEventDesc)toInfo(fn *FunctionDesc) *swyapi.FunctionEvent {
 		}
 	}
 
+	if e.MQ != nil {
+		ae.MQ = &swyapi.FunctionEventMQ {
+			MwName: e.MQ.MwName,
+			Queue: e.MQ.Queue,
+			Prefetch: uint(e.MQ.Prefetch),
+			Concurrency: uint(e.MQ.Workers),
+			DLQ: e.MQ.DLQ,
+		}
+	}
+
 	return &ae
 }
 
@@ -173,6 +204,8 @@ func guessSource(evt *swyapi.FunctionEvent) string {
 		return "s3"
 	case evt.WS != nil:
 		return "websocket"
+	case evt.MQ != nil:
+		return "mq"
 	default:
 		return ""
 	}
@@ -183,6 +216,7 @@ func getEventDesc(evt *swyapi.FunctionEvent) (*FnEventDesc, *xrest.ReqErr) {
 	ed := &FnEventDesc{
 		Name: evt.Name,
 		Source: source,
+		Alias: evt.Alias,
 	}
 
 	h, ok := evtHandlers[source]
@@ -210,7 +244,7 @@ func (ed *FnEventDesc)Add(ctx context.Context, fn *FunctionDesc) *xrest.ReqErr {
 	}
 
 	h := evtHandlers[ed.Source]
-	h.start(ctx, fn, ed)
+	err = h.start(ctx, fn, ed)
 	if err != nil {
 		dbRemove(ctx, ed)
 		return GateErrM(swyapi.GateGenErr, "Can't setup event")
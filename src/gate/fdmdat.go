@@ -19,11 +19,17 @@ var tdat sync.Map
 
 type FnMemData struct {
 	mem	uint
+	idle	time.Duration
+	scale	*scaleMemData
+	warmup	bool
 	depname	string
 	fnid	string
 	ac	*AuthCtx
 	bd	BalancerDat
+	cn	*canaryMemData
+	aliases	map[string]string
 	crl	*xrl.RL
+	conc	concLimit
 	td	*TenantMemData
 	stats	FnStats
 	lock	sync.Mutex
@@ -210,7 +216,14 @@ func fndatGetOrInit(ctx context.Context, cookie string, fn *FunctionDesc, forRem
 		nret.crl = xrl.MakeRL(fn.Size.Burst, fn.Size.Rate)
 	}
 
+	nret.conc.max = fn.Size.MaxConc
 	nret.mem = fn.Size.Mem
+	nret.idle = fn.Size.idleTmo()
+	nret.scale = fn.Size.toScaleMem()
+	nret.bd.scaled = nret.scale.min
+	nret.warmup = fn.Size.Warmup
+	nret.cn = fn.Canary.toMem(nil)
+	nret.aliases = fn.Aliases
 	nret.depname = fn.DepName()
 	nret.fnid = fn.Cookie
 	nret.id = fn.SwoId
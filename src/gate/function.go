@@ -97,6 +97,31 @@ type FnSizeDesc struct {
 	Tmo		uint		`bson:"timeout"`
 	Burst		uint		`bson:"burst"`
 	Rate		uint		`bson:"rate"`
+	Idle		uint		`bson:"idle,omitempty"`	// sec
+	Warm		uint		`bson:"warm,omitempty"`
+	Warmup		bool		`bson:"warmup,omitempty"`
+	Scale		*FnScaleDesc	`bson:"scale,omitempty"`
+	MaxConc		uint		`bson:"maxconc,omitempty"`
+}
+
+type FnRetryDesc struct {
+	Attempts	uint		`bson:"attempts"`
+	Backoff		uint		`bson:"backoff"`		// msec
+	MaxBackoff	uint		`bson:"max_backoff"`	// msec
+	Codes		[]int		`bson:"codes,omitempty"`
+}
+
+func (rp *FnRetryDesc)toInfo() *swyapi.FunctionRetry {
+	if rp == nil {
+		return &swyapi.FunctionRetry{}
+	}
+
+	return &swyapi.FunctionRetry {
+		Attempts:	rp.Attempts,
+		Backoff:	rp.Backoff,
+		MaxBackoff:	rp.MaxBackoff,
+		Codes:		rp.Codes,
+	}
 }
 
 func (fn *FunctionDesc)k8sId() string {
@@ -123,8 +148,14 @@ type FunctionDesc struct {
 	Code		FnCodeDesc	`bson:"code"`
 	Src		FnSrcDesc	`bson:"src"`
 	Size		FnSizeDesc	`bson:"size"`
+	Retry		*FnRetryDesc	`bson:"retry,omitempty"`
+	Canary		*FnCanaryDesc	`bson:"canary,omitempty"`
+	Versions	[]*FnVersionDesc	`bson:"versions,omitempty"`
+	Aliases		map[string]string	`bson:"aliases,omitempty"`
 	AuthCtx		string		`bson:"authctx,omitempty"`
 	UserData	string		`bson:"userdata,omitempty"`
+
+	alias		string		// fn@alias is called, not in DB
 }
 
 type Functions struct {}
@@ -166,6 +197,7 @@ func (fn *FunctionDesc)toMInfo(ctx context.Context) *swyapi.FunctionMdat {
 		}
 
 		fid.BR = []uint { uint(fdm.bd.rover[0]), uint(fdm.bd.rover[1]), uint(fdm.bd.goal) }
+		fid.Conc = fdm.conc.status()
 	}
 	fid.Cookie = fn.Cookie
 
@@ -312,7 +344,16 @@ func (fn *FunctionDesc)toInfo(ctx context.Context, details bool, periods int) (*
 			Timeout:	fn.Size.Tmo,
 			Rate:		fn.Size.Rate,
 			Burst:		fn.Size.Burst,
+			Idle:		fn.Size.Idle,
+			Warm:		fn.Size.Warm,
+			Warmup:		fn.Size.Warmup,
+			Scale:		fn.Size.Scale.toInfo(),
+			MaxConc:	fn.Size.MaxConc,
 		}
+		if fn.Retry != nil {
+			fi.Retry = fn.Retry.toInfo()
+		}
+		fi.Aliases = fn.Aliases
 	}
 
 	return fi, nil
@@ -361,6 +402,13 @@ func getFunctionDesc(id *SwoId, p_add *swyapi.FunctionAdd) (*FunctionDesc, *xres
 		return nil, GateErrE(swyapi.GateBadRequest, err)
 	}
 
+	if p_add.Retry != nil {
+		err = fnFixRetry(p_add.Retry)
+		if err != nil {
+			return nil, GateErrE(swyapi.GateBadRequest, err)
+		}
+	}
+
 	if !rtLangEnabled(p_add.Code.Lang) {
 		return nil, GateErrM(swyapi.GateBadRequest, "Unsupported language")
 	}
@@ -374,11 +422,15 @@ func getFunctionDesc(id *SwoId, p_add *swyapi.FunctionAdd) (*FunctionDesc, *xres
 	fn := &FunctionDesc {
 		SwoId: *id,
 		Size:		FnSizeDesc {
-			Replicas:	1,
 			Mem:		p_add.Size.Memory,
 			Tmo:		p_add.Size.Timeout,
 			Rate:		p_add.Size.Rate,
 			Burst:		p_add.Size.Burst,
+			Idle:		p_add.Size.Idle,
+			Warm:		p_add.Size.Warm,
+			Warmup:		p_add.Size.Warmup,
+			Scale:		scaleDescFrom(p_add.Size.Scale),
+			MaxConc:	p_add.Size.MaxConc,
 		},
 		Code:		FnCodeDesc {
 			Lang:		p_add.Code.Lang,
@@ -391,6 +443,17 @@ func getFunctionDesc(id *SwoId, p_add *swyapi.FunctionAdd) (*FunctionDesc, *xres
 		UserData:	p_add.UserData,
 	}
 
+	fn.Size.Replicas = fn.Size.minReplicas()
+
+	if p_add.Retry != nil && p_add.Retry.Attempts != 0 {
+		fn.Retry = &FnRetryDesc {
+			Attempts:	p_add.Retry.Attempts,
+			Backoff:	p_add.Retry.Backoff,
+			MaxBackoff:	p_add.Retry.MaxBackoff,
+			Codes:		p_add.Retry.Codes,
+		}
+	}
+
 	fn.Cookie = fn.SwoId.Cookie()
 	return fn, nil
 }
@@ -541,6 +604,83 @@ func fnFixSize(sz *swyapi.FunctionSize) error {
 		return errors.New("Too small/big memory size")
 	}
 
+	if sz.Idle != 0 && sz.Idle < uint(fnIdleMin) {
+		return errors.New("Too small idle timeout")
+	}
+
+	if sz.Scale != nil {
+		err := fnFixScale(sz.Scale)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sz.Warm != 0 {
+		if sz.Idle != 0 {
+			return errors.New("Warm pool cannot be scaled to zero")
+		}
+
+		if sz.Warm > uint(conf.Runtime.MaxReplicas) ||
+				(sz.Scale != nil && sz.Warm > sz.Scale.Max) {
+			return errors.New("Too big warm pool")
+		}
+	}
+
+	return nil
+}
+
+func fnFixRetry(rp *swyapi.FunctionRetry) error {
+	if rp.Attempts > uint(retryAttemptsMax) {
+		return errors.New("Too many attempts")
+	}
+
+	if rp.Backoff == 0 {
+		rp.Backoff = uint(retryBackoffDef)
+	}
+
+	if rp.MaxBackoff == 0 {
+		rp.MaxBackoff = uint(retryBackoffMax)
+	} else if rp.MaxBackoff > uint(retryBackoffMax) {
+		return errors.New("Too big max backoff")
+	}
+
+	if rp.Backoff > rp.MaxBackoff {
+		return errors.New("Backoff is bigger than max one")
+	}
+
+	for _, c := range rp.Codes {
+		if c <= 0 {
+			return errors.New("Bad retry code")
+		}
+	}
+
+	return nil
+}
+
+func (fn *FunctionDesc)setRetry(ctx context.Context, rp *swyapi.FunctionRetry) *xrest.ReqErr {
+	var nrp *FnRetryDesc
+
+	err := fnFixRetry(rp)
+	if err != nil {
+		return GateErrE(swyapi.GateBadRequest, err)
+	}
+
+	/* Zero attempts means "no policy", i.e. single call */
+	if rp.Attempts != 0 {
+		nrp = &FnRetryDesc {
+			Attempts:	rp.Attempts,
+			Backoff:	rp.Backoff,
+			MaxBackoff:	rp.MaxBackoff,
+			Codes:		rp.Codes,
+		}
+	}
+
+	err = dbUpdatePart(ctx, fn, bson.M{"retry": nrp})
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	fn.Retry = nrp
 	return nil
 }
 
@@ -593,6 +733,11 @@ func (fn *FunctionDesc)setSize(ctx context.Context, sz *swyapi.FunctionSize) *xr
 	restart := false
 	mfix := false
 	rlfix := false
+	ifix := false
+	sfix := false
+	wfix := false
+	cfix := false
+	oldmin := fn.Size.Replicas
 
 	err := fnFixSize(sz)
 	if err != nil {
@@ -620,6 +765,41 @@ func (fn *FunctionDesc)setSize(ctx context.Context, sz *swyapi.FunctionSize) *xr
 		rlfix = true
 	}
 
+	if sz.Idle != fn.Size.Idle {
+		fn.Size.Idle = sz.Idle
+		update["size.idle"] = sz.Idle
+		ifix = true
+	}
+
+	if sc := scaleDescFrom(sz.Scale); !sc.eq(fn.Size.Scale) {
+		fn.Size.Scale = sc
+		update["size.scale"] = sc
+		sfix = true
+	}
+
+	if sz.Warm != fn.Size.Warm {
+		fn.Size.Warm = sz.Warm
+		update["size.warm"] = sz.Warm
+		sfix = true
+	}
+
+	if sfix {
+		fn.Size.Replicas = fn.Size.minReplicas()
+		update["size.replicas"] = fn.Size.Replicas
+	}
+
+	if sz.Warmup != fn.Size.Warmup {
+		fn.Size.Warmup = sz.Warmup
+		update["size.warmup"] = sz.Warmup
+		wfix = true
+	}
+
+	if sz.MaxConc != fn.Size.MaxConc {
+		fn.Size.MaxConc = sz.MaxConc
+		update["size.maxconc"] = sz.MaxConc
+		cfix = true
+	}
+
 	if len(update) == 0 {
 		return nil
 	}
@@ -629,7 +809,7 @@ func (fn *FunctionDesc)setSize(ctx context.Context, sz *swyapi.FunctionSize) *xr
 		return GateErrD(err)
 	}
 
-	if rlfix || mfix {
+	if rlfix || mfix || ifix || sfix || wfix || cfix {
 		fdm := memdGetCond(fn.Cookie)
 		if fdm == nil {
 			goto skip
@@ -639,6 +819,22 @@ func (fn *FunctionDesc)setSize(ctx context.Context, sz *swyapi.FunctionSize) *xr
 			fdm.mem = fn.Size.Mem
 		}
 
+		if ifix {
+			fdm.idle = fn.Size.idleTmo()
+		}
+
+		if sfix {
+			fdm.scale = fn.Size.toScaleMem()
+		}
+
+		if wfix {
+			fdm.warmup = fn.Size.Warmup
+		}
+
+		if cfix {
+			fdm.conc.setMax(fn.Size.MaxConc)
+		}
+
 		if rlfix {
 			if fn.Size.Rate != 0 {
 				if fdm.crl != nil {
@@ -657,6 +853,23 @@ func (fn *FunctionDesc)setSize(ctx context.Context, sz *swyapi.FunctionSize) *xr
 		;
 	}
 
+	if ifix && fn.Size.Idle == 0 && fn.State == DBFuncStateRdy {
+		/* Might have been scaled to zero, nobody would wake it up */
+		k8sDepScaleUp(fn.DepName(), uint32(fn.Size.Replicas))
+	}
+
+	if sfix && fn.State == DBFuncStateRdy {
+		/* Running scaler (if any) will shrink down to new min itself */
+		if fn.Size.Replicas > oldmin {
+			k8sDepScaleUp(fn.DepName(), uint32(fn.Size.Replicas))
+		} else if fn.Size.Replicas < oldmin {
+			fdm := memdGetCond(fn.Cookie)
+			if fdm == nil || fdm.bd.wakeup == nil {
+				k8sDepScaleDown(fn.DepName(), uint32(fn.Size.Replicas))
+			}
+		}
+	}
+
 	if restart && fn.State == DBFuncStateRdy {
 		k8sUpdate(ctx, &conf, fn)
 	}
@@ -823,6 +1036,10 @@ func (fn *FunctionDesc)updateSources(ctx context.Context, src *swyapi.FunctionSo
 		return GateErrM(swyapi.GateGenErr, "Function should be running or stalled")
 	}
 
+	if fn.Canary != nil {
+		return GateErrM(swyapi.GateNotAvail, "Canary is running, promote or roll it back first")
+	}
+
 	err = updateSources(ctx, fn, src)
 	if err != nil {
 		return GateErrE(swyapi.GateGenErr, err)
@@ -923,6 +1140,36 @@ func (fn *FunctionDesc)Del(ctx context.Context) *xrest.ReqErr {
 		goto later
 	}
 
+	err = dlqRemove(ctx, fn)
+	if err != nil {
+		ctxlog(ctx).Errorf("dlq %s remove error: %s", fn.SwoId.Str(), err.Error())
+		goto later
+	}
+
+	err = invRemove(ctx, fn)
+	if err != nil {
+		ctxlog(ctx).Errorf("invocations %s remove error: %s", fn.SwoId.Str(), err.Error())
+		goto later
+	}
+
+	err = delayRemove(ctx, fn)
+	if err != nil {
+		ctxlog(ctx).Errorf("delayed calls %s remove error: %s", fn.SwoId.Str(), err.Error())
+		goto later
+	}
+
+	err = retryRemove(ctx, fn)
+	if err != nil {
+		ctxlog(ctx).Errorf("pending retries %s remove error: %s", fn.SwoId.Str(), err.Error())
+		goto later
+	}
+
+	err = idempRemove(ctx, fn)
+	if err != nil {
+		ctxlog(ctx).Errorf("idempotency keys %s remove error: %s", fn.SwoId.Str(), err.Error())
+		goto later
+	}
+
 	err = removeSources(ctx, fn)
 	if err != nil {
 		ctxlog(ctx).Errorf("sources %s remove error: %s", fn.SwoId.Str(), err.Error())
@@ -1076,6 +1323,11 @@ func (_ *FnSzProp)Info(ctx context.Context, o xrest.Obj, q url.Values) (interfac
 		Timeout:	uint(fn.Size.Tmo),
 		Rate:		fn.Size.Rate,
 		Burst:		fn.Size.Burst,
+		Idle:		fn.Size.Idle,
+		Warm:		fn.Size.Warm,
+		Warmup:		fn.Size.Warmup,
+		Scale:		fn.Size.Scale.toInfo(),
+		MaxConc:	fn.Size.MaxConc,
 	}, nil
 }
 
@@ -1083,6 +1335,16 @@ func (_ *FnSzProp)Upd(ctx context.Context, o xrest.Obj, p interface{}) *xrest.Re
 	return o.(*FunctionDesc).setSize(ctx, p.(*swyapi.FunctionSize))
 }
 
+type FnRetryProp struct { }
+
+func (_ *FnRetryProp)Info(ctx context.Context, o xrest.Obj, q url.Values) (interface{}, *xrest.ReqErr) {
+	return o.(*FunctionDesc).Retry.toInfo(), nil
+}
+
+func (_ *FnRetryProp)Upd(ctx context.Context, o xrest.Obj, p interface{}) *xrest.ReqErr {
+	return o.(*FunctionDesc).setRetry(ctx, p.(*swyapi.FunctionRetry))
+}
+
 type FnSrcProp struct { }
 
 func (_ *FnSrcProp)Info(ctx context.Context, o xrest.Obj, q url.Values) (interface{}, *xrest.ReqErr) {
@@ -1111,12 +1373,19 @@ func (_ *FnStatsProp)Info(ctx context.Context, o xrest.Obj, q url.Values) (inter
 		return nil, GateErrC(swyapi.GateBadRequest)
 	}
 
-	stats, cerr := o.(*FunctionDesc).getStats(ctx, periods)
+	fn := o.(*FunctionDesc)
+	stats, cerr := fn.getStats(ctx, periods)
 	if cerr != nil {
 		return nil, cerr
 	}
 
-	return &swyapi.FunctionStatsResp{ Stats: stats }, nil
+	resp := &swyapi.FunctionStatsResp{ Stats: stats }
+	if fdm := memdGetCond(fn.Cookie); fdm != nil {
+		resp.Scaling = fdm.scalingStatus(ctx)
+		resp.Concurrency = fdm.conc.status()
+	}
+
+	return resp, nil
 }
 
 func (_ *FnStatsProp)Upd(ctx context.Context, o xrest.Obj, p interface{}) *xrest.ReqErr {
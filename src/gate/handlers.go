@@ -14,10 +14,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"strconv"
 	"context"
 	"time"
 	"fmt"
 	"io"
+	"errors"
 
 	"swifty/apis"
 	"swifty/gate/mgo"
@@ -132,6 +134,11 @@ func handleProjectDel(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return xer
 	}
 
+	xer = delAll(ctx, q, Workflows{})
+	if xer != nil {
+		return xer
+	}
+
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
@@ -318,6 +325,302 @@ func handleFunctionWait(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	return nil
 }
 
+func handleFunctionRetry(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	var rp swyapi.FunctionRetry
+	return xrest.HandleProp(ctx, w, r, Functions{}, &FnRetryProp{}, &rp)
+}
+
+func handleFunctionDLQ(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	fn := fo.(*FunctionDesc)
+
+	switch r.Method {
+	case "GET":
+		ents, cerr := fn.dlqList(ctx, r.URL.Query())
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, ents)
+
+	case "DELETE":
+		cerr = fn.dlqPurge(ctx, r.URL.Query())
+		if cerr != nil {
+			return cerr
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
+func handleFunctionDLQReplay(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	cerr = fo.(*FunctionDesc).dlqReplay(ctx, r.URL.Query())
+	if cerr != nil {
+		return cerr
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleFunctionDelayed(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	fn := fo.(*FunctionDesc)
+
+	switch r.Method {
+	case "GET":
+		dcs, cerr := fn.delayList(ctx)
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, dcs)
+
+	case "POST":
+		var params swyapi.FunctionDelayed
+
+		err := xhttp.RReq(r, &params)
+		if err != nil {
+			return GateErrE(swyapi.GateBadRequest, err)
+		}
+
+		dc, cerr := fn.delayAdd(ctx, &params)
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, dc.toInfo())
+	}
+
+	return nil
+}
+
+func handleFunctionDelayedCall(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	dc, cerr := fo.(*FunctionDesc).delayFind(ctx, mux.Vars(r)["did"])
+	if cerr != nil {
+		return cerr
+	}
+
+	switch r.Method {
+	case "GET":
+		return xrest.Respond(ctx, w, dc.toInfo())
+
+	case "DELETE":
+		cerr = delayDel(ctx, dc)
+		if cerr != nil {
+			return cerr
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
+func handleFunctionCanary(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	fn := fo.(*FunctionDesc)
+
+	switch r.Method {
+	case "GET":
+		if fn.Canary == nil {
+			return GateErrM(swyapi.GateNotFound, "No canary running")
+		}
+
+		return xrest.Respond(ctx, w, fn.canaryInfo())
+
+	case "POST", "PUT":
+		var params swyapi.FunctionCanary
+
+		err := xhttp.RReq(r, &params)
+		if err != nil {
+			return GateErrE(swyapi.GateBadRequest, err)
+		}
+
+		if r.Method == "POST" {
+			cerr = fn.startCanary(ctx, &params)
+		} else {
+			cerr = fn.updateCanary(ctx, &params)
+		}
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, fn.canaryInfo())
+
+	case "DELETE":
+		cerr = fn.rollbackCanary(ctx)
+		if cerr != nil {
+			return cerr
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
+func handleFunctionCanaryPromote(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	cerr = fo.(*FunctionDesc).promoteCanary(ctx)
+	if cerr != nil {
+		return cerr
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleFunctionVersions(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	fn := fo.(*FunctionDesc)
+
+	switch r.Method {
+	case "GET":
+		return xrest.Respond(ctx, w, fn.versionsInfo())
+
+	case "POST":
+		var params swyapi.FunctionVersion
+
+		err := xhttp.RReq(r, &params)
+		if err != nil {
+			return GateErrE(swyapi.GateBadRequest, err)
+		}
+
+		vi, cerr := fn.publishVersion(ctx, params.Version)
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, vi)
+	}
+
+	return nil
+}
+
+func handleFunctionVersion(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	cerr = fo.(*FunctionDesc).unpublishVersion(ctx, mux.Vars(r)["ver"])
+	if cerr != nil {
+		return cerr
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleFunctionAliases(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	fn := fo.(*FunctionDesc)
+
+	switch r.Method {
+	case "GET":
+		return xrest.Respond(ctx, w, fn.aliasesInfo())
+
+	case "POST":
+		var params swyapi.FunctionAlias
+
+		err := xhttp.RReq(r, &params)
+		if err != nil {
+			return GateErrE(swyapi.GateBadRequest, err)
+		}
+
+		cerr = fn.setAlias(ctx, params.Name, params.Version, true)
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, &params)
+	}
+
+	return nil
+}
+
+func handleFunctionAlias(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	fo, cerr := Functions{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	fn := fo.(*FunctionDesc)
+	name := mux.Vars(r)["alias"]
+
+	switch r.Method {
+	case "GET":
+		ver, ok := fn.Aliases[name]
+		if !ok {
+			return GateErrM(swyapi.GateNotFound, "No such alias")
+		}
+
+		return xrest.Respond(ctx, w, &swyapi.FunctionAlias{Name: name, Version: ver})
+
+	case "PUT":
+		var params swyapi.FunctionAlias
+
+		err := xhttp.RReq(r, &params)
+		if err != nil {
+			return GateErrE(swyapi.GateBadRequest, err)
+		}
+
+		cerr = fn.setAlias(ctx, name, params.Version, false)
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, &swyapi.FunctionAlias{Name: name, Version: params.Version})
+
+	case "DELETE":
+		cerr = fn.delAlias(ctx, name)
+		if cerr != nil {
+			return cerr
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
 func handleFunctionSources(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
 	var src swyapi.FunctionSources
 	return xrest.HandleProp(ctx, w, r, Functions{}, &FnSrcProp{}, &src)
@@ -447,6 +750,101 @@ func handleFunctionRun(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		params.Method = &r.Method /* POST */
 	}
 
+	var keep *swyapi.WdogFunctionRunResult
+	var ie *IdempEntry
+
+	/* Custom sources runs are tests, no need to dedup them */
+	if ikey := idempKey(r); ikey != "" && suff == "" {
+		var code int
+
+		ie, code, err = idempBegin(ctx, fn.Cookie, ikey, idempHash(&params))
+		if err != nil {
+			switch code {
+			case http.StatusConflict:
+				return GateErrE(swyapi.GateNotAvail, err)
+			case http.StatusBadRequest:
+				return GateErrE(swyapi.GateBadRequest, err)
+			case http.StatusUnprocessableEntity:
+				http.Error(w, err.Error(), code)
+				return nil
+			}
+			return GateErrE(swyapi.GateDbError, err)
+		}
+
+		if ie.Done {
+			idempReplay(w)
+			if ie.Inv != "" {
+				inv, err := ie.invocation(ctx)
+				if err != nil {
+					return GateErrD(err)
+				}
+				invRespond(w, inv)
+				return nil
+			}
+			return xrest.Respond(ctx, w, ie.Res)
+		}
+	}
+
+	if isAsyncReq(r) {
+		if suff != "" {
+			return GateErrM(swyapi.GateBadRequest, "Custom sources cannot run async")
+		}
+
+		inv, err := invCreate(fn, "run", r.URL.Query().Get("webhook"))
+		if ie != nil {
+			ie.endInv(inv)
+		}
+		if err != nil {
+			return GateErrE(swyapi.GateBadRequest, err)
+		}
+
+		ver := fn.Src.Version
+		inv.run(func(ctx context.Context, fn *FunctionDesc) (*swyapi.WdogFunctionRunResult, error) {
+			/* Async runs queue for the same slots as sync ones */
+			fmd, err := memdGetFn(ctx, fn)
+			if err != nil {
+				return nil, err
+			}
+
+			err = fmd.conc.get(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			defer fmd.conc.put()
+
+			conn, errc := balancerGetConnExact(ctx, fn.Cookie, ver)
+			if errc != nil {
+				return nil, errors.New(errc.Message)
+			}
+
+			return conn.Run(ctx, nil, "", "run", &params)
+		})
+
+		invRespond(w, inv)
+		return nil
+	}
+
+	if ie != nil {
+		defer func() { ie.end(keep) }()
+	}
+
+	if suff == "" {
+		fmd, err := memdGetFn(ctx, fn)
+		if err != nil {
+			return GateErrD(err)
+		}
+
+		err = fmd.conc.get(ctx)
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(concRetryAfter))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return nil
+		}
+
+		defer fmd.conc.put()
+	}
+
 	conn, errc := balancerGetConnExact(ctx, fn.Cookie, fn.Src.Version)
 	if errc != nil {
 		return errc
@@ -457,6 +855,10 @@ func handleFunctionRun(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return GateErrE(swyapi.GateGenErr, err)
 	}
 
+	if res.Code >= 0 {
+		keep = res
+	}
+
 	if fn.SwoId.Project == "test" {
 		res.Stdout = xh.Fortune()
 	}
@@ -464,6 +866,28 @@ func handleFunctionRun(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	return xrest.Respond(ctx, w, res)
 }
 
+func handleInvocation(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	inv, cerr := invFind(ctx, mux.Vars(r)["iid"])
+	if cerr != nil {
+		return cerr
+	}
+
+	switch r.Method {
+	case "GET":
+		return xrest.Respond(ctx, w, inv.toInfo())
+
+	case "DELETE":
+		cerr = invDel(ctx, inv)
+		if cerr != nil {
+			return cerr
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
 /******************************* ROUTERS **************************************/
 func handleRouters(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
 	var params swyapi.RouterAdd
@@ -479,6 +903,80 @@ func handleRouterTable(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	return xrest.HandleProp(ctx, w, r, Routers{}, &RtTblProp{}, &tbl)
 }
 
+/******************************* WORKFLOWS ************************************/
+func handleWorkflows(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	var params swyapi.WorkflowAdd
+	return xrest.HandleMany(ctx, w, r, Workflows{}, &params)
+}
+
+func handleWorkflow(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	var def swyapi.WorkflowDef
+	return xrest.HandleOne(ctx, w, r, Workflows{}, &def)
+}
+
+func handleWorkflowRuns(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	wo, cerr := Workflows{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	wd := wo.(*WorkflowDesc)
+
+	switch r.Method {
+	case "GET":
+		runs, cerr := wd.runsList(ctx)
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, runs)
+
+	case "POST":
+		var params swyapi.WorkflowRunStart
+
+		err := xhttp.RReq(r, &params)
+		if err != nil {
+			return GateErrE(swyapi.GateBadRequest, err)
+		}
+
+		rd, cerr := wd.runStart(ctx, &params)
+		if cerr != nil {
+			return cerr
+		}
+
+		return xrest.Respond(ctx, w, rd.toInfo(false))
+	}
+
+	return nil
+}
+
+func handleWorkflowRun(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	wo, cerr := Workflows{}.Get(ctx, r)
+	if cerr != nil {
+		return cerr
+	}
+
+	rd, cerr := wo.(*WorkflowDesc).runFind(ctx, mux.Vars(r)["rid"])
+	if cerr != nil {
+		return cerr
+	}
+
+	switch r.Method {
+	case "GET":
+		return xrest.Respond(ctx, w, rd.toInfo(true))
+
+	case "DELETE":
+		cerr = wfRunDel(ctx, rd)
+		if cerr != nil {
+			return cerr
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
 /******************************* ACCOUNTS *************************************/
 func handleAccounts(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
 	var params map[string]string
@@ -716,6 +1214,22 @@ func handleS3Access(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	return xrest.Respond(ctx, w, creds)
 }
 
+func handleS3Presign(ctx context.Context, w http.ResponseWriter, r *http.Request) *xrest.ReqErr {
+	var params swyapi.S3Presign
+
+	err := xhttp.RReq(r, &params)
+	if err != nil {
+		return GateErrE(swyapi.GateBadRequest, err)
+	}
+
+	url, cerr := s3PresignURL(ctx, &params)
+	if cerr != nil {
+		return cerr
+	}
+
+	return xrest.Respond(ctx, w, url)
+}
+
 func writeLogs(w io.Writer, logs []DBLogRec) {
 	for _, loge := range logs {
 		fmt.Fprintf(w, "%s%12s: %s\n",
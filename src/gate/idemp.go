@@ -0,0 +1,267 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"errors"
+	"encoding/hex"
+	"encoding/json"
+	"crypto/sha256"
+	"time"
+	"context"
+	"net/http"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/gate/mgo"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Idempotency key makes repeated calls with the same key return the
+ * result of the first one instead of running the fn again. The first
+ * caller inserts the (fn, key) entry and becomes its owner, others
+ * find it in the DB and wait for the result to appear. If the owner
+ * dies in the middle, the lease expires and one of the waiters takes
+ * the entry over. Only the results the fn itself returned are kept,
+ * if the call fails before that the entry is dropped and the next
+ * repeat runs the fn for real. Async calls keep the invocation ID
+ * instead of the result, so repeats report the same invocation.
+ *
+ * The entry also remembers the hash of the request it was made for,
+ * the key re-used with different body or claims is an error.
+ */
+type IdempEntry struct {
+	ObjID		bson.ObjectId			`bson:"_id,omitempty"`
+	Cookie		string				`bson:"cookie"`
+	Key		string				`bson:"key"`
+	Hash		string				`bson:"hash,omitempty"`
+	Owner		string				`bson:"owner"`
+	Lease		time.Time			`bson:"lease"`
+	Done		bool				`bson:"done"`
+	Res		*swyapi.WdogFunctionRunResult	`bson:"res,omitempty"`
+	Inv		string				`bson:"inv,omitempty"`
+	Expire		time.Time			`bson:"expire"`
+}
+
+const (
+	idempHeader	= "Idempotency-Key"
+	idempReplayed	= "Idempotent-Replayed"
+	idempKeyMax	= 256
+)
+
+var idempTTL time.Duration = 24 * time.Hour
+var idempLease time.Duration = 5 * time.Minute
+var idempWait time.Duration = time.Minute
+var idempPoll time.Duration = 100 * time.Millisecond
+
+var idempBusy = errors.New("Call with this idempotency key is in progress")
+var idempMismatch = errors.New("Idempotency key re-used with different request")
+
+func init() {
+	sysctl.AddTimeSysctl("fn_idempotency_ttl", &idempTTL)
+	sysctl.AddTimeSysctl("fn_idempotency_lease", &idempLease)
+	sysctl.AddTimeSysctl("fn_idempotency_wait", &idempWait)
+}
+
+func idempKey(r *http.Request) string {
+	return r.Header.Get(idempHeader)
+}
+
+/*
+ * Headers, cookies and alike change from one repeat to another,
+ * only what the fn is called with matters
+ */
+func idempHash(args *swyapi.FunctionRun) string {
+	a := *args
+	a.Headers = nil
+	a.Cookies = nil
+	a.Remote = ""
+	a.ReqId = ""
+	a.Src = nil
+
+	data, _ := json.Marshal(&a)
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func idempTake(ctx context.Context, cookie, key string) (*IdempEntry, error) {
+	var ie IdempEntry
+
+	now := time.Now()
+	_, err := dbCol(ctx, gmgo.DBColIdemp).Find(bson.M{
+			"cookie": cookie, "key": key, "done": false,
+			"lease": bson.M{"$lt": now}}).Apply(mgo.Change{
+				Update: bson.M{"$set": bson.M{"owner": gateInstance, "lease": now.Add(idempLease)}},
+				ReturnNew: true,
+			}, &ie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ie, nil
+}
+
+/*
+ * Returns either the entry with the result to replay, or the entry
+ * we own and should finish after the call
+ */
+func idempBegin(ctx context.Context, cookie, key, hash string) (*IdempEntry, int, error) {
+	if len(key) > idempKeyMax {
+		return nil, http.StatusBadRequest, errors.New("Idempotency key is too long")
+	}
+
+	now := time.Now()
+	ie := &IdempEntry {
+		ObjID:		bson.NewObjectId(),
+		Cookie:		cookie,
+		Key:		key,
+		Hash:		hash,
+		Owner:		gateInstance,
+		Lease:		now.Add(idempLease),
+		Expire:		now.Add(idempTTL),
+	}
+
+	col := dbCol(ctx, gmgo.DBColIdemp)
+	err := col.Insert(ie)
+	if err == nil {
+		idempCalls.WithLabelValues("new").Inc()
+		return ie, 0, nil
+	}
+
+	if !mgo.IsDup(err) {
+		return nil, http.StatusInternalServerError, errors.New("DB error")
+	}
+
+	till := now.Add(idempWait)
+	for {
+		var old IdempEntry
+
+		err = col.Find(bson.M{"cookie": cookie, "key": key}).One(&old)
+		if err != nil {
+			if !dbNF(err) {
+				return nil, http.StatusInternalServerError, errors.New("DB error")
+			}
+
+			/* Owner failed and dropped it, try to become one */
+			err = col.Insert(ie)
+			if err == nil {
+				idempCalls.WithLabelValues("new").Inc()
+				return ie, 0, nil
+			}
+			if !mgo.IsDup(err) {
+				return nil, http.StatusInternalServerError, errors.New("DB error")
+			}
+
+			continue
+		}
+
+		if old.Hash != hash {
+			idempCalls.WithLabelValues("mismatch").Inc()
+			return nil, http.StatusUnprocessableEntity, idempMismatch
+		}
+
+		if old.Done {
+			idempCalls.WithLabelValues("replay").Inc()
+			return &old, 0, nil
+		}
+
+		if old.Lease.Before(time.Now()) {
+			tk, err := idempTake(ctx, cookie, key)
+			if err == nil {
+				idempCalls.WithLabelValues("takeover").Inc()
+				return tk, 0, nil
+			}
+			if !dbNF(err) {
+				return nil, http.StatusInternalServerError, errors.New("DB error")
+			}
+		}
+
+		if time.Now().After(till) {
+			idempCalls.WithLabelValues("busy").Inc()
+			return nil, http.StatusConflict, idempBusy
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, http.StatusConflict, idempBusy
+		case <-time.After(idempPoll):
+			;
+		}
+	}
+}
+
+/* Call finished, nil res means there's nothing to keep */
+func (ie *IdempEntry)end(res *swyapi.WdogFunctionRunResult) {
+	if res != nil && !resStorable(res) {
+		/* Next repeat will run the fn again */
+		glog.Warnf("Result for idempotency key %s is too big to keep", ie.Key)
+		res = nil
+	}
+
+	if res != nil {
+		ie.finish(bson.M{"res": res})
+	} else {
+		ie.finish(nil)
+	}
+}
+
+/* Async call accepted, nil inv means it wasn't */
+func (ie *IdempEntry)endInv(inv *InvocationDesc) {
+	if inv != nil {
+		ie.finish(bson.M{"inv": inv.ObjID.Hex()})
+	} else {
+		ie.finish(nil)
+	}
+}
+
+func (ie *IdempEntry)finish(set bson.M) {
+	ctx, done := mkContext("::idemp")
+	defer done(ctx)
+
+	var err error
+
+	col := dbCol(ctx, gmgo.DBColIdemp)
+	q := bson.M{"_id": ie.ObjID, "owner": gateInstance}
+	if set != nil {
+		set["done"] = true
+		set["expire"] = time.Now().Add(idempTTL)
+		err = col.Update(q, bson.M{"$set": set})
+	} else {
+		err = col.Remove(q)
+	}
+
+	if err != nil && !dbNF(err) {
+		ctxlog(ctx).Errorf("Can't finish idempotency entry %s: %s", ie.Key, err.Error())
+	}
+}
+
+func idempReplay(w http.ResponseWriter) {
+	w.Header().Set(idempReplayed, "true")
+}
+
+/* The invocation may be gone already, then there's nothing to replay */
+func (ie *IdempEntry)invocation(ctx context.Context) (*InvocationDesc, error) {
+	var inv InvocationDesc
+
+	err := dbCol(ctx, gmgo.DBColInvs).Find(bson.M{"_id": bson.ObjectIdHex(ie.Inv),
+				"cookie": ie.Cookie}).One(&inv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+func idempRemove(ctx context.Context, fn *FunctionDesc) error {
+	if !dbMayRemove(ctx) {
+		return dbNotAllowed
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColIdemp).RemoveAll(bson.M{"cookie": fn.Cookie})
+	return maybe(err)
+}
@@ -0,0 +1,339 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"syscall"
+	"bytes"
+	"time"
+	"fmt"
+	"net"
+	"context"
+	"net/url"
+	"net/http"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/gate/mgo"
+	"swifty/common/http"
+	"swifty/common/xrest"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Async invocation is a call that returns its ID right at once.
+ * The call itself runs in background and its result is kept for
+ * a while to be fetched by ID. Optionally the result is POST-ed
+ * to the webhook URL.
+ */
+type InvocationDesc struct {
+	ObjID		bson.ObjectId		`bson:"_id,omitempty"`
+	Cookie		string			`bson:"cookie"`
+	Tennant		string			`bson:"tennant"`
+	Project		string			`bson:"project"`
+	FnName		string			`bson:"fname"`
+	Event		string			`bson:"event"`
+	Status		string			`bson:"status"`
+	Created		time.Time		`bson:"ts"`
+	Started		time.Time		`bson:"started,omitempty"`
+	Finished	time.Time		`bson:"finished,omitempty"`
+	Expire		time.Time		`bson:"expire"`
+	Webhook		string			`bson:"webhook,omitempty"`
+	Code		int			`bson:"code"`
+	Return		string			`bson:"return,omitempty"`
+	Stdout		string			`bson:"stdout,omitempty"`
+	Stderr		string			`bson:"stderr,omitempty"`
+	Error		string			`bson:"error,omitempty"`
+}
+
+const (
+	InvPending	= "pending"
+	InvRunning	= "running"
+	InvDone		= "done"
+	InvFailed	= "failed"
+)
+
+var invKeep time.Duration = 24 * time.Hour
+var invLogExcerpt int = 4096
+var invWebhookTmo int = 10
+
+func init() {
+	sysctl.AddTimeSysctl("fn_invocation_keep", &invKeep)
+	sysctl.AddIntSysctl("fn_invocation_log_excerpt", &invLogExcerpt)
+	sysctl.AddIntSysctl("fn_invocation_webhook_tmo_sec", &invWebhookTmo)
+}
+
+const invHeader = "X-Swifty-Invocation-Type"
+
+func isAsyncReq(r *http.Request) bool {
+	return r.URL.Query().Get("async") == "1" ||
+		strings.ToLower(r.Header.Get(invHeader)) == "async"
+}
+
+func excerpt(s string) string {
+	if len(s) > invLogExcerpt {
+		return s[:invLogExcerpt]
+	}
+
+	return s
+}
+
+func (inv *InvocationDesc)toInfo() *swyapi.FunctionInvocation {
+	ret := &swyapi.FunctionInvocation {
+		Id:		inv.ObjID.Hex(),
+		Function:	inv.FnName,
+		Status:		inv.Status,
+		Created:	inv.Created.Format(time.RFC1123Z),
+	}
+
+	if inv.Status == InvDone || inv.Status == InvFailed {
+		ret.Finished = inv.Finished.Format(time.RFC1123Z)
+		ret.Duration = uint(inv.Finished.Sub(inv.Started) / time.Microsecond)
+		ret.Code = inv.Code
+		ret.Return = inv.Return
+		ret.Stdout = inv.Stdout
+		ret.Stderr = inv.Stderr
+		ret.Error = inv.Error
+	}
+
+	return ret
+}
+
+/*
+ * Webhooks are only taken from the authenticated fn owner and may
+ * only point outside, not to the cluster, loopback or link-local
+ * (e.g. cloud metadata) addresses. The address is checked both when
+ * the webhook is given and when connecting, so that DNS changes and
+ * redirects don't help either.
+ */
+var invWebhookDenied []*net.IPNet
+
+func init() {
+	for _, n := range []string {
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, ipn, _ := net.ParseCIDR(n)
+		invWebhookDenied = append(invWebhookDenied, ipn)
+	}
+}
+
+func invWebhookAddrOK(ip net.IP) bool {
+	if ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, n := range invWebhookDenied {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func invWebhookOK(wh string) error {
+	u, err := url.Parse(wh)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("Bad webhook URL")
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return errors.New("Can't resolve webhook host")
+	}
+
+	for _, ip := range ips {
+		if !invWebhookAddrOK(ip) {
+			return errors.New("Webhook address is not allowed")
+		}
+	}
+
+	return nil
+}
+
+func invWebhookDialCheck(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !invWebhookAddrOK(ip) {
+		return fmt.Errorf("Webhook address %s is not allowed", host)
+	}
+
+	return nil
+}
+
+/*
+ * Callers may be nobody-s (url calls), so the invocation is
+ * put on our own behalf
+ */
+func invCreate(fn *FunctionDesc, event, webhook string) (*InvocationDesc, error) {
+	if webhook != "" {
+		err := invWebhookOK(webhook)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, done := mkContext("::invoke")
+	defer done(ctx)
+
+	now := time.Now()
+	inv := &InvocationDesc {
+		ObjID:		bson.NewObjectId(),
+		Cookie:		fn.Cookie,
+		Tennant:	fn.SwoId.Tennant,
+		Project:	fn.SwoId.Project,
+		FnName:		fn.SwoId.Name,
+		Event:		event,
+		Status:		InvPending,
+		Created:	now,
+		Expire:		now.Add(invKeep),
+		Webhook:	webhook,
+	}
+
+	err := dbCol(ctx, gmgo.DBColInvs).Insert(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	asyncCalls.Inc()
+	return inv, nil
+}
+
+type invRunFn func(context.Context, *FunctionDesc) (*swyapi.WdogFunctionRunResult, error)
+
+/* Runs the call in background and saves its result */
+func (inv *InvocationDesc)run(run invRunFn) {
+	go func() {
+		ctx, done := mkContext("::async")
+		defer done(ctx)
+
+		var fn FunctionDesc
+		var res *swyapi.WdogFunctionRunResult
+
+		inv.Started = time.Now()
+		dbCol(ctx, gmgo.DBColInvs).UpdateId(inv.ObjID,
+				bson.M{"$set": bson.M{"status": InvRunning, "started": inv.Started}})
+
+		/* Re-read the fn, it could have changed (or gone) meanwhile */
+		err := dbFind(ctx, bson.M{"cookie": inv.Cookie}, &fn)
+		if err == nil {
+			res, err = run(ctx, &fn)
+		}
+
+		inv.Finished = time.Now()
+		if err != nil {
+			inv.Status = InvFailed
+			inv.Error = err.Error()
+		} else {
+			inv.Status = InvDone
+			if res.Code < 0 {
+				inv.Status = InvFailed
+			}
+			inv.Code = res.Code
+			if resStorable(res) {
+				inv.Return = res.Return
+			} else {
+				inv.Error = "Result is too big to keep"
+			}
+			inv.Stdout = excerpt(res.Stdout)
+			inv.Stderr = excerpt(res.Stderr)
+		}
+
+		err = dbCol(ctx, gmgo.DBColInvs).UpdateId(inv.ObjID, inv)
+		if err != nil {
+			ctxlog(ctx).Errorf("Can't save invocation %s: %s", inv.ObjID.Hex(), err.Error())
+		}
+
+		if inv.Webhook != "" {
+			inv.notify(ctx)
+		}
+	}()
+}
+
+func (inv *InvocationDesc)webhookPost() error {
+	data, err := json.Marshal(inv.toInfo())
+	if err != nil {
+		return err
+	}
+
+	c := &http.Client {
+		Timeout: time.Duration(invWebhookTmo) * time.Second,
+		Transport: &http.Transport {
+			DialContext: (&net.Dialer{ Control: invWebhookDialCheck }).DialContext,
+		},
+	}
+
+	rsp, err := c.Post(inv.Webhook, "application/json; charset=utf-8", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("Response is not OK: %d", rsp.StatusCode)
+	}
+
+	return nil
+}
+
+func (inv *InvocationDesc)notify(ctx context.Context) {
+	err := inv.webhookPost()
+	if err != nil {
+		ctxlog(ctx).Errorf("Invocation %s webhook error: %s", inv.ObjID.Hex(), err.Error())
+		invWebhookErrors.Inc()
+	}
+}
+
+func invFind(ctx context.Context, id string) (*InvocationDesc, *xrest.ReqErr) {
+	var inv InvocationDesc
+
+	if !bson.IsObjectIdHex(id) {
+		return nil, GateErrM(swyapi.GateBadRequest, "Bad ID value")
+	}
+
+	err := dbCol(ctx, gmgo.DBColInvs).Find(bson.M{"_id": bson.ObjectIdHex(id),
+				"tennant": gctx(ctx).Tenant}).One(&inv)
+	if err != nil {
+		return nil, GateErrD(err)
+	}
+
+	return &inv, nil
+}
+
+func invDel(ctx context.Context, inv *InvocationDesc) *xrest.ReqErr {
+	if !dbMayRemove(ctx) {
+		return GateErrD(dbNotAllowed)
+	}
+
+	err := dbCol(ctx, gmgo.DBColInvs).RemoveId(inv.ObjID)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	return nil
+}
+
+func invRemove(ctx context.Context, fn *FunctionDesc) error {
+	if !dbMayRemove(ctx) {
+		return dbNotAllowed
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColInvs).RemoveAll(bson.M{"cookie": fn.Cookie})
+	return maybe(err)
+}
+
+func invRespond(w http.ResponseWriter, inv *InvocationDesc) {
+	xhttp.Respond2(w, inv.toInfo(), http.StatusAccepted)
+}
@@ -56,19 +56,37 @@ func k8sPodDelete(podname string) error {
 }
 
 func k8sRemove(ctx context.Context, conf *YAMLConf, fn *FunctionDesc) error {
-	var nr_replicas int32 = 0
-	var orphan bool = false
-	var grace int64 = 0
-	var err error
-
 	depname := fn.DepName()
 
-	err = BalancerDelete(ctx, fn.Cookie)
+	err := BalancerDelete(ctx, fn.Cookie)
 	if err != nil {
 		ctxlog(ctx).Errorf("Can't delete balancer %s : %s", depname, err.Error())
 		return err
 	}
 
+	if fn.Canary != nil {
+		err = k8sRemoveDep(ctx, conf, fn, fn.CanaryDepName())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ver := range fn.aliasedVersions() {
+		err = k8sRemoveDep(ctx, conf, fn, fn.VerDepName(ver))
+		if err != nil {
+			return err
+		}
+	}
+
+	return k8sRemoveDep(ctx, conf, fn, depname)
+}
+
+func k8sRemoveDep(ctx context.Context, conf *YAMLConf, fn *FunctionDesc, depname string) error {
+	var nr_replicas int32 = 0
+	var orphan bool = false
+	var grace int64 = 0
+	var err error
+
 	deploy := k8sClientSet.Extensions().Deployments(conf.Wdog.Namespace)
 	this, err := deploy.Get(depname, metav1.GetOptions{})
 	if err != nil {
@@ -216,8 +234,31 @@ func k8sGenLabels(fn *FunctionDesc, depname string) map[string]string {
 }
 
 func k8sUpdate(ctx context.Context, conf *YAMLConf, fn *FunctionDesc) error {
-	depname := fn.DepName()
+	err := k8sUpdateDep(ctx, conf, fn, fn.DepName())
+	if err != nil {
+		return err
+	}
+
+	if fn.Canary != nil {
+		/* Canary should see the same env, mwares, etc. */
+		err = k8sUpdateDep(ctx, conf, fn.canaryFn(), fn.CanaryDepName())
+		if err != nil {
+			return err
+		}
+	}
+
+	/* Published sources are immutable, but env, mwares, etc. are not */
+	for _, ver := range fn.aliasedVersions() {
+		err = k8sUpdateDep(ctx, conf, fn.verFn(ver), fn.VerDepName(ver))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
+func k8sUpdateDep(ctx context.Context, conf *YAMLConf, fn *FunctionDesc, depname string) error {
 	deploy := k8sClientSet.Extensions().Deployments(conf.Wdog.Namespace)
 	this, err := deploy.Get(depname, metav1.GetOptions{})
 	if err != nil {
@@ -244,8 +285,8 @@ func k8sUpdate(ctx context.Context, conf *YAMLConf, fn *FunctionDesc) error {
 
 	specSetRes(&this.Spec.Template.Spec.Containers[0].Resources, fn)
 
-	if fn.Size.Replicas == 1 {
-		/* Don't let pods disappear at all */
+	if fn.Size.Replicas == 1 || fn.Size.Warm != 0 {
+		/* Don't let pods disappear at all, warm pool must stay full */
 		one := intstr.FromInt(1)
 		zero := intstr.FromInt(0)
 
@@ -279,10 +320,42 @@ func specSetRes(res *v1.ResourceRequirements, fn *FunctionDesc) {
 }
 
 func k8sRun(ctx context.Context, conf *YAMLConf, fn *FunctionDesc) error {
+	depname := fn.DepName()
+
+	err := BalancerCreate(ctx, fn.Cookie)
+	if err != nil {
+		ctxlog(ctx).Errorf("Can't create balancer %s for %s: %s", depname, fn.SwoId.Str(), err.Error())
+		return errors.New("Net error")
+	}
+
+	err = k8sRunDep(ctx, conf, fn, depname, int32(fn.Size.Replicas))
+	if err != nil {
+		BalancerDelete(ctx, fn.Cookie)
+		return err
+	}
+
+	if fn.Canary != nil {
+		err = k8sRunDep(ctx, conf, fn.canaryFn(), fn.CanaryDepName(), 1)
+		if err != nil {
+			/* Main version is up, canary can be re-tried with rollback/start */
+			ctxlog(ctx).Errorf("Can't start canary for %s: %s", fn.SwoId.Str(), err.Error())
+		}
+	}
+
+	for _, ver := range fn.aliasedVersions() {
+		err = k8sRunDep(ctx, conf, fn.verFn(ver), fn.VerDepName(ver), 1)
+		if err != nil {
+			ctxlog(ctx).Errorf("Can't start %s version %s: %s", fn.SwoId.Str(), ver, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func k8sRunDep(ctx context.Context, conf *YAMLConf, fn *FunctionDesc, depname string, nr_replicas int32) error {
 	var err error
 	roRoot := true
 
-	depname := fn.DepName()
 	ctxlog(ctx).Debugf("Start %s deploy for %s (img: %s)", fn.SwoId.Str(), depname, fn.Code.image())
 
 	envs := k8sGenEnvVar(ctx, fn, conf.Wdog.Port)
@@ -369,14 +442,6 @@ func k8sRun(ctx context.Context, conf *YAMLConf, fn *FunctionDesc) error {
 
 	specSetRes(&podspec.Spec.Containers[0].Resources, fn)
 
-	nr_replicas := int32(fn.Size.Replicas)
-
-	err = BalancerCreate(ctx, fn.Cookie)
-	if err != nil {
-		ctxlog(ctx).Errorf("Can't create balancer %s for %s: %s", depname, fn.SwoId.Str(), err.Error())
-		return errors.New("Net error")
-	}
-
 	deployspec := v1beta1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Deployment",
@@ -394,7 +459,6 @@ func k8sRun(ctx context.Context, conf *YAMLConf, fn *FunctionDesc) error {
 	deploy := k8sClientSet.Extensions().Deployments(conf.Wdog.Namespace)
 	_, err = deploy.Create(&deployspec)
 	if err != nil {
-		BalancerDelete(ctx, fn.Cookie)
 		ctxlog(ctx).Errorf("Can't start deployment %s: %s", fn.SwoId.Str(), err.Error())
 		return errors.New("K8S error")
 	}
@@ -421,6 +485,8 @@ func (pod *k8sPod)conn() *podConn {
 		Host: pod.Host,
 		FnId: pod.FnId,
 		PTok: pod.Token,
+		Version: pod.Version,
+		DepName: pod.DepName,
 	}
 }
 
@@ -557,6 +623,7 @@ func k8sPodUp(ctx context.Context, pod *k8sPod) error {
 			return
 		}
 
+		podWarmup(ctx, pod)
 		BalancerPodAdd(ctx, pod)
 		notifyPodUp(ctx, pod)
 	}()
@@ -879,7 +946,7 @@ func refreshDepsAndPods(ctx context.Context, hard bool) error {
 
 		}
 
-		if *dep.Spec.Replicas > 1 {
+		if *dep.Spec.Replicas > int32(fn.Size.Replicas) {
 			ctxlog(ctx).Debugf("Found grown-up (%d) deployment %s", *dep.Spec.Replicas, dep.Name)
 			err = scalerInit(ctx, &fn, uint32(*dep.Spec.Replicas))
 			if err != nil {
@@ -26,6 +26,7 @@ import (
 )
 
 var ModeDevel bool
+var gateInstance string /* identifies this gate among the replicas */
 var gateSecrets xsecret.Store
 var gateSecPas []byte
 
@@ -266,6 +267,17 @@ func getHandlers() http.Handler {
 	r.Handle("/v1/functions/{fid}/size",	genReqHandler(handleFunctionSize)).Methods("GET", "PUT", "OPTIONS")
 	r.Handle("/v1/functions/{fid}/sources",	genReqHandler(handleFunctionSources)).Methods("GET", "PUT", "OPTIONS")
 	r.Handle("/v1/functions/{fid}/env",	genReqHandler(handleFunctionEnv)).Methods("GET", "PUT", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/retry",	genReqHandler(handleFunctionRetry)).Methods("GET", "PUT", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/dlq",	genReqHandler(handleFunctionDLQ)).Methods("GET", "DELETE", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/dlq/replay", genReqHandler(handleFunctionDLQReplay)).Methods("POST", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/delayed",	genReqHandler(handleFunctionDelayed)).Methods("GET", "POST", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/delayed/{did}", genReqHandler(handleFunctionDelayedCall)).Methods("GET", "DELETE", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/canary",	genReqHandler(handleFunctionCanary)).Methods("GET", "POST", "PUT", "DELETE", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/canary/promote", genReqHandler(handleFunctionCanaryPromote)).Methods("POST", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/versions", genReqHandler(handleFunctionVersions)).Methods("GET", "POST", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/versions/{ver}", genReqHandler(handleFunctionVersion)).Methods("DELETE", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/aliases",	genReqHandler(handleFunctionAliases)).Methods("GET", "POST", "OPTIONS")
+	r.Handle("/v1/functions/{fid}/aliases/{alias}", genReqHandler(handleFunctionAlias)).Methods("GET", "PUT", "DELETE", "OPTIONS")
 	r.Handle("/v1/functions/{fid}/middleware", genReqHandler(handleFunctionMwares)).Methods("GET", "POST", "OPTIONS")
 	r.Handle("/v1/functions/{fid}/middleware/{mid}", genReqHandler(handleFunctionMware)).Methods("DELETE", "OPTIONS")
 	r.Handle("/v1/functions/{fid}/accounts", genReqHandler(handleFunctionAccounts)).Methods("GET", "POST", "OPTIONS")
@@ -275,6 +287,8 @@ func getHandlers() http.Handler {
 	r.Handle("/v1/functions/{fid}/wait",	genReqHandler(handleFunctionWait)).Methods("POST", "OPTIONS")
 	r.Handle("/v1/functions/{fid}/mdat",	genReqHandler(handleFunctionMdat)).Methods("GET")
 
+	r.Handle("/v1/invocations/{iid}",	genReqHandler(handleInvocation)).Methods("GET", "DELETE", "OPTIONS")
+
 	r.Handle("/v1/packages",		genReqHandler(handlePackages)).Methods("GET", "OPTIONS")
 	r.Handle("/v1/packages/{lang}",		genReqHandler(handlePackagesLang)).Methods("GET", "POST", "OPTIONS")
 	r.Handle("/v1/packages/{lang}/{pkgid:[a-zA-Z0-9./_-]+}",
@@ -293,6 +307,7 @@ func getHandlers() http.Handler {
 	r.Handle("/v1/accounts/{aid}",		genReqHandler(handleAccount)).Methods("GET", "PUT", "DELETE", "OPTIONS")
 
 	r.Handle("/v1/s3/access",		genReqHandler(handleS3Access)).Methods("POST", "OPTIONS")
+	r.Handle("/v1/s3/presign",		genReqHandler(handleS3Presign)).Methods("POST", "OPTIONS")
 
 	r.Handle("/v1/auths",			genReqHandler(handleAuths)).Methods("GET", "POST", "OPTIONS")
 	r.Handle("/v1/auths/{aid}",		genReqHandler(handleAuth)).Methods("GET", "DELETE", "OPTIONS")
@@ -304,6 +319,11 @@ func getHandlers() http.Handler {
 	r.Handle("/v1/routers/{rid}",		genReqHandler(handleRouter)).Methods("GET", "DELETE", "OPTIONS")
 	r.Handle("/v1/routers/{rid}/table",	genReqHandler(handleRouterTable)).Methods("GET", "PUT", "OPTIONS")
 
+	r.Handle("/v1/workflows",		genReqHandler(handleWorkflows)).Methods("GET", "POST", "OPTIONS")
+	r.Handle("/v1/workflows/{wid}",		genReqHandler(handleWorkflow)).Methods("GET", "PUT", "DELETE", "OPTIONS")
+	r.Handle("/v1/workflows/{wid}/runs",	genReqHandler(handleWorkflowRuns)).Methods("GET", "POST", "OPTIONS")
+	r.Handle("/v1/workflows/{wid}/runs/{rid}", genReqHandler(handleWorkflowRun)).Methods("GET", "DELETE", "OPTIONS")
+
 	r.Handle("/v1/info/langs",		genReqHandler(handleLanguages)).Methods("GET", "OPTIONS")
 	r.Handle("/v1/info/langs/{lang}",	genReqHandler(handleLanguage)).Methods("GET", "OPTIONS")
 	r.Handle("/v1/info/mwares",		genReqHandler(handleMwareTypes)).Methods("GET", "OPTIONS")
@@ -380,6 +400,11 @@ func main() {
 				err.Error())
 	}
 
+	gateInstance, err = xh.GenRandId(16)
+	if err != nil {
+		glog.Fatalf("Can't generate instance ID: %s", err.Error())
+	}
+
 	ctx, done := mkContext("::init")
 
 	err = eventsInit(ctx)
@@ -418,6 +443,11 @@ func main() {
 		glog.Fatalf("Can't set up prometheus: %s", err.Error())
 	}
 
+	err = wfInit(ctx)
+	if err != nil {
+		glog.Fatalf("Can't set up workflows: %s", err.Error())
+	}
+
 	MwInit()
 	RtInit()
 	done(ctx)
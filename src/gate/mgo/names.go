@@ -17,4 +17,11 @@ const (
 	DBColAccounts	= "Accounts"
 	DBColRouters	= "Routers"
 	DBColTCache	= "TCache"
+	DBColDLQ	= "DeadLetters"
+	DBColInvs	= "Invocations"
+	DBColDelayed	= "DelayedCalls"
+	DBColRetries	= "PendingRetries"
+	DBColWorkflows	= "Workflows"
+	DBColWfRuns	= "WorkflowRuns"
+	DBColIdemp	= "IdempotencyKeys"
 )
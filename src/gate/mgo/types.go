@@ -18,6 +18,10 @@ type FnStatValues struct {
 	BytesIn		uint64		`bson:"bytesin"`
 	BytesOut	uint64		`bson:"bytesout"`
 
+	/* Scale-from-zero starts and time calls waited for them */
+	ColdStarts	uint64		`bson:"coldstarts"`
+	ColdTime	time.Duration	`bson:"coldtime"`
+
 	/* RunCost is a value that represents the amount of
 	 * resources spent for this function. It's used by
 	 * billing to change the tennant.
@@ -7,6 +7,8 @@ package main
 
 import (
 	"context"
+	"strings"
+	"time"
 	"github.com/streadway/amqp"
 )
 
@@ -19,6 +21,40 @@ type mqConsumer struct {
 
 type mqListenerCb func(context.Context, string, []byte)
 
+/*
+ * Delivery callback is used by consumers that ack messages
+ * themselves. Returning nil acks the message. Permanent error
+ * (mqPermanent) makes the consumer move one into the dead-letter
+ * queue (if any) or reject it, other errors are considered to be
+ * transient and the message is requeued after a backoff.
+ */
+type mqDeliveryCb func(context.Context, *amqp.Delivery) error
+
+type mqPermanentErr struct {
+	error
+}
+
+func mqPermanent(err error) error {
+	return &mqPermanentErr{err}
+}
+
+func mqIsPermanent(err error) bool {
+	_, ok := err.(*mqPermanentErr)
+	return ok
+}
+
+const (
+	mqRequeueDelay		= 100 * time.Millisecond
+	mqRequeueDelayMax	= 10 * time.Second
+)
+
+type mqConsumerOpts struct {
+	tag		string
+	prefetch	int
+	workers		int
+	dlq		string
+}
+
 // XXX -- isn't there out-of-the-box factory engine in go?
 type mq_listener_req struct {
 	user	string
@@ -26,12 +62,18 @@ type mq_listener_req struct {
 	url	string
 	queue	string
 	cb	mqListenerCb
+	dcb	mqDeliveryCb
+	opts	*mqConsumerOpts
 	add	bool
 	resp	chan error
 }
 
 func (req *mq_listener_req)hkey() string {
-	return req.url + ":" + req.queue
+	key := req.url + ":" + req.queue
+	if req.opts != nil {
+		key += "/" + req.opts.tag
+	}
+	return key
 }
 
 var consumers map[string]*mqConsumer
@@ -59,8 +101,24 @@ func init() {
 	}()
 }
 
+/* Consumers stopped with unknown url are looked up by the queue and tag */
+func findListener(req *mq_listener_req) string {
+	if req.url != "" || req.opts == nil {
+		return req.hkey()
+	}
+
+	sfx := ":" + req.queue + "/" + req.opts.tag
+	for key, _ := range consumers {
+		if strings.HasSuffix(key, sfx) {
+			return key
+		}
+	}
+
+	return sfx
+}
+
 func stopListener(req *mq_listener_req) {
-	key := req.hkey()
+	key := findListener(req)
 	cons, ok := consumers[key]
 	if !ok {
 		glog.Errorf("mq: FATAL: no consumer for %s found", key)
@@ -70,15 +128,109 @@ func stopListener(req *mq_listener_req) {
 	cons.counter--
 	if cons.counter == 0 {
 		glog.Debugf("mq: Stopping mq listener @%s", key)
-		cons.done <-true
+		close(cons.done)
 		cons.channel.Close()
 		cons.conn.Close()
 		delete(consumers, key)
 	}
 }
 
+func mqDeadLetter(cons *mqConsumer, dlq string, d *amqp.Delivery, reason error) error {
+	hdrs := amqp.Table{}
+	for k, v := range d.Headers {
+		hdrs[k] = v
+	}
+	hdrs["x-swifty-error"] = reason.Error()
+	hdrs["x-swifty-queue"] = d.RoutingKey
+
+	return cons.channel.Publish("", dlq, false, false, amqp.Publishing{
+			Headers:	hdrs,
+			ContentType:	d.ContentType,
+			Body:		d.Body,
+		})
+}
+
+func startAckedConsumer(cons *mqConsumer, req *mq_listener_req, queue string) error {
+	var err error
+
+	opts := req.opts
+	key := req.hkey()
+
+	if opts.dlq != "" {
+		_, err = cons.channel.QueueDeclare(opts.dlq, false, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.prefetch > 0 {
+		err = cons.channel.Qos(opts.prefetch, 0, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	msgs, err := cons.channel.Consume(queue, opts.tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < opts.workers; i++ {
+		go func() {
+			delay := time.Duration(0)
+		loop:
+			for {
+				select {
+				case d, ok := <-msgs:
+					if !ok {
+						break loop
+					}
+
+					ctx, done := mkContext("::mq")
+					err := req.dcb(ctx, &d)
+					if err == nil {
+						delay = 0
+						d.Ack(false)
+					} else if !mqIsPermanent(err) {
+						/* Fn not ready, balancer hiccup and alike */
+						delay *= 2
+						if delay == 0 {
+							delay = mqRequeueDelay
+						} else if delay > mqRequeueDelayMax {
+							delay = mqRequeueDelayMax
+						}
+						ctxlog(ctx).Debugf("mq: Requeueing message from %s in %s: %s",
+								key, delay.String(), err.Error())
+						select {
+						case <-time.After(delay):
+						case <-cons.done:
+						}
+						d.Nack(false, true)
+					} else if opts.dlq == "" {
+						delay = 0
+						ctxlog(ctx).Debugf("mq: Rejecting message from %s: %s", key, err.Error())
+						d.Nack(false, false)
+					} else if mqDeadLetter(cons, opts.dlq, &d, err) == nil {
+						delay = 0
+						d.Ack(false)
+					} else {
+						ctxlog(ctx).Errorf("mq: Can't dead-letter message from %s", key)
+						d.Nack(false, true)
+					}
+					done(ctx)
+				case <-cons.done:
+					break loop
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
 func startListener(req *mq_listener_req) error {
 	var err error
+	var msgs <-chan amqp.Delivery
 
 	key := req.hkey()
 	cons := consumers[key]
@@ -109,7 +261,16 @@ func startListener(req *mq_listener_req) error {
 		return err
 	}
 
-	msgs, err := cons.channel.Consume(q.Name, "", true, false, false, false, nil)
+	if req.dcb != nil {
+		err = startAckedConsumer(cons, req, q.Name)
+		if err != nil {
+			return err
+		}
+
+		goto out
+	}
+
+	msgs, err = cons.channel.Consume(q.Name, "", true, false, false, false, nil)
 	if err != nil {
 		return err
 	}
@@ -132,6 +293,7 @@ func startListener(req *mq_listener_req) error {
 		glog.Debugf("mq: Stop getting messages")
 	}()
 
+out:
 	consumers[key] = cons
 	glog.Debugf("mq: ... Done");
 	return nil
@@ -154,3 +316,23 @@ func mqStopListener(url, queue string) {
 			queue: queue,
 		})
 }
+
+func mqStartConsumer(user, pass, url, queue string, opts *mqConsumerOpts, cb mqDeliveryCb) error {
+	return factoryMakeReq(&mq_listener_req{
+			user: user,
+			pass: pass,
+			url: url,
+			queue: queue,
+			dcb: cb,
+			opts: opts,
+			add: true,
+		})
+}
+
+func mqStopConsumer(url, queue string, opts *mqConsumerOpts) {
+	factoryMakeReq(&mq_listener_req{
+			url: url,
+			queue: queue,
+			opts: opts,
+		})
+}
@@ -10,9 +10,11 @@ import (
 	"context"
 	"gopkg.in/mgo.v2/bson"
 	"github.com/michaelklishin/rabbit-hole"
+	"github.com/streadway/amqp"
 	"fmt"
 	"errors"
 	"swifty/apis"
+	"swifty/common/xrest/sysctl"
 )
 
 func rabbitConn() (*rabbithole.Client, error) {
@@ -94,21 +96,201 @@ func FiniRabbitMQ(ctx context.Context, mwd *MwareDesc) error {
 	return nil
 }
 
-func mqEvent(ctx context.Context, mwid, queue, userid, data string) {
-	var mware MwareDesc
-	err := dbFind(ctx, bson.M{"mwaretype": "rabbit", "client": userid}, &mware)
+type FnEventMQ struct {
+	MwName		string		`bson:"mware"`
+	MwId		string		`bson:"mwid"`
+	Vhost		string		`bson:"vhost,omitempty"`
+	Queue		string		`bson:"queue"`
+	Prefetch	int		`bson:"prefetch"`
+	Workers		int		`bson:"workers"`
+	DLQ		string		`bson:"dlq,omitempty"`
+}
+
+var mqMaxWorkers int = 16
+
+func init() {
+	sysctl.AddIntSysctl("mq_trigger_workers_max", &mqMaxWorkers)
+}
+
+func mqKey(mwid, queue string) string { return "mq:" + mwid + "/" + queue }
+
+func mqConsOpts(ed *FnEventDesc) *mqConsumerOpts {
+	return &mqConsumerOpts {
+		tag:		ed.ObjID.Hex(),
+		prefetch:	ed.MQ.Prefetch,
+		workers:	ed.MQ.Workers,
+		dlq:		ed.MQ.DLQ,
+	}
+}
+
+func mqURL(mwd *MwareDesc) string {
+	return mqVhostURL(mwd.Namespace)
+}
+
+func mqVhostURL(vhost string) string {
+	return conf.Mware.Rabbit.c.Addr() + "/" + vhost
+}
+
+func mqTriggerRun(ctx context.Context, ed *FnEventDesc, d *amqp.Delivery) error {
+	var fn FunctionDesc
+
+	err := dbFind(ctx, bson.M{"cookie": ed.FnId, "state": DBFuncStateRdy}, &fn)
 	if err != nil {
-		//danglingEvents.WithLabelValues("mq").Inc()
-		return
+		danglingEvents.WithLabelValues("mq").Inc()
+		return fmt.Errorf("No function to run: %s", err.Error())
+	}
+
+	fn.alias = ed.Alias
+	res, err := doRun(ctx, &fn, "mq",
+			&swyapi.FunctionRun{
+				Args: map[string]string {
+					"mwid":		ed.MQ.MwName,
+					"queue":	ed.MQ.Queue,
+				},
+				ContentType: d.ContentType,
+				Body: string(d.Body),
+			})
+	if err != nil {
+		return err
 	}
 
-	var funcs []*FunctionDesc
-	/* FIXME -- list FNs with events here, now they are in separate DB */
+	if res.Code < 0 {
+		return mqPermanent(fmt.Errorf("Function failed: %d", -res.Code))
+	}
+
+	return nil
+}
+
+func mqEventStart(ctx context.Context, fn *FunctionDesc, ed *FnEventDesc) error {
+	var mwd MwareDesc
+
+	attached := false
+	for _, mwn := range fn.Mware {
+		if mwn == ed.MQ.MwName {
+			attached = true
+			break
+		}
+	}
+
+	if !attached {
+		return errors.New("Mware not attached")
+	}
 
-	for _, fn := range funcs {
-		doRunBg(ctx, fn, "mq",
-				&swyapi.FunctionRun{Body: data})
+	id := fn.SwoId
+	id.Name = ed.MQ.MwName
+
+	err := dbFind(ctx, bson.M{"cookie": id.Cookie(), "mwaretype": "rabbit", "state": DBMwareStateRdy}, &mwd)
+	if err != nil {
+		return err
 	}
+
+	ed.MQ.MwId = mwd.Cookie
+	ed.MQ.Vhost = mwd.Namespace
+	ed.Key = mqKey(mwd.Cookie, ed.MQ.Queue)
+	rc := conf.Mware.Rabbit.c
+
+	return mqStartConsumer(rc.User, rc.Pass, mqURL(&mwd), ed.MQ.Queue, mqConsOpts(ed),
+			func(ctx context.Context, d *amqp.Delivery) error {
+				return mqTriggerRun(ctx, ed, d)
+			})
+}
+
+/*
+ * The mware may be already gone, so the consumer is found by the
+ * vhost remembered on start. Triggers started before it was kept
+ * fall back to the mware and then to the consumer tag.
+ */
+func mqEventStop(ctx context.Context, ed *FnEventDesc) error {
+	var mwd MwareDesc
+
+	if ed.MQ.Vhost != "" {
+		mqStopConsumer(mqVhostURL(ed.MQ.Vhost), ed.MQ.Queue, mqConsOpts(ed))
+		return nil
+	}
+
+	err := dbFind(ctx, bson.M{"cookie": ed.MQ.MwId}, &mwd)
+	if err != nil {
+		ctxlog(ctx).Warnf("mq: No mware for trigger %s, stopping by tag", ed.ObjID.Hex())
+		mqStopConsumer("", ed.MQ.Queue, mqConsOpts(ed))
+		return nil
+	}
+
+	mqStopConsumer(mqURL(&mwd), ed.MQ.Queue, mqConsOpts(ed))
+	return nil
+}
+
+func mqEventsInit(ctx context.Context) error {
+	var evs []*FnEventDesc
+
+	if conf.Mware.Rabbit == nil {
+		return nil
+	}
+
+	err := dbFindAll(ctx, bson.M{"source": "mq"}, &evs)
+	if err != nil {
+		return err
+	}
+
+	for _, ed := range evs {
+		if ed.Key == "" {
+			continue /* Being removed */
+		}
+
+		var fn FunctionDesc
+
+		err = dbFind(ctx, bson.M{"cookie": ed.FnId}, &fn)
+		if err != nil {
+			ctxlog(ctx).Errorf("mq: No function for trigger %s: %s", ed.ObjID.Hex(), err.Error())
+			continue
+		}
+
+		err = mqEventStart(ctx, &fn, ed)
+		if err != nil {
+			ctxlog(ctx).Errorf("mq: Can't restart trigger %s: %s", ed.ObjID.Hex(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+var mqEOps = EventOps {
+	setup: func(ed *FnEventDesc, evt *swyapi.FunctionEvent) error {
+		if conf.Mware.Rabbit == nil {
+			return errors.New("Not enabled")
+		}
+
+		if evt.MQ == nil {
+			return errors.New("Field \"mq\" missing")
+		}
+
+		if evt.MQ.MwName == "" || evt.MQ.Queue == "" {
+			return errors.New("Mware name and queue required")
+		}
+
+		wrk := int(evt.MQ.Concurrency)
+		if wrk == 0 {
+			wrk = 1
+		} else if wrk > mqMaxWorkers {
+			return errors.New("Concurrency too high")
+		}
+
+		pf := int(evt.MQ.Prefetch)
+		if pf == 0 {
+			pf = wrk
+		}
+
+		ed.MQ = &FnEventMQ{
+			MwName:		evt.MQ.MwName,
+			Queue:		evt.MQ.Queue,
+			Prefetch:	pf,
+			Workers:	wrk,
+			DLQ:		evt.MQ.DLQ,
+		}
+
+		return nil
+	},
+	start:	mqEventStart,
+	stop:	mqEventStop,
 }
 
 func GetEnvRabbitMQ(ctx context.Context, mwd *MwareDesc) map[string][]byte {
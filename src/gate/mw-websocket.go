@@ -259,6 +259,7 @@ func wsTrigger(mwd *MwareDesc, cid string, mtype int, message []byte, claims map
 			continue
 		}
 
+		fn.alias = ed.Alias
 		doRunBg(ctx, &fn, "websocket", &args)
 	}
 }
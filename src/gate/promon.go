@@ -89,6 +89,68 @@ var (
 		[]string { "event" },
 	)
 
+	bgRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_bg_retries",
+			Help: "Number of background calls re-tried",
+		},
+		[]string { "event" },
+	)
+
+	deadLetters = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_dead_letters",
+			Help: "Number of background calls put into DLQ",
+		},
+		[]string { "event" },
+	)
+
+	asyncCalls = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_async_calls",
+			Help: "Number of asynchronous invocations",
+		},
+	)
+
+	invWebhookErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_invocation_webhook_errors",
+			Help: "Number of failed invocation completion webhooks",
+		},
+	)
+
+	delayedCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_delayed_calls",
+			Help: "Number of delayed calls put, run and cancelled",
+		},
+		[]string { "op" },
+	)
+
+	wfRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_workflow_runs",
+			Help: "Number of workflow runs finished (or resumed)",
+		},
+		[]string { "status" },
+	)
+
+	concThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_concurrency_throttled",
+			Help: "Number of calls rejected by functions' concurrency limits",
+		},
+		[]string { "reason" },
+	)
+
+	idempCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_idempotent_calls",
+			Help: "Number of calls with idempotency key (new, replayed, etc.)",
+		},
+		[]string { "result" },
+	)
+
 	gateCalls = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "swifty_gate_function_calls",
@@ -186,6 +248,20 @@ var (
 		},
 	)
 
+	scaleIdles = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_scale_idles",
+			Help: "How many times idle functions were scaled to zero",
+		},
+	)
+
+	coldOverruns = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_cold_overruns",
+			Help: "Calls rejected since too many wait for cold start",
+		},
+	)
+
 	dbAccViolations = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "swifty_db_access_violations",
@@ -224,6 +300,41 @@ var (
 		},
 	)
 
+	warmupErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swifty_gate_warmup_errors",
+			Help: "Number of failed pod warmup calls",
+		},
+	)
+
+	warmupLat = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "swifty_gate_warmup_lat",
+			Help: "Time it takes a pod to handle the warmup event",
+			Buckets: []float64{
+				(100 * time.Millisecond).Seconds(),
+				(500 * time.Millisecond).Seconds(),
+				(  1 * time.Second).Seconds(),
+				(  5 * time.Second).Seconds(),
+			},
+		},
+	)
+
+	coldStartLat = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "swifty_gate_cold_start_lat",
+			Help: "Time it takes a function to scale from zero",
+			Buckets: []float64{
+				(500 * time.Millisecond).Seconds(),
+				(  1 * time.Second).Seconds(),
+				(  2 * time.Second).Seconds(),
+				(  5 * time.Second).Seconds(),
+				( 10 * time.Second).Seconds(),
+				( 30 * time.Second).Seconds(),
+			},
+		},
+	)
+
 	scalerGoals = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Name: "swifty_gate_scaler_goals",
@@ -309,12 +420,25 @@ func PrometheusInit(ctx context.Context) error {
 	prometheus.MustRegister(limitPullErrs)
 	prometheus.MustRegister(statWrites)
 	prometheus.MustRegister(scaleOverruns)
+	prometheus.MustRegister(scaleIdles)
+	prometheus.MustRegister(coldOverruns)
+	prometheus.MustRegister(coldStartLat)
+	prometheus.MustRegister(warmupErrors)
+	prometheus.MustRegister(warmupLat)
 	prometheus.MustRegister(dbAccViolations)
 	prometheus.MustRegister(statWriteFails)
 	prometheus.MustRegister(scalers)
 	prometheus.MustRegister(portWaiters)
 	prometheus.MustRegister(srcGCs)
 	prometheus.MustRegister(danglingEvents)
+	prometheus.MustRegister(bgRetries)
+	prometheus.MustRegister(deadLetters)
+	prometheus.MustRegister(asyncCalls)
+	prometheus.MustRegister(invWebhookErrors)
+	prometheus.MustRegister(delayedCalls)
+	prometheus.MustRegister(wfRuns)
+	prometheus.MustRegister(idempCalls)
+	prometheus.MustRegister(concThrottled)
 
 	r := mux.NewRouter()
 	r.Handle("/metrics", promhttp.Handler())
@@ -0,0 +1,101 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"errors"
+	"time"
+	"context"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/gate/mgo"
+)
+
+/*
+ * Background call waiting for its next attempt. These are kept in
+ * the DB and picked up by the delayed calls poller with the same
+ * claim and lease rules, so gate restarts don't lose them.
+ */
+type PendingRetry struct {
+	ObjID		bson.ObjectId		`bson:"_id,omitempty"`
+	Cookie		string			`bson:"cookie"`
+	Alias		string			`bson:"alias,omitempty"`
+	Event		string			`bson:"event"`
+	Attempt		uint			`bson:"attempt"`
+	At		time.Time		`bson:"at"`
+	Args		*swyapi.FunctionRun	`bson:"args"`
+	Owner		string			`bson:"owner,omitempty"`
+	Lease		time.Time		`bson:"lease,omitempty"`
+}
+
+var retryTooBig = errors.New("Call body is too big to keep for retry")
+
+func retryPut(fn *FunctionDesc, event string, args *swyapi.FunctionRun, attempt uint, at time.Time) error {
+	if !argsStorable(args) {
+		return retryTooBig
+	}
+
+	ctx, done := mkContext("::retry")
+	defer done(ctx)
+
+	return dbCol(ctx, gmgo.DBColRetries).Insert(&PendingRetry {
+		ObjID:		bson.NewObjectId(),
+		Cookie:		fn.Cookie,
+		Alias:		fn.alias,
+		Event:		event,
+		Attempt:	attempt,
+		At:		at,
+		Args:		args,
+	})
+}
+
+func retryClaim(ctx context.Context) (*PendingRetry, error) {
+	var rt PendingRetry
+
+	err := leaseClaim(ctx, gmgo.DBColRetries, &rt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rt, nil
+}
+
+func retryRemove(ctx context.Context, fn *FunctionDesc) error {
+	if !dbMayRemove(ctx) {
+		return dbNotAllowed
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColRetries).RemoveAll(bson.M{"cookie": fn.Cookie})
+	return maybe(err)
+}
+
+func (rt *PendingRetry)run() {
+	ctx, done := mkContext("::retry")
+	defer done(ctx)
+
+	var fn FunctionDesc
+
+	/* Re-read the fn, it could have changed (or gone) meanwhile */
+	err := dbFind(ctx, bson.M{"cookie": rt.Cookie}, &fn)
+	if err != nil {
+		danglingEvents.WithLabelValues(rt.Event).Inc()
+		ctxlog(ctx).Errorf("Can't find FN %s to retry %s event", rt.Cookie, rt.Event)
+		goto out
+	}
+
+	if rt.Args == nil {
+		rt.Args = &swyapi.FunctionRun{}
+	}
+
+	fn.alias = rt.Alias
+	doRunBgAttempt(ctx, &fn, rt.Event, rt.Args, rt.Attempt)
+out:
+	err = dbCol(ctx, gmgo.DBColRetries).Remove(bson.M{"_id": rt.ObjID, "owner": gateInstance})
+	if err != nil && !dbNF(err) {
+		ctxlog(ctx).Errorf("Can't remove pending retry %s: %s", rt.ObjID.Hex(), err.Error())
+	}
+}
@@ -80,8 +80,8 @@ func makeRouterURL(ctx context.Context, urlid string) (*RouterURL, error) {
 	rurl.table = make(map[string]*RouterEntry)
 	id := rt.SwoId
 	for _, e := range rt.Table {
-		id.Name = e.Call
 		re := RouterEntry{}
+		id.Name, re.alias = splitAlias(e.Call)
 		re.cookie = id.Cookie()
 		re.key = e.Key
 		if e.Method == "*" {
@@ -241,6 +241,7 @@ type RouterEntry struct {
 	ac	*AuthCtx
 	methods	xh.Bitmask
 	key	string
+	alias	string
 }
 
 type RouterURL struct {
@@ -289,7 +290,7 @@ func (rt *RouterURL)Handle(ctx context.Context, w http.ResponseWriter, r *http.R
 		return
 	}
 
-	fmd.Handle(ctx, w, r, sopq, args)
+	fmd.Handle(ctx, w, r, sopq, args, e.alias)
 }
 
 type RtTblProp struct { }
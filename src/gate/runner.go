@@ -11,7 +11,15 @@ import (
 	"time"
 	"context"
 	"strings"
+	"io"
 	"io/ioutil"
+	"net"
+	"strconv"
+	"mime"
+	"mime/multipart"
+	"bytes"
+	"errors"
+	"encoding/json"
 
 	"swifty/apis"
 	"swifty/common"
@@ -22,10 +30,25 @@ import (
 )
 
 var acceptedContent xh.StringsValues
+var retryAttemptsMax int = 10
+var retryBackoffDef int = 1000		/* msec */
+var retryBackoffMax int = 600000	/* msec */
+var streamTmo int = 600			/* sec */
+var callBodyMax int = 16384		/* KB */
+var callStoreMax int = 4096		/* KB */
+var callTrustProxy bool = false
 
 func init() {
 	acceptedContent = xh.MakeStringValues("application/json", "text/plain")
 
+	sysctl.AddIntSysctl("fn_retry_attempts_max", &retryAttemptsMax)
+	sysctl.AddIntSysctl("fn_retry_backoff_def_ms", &retryBackoffDef)
+	sysctl.AddIntSysctl("fn_retry_backoff_max_ms", &retryBackoffMax)
+	sysctl.AddIntSysctl("fn_stream_tmo_sec", &streamTmo)
+	sysctl.AddIntSysctl("call_body_max_kb", &callBodyMax)
+	sysctl.AddIntSysctl("call_store_max_kb", &callStoreMax)
+	sysctl.AddBoolSysctl("call_trust_proxy", &callTrustProxy)
+
 	sysctl.AddSysctl("call_accepted_ctyp",
 		func() string { return acceptedContent.String() },
 		func (nv string) error {
@@ -34,46 +57,176 @@ func init() {
 		})
 }
 
-func makeArgs(args *swyapi.FunctionRun, sopq *statsOpaque, r *http.Request) {
+/*
+ * With gate sitting behind a proxy the peer address is the proxy's
+ * one, the client's is in the headers then. Without one the headers
+ * are not to be trusted.
+ */
+func remoteAddr(r *http.Request) string {
+	if callTrustProxy {
+		if ff := r.Header.Get("X-Forwarded-For"); ff != "" {
+			return strings.TrimSpace(strings.SplitN(ff, ",", 2)[0])
+		}
+		if ri := r.Header.Get("X-Real-IP"); ri != "" {
+			return ri
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+/*
+ * Call args and results kept in DB documents (pending retries, dead
+ * letters, invocations, idempotent results) should leave mongo's 16MB
+ * document limit enough room for the rest of the fields
+ */
+func argsPayload(args *swyapi.FunctionRun) int {
+	sz := len(args.Body) + len(args.Raw)
+	for k, v := range args.Form {
+		sz += len(k) + len(v)
+	}
+	for _, f := range args.Files {
+		sz += len(f.Data)
+	}
+	return sz
+}
+
+func argsStorable(args *swyapi.FunctionRun) bool {
+	return argsPayload(args) <= callStoreMax << 10
+}
+
+func resStorable(res *swyapi.WdogFunctionRunResult) bool {
+	return len(res.Return) + len(res.Raw) + len(res.Stdout) + len(res.Stderr) <= callStoreMax << 10
+}
+
+func makeArgs(ctx context.Context, args *swyapi.FunctionRun, sopq *statsOpaque, r *http.Request) (int, error) {
 	defer r.Body.Close()
 
 	args.Args = make(map[string]string)
+	args.Query = r.URL.Query()
 
-	for k, v := range r.URL.Query() {
+	for k, v := range args.Query {
 		if len(v) < 1 {
 			continue
 		}
 
 		args.Args[k] = v[0]
-		sopq.argsSz += len(k) + len(v[0])
-	}
-
-	body, err := ioutil.ReadAll(r.Body)
-	if err == nil && len(body) > 0 {
-		ct := r.Header.Get("Content-Type")
-		ctp := strings.SplitN(ct, ";", 2)
-		if len(ctp) > 0 {
-			/*
-			 * Some comments on the content/type
-			 * THe text/plain type is simple
-			 * The app/json type means, there's an object
-			 * inside and we can decode it rigt in the
-			 * runner. On the other hand, decoding the
-			 * json into a struct, rather into a generic
-			 * map is better for compile-able languages.
-			 * Any binary type is better to be handled
-			 * with asyncs, as binary data can be big and
-			 * tranferring is back and firth is not good.
-			 */
-			if acceptedContent.Have(ctp[0]) {
-				args.ContentType = ctp[0]
-				args.Body = string(body)
-				sopq.bodySz = len(body)
-			}
+		for _, x := range v {
+			sopq.argsSz += len(k) + len(x)
 		}
 	}
 
+	/* Repeated headers are folded the way RFC7230 allows */
+	args.Headers = make(map[string]string)
+	for h, v := range r.Header {
+		if h == "Cookie" {
+			continue
+		}
+		args.Headers[h] = strings.Join(v, ", ")
+	}
+
+	if cks := r.Cookies(); len(cks) != 0 {
+		args.Cookies = make(map[string]string)
+		for _, c := range cks {
+			args.Cookies[c.Name] = c.Value
+		}
+	}
+
+	args.Remote = remoteAddr(r)
+	args.ReqId = r.Header.Get("X-Request-Id")
+	if args.ReqId == "" {
+		args.ReqId = strconv.FormatUint(gctx(ctx).ReqId, 16)
+	}
+
 	args.Method = &r.Method
+
+	max := int64(callBodyMax) << 10
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, max + 1))
+	if err != nil || len(body) == 0 {
+		return 0, nil
+	}
+
+	if int64(len(body)) > max {
+		return http.StatusRequestEntityTooLarge, errors.New("Body too large")
+	}
+
+	sopq.bodySz = len(body)
+
+	ct := r.Header.Get("Content-Type")
+	ctp := strings.SplitN(ct, ";", 2)
+
+	/*
+	 * Some comments on the content/type
+	 * THe text/plain type is simple
+	 * The app/json type means, there's an object
+	 * inside and we can decode it rigt in the
+	 * runner. On the other hand, decoding the
+	 * json into a struct, rather into a generic
+	 * map is better for compile-able languages.
+	 * Forms are parsed here, files go as binary
+	 * parts. Any other type is passed as is, the
+	 * body is base64-ed on the wire to wdog.
+	 */
+	switch {
+	case acceptedContent.Have(ctp[0]):
+		args.ContentType = ctp[0]
+		args.Body = string(body)
+	case ctp[0] == "multipart/form-data":
+		args.ContentType = ctp[0]
+		err = makeFormArgs(args, ct, body)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	default:
+		args.ContentType = ctp[0]
+		args.Raw = body
+	}
+
+	return 0, nil
+}
+
+func makeFormArgs(args *swyapi.FunctionRun, ct string, body []byte) error {
+	_, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return fmt.Errorf("Bad content type: %s", err.Error())
+	}
+
+	args.Form = make(map[string]string)
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Bad form: %s", err.Error())
+		}
+
+		data, err := ioutil.ReadAll(p)
+		if err != nil {
+			return fmt.Errorf("Bad form part: %s", err.Error())
+		}
+
+		if p.FileName() == "" {
+			args.Form[p.FormName()] = string(data)
+			continue
+		}
+
+		args.Files = append(args.Files, &swyapi.FunctionFile {
+			Field:		p.FormName(),
+			Name:		p.FileName(),
+			ContentType:	p.Header.Get("Content-Type"),
+			Data:		data,
+		})
+	}
+
+	return nil
 }
 
 type podConn struct {
@@ -82,17 +235,27 @@ type podConn struct {
 	Port	string
 	FnId	string
 	PTok	string
+	Version	string
+	DepName	string
 }
 
-func talkHTTP(addr, port, url string, args *swyapi.FunctionRun) (*swyapi.WdogFunctionRunResult, error) {
+type streamSink func(*swyapi.WdogFunctionRunChunk) error
+
+func talkHTTP(addr, port, url string, args *swyapi.FunctionRun, sink streamSink) (*swyapi.WdogFunctionRunResult, error) {
 	var resp *http.Response
 	var res swyapi.WdogFunctionRunResult
 	var err error
 
+	tmo := uint(conf.Runtime.Timeout.Max)
+	if sink != nil {
+		/* Chunks keep the runner alive, so the whole call may last longer */
+		tmo = uint(streamTmo)
+	}
+
 	resp, err = xhttp.Req(
 			&xhttp.RestReq{
 				Address: "http://" + addr + ":" + port + "/v1/run/" + url,
-				Timeout: uint(conf.Runtime.Timeout.Max),
+				Timeout: tmo,
 			}, args)
 	if err != nil {
 		if resp == nil {
@@ -103,6 +266,10 @@ func talkHTTP(addr, port, url string, args *swyapi.FunctionRun) (*swyapi.WdogFun
 		return nil, err
 	}
 
+	if resp.Header.Get("Content-Type") == swyapi.WdogStreamType {
+		return talkStream(resp, sink)
+	}
+
 	err = xhttp.RResp(resp, &res)
 	if err != nil {
 		return nil, err
@@ -111,6 +278,33 @@ func talkHTTP(addr, port, url string, args *swyapi.FunctionRun) (*swyapi.WdogFun
 	return &res, nil
 }
 
+func talkStream(resp *http.Response, sink streamSink) (*swyapi.WdogFunctionRunResult, error) {
+	defer resp.Body.Close()
+
+	if sink == nil {
+		return nil, errors.New("Unexpected stream")
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ch swyapi.WdogFunctionRunChunk
+
+		err := dec.Decode(&ch)
+		if err != nil {
+			return nil, fmt.Errorf("Stream broken: %s", err.Error())
+		}
+
+		if ch.Res != nil {
+			return ch.Res, nil
+		}
+
+		err = sink(&ch)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 func traceTime(sopq *statsOpaque, w string, wt *uint) {
 	if sopq != nil && sopq.trace != nil {
 		sopq.trace[w] = time.Since(sopq.ts)
@@ -121,23 +315,36 @@ func traceTime(sopq *statsOpaque, w string, wt *uint) {
 }
 
 func (conn *podConn)Run(ctx context.Context, sopq *statsOpaque, suff, event string, args *swyapi.FunctionRun) (*swyapi.WdogFunctionRunResult, error) {
+	return conn.run(ctx, sopq, suff, event, args, nil)
+}
+
+/* Chunks the fn streams (if it does) go to sink as they come */
+func (conn *podConn)RunStream(ctx context.Context, sopq *statsOpaque, event string, args *swyapi.FunctionRun, sink streamSink) (*swyapi.WdogFunctionRunResult, error) {
+	args.Stream = true
+	return conn.run(ctx, sopq, "", event, args, sink)
+}
+
+func (conn *podConn)run(ctx context.Context, sopq *statsOpaque, suff, event string, args *swyapi.FunctionRun, sink streamSink) (*swyapi.WdogFunctionRunResult, error) {
 	var res *swyapi.WdogFunctionRunResult
 	var err error
 
 	args.Event = event
 	proxy := (conf.Wdog.Proxy != 0) && suff == ""
+	if sopq != nil {
+		sopq.ver = conn.Version
+	}
 
 	traceTime(sopq, "wdog.req", nil)
 
 	if proxy {
 		res, err = talkHTTP(conn.Host, conf.Wdog.p_port,
-				conn.PTok + "/" + strings.Replace(conn.Addr, ".", "_", -1), args)
+				conn.PTok + "/" + strings.Replace(conn.Addr, ".", "_", -1), args, sink)
 	} else {
 		url := conn.PTok
 		if suff != "" {
 			url += "/" + suff
 		}
-		res, err = talkHTTP(conn.Addr, conn.Port, url, args)
+		res, err = talkHTTP(conn.Addr, conn.Port, url, args, sink)
 	}
 
 	if err != nil {
@@ -164,7 +371,21 @@ func doRun(ctx context.Context, fn *FunctionDesc, event string, args *swyapi.Fun
 		return nil, err
 	}
 
-	conn, err := balancerGetConnAny(ctx, fmd)
+	err = fmd.conc.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fmd.conc.put()
+
+	sopq := statsStart()
+
+	var conn *podConn
+	if fn.alias != "" {
+		conn, err = balancerGetConnAlias(ctx, fmd, fn.alias)
+	} else {
+		conn, err = balancerGetConnAny(ctx, fmd, "", sopq)
+	}
 	if conn == nil {
 		ctxlog(ctx).Errorf("Can't find %s cookie balancer: %s", fn.Cookie, err.Error())
 		return nil, fmt.Errorf("Can't find balancer for %s", fn.Cookie)
@@ -173,7 +394,6 @@ func doRun(ctx context.Context, fn *FunctionDesc, event string, args *swyapi.Fun
 	defer balancerPutConn(fmd)
 	traceFnEvent(ctx, "run (" + event + ")", fn)
 
-	sopq := statsStart()
 	res, err := conn.Run(ctx, sopq, "", event, args)
 	if err == nil {
 		statsUpdate(fmd, sopq, res, event)
@@ -185,11 +405,87 @@ func doRun(ctx context.Context, fn *FunctionDesc, event string, args *swyapi.Fun
 	return res, err
 }
 
-func doRunBg(ctx context.Context, fn *FunctionDesc, event string, args *swyapi.FunctionRun) {
-	_, err := doRun(ctx, fn, event, args)
+func (rp *FnRetryDesc)attempts() uint {
+	if rp == nil || rp.Attempts == 0 {
+		return 1
+	}
+
+	return rp.Attempts
+}
+
+func (rp *FnRetryDesc)delay(attempt uint) time.Duration {
+	d := rp.Backoff
+	for i := uint(1); i < attempt && d < rp.MaxBackoff; i++ {
+		d <<= 1
+	}
+	if d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+
+	return time.Duration(d) * time.Millisecond
+}
+
+/*
+ * Returns why the call is considered failed or empty string if it's not.
+ * Errors talking to wdog and wdog-reported ones (negative codes, e.g.
+ * timeout) are failures always, fn's own codes -- if the policy says so.
+ */
+func (rp *FnRetryDesc)failed(res *swyapi.WdogFunctionRunResult, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	if res.Code < 0 {
+		return fmt.Sprintf("wdog error %d", -res.Code)
+	}
+
+	if rp != nil {
+		for _, c := range rp.Codes {
+			if c == res.Code {
+				return fmt.Sprintf("code %d", res.Code)
+			}
+		}
+	}
+
+	return ""
+}
+
+/*
+ * Returns why the first attempt failed, empty string if it
+ * didn't. Retries, if any, go on in background.
+ */
+func doRunBg(ctx context.Context, fn *FunctionDesc, event string, args *swyapi.FunctionRun) string {
+	return doRunBgAttempt(ctx, fn, event, args, 1)
+}
+
+func doRunBgAttempt(ctx context.Context, fn *FunctionDesc, event string, args *swyapi.FunctionRun, attempt uint) string {
+	res, err := doRun(ctx, fn, event, args)
+	why := fn.Retry.failed(res, err)
+	if why == "" {
+		return ""
+	}
+
+	ctxlog(ctx).Errorf("bg.%s: error running fn %s (attempt %d): %s", event, fn.SwoId.Str(), attempt, why)
+
+	/* Without the retry policy the failure is only logged */
+	if fn.Retry == nil {
+		return why
+	}
+
+	if attempt >= fn.Retry.attempts() {
+		dlqPut(fn, event, args, attempt, why)
+		return why
+	}
+
+	err = retryPut(fn, event, args, attempt + 1, time.Now().Add(fn.Retry.delay(attempt)))
 	if err != nil {
-		ctxlog(ctx).Errorf("bg.%s: error running fn: %s", event, err.Error())
+		ctxlog(ctx).Errorf("Can't schedule retry of %s: %s", fn.SwoId.Str(), err.Error())
+		dlqPut(fn, event, args, attempt, why)
+		return why
 	}
+
+	bgRetries.WithLabelValues(event).Inc()
+	return why
 }
 
 func prepareTempRun(ctx context.Context, fn *FunctionDesc, td *TenantMemData, params *swyapi.FunctionSources, w http.ResponseWriter) (string, *xrest.ReqErr) {
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"fmt"
 	"errors"
+	"time"
 	"context"
 	"net/http"
 	"encoding/json"
@@ -185,6 +186,7 @@ func handleS3Event(ctx context.Context, user string, data []byte) {
 			continue
 		}
 
+		fn.alias = ed.Alias
 		doRunBg(ctx, &fn, "s3",
 				&swyapi.FunctionRun{Args: map[string]string {
 					"bucket": evt.Bucket,
@@ -296,6 +298,55 @@ func s3GetCreds(ctx context.Context, acc *swyapi.S3Access) (*swyapi.S3Creds, *xr
 	return creds, nil
 }
 
+func s3PresignURL(ctx context.Context, ps *swyapi.S3Presign) (*swyapi.S3PresignedURL, *xrest.ReqErr) {
+	if conf.Mware.S3 == nil {
+		return nil, GateErrC(swyapi.GateNotAvail)
+	}
+
+	if ps.Bucket == "" || ps.Object == "" {
+		return nil, GateErrM(swyapi.GateBadRequest, "Bucket and object required")
+	}
+
+	if ps.Expires == 0 {
+		return nil, GateErrM(swyapi.GateBadRequest, "Perpetual URLs not allowed")
+	}
+
+	if ps.Method == "" {
+		ps.Method = http.MethodGet
+	}
+
+	enforceS3Limits(ctx)
+
+	var out swys3api.PresignResult
+
+	id := ctxSwoId(ctx, DefaultProject, "")
+	err, code := s3Call2(
+		&xhttp.RestReq{
+			Method: "POST",
+			Address: "/v1/api/presign",
+		}, &swys3api.Presign {
+			Namespace:	id.S3Namespace(),
+			Bucket:		ps.Bucket,
+			Object:		ps.Object,
+			Method:		ps.Method,
+			Expires:	ps.Expires,
+			Endpoint:	s3Endpoint(conf.Mware.S3, true),
+		}, &out)
+	if err != nil {
+		if code == http.StatusBadRequest {
+			return nil, GateErrM(swyapi.GateBadRequest, "Bad presign request")
+		}
+
+		ctxlog(ctx).Errorf("Can't presign S3 URL for %s.%s: %s", id.Str(), ps.Bucket, err.Error())
+		return nil, GateErrM(swyapi.GateGenErr, "Error talking to S3")
+	}
+
+	return &swyapi.S3PresignedURL {
+		URL:		out.URL,
+		Expires:	time.Now().Add(time.Duration(ps.Expires) * time.Second).Format(time.RFC1123Z),
+	}, nil
+}
+
 var s3EOps = EventOps {
 	setup: func(ed *FnEventDesc, evt *swyapi.FunctionEvent) error {
 		if conf.Mware.S3 == nil {
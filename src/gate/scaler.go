@@ -7,11 +7,187 @@ package main
 
 import (
 	"fmt"
+	"errors"
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
+	"gopkg.in/mgo.v2/bson"
+	"swifty/apis"
+	"swifty/common/xrest/sysctl"
 )
 
+var (
+	fnIdleMin int				= 60 /* sec */
+	fnIdleCheckPeriod time.Duration		= 30 * time.Second
+	coldQueueMax int			= 64
+	coldStartTmo time.Duration		= 60 * time.Second
+	scaleDecisionsMax int			= 16
+)
+
+func init() {
+	sysctl.AddIntSysctl("fn_idle_min_sec",		&fnIdleMin)
+	sysctl.AddTimeSysctl("fn_idle_check_period",	&fnIdleCheckPeriod)
+	sysctl.AddIntSysctl("fn_cold_queue_max",	&coldQueueMax)
+	sysctl.AddTimeSysctl("fn_cold_start_tmo",	&coldStartTmo)
+	sysctl.AddIntSysctl("fn_scale_decisions",	&scaleDecisionsMax)
+}
+
+/*
+ * Autoscaler keeps target calls in flight per pod. When more
+ * calls come in the deployment grows (up to max replicas) and,
+ * after the load goes away, shrinks back down to min ones.
+ */
+type FnScaleDesc struct {
+	Target		uint		`bson:"target"`
+	Min		uint		`bson:"min"`
+	Max		uint		`bson:"max"`
+	UpWin		uint		`bson:"upwin,omitempty"`		// msec
+	DownWin		uint		`bson:"downwin,omitempty"`	// msec
+}
+
+type scaleMemData struct {
+	target		uint32
+	min		uint32
+	max		uint32
+	upw		time.Duration
+	downw		time.Duration
+}
+
+type scaleDecision struct {
+	ts		time.Time
+	from		uint32
+	to		uint32
+	why		string
+}
+
+func fnFixScale(sc *swyapi.FunctionScaling) error {
+	if sc.Target == 0 {
+		sc.Target = 1
+	}
+
+	if sc.Min == 0 {
+		sc.Min = 1
+	}
+
+	if sc.Max == 0 {
+		sc.Max = uint(conf.Runtime.MaxReplicas)
+	} else if sc.Max > uint(conf.Runtime.MaxReplicas) {
+		return errors.New("Too many max replicas")
+	}
+
+	if sc.Min > sc.Max {
+		return errors.New("Min replicas exceed max ones")
+	}
+
+	return nil
+}
+
+func (sc *FnScaleDesc)toInfo() *swyapi.FunctionScaling {
+	if sc == nil {
+		return nil
+	}
+
+	return &swyapi.FunctionScaling {
+		Target:		sc.Target,
+		Min:		sc.Min,
+		Max:		sc.Max,
+		UpWindow:	sc.UpWin,
+		DownWindow:	sc.DownWin,
+	}
+}
+
+func (sc *FnScaleDesc)eq(sc2 *FnScaleDesc) bool {
+	if sc == nil || sc2 == nil {
+		return sc == sc2
+	}
+
+	return *sc == *sc2
+}
+
+func scaleDescFrom(sc *swyapi.FunctionScaling) *FnScaleDesc {
+	if sc == nil {
+		return nil
+	}
+
+	return &FnScaleDesc {
+		Target:		sc.Target,
+		Min:		sc.Min,
+		Max:		sc.Max,
+		UpWin:		sc.UpWindow,
+		DownWin:	sc.DownWindow,
+	}
+}
+
+/* Replicas the deployment is started with and shrinks down to */
+func (sz *FnSizeDesc)minReplicas() int {
+	min := uint(1)
+	if sz.Scale != nil {
+		min = sz.Scale.Min
+	}
+	if sz.Warm > min {
+		min = sz.Warm
+	}
+
+	return int(min)
+}
+
+func (sz *FnSizeDesc)toScaleMem() *scaleMemData {
+	sm := &scaleMemData{target: 1, min: uint32(sz.Replicas)}
+
+	if sc := sz.Scale; sc != nil {
+		sm.target = uint32(sc.Target)
+		sm.max = uint32(sc.Max)
+		sm.upw = time.Duration(sc.UpWin) * time.Millisecond
+		sm.downw = time.Duration(sc.DownWin) * time.Millisecond
+	}
+
+	return sm
+}
+
+/* Zero limits mean "use the global setting", these can be tuned on the fly */
+func (sm *scaleMemData)maxReplicas() uint32 {
+	if sm.max == 0 || sm.max > uint32(conf.Runtime.MaxReplicas) {
+		return uint32(conf.Runtime.MaxReplicas)
+	}
+
+	return sm.max
+}
+
+func (sm *scaleMemData)relax() time.Duration {
+	if sm.downw == 0 {
+		return DepScaleupRelax
+	}
+
+	return sm.downw
+}
+
+func (fdm *FnMemData)inFlight() uint32 {
+	return atomic.LoadUint32(&fdm.bd.rover[0]) - atomic.LoadUint32(&fdm.bd.rover[1])
+}
+
+func (fdm *FnMemData)scalingStatus(ctx context.Context) *swyapi.FunctionScalingStatus {
+	ret := &swyapi.FunctionScalingStatus {
+		InFlight:	fdm.inFlight(),
+		Replicas:	uint32(len(balancerMainPods(ctx, fdm))),
+		Decisions:	[]*swyapi.FunctionScaleDecision{},
+	}
+
+	fdm.lock.Lock()
+	ret.Goal = fdm.bd.goal
+	for _, d := range fdm.bd.decisions {
+		ret.Decisions = append(ret.Decisions, &swyapi.FunctionScaleDecision {
+			Ts:	d.ts.Format(time.RFC1123Z),
+			From:	d.from,
+			To:	d.to,
+			Why:	d.why,
+		})
+	}
+	fdm.lock.Unlock()
+
+	return ret
+}
+
 func condWaitTmo(cond *sync.Cond, tmo time.Duration) {
 	d := time.AfterFunc(tmo, func() { cond.Signal() })
 	cond.Wait()
@@ -19,11 +195,27 @@ func condWaitTmo(cond *sync.Cond, tmo time.Duration) {
 }
 
 func scalerLog(fdm *FnMemData, msg string) {
+	scalerLogGoal(fdm, msg, fdm.bd.goal)
+}
+
+func scalerLogGoal(fdm *FnMemData, msg string, goal uint32) {
 	ctx, done := mkContext("::scaler")
 	defer done(ctx)
 
-	ctxlog(ctx).Debugf("Scale %s %s to %d", fdm.depname, msg, fdm.bd.goal)
-	logSaveEvent(ctx, fdm.fnid, fmt.Sprintf("scale %s -> %d", msg, fdm.bd.goal))
+	ctxlog(ctx).Debugf("Scale %s %s to %d", fdm.depname, msg, goal)
+
+	fdm.lock.Lock()
+	if fdm.bd.scaled != goal {
+		d := &scaleDecision{ts: time.Now(), from: fdm.bd.scaled, to: goal, why: msg}
+		fdm.bd.decisions = append(fdm.bd.decisions, d)
+		if l := len(fdm.bd.decisions); l > scaleDecisionsMax {
+			fdm.bd.decisions = fdm.bd.decisions[l - scaleDecisionsMax:]
+		}
+		fdm.bd.scaled = goal
+	}
+	fdm.lock.Unlock()
+
+	logSaveEvent(ctx, fdm.fnid, fmt.Sprintf("scale %s -> %d", msg, goal))
 }
 
 func balancerFnScaler(fdm *FnMemData) {
@@ -42,10 +234,10 @@ up:
 		goto up
 	}
 relax:
-	condWaitTmo(fdm.bd.wakeup, DepScaleupRelax)
+	condWaitTmo(fdm.bd.wakeup, fdm.scale.relax())
 
 down:
-	if fdm.bd.goal <= 1 {
+	if fdm.bd.goal <= fdm.scale.min {
 		fdm.bd.wakeup = nil
 		goto fin
 	}
@@ -89,9 +281,18 @@ func scalerStop(ctx context.Context, fdm *FnMemData) {
 	fdm.lock.Unlock()
 }
 
-func scalerSetGoal(ctx context.Context, fdm *FnMemData, goal uint32) {
+func scalerSetGoal(ctx context.Context, fdm *FnMemData, inflight uint32) {
+	sm := fdm.scale
+	goal := (inflight + sm.target - 1) / sm.target
+
 	scalerGoals.Observe(float64(goal))
-	if goal <= fdm.bd.goal {
+	if goal <= fdm.bd.goal || goal <= sm.min {
+		if sm.upw != 0 && !fdm.bd.upts.IsZero() {
+			/* Load went down, the up window starts over */
+			fdm.lock.Lock()
+			fdm.bd.upts = time.Time{}
+			fdm.lock.Unlock()
+		}
 		return
 	}
 
@@ -101,11 +302,26 @@ func scalerSetGoal(ctx context.Context, fdm *FnMemData, goal uint32) {
 		return
 	}
 
-	if goal > uint32(conf.Runtime.MaxReplicas) {
-		fdm.lock.Unlock()
+	if max := sm.maxReplicas(); goal > max {
 		ctxlog(ctx).Debugf("Too many replicas (%d) needed for %s", goal, fdm.depname)
 		scaleOverruns.Inc()
-		return
+		if max <= fdm.bd.goal {
+			fdm.lock.Unlock()
+			return
+		}
+		goal = max
+	}
+
+	if sm.upw != 0 {
+		/* Only scale up if the load holds for the up window */
+		if fdm.bd.upts.IsZero() {
+			fdm.bd.upts = time.Now()
+		}
+		if time.Since(fdm.bd.upts) < sm.upw {
+			fdm.lock.Unlock()
+			return
+		}
+		fdm.bd.upts = time.Time{}
 	}
 
 	fdm.bd.goal = goal
@@ -131,3 +347,168 @@ func scalerInit(ctx context.Context, fn *FunctionDesc, tgt uint32) error {
 
 	return nil
 }
+
+func (sz *FnSizeDesc)idleTmo() time.Duration {
+	return time.Duration(sz.Idle) * time.Second
+}
+
+/*
+ * Functions with idle timeout set get scaled to zero replicas
+ * when not called for that long. The next call wakes one up.
+ * The last call time is taken from the DB stats, so that calls
+ * that come via other gates are seen too, and each gate keeps
+ * it fresh for the calls it has in flight.
+ */
+func scalerIdler() {
+	for {
+		time.Sleep(fnIdleCheckPeriod)
+
+		ctx, done := mkContext("::idler")
+		scalerIdleScan(ctx)
+		done(ctx)
+	}
+}
+
+func scalerIdleScan(ctx context.Context) {
+	var fn FunctionDesc
+
+	iter := dbIterAll(ctx, bson.M{"state": DBFuncStateRdy, "size.idle": bson.M{"$gt": 0}}, &fn)
+	defer iter.Close()
+
+	for iter.Next(&fn) {
+		fdm := memdGetCond(fn.Cookie)
+		if fdm != nil && fdm.isBusy() {
+			dbFnStatsTouch(ctx, fn.Cookie, time.Now())
+			continue
+		}
+
+		last, err := dbFnLastCall(ctx, fn.Cookie)
+		if err != nil {
+			ctxlog(ctx).Errorf("Can't get %s last call: %s", fn.SwoId.Str(), err.Error())
+			continue
+		}
+
+		if time.Since(last) <= fn.Size.idleTmo() || !scalerHasBasePods(ctx, &fn) {
+			continue
+		}
+
+		if fdm != nil {
+			scalerLogGoal(fdm, "idle", 0)
+		} else {
+			ctxlog(ctx).Debugf("Scale %s idle to 0", fn.DepName())
+		}
+		k8sDepScaleDown(fn.DepName(), 0)
+		if fn.Canary != nil {
+			k8sDepScaleDown(fn.CanaryDepName(), 0)
+		}
+		scaleIdles.Inc()
+	}
+
+	err := iter.Err()
+	if err != nil {
+		ctxlog(ctx).Errorf("Can't scan for idle fns: %s", err.Error())
+	}
+}
+
+func scalerHasBasePods(ctx context.Context, fn *FunctionDesc) bool {
+	for _, ap := range podsFindAll(ctx, fn.Cookie) {
+		if ap.DepName == fn.DepName() {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* Whether this gate is waking the fn up or has calls to it in flight */
+func (fdm *FnMemData)isBusy() bool {
+	fdm.lock.Lock()
+	defer fdm.lock.Unlock()
+
+	if fdm.bd.wakeup != nil || fdm.bd.cold != nil {
+		return true
+	}
+
+	return atomic.LoadUint32(&fdm.bd.rover[0]) != atomic.LoadUint32(&fdm.bd.rover[1])
+}
+
+/*
+ * Calls that come to a function scaled to zero wait here for
+ * the first pod to appear. The number of waiters is limited,
+ * the excessive calls are rejected right at once.
+ */
+type coldStart struct {
+	ts	time.Time
+	queued	int
+	up	chan bool
+}
+
+func scalerColdStart(ctx context.Context, fdm *FnMemData, sopq *statsOpaque) error {
+	var err error
+
+	fdm.lock.Lock()
+	cs := fdm.bd.cold
+	wake := (cs == nil)
+	if wake {
+		cs = &coldStart{ts: time.Now(), up: make(chan bool)}
+		fdm.bd.cold = cs
+	}
+	if cs.queued >= coldQueueMax {
+		fdm.lock.Unlock()
+		coldOverruns.Inc()
+		return errors.New("Too many calls wait for cold start")
+	}
+	cs.queued++
+	fdm.lock.Unlock()
+
+	if wake {
+		scalerLogGoal(fdm, "cold", fdm.scale.min)
+		/* Other gates should not scale it back before the call comes */
+		dbFnStatsTouch(ctx, fdm.fnid, time.Now())
+		k8sDepScaleUp(fdm.depname, fdm.scale.min)
+		if fdm.canary() != nil {
+			k8sDepScaleUp(fdm.depname + canaryDepSuff, 1)
+		}
+
+		/* The pod might have come up before we set the cs */
+		if balancerHasBasePods(ctx, fdm) {
+			scalerColdDone(fdm)
+		}
+	}
+
+	select {
+	case <-cs.up:
+		traceTime(sopq, "cold", nil)
+	case <-time.After(coldStartTmo):
+		err = errors.New("Cold start timeout")
+	}
+
+	fdm.lock.Lock()
+	cs.queued--
+	if err != nil && fdm.bd.cold == cs {
+		/* Let the next call try again */
+		fdm.bd.cold = nil
+	}
+	fdm.lock.Unlock()
+
+	return err
+}
+
+func scalerColdDone(fdm *FnMemData) {
+	fdm.lock.Lock()
+	cs := fdm.bd.cold
+	if cs == nil {
+		fdm.lock.Unlock()
+		return
+	}
+
+	lat := time.Since(cs.ts)
+	fdm.bd.cold = nil
+	fdm.stats.ColdStarts++
+	fdm.stats.ColdTime += lat
+	close(cs.up)
+	fdm.lock.Unlock()
+
+	fdm.stats.Dirty()
+	coldStartLat.Observe(lat.Seconds())
+}
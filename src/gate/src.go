@@ -188,6 +188,10 @@ func init() {
 }
 
 func GCOldSources(ctx context.Context, fn *FunctionDesc, ver string) {
+	if fn.isPublished(ver) {
+		return
+	}
+
 	np, err := xh.DropDirPrep(functionsDir(), fn.srcDir(ver))
 	if err != nil {
 		ctxlog(ctx).Errorf("Leaking %s sources till FN removal (err %s)", ver, err.Error())
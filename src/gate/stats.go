@@ -83,6 +83,10 @@ func (fs *FnStats)RunTimeUsec() uint64 {
 	return uint64(fs.RunTime/time.Microsecond)
 }
 
+func (fs *FnStats)ColdTimeUsec() uint64 {
+	return uint64(fs.ColdTime/time.Microsecond)
+}
+
 func getCallStats(ctx context.Context, periods int) ([]swyapi.TenantStatsFn, *xrest.ReqErr) {
 	var cs []swyapi.TenantStatsFn
 
@@ -159,6 +163,8 @@ func (fn *FunctionDesc)getStats(ctx context.Context, periods int) ([]swyapi.Func
 				Time:		prev.RunTimeUsec() - cur.RunTimeUsec(),
 				GBS:		GBS(prev.RunCost - cur.RunCost),
 				BytesOut:	prev.BytesOut - cur.BytesOut,
+				ColdStarts:	prev.ColdStarts - cur.ColdStarts,
+				ColdTime:	prev.ColdTimeUsec() - cur.ColdTimeUsec(),
 				Till:		prev.TillS(),
 				From:		cur.TillS(),
 			})
@@ -174,6 +180,8 @@ func (fn *FunctionDesc)getStats(ctx context.Context, periods int) ([]swyapi.Func
 		Time:		prev.RunTimeUsec(),
 		GBS:		GBS(prev.RunCost),
 		BytesOut:	prev.BytesOut,
+		ColdStarts:	prev.ColdStarts,
+		ColdTime:	prev.ColdTimeUsec(),
 		Till:		prev.TillS(),
 	})
 
@@ -184,6 +192,7 @@ type statsOpaque struct {
 	ts		time.Time
 	argsSz		int
 	bodySz		int
+	ver		string
 	trace		map[string]time.Duration
 }
 
@@ -237,6 +246,10 @@ func statsUpdate(fmd *FnMemData, op *statsOpaque, res *swyapi.WdogFunctionRunRes
 
 	fmd.stats.Dirty()
 
+	if cn := fmd.canary(); cn != nil && cn.ver == op.ver {
+		cn.account(fmd, res)
+	}
+
 	td := fmd.td
 	td.lock.Lock()
 	td.stats.RunCost += rc
@@ -304,6 +317,8 @@ func (st *FnStats)Write(ctx context.Context) error {
 		BytesIn: now.BytesIn - st.onDisk.BytesIn,
 		BytesOut: now.BytesOut - st.onDisk.BytesOut,
 		RunCost: now.RunCost - st.onDisk.RunCost,
+		ColdStarts: now.ColdStarts - st.onDisk.ColdStarts,
+		ColdTime: now.ColdTime - st.onDisk.ColdTime,
 	}
 	err := dbFnStatsUpdate(ctx, st.Cookie, &delta, now.LastCall)
 	if err == nil {
@@ -19,8 +19,10 @@ func doThenCall(ctx context.Context, fmd *FnMemData, tc *swyapi.ThenCall) {
 		cctx, done := mkContext("::cron")
 		defer done(cctx)
 
+		var alias string
+
 		id := fmd.id
-		id.Name = tc.Name
+		id.Name, alias = splitAlias(tc.Name)
 
 		var fn FunctionDesc
 
@@ -36,10 +38,33 @@ func doThenCall(ctx context.Context, fmd *FnMemData, tc *swyapi.ThenCall) {
 			return
 		}
 
+		fn.alias = alias
 		doRunBg(cctx, &fn, "then", &swyapi.FunctionRun{Args: tc.Args})
 	}()
 }
 
+func doThenDelayed(ctx context.Context, fmd *FnMemData, td *swyapi.ThenDelayed) {
+	cctx, done := mkContext("::then")
+	defer done(cctx)
+
+	var alias string
+	var fn FunctionDesc
+
+	id := fmd.id
+	id.Name, alias = splitAlias(td.Name)
+
+	at, err := delayWhen(td.At, td.Delay)
+	if err == nil {
+		err = dbFind(cctx, bson.M{"cookie": id.Cookie()}, &fn)
+	}
+	if err == nil {
+		_, err = delayPut(&fn, alias, at, td.Args)
+	}
+	if err != nil {
+		logSaveEvent(ctx, fmd.fnid, "Can't delay " + td.Name + " call: " + err.Error())
+	}
+}
+
 func noteThens(ctx context.Context, fmd *FnMemData, then_msg json.RawMessage) {
 	var then swyapi.Then
 
@@ -53,4 +78,8 @@ func noteThens(ctx context.Context, fmd *FnMemData, then_msg json.RawMessage) {
 	case then.Call != nil:
 		doThenCall(ctx, fmd, then.Call)
 	}
+
+	for _, td := range then.Delayed {
+		doThenDelayed(ctx, fmd, td)
+	}
 }
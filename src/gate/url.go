@@ -125,7 +125,7 @@ var urlEOps = EventOps {
 func (furl *FnURL)Handle(ctx context.Context, w http.ResponseWriter, r *http.Request, sopq *statsOpaque) {
 	path := reqPath(r)
 	args := &swyapi.FunctionRun{Path: &path}
-	furl.fd.Handle(ctx, w, r, sopq, args)
+	furl.fd.Handle(ctx, w, r, sopq, args, "")
 }
 
 var wrl *xrl.RL
@@ -158,12 +158,117 @@ func init() {
 			})
 }
 
+/*
+ * Proxies the chunks fn streams to the client as they come. The
+ * response has no length, so it goes with chunked encoding.
+ */
+type streamResp struct {
+	w	http.ResponseWriter
+	started	bool
+}
+
+func (sr *streamResp)chunk(ch *swyapi.WdogFunctionRunChunk) error {
+	if !sr.started {
+		ct := ch.CType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		sr.w.Header().Set("Content-Type", ct)
+		sr.w.Header().Set("Cache-Control", "no-cache")
+		sr.w.WriteHeader(http.StatusOK)
+		sr.started = true
+	}
+
+	_, err := sr.w.Write([]byte(ch.Data))
+	if err != nil {
+		return err
+	}
+
+	if f, ok := sr.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+/* Headers the function may not set, http server manages them */
+var fnHeadersSkip = map[string]bool {
+	"Content-Length":	true,
+	"Transfer-Encoding":	true,
+	"Connection":		true,
+	"Set-Cookie":		true,
+}
+
+func respond(w http.ResponseWriter, res *swyapi.WdogFunctionRunResult) {
+	for h, v := range res.Headers {
+		h = http.CanonicalHeaderKey(h)
+		if !fnHeadersSkip[h] {
+			w.Header().Set(h, v)
+		}
+	}
+
+	for _, c := range res.Cookies {
+		http.SetCookie(w, &http.Cookie {
+			Name:		c.Name,
+			Value:		c.Value,
+			Path:		c.Path,
+			Domain:		c.Domain,
+			MaxAge:		c.MaxAge,
+			Secure:		c.Secure,
+			HttpOnly:	c.HttpOnly,
+		})
+	}
+
+	ct := res.CType
+	if ct == "" {
+		ct = "application/json; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(res.Code)
+
+	if res.Raw != nil {
+		w.Write(res.Raw)
+	} else {
+		w.Write([]byte(res.Return))
+	}
+}
+
+func (fmd *FnMemData)handleAsync(ctx context.Context, w http.ResponseWriter, r *http.Request,
+		args *swyapi.FunctionRun, alias string) (*InvocationDesc, int, error) {
+	/* The fn is re-read by invocation anyway, this one is for IDs */
+	fn := &FunctionDesc{SwoId: fmd.id, Cookie: fmd.fnid}
+	/* URL callers are anonymous, they can't make gate POST anywhere */
+	if r.URL.Query().Get("webhook") != "" {
+		return nil, http.StatusBadRequest, errors.New("Webhooks are not accepted on URL calls")
+	}
+
+	inv, err := invCreate(fn, "call", "")
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	inv.run(func(ctx context.Context, fn *FunctionDesc) (*swyapi.WdogFunctionRunResult, error) {
+		fn.alias = alias
+		res, err := doRun(ctx, fn, "call", args)
+		if err == nil && res.Code >= 0 && res.Then != nil && string(res.Then) != "null" {
+			noteThens(ctx, fmd, res.Then)
+		}
+		return res, err
+	})
+
+	invRespond(w, inv)
+	return inv, 0, nil
+}
+
 func (fmd *FnMemData)Handle(ctx context.Context, w http.ResponseWriter, r *http.Request, sopq *statsOpaque,
-		args *swyapi.FunctionRun) {
+		args *swyapi.FunctionRun, alias string) {
 	var res *swyapi.WdogFunctionRunResult
 	var err error
 	var code int
 	var conn *podConn
+	var ie *IdempEntry
+	var keep *swyapi.WdogFunctionRunResult
+	sr := &streamResp{w: w}
 
 	if fmd.ratelimited() {
 		code = http.StatusTooManyRequests
@@ -177,32 +282,101 @@ func (fmd *FnMemData)Handle(ctx context.Context, w http.ResponseWriter, r *http.
 		goto out
 	}
 
-	conn, err = balancerGetConnAny(ctx, fmd)
+	if args.Claims == nil && fmd.ac != nil {
+		args.Claims, err = fmd.ac.Verify(r)
+		if err != nil {
+			code = http.StatusUnauthorized
+			goto out
+		}
+	}
+
+	code, err = makeArgs(ctx, args, sopq, r)
 	if err != nil {
-		code = http.StatusInternalServerError
-		err = errors.New("DB error")
 		goto out
 	}
 
-	defer balancerPutConn(fmd)
+	if ikey := idempKey(r); ikey != "" {
+		ie, code, err = idempBegin(ctx, fmd.fnid, ikey, idempHash(args))
+		if err != nil {
+			goto out
+		}
 
-	if args.Claims == nil && fmd.ac != nil {
-		args.Claims, err = fmd.ac.Verify(r)
+		if ie.Done {
+			idempReplay(w)
+			if ie.Inv != "" {
+				inv, err := ie.invocation(ctx)
+				if err != nil {
+					http.Error(w, "Invocation is gone", http.StatusNotFound)
+					return
+				}
+				invRespond(w, inv)
+			} else {
+				respond(w, ie.Res)
+			}
+			return
+		}
+	}
+
+	if isAsyncReq(r) {
+		var inv *InvocationDesc
+
+		inv, code, err = fmd.handleAsync(ctx, w, r, args, alias)
+		if ie != nil {
+			ie.endInv(inv)
+		}
 		if err != nil {
-			code = http.StatusUnauthorized
 			goto out
 		}
+		return
+	}
+
+	if ie != nil {
+		defer func() { ie.end(keep) }()
 	}
 
-	makeArgs(args, sopq, r)
-	res, err = conn.Run(ctx, sopq, "", "call", args)
+	err = fmd.conc.get(ctx)
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(concRetryAfter))
+		code = http.StatusTooManyRequests
+		goto out
+	}
+
+	defer fmd.conc.put()
+
+	if alias != "" {
+		conn, err = balancerGetConnAlias(ctx, fmd, alias)
+	} else {
+		conn, err = balancerGetConnAny(ctx, fmd, fmd.canary().stickyKey(r, args), sopq)
+	}
 	if err != nil {
 		code = http.StatusInternalServerError
+		err = errors.New("DB error")
+		goto out
+	}
+
+	defer balancerPutConn(fmd)
+
+	res, err = conn.RunStream(ctx, sopq, "call", args, sr.chunk)
+	if err != nil {
 		gateCallErrs.WithLabelValues("fail").Inc()
+		if sr.started {
+			/* Too late to report, the client sees the body cut */
+			ctxlog(ctx).Warnf("Function stream broken: %s", err.Error())
+			return
+		}
+		code = http.StatusInternalServerError
 		goto out
 	}
 
-	if res.Code >= 0 {
+	if sr.started {
+		/* The body is streamed already, only the thens may matter */
+		if res.Code >= 0 && res.Then != nil && string(res.Then) != "null" {
+			noteThens(ctx, fmd, res.Then)
+		}
+		if res.Code < 0 && wrl.Get() {
+			ctxlog(ctx).Warnf("Function stream falied: %d/%s", res.Code, res.Return)
+		}
+	} else if res.Code >= 0 {
 		if res.Then != nil && string(res.Then) != "null" {
 			noteThens(ctx, fmd, res.Then)
 		}
@@ -211,9 +385,8 @@ func (fmd *FnMemData)Handle(ctx context.Context, w http.ResponseWriter, r *http.
 			res.Code = http.StatusOK
 		}
 
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(res.Code)
-		w.Write([]byte(res.Return))
+		keep = res
+		respond(w, res)
 	} else {
 		http.Error(w, res.Return, -res.Code)
 
@@ -0,0 +1,45 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"time"
+	"context"
+
+	"swifty/apis"
+)
+
+/*
+ * Warm pool is the number of pods kept running no matter what the
+ * load is. Pods that come up (after deploy, scale-up or for a new
+ * version) may also get the "warmup" event before they are put
+ * into rotation, so that the first real call finds the runtime
+ * (and whatever the fn caches) hot.
+ */
+const warmupEvent = "warmup"
+
+func podWarmup(ctx context.Context, pod *k8sPod) {
+	fdm, err := memdGet(ctx, pod.FnId)
+	if err != nil {
+		ctxlog(ctx).Errorf("Can't get %s memdat: %s", pod.FnId, err.Error())
+		return
+	}
+
+	if !fdm.warmup {
+		return
+	}
+
+	ts := time.Now()
+	_, err = pod.conn().Run(ctx, nil, "", warmupEvent, &swyapi.FunctionRun{})
+	if err != nil {
+		/* The pod is up anyway, just not that warm */
+		ctxlog(ctx).Errorf("POD %s warmup err: %s", pod.UID, err.Error())
+		warmupErrors.Inc()
+		return
+	}
+
+	warmupLat.Observe(time.Since(ts).Seconds())
+}
@@ -0,0 +1,761 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	"context"
+	"encoding/json"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/gate/mgo"
+	"swifty/common/xrest"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Workflow run. The definition is copied here, so that updates of
+ * the workflow don't affect runs in progress. The top-level state
+ * being executed and its input are saved before entering it, if
+ * the gate dies the run is taken by another one (after the lease
+ * expires) and goes on from that state. States inside parallel and
+ * map ones are not saved, the whole parallel/map is re-done then.
+ * While the run executes its lease is renewed periodically. Each
+ * claim puts its own token into the owner, so a runner that lost
+ * the run fails all further updates.
+ */
+type WfRunDesc struct {
+	ObjID		bson.ObjectId		`bson:"_id,omitempty"`
+	Cookie		string			`bson:"cookie"`
+	Tennant		string			`bson:"tennant"`
+	Project		string			`bson:"project"`
+	Def		*swyapi.WorkflowDef	`bson:"def"`
+	Status		string			`bson:"status"`
+	Input		string			`bson:"input,omitempty"`
+	Output		string			`bson:"output,omitempty"`
+	Error		string			`bson:"error,omitempty"`
+	State		string			`bson:"state,omitempty"`
+	SInput		string			`bson:"sinput,omitempty"`
+	Started		time.Time		`bson:"started"`
+	Finished	time.Time		`bson:"finished,omitempty"`
+	Expire		time.Time		`bson:"expire,omitempty"`
+	Owner		string			`bson:"owner,omitempty"`
+	Lease		time.Time		`bson:"lease,omitempty"`
+	History		[]*WfHistEntry		`bson:"history"`
+}
+
+type WfHistEntry struct {
+	Ts		time.Time		`bson:"ts"`
+	State		string			`bson:"state"`
+	Event		string			`bson:"event"`
+	Info		string			`bson:"info,omitempty"`
+}
+
+const (
+	WfRunning	= "running"
+	WfSucceeded	= "succeeded"
+	WfFailed	= "failed"
+)
+
+var wfRunKeep time.Duration = 7 * 24 * time.Hour
+var wfLease time.Duration = 15 * time.Minute
+var wfResumePeriod time.Duration = time.Minute
+var wfHistoryMax int = 1000
+var wfMapConcurrency int = 8
+var wfMapItemsMax int = 1024
+var wfRunsListMax int = 256
+
+func init() {
+	sysctl.AddTimeSysctl("wf_run_keep", &wfRunKeep)
+	sysctl.AddTimeSysctl("wf_run_lease", &wfLease)
+	sysctl.AddTimeSysctl("wf_resume_period", &wfResumePeriod)
+	sysctl.AddIntSysctl("wf_history_max", &wfHistoryMax)
+	sysctl.AddIntSysctl("wf_map_items_max", &wfMapItemsMax)
+	sysctl.AddIntSysctl("wf_runs_list_max", &wfRunsListMax)
+	sysctl.AddSysctl("wf_map_concurrency",
+		func() string { return strconv.Itoa(wfMapConcurrency) },
+		func(v string) error {
+			n, er := strconv.Atoi(v)
+			if er != nil {
+				return er
+			}
+			if n < 1 {
+				return errors.New("Value should be positive")
+			}
+
+			wfMapConcurrency = n
+			return nil
+		})
+}
+
+/* The run was removed or taken by another gate */
+var wfGone = errors.New("Run is gone")
+
+func (rd *WfRunDesc)toInfo(details bool) *swyapi.WorkflowRunInfo {
+	ret := &swyapi.WorkflowRunInfo {
+		Id:		rd.ObjID.Hex(),
+		Status:		rd.Status,
+		Started:	rd.Started.Format(time.RFC1123Z),
+		Error:		rd.Error,
+	}
+
+	if rd.Status == WfRunning {
+		ret.State = rd.State
+	} else {
+		ret.Finished = rd.Finished.Format(time.RFC1123Z)
+	}
+
+	if details {
+		ret.Input = rd.Input
+		ret.Output = rd.Output
+		for _, h := range rd.History {
+			ret.History = append(ret.History, &swyapi.WorkflowHistEntry {
+				Ts:	h.Ts.Format(time.RFC3339Nano),
+				State:	h.State,
+				Event:	h.Event,
+				Info:	h.Info,
+			})
+		}
+	}
+
+	return ret
+}
+
+type wfRunner struct {
+	rd		*WfRunDesc
+	id		SwoId
+	owner		string
+	stop		chan bool
+	stopOnce	sync.Once
+}
+
+/* Runs executed by this gate, so that removal stops them at once */
+var wfActive sync.Map
+
+func (wr *wfRunner)cancel() {
+	wr.stopOnce.Do(func() { close(wr.stop) })
+}
+
+/* Sleeps unless the run is removed meanwhile */
+func (wr *wfRunner)sleep(d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-wr.stop:
+		return wfGone
+	}
+}
+
+func wfClaimToken() string {
+	return gateInstance + "/" + bson.NewObjectId().Hex()
+}
+
+func (wr *wfRunner)upd(ctx context.Context, u bson.M) error {
+	err := dbCol(ctx, gmgo.DBColWfRuns).Update(bson.M{"_id": wr.rd.ObjID, "owner": wr.owner}, u)
+	if dbNF(err) {
+		err = wfGone
+	}
+
+	return err
+}
+
+func (wr *wfRunner)hist(ctx context.Context, state, event, info string) error {
+	e := &WfHistEntry{Ts: time.Now(), State: state, Event: event, Info: info}
+	return wr.upd(ctx, bson.M{"$push": bson.M{"history": bson.M{
+				"$each": []*WfHistEntry{e}, "$slice": -wfHistoryMax}}})
+}
+
+func (wr *wfRunner)save(ctx context.Context, state, input string) error {
+	return wr.upd(ctx, bson.M{"$set": bson.M{"state": state, "sinput": input,
+				"lease": time.Now().Add(wfLease)}})
+}
+
+func (wr *wfRunner)touch(ctx context.Context, extra time.Duration) error {
+	return wr.upd(ctx, bson.M{"$set": bson.M{"lease": time.Now().Add(wfLease + extra)}})
+}
+
+/* Keeps the lease while the run executes, stops when done is closed */
+func (wr *wfRunner)keepLease(done chan bool) {
+	ctx, cdone := mkContext("::wflease")
+	defer cdone(ctx)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(wfLease / 3):
+		}
+
+		err := wr.upd(ctx, bson.M{"$max": bson.M{"lease": time.Now().Add(wfLease)}})
+		if err != nil {
+			ctxlog(ctx).Errorf("Can't renew wf run %s lease: %s", wr.rd.ObjID.Hex(), err.Error())
+			if err == wfGone {
+				wr.cancel()
+				return
+			}
+		}
+	}
+}
+
+func (wr *wfRunner)finish(ctx context.Context, out string, err error) {
+	now := time.Now()
+	u := bson.M{"finished": now, "expire": now.Add(wfRunKeep)}
+	status := WfSucceeded
+
+	if err != nil {
+		status = WfFailed
+		u["error"] = err.Error()
+	} else {
+		u["output"] = out
+	}
+	u["status"] = status
+
+	err = wr.upd(ctx, bson.M{"$set": u, "$unset": bson.M{"sinput": "", "owner": ""}})
+	if err != nil && err != wfGone {
+		ctxlog(ctx).Errorf("Can't finish wf run %s: %s", wr.rd.ObjID.Hex(), err.Error())
+	}
+
+	wfRuns.WithLabelValues(status).Inc()
+}
+
+/*
+ * Executes the states machine from the given state. The path is
+ * what history shows as the state name prefix for nested ones.
+ */
+func (wr *wfRunner)exec(ctx context.Context, def *swyapi.WorkflowDef, name, input, path string, top bool) (string, error) {
+	for {
+		st := def.States[name]
+		sname := path + name
+
+		if top {
+			err := wr.save(ctx, name, input)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		err := wr.hist(ctx, sname, "entered", "")
+		if err != nil {
+			return "", err
+		}
+
+		out, next, err := wr.state(ctx, st, sname, input)
+		if err != nil {
+			if err == wfGone {
+				return "", err
+			}
+
+			if st.Catch == "" {
+				wr.hist(ctx, sname, "failed", err.Error())
+				return "", err
+			}
+
+			input = wfErrJSON(err)
+			err = wr.hist(ctx, sname, "caught", err.Error())
+			if err != nil {
+				return "", err
+			}
+
+			name = st.Catch
+			continue
+		}
+
+		if next == "" {
+			return out, nil
+		}
+
+		name = next
+		input = out
+	}
+}
+
+func (wr *wfRunner)state(ctx context.Context, st *swyapi.WorkflowState, sname, input string) (string, string, error) {
+	next := st.Next
+	if st.End {
+		next = ""
+	}
+
+	switch st.Type {
+	case WfStateTask:
+		out, err := wr.retry(ctx, st, sname, func() (string, error) {
+			return wr.task(ctx, st.Function, input)
+		})
+		return out, next, err
+
+	case WfStateParallel:
+		out, err := wr.retry(ctx, st, sname, func() (string, error) {
+			return wr.parallel(ctx, st, sname, input)
+		})
+		return out, next, err
+
+	case WfStateMap:
+		out, err := wr.retry(ctx, st, sname, func() (string, error) {
+			return wr.mapItems(ctx, st, sname, input)
+		})
+		return out, next, err
+
+	case WfStateChoice:
+		next, err := wfChoose(st, input)
+		return input, next, err
+
+	case WfStateWait:
+		d := time.Duration(st.Seconds) * time.Second
+		err := wr.touch(ctx, d)
+		if err != nil {
+			return "", "", err
+		}
+		err = wr.sleep(d)
+		if err != nil {
+			return "", "", err
+		}
+		return input, next, nil
+
+	case WfStateSucceed:
+		return input, "", nil
+
+	case WfStateFail:
+		if st.Error == "" {
+			return "", "", errors.New("Failed")
+		}
+		return "", "", errors.New(st.Error)
+	}
+
+	return "", "", fmt.Errorf("Unknown state type %s", st.Type)
+}
+
+func (wr *wfRunner)retry(ctx context.Context, st *swyapi.WorkflowState, sname string, fn func() (string, error)) (string, error) {
+	attempts := uint(1)
+	interval := time.Duration(0)
+	if st.Retry != nil {
+		if st.Retry.Attempts > 1 {
+			attempts = st.Retry.Attempts
+		}
+		interval = time.Duration(st.Retry.Interval) * time.Millisecond
+	}
+
+	for a := uint(1); ; a++ {
+		out, err := fn()
+		if err == nil || err == wfGone || a >= attempts {
+			return out, err
+		}
+
+		err = wr.hist(ctx, sname, "retry", err.Error())
+		if err != nil {
+			return "", err
+		}
+
+		err = wr.sleep(interval)
+		if err != nil {
+			return "", err
+		}
+		interval <<= 1
+		if max := time.Duration(wfRetryIntervalMax) * time.Second; interval > max {
+			interval = max
+		}
+	}
+}
+
+func (wr *wfRunner)task(ctx context.Context, fname, input string) (string, error) {
+	var fn FunctionDesc
+	var alias string
+
+	id := wr.id
+	id.Name, alias = splitAlias(fname)
+
+	err := dbFind(ctx, bson.M{"cookie": id.Cookie()}, &fn)
+	if err != nil {
+		if dbNF(err) {
+			return "", fmt.Errorf("No function %s", id.Name)
+		}
+		return "", err
+	}
+
+	if fn.State != DBFuncStateRdy {
+		return "", fmt.Errorf("Function %s not ready", id.Name)
+	}
+
+	fn.alias = alias
+	res, err := doRun(ctx, &fn, "workflow", &swyapi.FunctionRun{ContentType: "application/json", Body: input})
+	if err != nil {
+		return "", err
+	}
+
+	if res.Code < 0 {
+		return "", fmt.Errorf("wdog error %d", -res.Code)
+	}
+
+	if res.Code >= 400 {
+		return "", fmt.Errorf("code %d: %s", res.Code, res.Return)
+	}
+
+	return res.Return, nil
+}
+
+/* Runs sub-workflows in parallel, the output is the array of results */
+func (wr *wfRunner)fork(ctx context.Context, defs []*swyapi.WorkflowDef, inputs []string, sname string) (string, error) {
+	var wg sync.WaitGroup
+
+	outs := make([]string, len(defs))
+	errs := make([]error, len(defs))
+	sem := make(chan bool, wfMapConcurrency)
+
+	for i := range defs {
+		wg.Add(1)
+		sem <- true
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			outs[i], errs[i] = wr.exec(ctx, defs[i], defs[i].Start, inputs[i],
+					fmt.Sprintf("%s[%d].", sname, i), false)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return wfJoin(outs), nil
+}
+
+func (wr *wfRunner)parallel(ctx context.Context, st *swyapi.WorkflowState, sname, input string) (string, error) {
+	inputs := make([]string, len(st.Branches))
+	for i := range inputs {
+		inputs[i] = input
+	}
+
+	return wr.fork(ctx, st.Branches, inputs, sname)
+}
+
+func (wr *wfRunner)mapItems(ctx context.Context, st *swyapi.WorkflowState, sname, input string) (string, error) {
+	var in interface{}
+
+	err := json.Unmarshal([]byte(input), &in)
+	if err != nil {
+		return "", errors.New("Input is not JSON")
+	}
+
+	if st.Items != "" {
+		in, _ = wfLookup(in, st.Items)
+	}
+
+	items, ok := in.([]interface{})
+	if !ok {
+		return "", errors.New("Items are not an array")
+	}
+
+	if len(items) > wfMapItemsMax {
+		return "", fmt.Errorf("Too many items (max %d)", wfMapItemsMax)
+	}
+
+	defs := make([]*swyapi.WorkflowDef, len(items))
+	inputs := make([]string, len(items))
+	for i, it := range items {
+		x, _ := json.Marshal(it)
+		defs[i] = st.Iterator
+		inputs[i] = string(x)
+	}
+
+	return wr.fork(ctx, defs, inputs, sname)
+}
+
+func wfJSON(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("null")
+	}
+
+	if !json.Valid([]byte(s)) {
+		x, _ := json.Marshal(s)
+		return json.RawMessage(x)
+	}
+
+	return json.RawMessage(s)
+}
+
+func wfJoin(outs []string) string {
+	res := []json.RawMessage{}
+	for _, o := range outs {
+		res = append(res, wfJSON(o))
+	}
+
+	x, _ := json.Marshal(res)
+	return string(x)
+}
+
+func wfErrJSON(err error) string {
+	x, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(x)
+}
+
+func wfLookup(in interface{}, path string) (interface{}, bool) {
+	for _, p := range strings.Split(path, ".") {
+		m, ok := in.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		in, ok = m[p]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return in, true
+}
+
+func wfChoiceOpOK(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "exists":
+		return true
+	}
+
+	return false
+}
+
+func wfCompare(op string, c int) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+func wfMatch(c *swyapi.WorkflowChoice, in interface{}) bool {
+	v, ok := wfLookup(in, c.Var)
+	if c.Op == "exists" {
+		return ok
+	}
+
+	if !ok {
+		return false
+	}
+
+	sv, ok := v.(string)
+	if !ok {
+		x, _ := json.Marshal(v)
+		sv = string(x)
+	}
+
+	a, e1 := strconv.ParseFloat(sv, 64)
+	b, e2 := strconv.ParseFloat(c.Value, 64)
+	if e1 == nil && e2 == nil {
+		switch {
+		case a < b:
+			return wfCompare(c.Op, -1)
+		case a > b:
+			return wfCompare(c.Op, 1)
+		default:
+			return wfCompare(c.Op, 0)
+		}
+	}
+
+	return wfCompare(c.Op, strings.Compare(sv, c.Value))
+}
+
+func wfChoose(st *swyapi.WorkflowState, input string) (string, error) {
+	var in interface{}
+
+	json.Unmarshal([]byte(input), &in)
+	for _, c := range st.Choices {
+		if wfMatch(c, in) {
+			return c.Next, nil
+		}
+	}
+
+	if st.Default != "" {
+		return st.Default, nil
+	}
+
+	return "", errors.New("No choice matched")
+}
+
+func (rd *WfRunDesc)run() {
+	go func() {
+		ctx, done := mkContext("::workflow")
+		defer done(ctx)
+
+		wr := &wfRunner{rd: rd, id: SwoId{Tennant: rd.Tennant, Project: rd.Project},
+				owner: rd.Owner, stop: make(chan bool)}
+
+		wfActive.Store(rd.ObjID, wr)
+		lease := make(chan bool)
+		go wr.keepLease(lease)
+		out, err := wr.exec(ctx, rd.Def, rd.State, rd.SInput, "", true)
+		close(lease)
+		wfActive.Delete(rd.ObjID)
+		if err == wfGone {
+			return
+		}
+
+		wr.finish(ctx, out, err)
+	}()
+}
+
+func (wd *WorkflowDesc)runStart(ctx context.Context, params *swyapi.WorkflowRunStart) (*WfRunDesc, *xrest.ReqErr) {
+	if params.Input != "" && !json.Valid([]byte(params.Input)) {
+		return nil, GateErrM(swyapi.GateBadRequest, "Input is not JSON")
+	}
+
+	now := time.Now()
+	rd := &WfRunDesc {
+		ObjID:		bson.NewObjectId(),
+		Cookie:		wd.Cookie,
+		Tennant:	wd.SwoId.Tennant,
+		Project:	wd.SwoId.Project,
+		Def:		wd.Def,
+		Status:		WfRunning,
+		Input:		params.Input,
+		State:		wd.Def.Start,
+		SInput:		params.Input,
+		Started:	now,
+		Owner:		wfClaimToken(),
+		Lease:		now.Add(wfLease),
+		History:	[]*WfHistEntry{},
+	}
+
+	err := dbCol(ctx, gmgo.DBColWfRuns).Insert(rd)
+	if err != nil {
+		return nil, GateErrD(err)
+	}
+
+	rd.run()
+	return rd, nil
+}
+
+func (wd *WorkflowDesc)runsList(ctx context.Context) ([]*swyapi.WorkflowRunInfo, *xrest.ReqErr) {
+	var rds []*WfRunDesc
+
+	err := dbCol(ctx, gmgo.DBColWfRuns).Find(bson.M{"cookie": wd.Cookie}).
+			Select(bson.M{"history": 0, "def": 0}).Sort("-started").Limit(wfRunsListMax).All(&rds)
+	if err != nil {
+		return nil, GateErrD(err)
+	}
+
+	ret := []*swyapi.WorkflowRunInfo{}
+	for _, rd := range rds {
+		ret = append(ret, rd.toInfo(false))
+	}
+
+	return ret, nil
+}
+
+func (wd *WorkflowDesc)runFind(ctx context.Context, id string) (*WfRunDesc, *xrest.ReqErr) {
+	var rd WfRunDesc
+
+	if !bson.IsObjectIdHex(id) {
+		return nil, GateErrM(swyapi.GateBadRequest, "Bad ID value")
+	}
+
+	err := dbCol(ctx, gmgo.DBColWfRuns).Find(bson.M{"_id": bson.ObjectIdHex(id),
+				"cookie": wd.Cookie}).One(&rd)
+	if err != nil {
+		return nil, GateErrD(err)
+	}
+
+	return &rd, nil
+}
+
+/*
+ * Removing a run in progress stops it right away if it's run by this
+ * gate, otherwise at the next state or lease renewal, whichever comes
+ * first.
+ */
+func wfRunDel(ctx context.Context, rd *WfRunDesc) *xrest.ReqErr {
+	if !dbMayRemove(ctx) {
+		return GateErrD(dbNotAllowed)
+	}
+
+	err := dbCol(ctx, gmgo.DBColWfRuns).RemoveId(rd.ObjID)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	if wr, ok := wfActive.Load(rd.ObjID); ok {
+		wr.(*wfRunner).cancel()
+	}
+
+	return nil
+}
+
+func wfRunsRemove(ctx context.Context, wd *WorkflowDesc) error {
+	if !dbMayRemove(ctx) {
+		return dbNotAllowed
+	}
+
+	_, err := dbCol(ctx, gmgo.DBColWfRuns).RemoveAll(bson.M{"cookie": wd.Cookie})
+	if err != nil {
+		return maybe(err)
+	}
+
+	wfActive.Range(func(k, v interface{}) bool {
+		if wr := v.(*wfRunner); wr.rd.Cookie == wd.Cookie {
+			wr.cancel()
+		}
+		return true
+	})
+
+	return nil
+}
+
+/* Picks up runs whose gates have died */
+func wfResumer() {
+	for {
+		time.Sleep(wfResumePeriod)
+
+		ctx, done := mkContext("::wfresume")
+		for {
+			var rd WfRunDesc
+
+			now := time.Now()
+			_, err := dbCol(ctx, gmgo.DBColWfRuns).Find(bson.M{
+					"status": WfRunning,
+					"lease": bson.M{"$lt": now},
+				}).Apply(mgo.Change{
+					Update: bson.M{"$set": bson.M{"owner": wfClaimToken(), "lease": now.Add(wfLease)}},
+					ReturnNew: true,
+				}, &rd)
+			if err != nil {
+				if !dbNF(err) {
+					ctxlog(ctx).Errorf("Can't claim wf run: %s", err.Error())
+				}
+				break
+			}
+
+			ctxlog(ctx).Debugf("Resuming wf run %s from %s", rd.ObjID.Hex(), rd.State)
+			wfRuns.WithLabelValues("resumed").Inc()
+			rd.run()
+		}
+		done(ctx)
+	}
+}
+
+func wfInit(ctx context.Context) error {
+	go wfResumer()
+	return nil
+}
@@ -0,0 +1,298 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"context"
+	"gopkg.in/mgo.v2/bson"
+
+	"swifty/apis"
+	"swifty/common/xrest"
+	"swifty/common/xrest/sysctl"
+)
+
+type WorkflowDesc struct {
+	ObjID		bson.ObjectId		`bson:"_id,omitempty"`
+	SwoId					`bson:",inline"`
+	Cookie		string			`bson:"cookie"`
+	Labels		[]string		`bson:"labels,omitempty"`
+	Def		*swyapi.WorkflowDef	`bson:"def"`
+}
+
+type Workflows struct {}
+
+const (
+	WfStateTask	= "task"
+	WfStateParallel	= "parallel"
+	WfStateMap	= "map"
+	WfStateChoice	= "choice"
+	WfStateWait	= "wait"
+	WfStateSucceed	= "succeed"
+	WfStateFail	= "fail"
+)
+
+var wfStatesMax int = 256
+var wfWaitMax int = 7 * 24 * 3600
+var wfRetryMax int = 16
+var wfRetryIntervalMax int = 3600
+
+func init() {
+	sysctl.AddIntSysctl("wf_states_max", &wfStatesMax)
+	sysctl.AddIntSysctl("wf_wait_max_sec", &wfWaitMax)
+	sysctl.AddIntSysctl("wf_retry_max", &wfRetryMax)
+	sysctl.AddIntSysctl("wf_retry_interval_max_sec", &wfRetryIntervalMax)
+}
+
+/* State names become mongo keys, so no dots and dollars there */
+func wfStateNameOK(n string) bool {
+	return n != "" && !strings.ContainsAny(n, ".$[]")
+}
+
+func wfCheck(def *swyapi.WorkflowDef, nr *int) error {
+	if def == nil || len(def.States) == 0 {
+		return fmt.Errorf("No states")
+	}
+
+	if _, ok := def.States[def.Start]; !ok {
+		return fmt.Errorf("No start state %s", def.Start)
+	}
+
+	ref := func(from, to string) error {
+		if _, ok := def.States[to]; !ok {
+			return fmt.Errorf("State %s refers to unknown %s", from, to)
+		}
+		return nil
+	}
+
+	for n, st := range def.States {
+		var err error
+
+		*nr++
+		if *nr > wfStatesMax {
+			return fmt.Errorf("Too many states")
+		}
+
+		if !wfStateNameOK(n) {
+			return fmt.Errorf("Bad state name %s", n)
+		}
+
+		if st.Next != "" {
+			err = ref(n, st.Next)
+			if err != nil {
+				return err
+			}
+		}
+
+		if st.Catch != "" {
+			err = ref(n, st.Catch)
+			if err != nil {
+				return err
+			}
+		}
+
+		if st.Retry != nil && st.Retry.Attempts > uint(wfRetryMax) {
+			return fmt.Errorf("Too many retries in %s", n)
+		}
+
+		if st.Retry != nil && st.Retry.Interval > uint(wfRetryIntervalMax) * 1000 {
+			return fmt.Errorf("Too long retry interval in %s", n)
+		}
+
+		switch st.Type {
+		case WfStateTask:
+			if st.Function == "" {
+				return fmt.Errorf("No function in %s", n)
+			}
+
+		case WfStateParallel:
+			if len(st.Branches) == 0 {
+				return fmt.Errorf("No branches in %s", n)
+			}
+
+			for _, b := range st.Branches {
+				err = wfCheck(b, nr)
+				if err != nil {
+					return err
+				}
+			}
+
+		case WfStateMap:
+			err = wfCheck(st.Iterator, nr)
+			if err != nil {
+				return err
+			}
+
+		case WfStateChoice:
+			if len(st.Choices) == 0 {
+				return fmt.Errorf("No choices in %s", n)
+			}
+
+			for _, c := range st.Choices {
+				if !wfChoiceOpOK(c.Op) {
+					return fmt.Errorf("Bad choice op %s in %s", c.Op, n)
+				}
+
+				err = ref(n, c.Next)
+				if err != nil {
+					return err
+				}
+			}
+
+			if st.Default != "" {
+				err = ref(n, st.Default)
+				if err != nil {
+					return err
+				}
+			}
+
+		case WfStateWait:
+			if st.Seconds > uint(wfWaitMax) {
+				return fmt.Errorf("Too long wait in %s", n)
+			}
+
+		case WfStateSucceed, WfStateFail:
+			;
+
+		default:
+			return fmt.Errorf("Bad state %s type %s", n, st.Type)
+		}
+	}
+
+	return nil
+}
+
+func getWorkflowDesc(id *SwoId, def *swyapi.WorkflowDef) (*WorkflowDesc, *xrest.ReqErr) {
+	if !id.NameOK() {
+		return nil, GateErrM(swyapi.GateBadRequest, "Bad workflow name")
+	}
+
+	nr := 0
+	err := wfCheck(def, &nr)
+	if err != nil {
+		return nil, GateErrE(swyapi.GateBadRequest, err)
+	}
+
+	wd := WorkflowDesc {
+		SwoId:	*id,
+		Def:	def,
+	}
+
+	return &wd, nil
+}
+
+func (_ Workflows)Get(ctx context.Context, r *http.Request) (xrest.Obj, *xrest.ReqErr) {
+	var wd WorkflowDesc
+
+	cerr := objFindForReq(ctx, r, "wid", &wd)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	return &wd, nil
+}
+
+func (_ Workflows)Iterate(ctx context.Context, q url.Values, cb func(context.Context, xrest.Obj) *xrest.ReqErr) *xrest.ReqErr {
+	project := q.Get("project")
+	if project == "" {
+		project = DefaultProject
+	}
+
+	if wname := q.Get("name"); wname != "" {
+		var wd WorkflowDesc
+
+		err := dbFind(ctx, cookieReq(ctx, project, wname), &wd)
+		if err != nil {
+			return GateErrD(err)
+		}
+
+		return cb(ctx, &wd)
+	}
+
+	var wds []*WorkflowDesc
+
+	err := dbFindAll(ctx, listReq(ctx, project, q["label"]), &wds)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	for _, wd := range wds {
+		cerr := cb(ctx, wd)
+		if cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+func (_ Workflows)Create(ctx context.Context, p interface{}) (xrest.Obj, *xrest.ReqErr) {
+	params := p.(*swyapi.WorkflowAdd)
+	id := ctxSwoId(ctx, params.Project, params.Name)
+	return getWorkflowDesc(id, &params.WorkflowDef)
+}
+
+func (wd *WorkflowDesc)Info(ctx context.Context, q url.Values, details bool) (interface{}, *xrest.ReqErr) {
+	wi := &swyapi.WorkflowInfo {
+		Id:		wd.ObjID.Hex(),
+		Name:		wd.SwoId.Name,
+		Project:	wd.SwoId.Project,
+		Labels:		wd.Labels,
+	}
+
+	if details {
+		wi.Def = wd.Def
+	}
+
+	return wi, nil
+}
+
+func (wd *WorkflowDesc)Add(ctx context.Context, _ interface{}) *xrest.ReqErr {
+	wd.ObjID = bson.NewObjectId()
+	wd.Cookie = wd.SwoId.Cookie()
+	err := dbInsert(ctx, wd)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	return nil
+}
+
+/* Runs in progress keep going with the definition they've started with */
+func (wd *WorkflowDesc)Upd(ctx context.Context, upd interface{}) *xrest.ReqErr {
+	def := upd.(*swyapi.WorkflowDef)
+
+	nr := 0
+	err := wfCheck(def, &nr)
+	if err != nil {
+		return GateErrE(swyapi.GateBadRequest, err)
+	}
+
+	err = dbUpdatePart(ctx, wd, bson.M{"def": def})
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	wd.Def = def
+	return nil
+}
+
+func (wd *WorkflowDesc)Del(ctx context.Context) *xrest.ReqErr {
+	err := wfRunsRemove(ctx, wd)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	err = dbRemove(ctx, wd)
+	if err != nil {
+		return GateErrD(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,634 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"context"
+	"strings"
+	"errors"
+	"sync"
+	"net"
+
+	"swifty/s3/mgo"
+)
+
+/*
+ * Access to a bucket and its objects is granted by the requester's own
+ * policy, by the bucket policy or by the ACLs. An explicit deny in the
+ * bucket policy wins over everything but the root key.
+ */
+
+const (
+	S3PolicyMaxSize		= 20 << 10
+	S3PolicyWildMax		= 16
+	S3PolicyCacheMax	= 1024
+	S3ArnPrefix		= "arn:aws:s3:::"
+	S3IamArnPrefix		= "arn:aws:iam::"
+)
+
+const (
+	policyNone	= iota
+	policyAllow
+	policyDeny
+)
+
+var s3PolicyActionName map[int]string
+
+func init() {
+	s3PolicyActionName = make(map[int]string)
+	for n, a := range S3PolicyAction_Map {
+		if a != S3P_All {
+			s3PolicyActionName[int(a)] = strings.ToLower(n)
+		}
+	}
+}
+
+/* Both "x" and [ "x", "y" ] are valid in policy documents */
+type policyStrings []string
+
+func (ps *policyStrings) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if json.Unmarshal(data, &s) == nil {
+		*ps = policyStrings{ s }
+		return nil
+	}
+
+	var l []string
+	err := json.Unmarshal(data, &l)
+	if err != nil {
+		return err
+	}
+
+	*ps = l
+	return nil
+}
+
+type policyPrincipal struct {
+	AWS		policyStrings	`json:"AWS"`
+}
+
+func (pp *policyPrincipal) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if json.Unmarshal(data, &s) == nil {
+		if s != "*" {
+			return errors.New("Bad principal")
+		}
+		pp.AWS = policyStrings{ "*" }
+		return nil
+	}
+
+	var m struct {
+		AWS	policyStrings	`json:"AWS"`
+	}
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return err
+	}
+
+	pp.AWS = m.AWS
+	return nil
+}
+
+type policyStatement struct {
+	Sid		string						`json:"Sid,omitempty"`
+	Effect		string						`json:"Effect"`
+	Principal	*policyPrincipal				`json:"Principal"`
+	Action		policyStrings					`json:"Action"`
+	Resource	policyStrings					`json:"Resource"`
+	Condition	map[string]map[string]policyStrings		`json:"Condition,omitempty"`
+}
+
+type policyStatements []policyStatement
+
+func (pss *policyStatements) UnmarshalJSON(data []byte) error {
+	var st policyStatement
+
+	if len(data) > 0 && data[0] == '{' {
+		err := json.Unmarshal(data, &st)
+		if err != nil {
+			return err
+		}
+		*pss = policyStatements{ st }
+		return nil
+	}
+
+	var l []policyStatement
+	err := json.Unmarshal(data, &l)
+	if err != nil {
+		return err
+	}
+
+	*pss = l
+	return nil
+}
+
+type policyDoc struct {
+	Version		string				`json:"Version,omitempty"`
+	Id		string				`json:"Id,omitempty"`
+	Statement	policyStatements		`json:"Statement"`
+}
+
+type accessReq struct {
+	iam		*s3mgo.Iam
+	action		string
+	resource	string
+	r		*http.Request
+}
+
+/*
+ * Glob with * and ? only, the way IAM matches names. On mismatch
+ * we only get back to the last star and let it eat one more char,
+ * no deeper backtracking is ever needed.
+ */
+func wildMatch(pat, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+
+	for i < len(s) {
+		if p < len(pat) && (pat[p] == '?' || pat[p] == s[i]) {
+			p++
+			i++
+		} else if p < len(pat) && pat[p] == '*' {
+			star = p
+			mark = i
+			p++
+		} else if star != -1 {
+			mark++
+			p = star + 1
+			i = mark
+		} else {
+			return false
+		}
+	}
+
+	for p < len(pat) && pat[p] == '*' {
+		p++
+	}
+
+	return p == len(pat)
+}
+
+func wildCheck(pat string) error {
+	if strings.Count(pat, "*") > S3PolicyWildMax {
+		return errors.New("Too many wildcards in " + pat)
+	}
+
+	return nil
+}
+
+func (pp *policyPrincipal) matches(iam *s3mgo.Iam) bool {
+	for _, p := range pp.AWS {
+		if p == "*" {
+			return true
+		}
+		if iam.AwsID == "" {
+			continue
+		}
+		if p == iam.AwsID || p == iam.User || strings.HasPrefix(p, S3IamArnPrefix + iam.AwsID + ":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (req *accessReq) condValue(key string) (string, bool) {
+	switch key {
+	case "aws:sourceip":
+		host, _, err := net.SplitHostPort(req.r.RemoteAddr)
+		if err != nil {
+			host = req.r.RemoteAddr
+		}
+		return host, true
+	case "aws:securetransport":
+		if req.r.TLS != nil {
+			return "true", true
+		}
+		return "false", true
+	case "aws:useragent":
+		return req.r.UserAgent(), true
+	case "aws:referer":
+		v := req.r.Referer()
+		return v, v != ""
+	case "s3:prefix":
+		v, ok := getURLParam(req.r, "prefix")
+		return v, ok
+	}
+
+	return "", false
+}
+
+func ipMatch(ip net.IP, val string) bool {
+	if strings.Contains(val, "/") {
+		_, nw, err := net.ParseCIDR(val)
+		return err == nil && nw.Contains(ip)
+	}
+
+	vip := net.ParseIP(val)
+	return vip != nil && vip.Equal(ip)
+}
+
+var policyCondOps = map[string]bool {
+	"StringEquals":			true,
+	"StringNotEquals":		true,
+	"StringEqualsIgnoreCase":	true,
+	"StringLike":			true,
+	"StringNotLike":		true,
+	"IpAddress":			true,
+	"NotIpAddress":			true,
+	"Bool":				true,
+}
+
+var policyCondKeys = map[string]bool {
+	"aws:sourceip":			true,
+	"aws:securetransport":		true,
+	"aws:useragent":		true,
+	"aws:referer":			true,
+	"s3:prefix":			true,
+}
+
+func policyCondMatch(op, val string, vals policyStrings) bool {
+	for _, v := range vals {
+		switch op {
+		case "StringEquals", "StringNotEquals", "Bool":
+			if val == v { return true }
+		case "StringEqualsIgnoreCase":
+			if strings.EqualFold(val, v) { return true }
+		case "StringLike", "StringNotLike":
+			if wildMatch(v, val) { return true }
+		case "IpAddress", "NotIpAddress":
+			ip := net.ParseIP(val)
+			if ip != nil && ipMatch(ip, v) { return true }
+		}
+	}
+
+	return false
+}
+
+func (st *policyStatement) condOK(req *accessReq) bool {
+	for op, kv := range st.Condition {
+		negative := strings.Contains(op, "Not")
+
+		for key, vals := range kv {
+			val, ok := req.condValue(strings.ToLower(key))
+			if !ok {
+				if !negative {
+					return false
+				}
+				continue
+			}
+
+			if policyCondMatch(op, val, vals) == negative {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (st *policyStatement) applies(req *accessReq) bool {
+	if !st.Principal.matches(req.iam) {
+		return false
+	}
+
+	found := false
+	for _, a := range st.Action {
+		if wildMatch(strings.ToLower(a), req.action) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	found = false
+	for _, res := range st.Resource {
+		if wildMatch(res, req.resource) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	return st.condOK(req)
+}
+
+func (doc *policyDoc) eval(req *accessReq) int {
+	ret := policyNone
+
+	for i := range doc.Statement {
+		st := &doc.Statement[i]
+		if !st.applies(req) {
+			continue
+		}
+
+		if st.Effect == Policy_Deny {
+			return policyDeny
+		}
+
+		ret = policyAllow
+	}
+
+	return ret
+}
+
+func parseBucketPolicy(bucket *s3mgo.Bucket, data []byte) (*policyDoc, error) {
+	var doc policyDoc
+
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch doc.Version {
+	case "", "2012-10-17", "2008-10-17":
+		;
+	default:
+		return nil, errors.New("Unsupported policy version")
+	}
+
+	if len(doc.Statement) == 0 {
+		return nil, errors.New("No statements")
+	}
+
+	for _, st := range doc.Statement {
+		if st.Effect != Policy_Allow && st.Effect != Policy_Deny {
+			return nil, errors.New("Bad effect " + st.Effect)
+		}
+
+		if st.Principal == nil || len(st.Principal.AWS) == 0 {
+			return nil, errors.New("Missing principal")
+		}
+
+		if len(st.Action) == 0 {
+			return nil, errors.New("Missing action")
+		}
+
+		for _, a := range st.Action {
+			if err = wildCheck(a); err != nil {
+				return nil, err
+			}
+
+			known := false
+			for n, _ := range S3PolicyAction_Map {
+				if wildMatch(strings.ToLower(a), strings.ToLower(n)) {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return nil, errors.New("Unknown action " + a)
+			}
+		}
+
+		if len(st.Resource) == 0 {
+			return nil, errors.New("Missing resource")
+		}
+
+		for _, res := range st.Resource {
+			if err = wildCheck(res); err != nil {
+				return nil, err
+			}
+
+			arn := S3ArnPrefix + bucket.Name
+			if res != arn && !strings.HasPrefix(res, arn + "/") {
+				return nil, errors.New("Resource " + res + " is out of the bucket")
+			}
+		}
+
+		for op, kv := range st.Condition {
+			if !policyCondOps[op] {
+				return nil, errors.New("Unsupported condition " + op)
+			}
+			for key, vals := range kv {
+				if !policyCondKeys[strings.ToLower(key)] {
+					return nil, errors.New("Unsupported condition key " + key)
+				}
+				for _, v := range vals {
+					if err = wildCheck(v); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	return &doc, nil
+}
+
+/*
+ * Parsed policies by bucket. The doc is put here when the policy
+ * is stored, other s3 instances (and this one after restart) parse
+ * it on first use. An entry with the text not matching the bucket's
+ * one is stale and gets re-parsed.
+ */
+type policyCacheEnt struct {
+	text		string
+	doc		*policyDoc
+}
+
+var policyCache = make(map[bson.ObjectId]*policyCacheEnt)
+var policyCacheLock sync.Mutex
+
+func policyCachePut(bucket *s3mgo.Bucket, text string, doc *policyDoc) {
+	policyCacheLock.Lock()
+	defer policyCacheLock.Unlock()
+
+	if doc == nil {
+		delete(policyCache, bucket.ObjID)
+		return
+	}
+
+	if len(policyCache) >= S3PolicyCacheMax {
+		/* Go maps iterate randomly, so this drops a random one */
+		for id := range policyCache {
+			delete(policyCache, id)
+			break
+		}
+	}
+
+	policyCache[bucket.ObjID] = &policyCacheEnt{ text: text, doc: doc }
+}
+
+func policyCacheGet(bucket *s3mgo.Bucket) *policyDoc {
+	policyCacheLock.Lock()
+	defer policyCacheLock.Unlock()
+
+	ent, ok := policyCache[bucket.ObjID]
+	if !ok || ent.text != bucket.Policy {
+		return nil
+	}
+
+	return ent.doc
+}
+
+func bucketPolicyEval(bucket *s3mgo.Bucket, req *accessReq) int {
+	if bucket.Policy == "" {
+		return policyNone
+	}
+
+	doc := policyCacheGet(bucket)
+	if doc == nil {
+		var err error
+
+		doc, err = parseBucketPolicy(bucket, []byte(bucket.Policy))
+		if err != nil {
+			log.Errorf("s3: Bad policy on %s: %s", infoLong(bucket), err.Error())
+			return policyNone
+		}
+
+		policyCachePut(bucket, bucket.Policy, doc)
+	}
+
+	return doc.eval(req)
+}
+
+func s3CheckAccess(ctx context.Context, r *http.Request, bucket *s3mgo.Bucket, oname string, action int) *S3Error {
+	return s3CheckVersionAccess(ctx, r, bucket, oname, nil, action)
+}
+
+/*
+ * Version actions are checked against the version's own ACL, nil
+ * version (e.g. not found) leaves only the policies to grant them
+ */
+func s3CheckVersionAccess(ctx context.Context, r *http.Request, bucket *s3mgo.Bucket, oname string,
+		version *s3mgo.Object, action int) *S3Error {
+	iam := ctxIam(ctx)
+
+	req := &accessReq {
+		iam:		iam,
+		action:		s3PolicyActionName[action],
+		resource:	S3ArnPrefix + bucket.Name,
+		r:		r,
+	}
+	if oname != "" {
+		req.resource += "/" + oname
+	}
+
+	pe := bucketPolicyEval(bucket, req)
+	if pe == policyDeny && !isRoot(&iam.Policy) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+
+	own := iam.Policy.MayAccess(bucket.Name)
+	if own && iam.Policy.Allowed(action) {
+		return nil
+	}
+
+	if pe == policyAllow {
+		return nil
+	}
+
+	if aclAllows(ctx, bucket, oname, version, action) {
+		return nil
+	}
+
+	if own {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	return &S3Error{ ErrorCode: S3ErrAccessDenied }
+}
+
+func s3SetBucketPolicy(ctx context.Context, bucket *s3mgo.Bucket, policy string) error {
+	var update bson.M
+
+	if policy != "" {
+		update = bson.M{ "$set": bson.M{ "policy": policy } }
+	} else {
+		update = bson.M{ "$unset": bson.M{ "policy": "" } }
+	}
+
+	return dbS3Update(ctx, bson.M{ "state": S3StateActive }, update, true, bucket)
+}
+
+func handleGetBucketPolicy(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_GetBucketPolicy); e != nil {
+		return e
+	}
+
+	if bucket.Policy == "" {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucketPolicy }
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(bucket.Policy))
+	return nil
+}
+
+func handlePutBucketPolicy(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_PutBucketPolicy); e != nil {
+		return e
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrIncompleteBody }
+	}
+
+	if len(body) > S3PolicyMaxSize {
+		return &S3Error{ ErrorCode: S3ErrInvalidPolicyDocument, Message: "Policy is too big" }
+	}
+
+	doc, err := parseBucketPolicy(bucket, body)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrInvalidPolicyDocument, Message: err.Error() }
+	}
+
+	err = s3SetBucketPolicy(ctx, bucket, string(body))
+	if err != nil {
+		log.Errorf("s3: Can't set policy on %s: %s", infoLong(bucket), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	policyCachePut(bucket, string(body), doc)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func handleDelBucketPolicy(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_DeleteBucketPolicy); e != nil {
+		return e
+	}
+
+	err = s3SetBucketPolicy(ctx, bucket, "")
+	if err != nil {
+		log.Errorf("s3: Can't drop policy on %s: %s", infoLong(bucket), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	policyCachePut(bucket, "", nil)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,347 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"context"
+
+	"swifty/s3/mgo"
+	"swifty/apis/s3"
+)
+
+/*
+ * The owner always has full control over its buckets and objects
+ * via its own policy, ACLs only grant access to others. Canned ACL
+ * is kept as is and is expanded into grants on demand, explicit
+ * grants (if any) override it.
+ */
+
+var aclPerms = []string {
+	swys3api.S3PermRead,
+	swys3api.S3PermWrite,
+	swys3api.S3PermReadACP,
+	swys3api.S3PermWriteACP,
+	swys3api.S3PermFull,
+}
+
+/*
+ * Permissions checked on the bucket ACL. Deleting versions is never
+ * granted by ACLs, it destroys the data versioning is there to keep.
+ */
+var aclBucketPerm = map[int]string {
+	S3P_HeadBucket:			swys3api.S3PermRead,
+	S3P_ListBucket:			swys3api.S3PermRead,
+	S3P_ListBucketVersions:		swys3api.S3PermRead,
+	S3P_ListBucketMultipartUploads:	swys3api.S3PermRead,
+	S3P_PutObject:			swys3api.S3PermWrite,
+	S3P_DeleteObject:		swys3api.S3PermWrite,
+	S3P_AbortMultipartUpload:	swys3api.S3PermWrite,
+	S3P_ListMultipartUploadParts:	swys3api.S3PermWrite,
+	S3P_GetBucketAcl:		swys3api.S3PermReadACP,
+	S3P_PutBucketAcl:		swys3api.S3PermWriteACP,
+}
+
+/* Permissions checked on the current object ACL */
+var aclObjectPerm = map[int]string {
+	S3P_GetObject:			swys3api.S3PermRead,
+	S3P_GetObjectAcl:		swys3api.S3PermReadACP,
+	S3P_PutObjectAcl:		swys3api.S3PermWriteACP,
+}
+
+/* Permissions checked on the ACL of the exact version asked for */
+var aclVersionPerm = map[int]string {
+	S3P_GetObjectVersion:		swys3api.S3PermRead,
+	S3P_GetObjectVersionAcl:	swys3api.S3PermReadACP,
+	S3P_PutObjectVersionAcl:	swys3api.S3PermWriteACP,
+}
+
+func cannedGrants(canned string) []s3mgo.Grant {
+	switch canned {
+	case swys3api.S3BucketAclCannedPublicRead:
+		return []s3mgo.Grant{
+			{ Grantee: s3mgo.GranteeAllUsers, Perm: swys3api.S3PermRead },
+		}
+	case swys3api.S3BucketAclCannedPublicReadWrite:
+		return []s3mgo.Grant{
+			{ Grantee: s3mgo.GranteeAllUsers, Perm: swys3api.S3PermRead },
+			{ Grantee: s3mgo.GranteeAllUsers, Perm: swys3api.S3PermWrite },
+		}
+	case swys3api.S3BucketAclCannedAuthenticatedRead:
+		return []s3mgo.Grant{
+			{ Grantee: s3mgo.GranteeAuthUsers, Perm: swys3api.S3PermRead },
+		}
+	}
+
+	return nil
+}
+
+func aclGrants(canned string, grants []s3mgo.Grant) []s3mgo.Grant {
+	if len(grants) > 0 {
+		return grants
+	}
+
+	return cannedGrants(canned)
+}
+
+func granteeMatches(grantee string, iam *s3mgo.Iam) bool {
+	switch grantee {
+	case s3mgo.GranteeAllUsers:
+		return true
+	case s3mgo.GranteeAuthUsers:
+		return iam.AwsID != ""
+	}
+
+	return iam.AwsID != "" && grantee == iam.AwsID
+}
+
+func grantsAllow(grants []s3mgo.Grant, perm string, iam *s3mgo.Iam) bool {
+	for _, g := range grants {
+		if g.Perm != perm && g.Perm != swys3api.S3PermFull {
+			continue
+		}
+		if granteeMatches(g.Grantee, iam) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ * The version is the object found by the request's versionId, without
+ * one the version actions are not granted by ACLs at all
+ */
+func aclAllows(ctx context.Context, bucket *s3mgo.Bucket, oname string, version *s3mgo.Object, action int) bool {
+	iam := ctxIam(ctx)
+
+	if perm, ok := aclBucketPerm[action]; ok {
+		return grantsAllow(aclGrants(bucket.CannedAcl, bucket.Grants), perm, iam)
+	}
+
+	if perm, ok := aclVersionPerm[action]; ok {
+		if version == nil {
+			return false
+		}
+
+		return grantsAllow(aclGrants(version.Acl, version.Grants), perm, iam)
+	}
+
+	if perm, ok := aclObjectPerm[action]; ok && oname != "" {
+		object, err := FindCurObject(ctx, bucket, oname)
+		if err != nil {
+			return false
+		}
+
+		return grantsAllow(aclGrants(object.Acl, object.Grants), perm, iam)
+	}
+
+	return false
+}
+
+func aclToXML(account *s3mgo.Account, canned string, grants []s3mgo.Grant) *swys3api.S3AccessControlPolicy {
+	owner := swys3api.S3Owner{ ID: account.AwsID, DisplayName: account.User }
+	resp := &swys3api.S3AccessControlPolicy{ Owner: owner }
+
+	resp.AccessControlList.Grant = append(resp.AccessControlList.Grant, swys3api.S3Grant {
+		Grantee: swys3api.S3Grantee {
+			Type:		swys3api.S3GranteeCanonicalUser,
+			ID:		owner.ID,
+			DisplayName:	owner.DisplayName,
+		},
+		Permission: swys3api.S3PermFull,
+	})
+
+	for _, g := range aclGrants(canned, grants) {
+		var grantee swys3api.S3Grantee
+
+		switch g.Grantee {
+		case s3mgo.GranteeAllUsers:
+			grantee.Type = swys3api.S3GranteeGroup
+			grantee.URI = swys3api.S3GroupAllUsers
+		case s3mgo.GranteeAuthUsers:
+			grantee.Type = swys3api.S3GranteeGroup
+			grantee.URI = swys3api.S3GroupAuthenticatedUsers
+		default:
+			grantee.Type = swys3api.S3GranteeCanonicalUser
+			grantee.ID = g.Grantee
+		}
+
+		resp.AccessControlList.Grant = append(resp.AccessControlList.Grant,
+				swys3api.S3Grant{ Grantee: grantee, Permission: g.Perm })
+	}
+
+	return resp
+}
+
+/*
+ * Either the x-amz-acl canned header or the AccessControlPolicy
+ * body. Grants to the owner are implicit and are not kept.
+ */
+func aclFromReq(r *http.Request, account *s3mgo.Account) (string, []s3mgo.Grant, *S3Error) {
+	var acp swys3api.S3AccessControlPolicy
+	var grants []s3mgo.Grant
+
+	if canned := r.Header.Get("x-amz-acl"); canned != "" {
+		if !verifyAclValue(canned, BucketCannedAcls) {
+			return "", nil, &S3Error{ ErrorCode: S3ErrInvalidArgument, Message: "Bad canned ACL" }
+		}
+		return canned, nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", nil, &S3Error{ ErrorCode: S3ErrIncompleteBody }
+	}
+
+	err = xml.Unmarshal(body, &acp)
+	if err != nil {
+		return "", nil, &S3Error{ ErrorCode: S3ErrMalformedACLError }
+	}
+
+	for _, g := range acp.AccessControlList.Grant {
+		var grantee string
+
+		if !verifyAclValue(g.Permission, aclPerms) {
+			return "", nil, &S3Error{ ErrorCode: S3ErrMalformedACLError,
+					Message: "Bad permission " + g.Permission }
+		}
+
+		switch {
+		case g.Grantee.URI == swys3api.S3GroupAllUsers:
+			grantee = s3mgo.GranteeAllUsers
+		case g.Grantee.URI == swys3api.S3GroupAuthenticatedUsers:
+			grantee = s3mgo.GranteeAuthUsers
+		case g.Grantee.EmailAddress != "":
+			return "", nil, &S3Error{ ErrorCode: S3ErrUnresolvableGrantByEmailAddress }
+		case g.Grantee.ID != "":
+			grantee = g.Grantee.ID
+		default:
+			return "", nil, &S3Error{ ErrorCode: S3ErrMalformedACLError, Message: "Bad grantee" }
+		}
+
+		if grantee == account.AwsID {
+			continue
+		}
+
+		grants = append(grants, s3mgo.Grant{ Grantee: grantee, Perm: g.Permission })
+	}
+
+	return swys3api.S3BucketAclCannedPrivate, grants, nil
+}
+
+func aclUpdate(canned_key, canned string, grants []s3mgo.Grant) bson.M {
+	if len(grants) > 0 {
+		return bson.M{ "$set": bson.M{ canned_key: canned, "grants": grants } }
+	}
+
+	return bson.M{ "$set": bson.M{ canned_key: canned }, "$unset": bson.M{ "grants": "" } }
+}
+
+func handleGetBucketAcl(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_GetBucketAcl); e != nil {
+		return e
+	}
+
+	account, err := s3AccountLookup(ctx)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	HTTPRespXML(w, aclToXML(account, bucket.CannedAcl, bucket.Grants))
+	return nil
+}
+
+func handlePutBucketAcl(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_PutBucketAcl); e != nil {
+		return e
+	}
+
+	account, err := s3AccountLookup(ctx)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	canned, grants, e := aclFromReq(r, account)
+	if e != nil {
+		return e
+	}
+
+	err = dbS3Update(ctx, bson.M{ "state": S3StateActive },
+			aclUpdate("canned-acl", canned, grants), true, bucket)
+	if err != nil {
+		log.Errorf("s3: Can't set ACL on %s: %s", infoLong(bucket), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleGetObjectAcl(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_GetObjectAcl); e != nil {
+		return e
+	}
+
+	object, e := findReqObject(ctx, oname, bucket, r, S3P_GetObjectVersionAcl)
+	if e != nil {
+		return e
+	}
+
+	account, err := s3AccountLookup(ctx)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	setVersionHeader(w, bucket, object)
+	HTTPRespXML(w, aclToXML(account, object.Acl, object.Grants))
+	return nil
+}
+
+func handlePutObjectAcl(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_PutObjectAcl); e != nil {
+		return e
+	}
+
+	account, err := s3AccountLookup(ctx)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	canned, grants, e := aclFromReq(r, account)
+	if e != nil {
+		return e
+	}
+
+	object, e := findReqObject(ctx, oname, bucket, r, S3P_PutObjectVersionAcl)
+	if e != nil {
+		return e
+	}
+
+	err = dbS3Update(ctx, bson.M{ "state": S3StateActive },
+			aclUpdate("acl", canned, grants), true, object)
+	if err != nil {
+		log.Errorf("s3: Can't set ACL on %s: %s", infoLong(object), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	setVersionHeader(w, bucket, object)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
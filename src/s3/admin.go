@@ -85,6 +85,56 @@ out:
 	http.Error(w, err.Error(), http.StatusBadRequest)
 }
 
+func handlePresign(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var akey *s3mgo.AccessKey
+	var ps swys3api.Presign
+	var url string
+	var err error
+
+	err = xhttp.RReq(r, &ps)
+	if err != nil {
+		goto out
+	}
+
+	switch ps.Method {
+	case http.MethodGet, http.MethodPut, http.MethodHead:
+		;
+	default:
+		err = errors.New("Bad method")
+		goto out
+	}
+
+	if ps.Namespace == "" || ps.Bucket == "" || ps.Object == "" || ps.Endpoint == "" {
+		err = errors.New("Missing namespace, bucket, object or endpoint")
+		goto out
+	}
+
+	if ps.Expires == 0 || ps.Expires > AWSPresignExpiresMax {
+		err = errors.New("Bad expiration value")
+		goto out
+	}
+
+	akey, err = getPresignKey(ctx, ps.Namespace, ps.Bucket, ps.Expires)
+	if err != nil {
+		goto out
+	}
+
+	url, err = s3Presign(akey, ps.Endpoint, ps.Method, ps.Bucket, ps.Object, int(ps.Expires))
+	if err != nil {
+		goto out
+	}
+
+	err = xhttp.Respond(w, &swys3api.PresignResult{URL: url})
+	if err != nil {
+		goto out
+	}
+	return
+
+out:
+	log.Errorf("Can't presign: %s", err.Error())
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
 func handleNotify(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	var params swys3api.Subscribe
 
@@ -11,7 +11,7 @@ import (
 	"strings"
 	"regexp"
 	"context"
-	"sort"
+	"net/url"
 	"time"
 
 	"swifty/apis/s3"
@@ -34,6 +34,8 @@ type S3ListObjectsRP struct {
 	StartAfter		string
 	Marker			string
 
+	UrlEncode		bool
+
 	// Private fields
 	ContTokenDecoded	string
 	V2			bool
@@ -393,47 +395,81 @@ func (params *S3ListObjectsRP) Validate() (bool) {
 	return true
 }
 
-func s3ListBucket(ctx context.Context, bname string, params *S3ListObjectsRP) (*swys3api.S3Bucket, *S3Error) {
-	var start_after, cont_after bool
-	var prefixes_map map[string]bool
+/* encoding-type=url, keys may contain chars XML cannot carry */
+func listUrlEncode(list *swys3api.S3Bucket) {
+	list.EncodingType = "url"
+	list.Prefix = url.QueryEscape(list.Prefix)
+	list.Delimiter = url.QueryEscape(list.Delimiter)
+	list.StartAfter = url.QueryEscape(list.StartAfter)
+	list.Marker = url.QueryEscape(list.Marker)
+	list.NextMarker = url.QueryEscape(list.NextMarker)
+
+	for i, _ := range list.Contents {
+		list.Contents[i].Key = url.QueryEscape(list.Contents[i].Key)
+	}
+	for i, _ := range list.CommonPrefixes {
+		list.CommonPrefixes[i].Prefix = url.QueryEscape(list.CommonPrefixes[i].Prefix)
+	}
+}
+
+/*
+ * Both V1 markers and V2 tokens point to the last returned entry, which
+ * can be a common prefix. In the latter case all the keys under it were
+ * already reported and are skipped.
+ */
+func s3ListBucket(ctx context.Context, bucket *s3mgo.Bucket, params *S3ListObjectsRP) (*swys3api.S3Bucket, *S3Error) {
 	var list swys3api.S3Bucket
-	var bucket *s3mgo.Bucket
 	var object s3mgo.Object
+	var owner *swys3api.S3Owner
 	var pipe *mgo.Pipe
 	var iter *mgo.Iter
-	var err error
-	var pkey string
+	var pkey, last string
 
 	if params.Validate() == false {
 		return nil, &S3Error{ ErrorCode: S3ErrInvalidArgument }
 	}
 
-	bucket, err = FindBucket(ctx, bname)
-	if err != nil {
-		if err == mgo.ErrNotFound {
-			return nil, &S3Error{ ErrorCode: S3ErrNoSuchBucket }
-		}
-		return nil, &S3Error{ ErrorCode: S3ErrInternalError }
-	}
-
-	if params.StartAfter != "" { start_after = true }
+	after := params.StartAfter
 	if params.ContTokenDecoded != "" {
-		start_after = false
-		cont_after = true
+		after = params.ContTokenDecoded
 	}
 
 	list.Name	= bucket.Name
+	list.Prefix	= params.Prefix
+	list.Delimiter	= params.Delimiter
 	list.KeyCount	= 0
 	list.MaxKeys	= params.MaxKeys
 	list.IsTruncated= false
 
-	query := bson.M{ "bucket-id": bucket.ObjID, "state": S3StateActive}
+	if params.V2 {
+		list.StartAfter = params.StartAfter
+		list.ContinuationToken = params.ContToken
+	} else {
+		list.Marker = params.Marker
+	}
+
+	if !params.V2 || params.FetchOwner {
+		account, err := s3AccountLookup(ctx)
+		if err != nil {
+			return nil, &S3Error{ ErrorCode: S3ErrInternalError }
+		}
+		owner = &swys3api.S3Owner{ ID: account.AwsID, DisplayName: account.User }
+	}
 
+	query := bson.M{ "bucket-id": bucket.ObjID, "state": S3StateActive}
+	keyq := bson.M{}
 	if params.Prefix != "" {
-		query["key"] = bson.M{ "$regex": "^" + params.Prefix, }
+		keyq["$regex"] = "^" + regexp.QuoteMeta(params.Prefix)
+	}
+	if after != "" {
+		keyq["$gt"] = after
+	}
+	if len(keyq) > 0 {
+		query["key"] = keyq
 	}
 
-	prefixes_map = make(map[string]bool)
+	skip_after := params.Delimiter != "" && strings.HasSuffix(after, params.Delimiter)
+	prefixes_map := make(map[string]bool)
 
 	pipe = dbS3Pipe(ctx, &object, []bson.M{{"$match": query}, {"$sort": bson.M{"key": 1, "rover": -1}}})
 	iter = pipe.Iter()
@@ -444,72 +480,66 @@ func s3ListBucket(ctx context.Context, bname string, params *S3ListObjectsRP) (*
 
 		pkey = object.Key
 
-		if start_after {
-			if object.Key != params.StartAfter { continue }
-			start_after = false
+		if object.DelMarker {
 			continue
 		}
-		if cont_after {
-			if object.Key != params.ContTokenDecoded { continue }
-			cont_after = false
+		if skip_after && strings.HasPrefix(object.Key, after) {
 			continue
 		}
+
 		if params.Delimiter != "" {
 			len_pfx := len(params.Prefix)
-			len_dlm := len(params.Delimiter)
-			len_key := len(object.Key)
 			pos := strings.Index(object.Key[len_pfx:], params.Delimiter)
-			if pos >= 0 && (pos + len_pfx + len_dlm) <= len_key {
-				if pos == 0 { continue }
-				prefix := object.Key[:len_pfx+pos+len_dlm]
-				if _, ok := prefixes_map[prefix]; !ok {
-					prefixes_map[prefix] = true
+			if pos >= 0 {
+				prefix := object.Key[:len_pfx+pos+len(params.Delimiter)]
+				if prefixes_map[prefix] {
+					continue
 				}
+
+				if list.KeyCount >= list.MaxKeys {
+					list.IsTruncated = true
+					break
+				}
+
+				prefixes_map[prefix] = true
+				list.CommonPrefixes = append(list.CommonPrefixes,
+					swys3api.S3Prefix {
+						Prefix: prefix,
+					})
+				list.KeyCount++
+				last = prefix
 				continue
 			}
 		}
-		o := swys3api.S3Object {
+
+		if list.KeyCount >= list.MaxKeys {
+			list.IsTruncated = true
+			break
+		}
+
+		list.Contents = append(list.Contents, swys3api.S3Object {
 			Key:		object.Key,
 			Size:		object.Size,
+			Owner:		owner,
 			LastModified:	object.CreationTime,
 			ETag:		object.ETag,
 			StorageClass:	swys3api.S3StorageClassStandard,
-		}
-
-		if params.FetchOwner {
-			iam := ctxIam(ctx)
-			o.Owner.DisplayName = iam.User
-			o.Owner.ID = iam.AwsID
-		}
-
-		list.Contents = append(list.Contents, o)
+		})
 		list.KeyCount++
-
-		if list.KeyCount >= list.MaxKeys {
-			list.IsTruncated = true
-			if params.V2 {
-				list.ContinuationToken = params.ContToken
-				list.NextContinuationToken = base64_encode([]byte(object.Key))
-			} else {
-				list.Marker = params.Marker
-				list.NextMarker = object.Key
-			}
-			break
-		}
+		last = object.Key
 	}
 	iter.Close()
 
-	keys := []string{ }
-	for k, _ := range prefixes_map {
-		keys = append(keys, k)
+	if list.IsTruncated {
+		if params.V2 {
+			list.NextContinuationToken = base64_encode([]byte(last))
+		} else {
+			list.NextMarker = last
+		}
 	}
-	sort.Strings(keys)
 
-	for _, k := range keys {
-		list.CommonPrefixes = append(list.CommonPrefixes,
-			swys3api.S3Prefix {
-				Prefix: k,
-			})
+	if params.UrlEncode {
+		listUrlEncode(&list)
 	}
 
 	return &list, nil
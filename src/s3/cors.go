@@ -0,0 +1,381 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"context"
+	"errors"
+
+	"swifty/apis/s3"
+	"swifty/s3/mgo"
+)
+
+/*
+ * CORS rules are kept on the bucket. The first rule matching the
+ * origin, the method and the requested headers decides what goes
+ * into the response, if none matches the browser gets no CORS
+ * headers and the preflight is rejected.
+ */
+
+const (
+	S3CorsRulesMax		= 100
+	S3CorsIDMax		= 255
+)
+
+var corsMethods = map[string]bool {
+	http.MethodGet:		true,
+	http.MethodPut:		true,
+	http.MethodPost:	true,
+	http.MethodDelete:	true,
+	http.MethodHead:	true,
+}
+
+func corsRuleFromXML(xr *swys3api.S3CorsRule) (*s3mgo.CorsRule, error) {
+	if len(xr.ID) > S3CorsIDMax {
+		return nil, errors.New("Rule ID is too long")
+	}
+
+	if len(xr.AllowedOrigin) == 0 {
+		return nil, errors.New("Rule has no AllowedOrigin")
+	}
+	for _, o := range xr.AllowedOrigin {
+		if strings.Count(o, "*") > 1 {
+			return nil, errors.New("AllowedOrigin " + o + " can have at most one wildcard")
+		}
+	}
+
+	if len(xr.AllowedMethod) == 0 {
+		return nil, errors.New("Rule has no AllowedMethod")
+	}
+	for _, m := range xr.AllowedMethod {
+		if !corsMethods[m] {
+			return nil, errors.New("Unsupported AllowedMethod " + m)
+		}
+	}
+
+	for _, h := range xr.AllowedHeader {
+		if strings.Count(h, "*") > 1 {
+			return nil, errors.New("AllowedHeader " + h + " can have at most one wildcard")
+		}
+	}
+
+	if xr.MaxAgeSeconds < 0 {
+		return nil, errors.New("Bad MaxAgeSeconds")
+	}
+
+	return &s3mgo.CorsRule {
+		ID:		xr.ID,
+		Origins:	xr.AllowedOrigin,
+		Methods:	xr.AllowedMethod,
+		Headers:	xr.AllowedHeader,
+		Expose:		xr.ExposeHeader,
+		MaxAge:		xr.MaxAgeSeconds,
+	}, nil
+}
+
+func corsRuleToXML(rule *s3mgo.CorsRule) swys3api.S3CorsRule {
+	return swys3api.S3CorsRule {
+		ID:		rule.ID,
+		AllowedOrigin:	rule.Origins,
+		AllowedMethod:	rule.Methods,
+		AllowedHeader:	rule.Headers,
+		ExposeHeader:	rule.Expose,
+		MaxAgeSeconds:	rule.MaxAge,
+	}
+}
+
+func s3SetCors(ctx context.Context, bucket *s3mgo.Bucket, rules []s3mgo.CorsRule) error {
+	var update bson.M
+
+	if len(rules) > 0 {
+		update = bson.M{ "$set": bson.M{ "cors": rules } }
+	} else {
+		update = bson.M{ "$unset": bson.M{ "cors": "" } }
+	}
+
+	return dbS3Update(ctx, bson.M{ "state": S3StateActive }, update, true, bucket)
+}
+
+func handleGetBucketCors(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_GetBucketCORS) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if len(b.Cors) == 0 {
+		return &S3Error{ ErrorCode: S3ErrNoSuchCORSConfiguration }
+	}
+
+	var resp swys3api.S3CorsConfig
+	for i := range b.Cors {
+		resp.CORSRule = append(resp.CORSRule, corsRuleToXML(&b.Cors[i]))
+	}
+
+	HTTPRespXML(w, &resp)
+	return nil
+}
+
+func handlePutBucketCors(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_PutBucketCORS) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrIncompleteBody }
+	}
+
+	var cfg swys3api.S3CorsConfig
+
+	err = xml.Unmarshal(body, &cfg)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrMalformedXML }
+	}
+
+	if len(cfg.CORSRule) == 0 || len(cfg.CORSRule) > S3CorsRulesMax {
+		return &S3Error{ ErrorCode: S3ErrMalformedXML, Message: "Bad number of rules" }
+	}
+
+	var rules []s3mgo.CorsRule
+
+	for i := range cfg.CORSRule {
+		rule, err := corsRuleFromXML(&cfg.CORSRule[i])
+		if err != nil {
+			return &S3Error{ ErrorCode: S3ErrInvalidArgument, Message: err.Error() }
+		}
+
+		rules = append(rules, *rule)
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	err = s3SetCors(ctx, b, rules)
+	if err != nil {
+		log.Errorf("s3: Can't set cors on %s: %s", infoLong(b), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleDelBucketCors(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_PutBucketCORS) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	err = s3SetCors(ctx, b, nil)
+	if err != nil {
+		log.Errorf("s3: Can't drop cors on %s: %s", infoLong(b), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+/* The pattern may have one '*' matching any (possibly empty) substring */
+func corsMatch(pattern, s string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == s
+	}
+
+	pre, suf := pattern[:i], pattern[i + 1:]
+	return len(s) >= len(pre) + len(suf) &&
+		strings.HasPrefix(s, pre) && strings.HasSuffix(s, suf)
+}
+
+func corsHeaderAllowed(rule *s3mgo.CorsRule, hdr string) bool {
+	for _, h := range rule.Headers {
+		if corsMatch(strings.ToLower(h), hdr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/* Returns the matched rule and the origin pattern that let it match */
+func corsFindRule(rules []s3mgo.CorsRule, origin, method string, hdrs []string) (*s3mgo.CorsRule, string) {
+	for i := range rules {
+		rule := &rules[i]
+
+		match := ""
+		for _, o := range rule.Origins {
+			if corsMatch(o, origin) {
+				match = o
+				break
+			}
+		}
+		if match == "" {
+			continue
+		}
+
+		mok := false
+		for _, m := range rule.Methods {
+			if m == method {
+				mok = true
+				break
+			}
+		}
+		if !mok {
+			continue
+		}
+
+		hok := true
+		for _, h := range hdrs {
+			if !corsHeaderAllowed(rule, h) {
+				hok = false
+				break
+			}
+		}
+		if !hok {
+			continue
+		}
+
+		return rule, match
+	}
+
+	return nil, ""
+}
+
+func corsRequestHeaders(r *http.Request) []string {
+	var ret []string
+
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" {
+			ret = append(ret, h)
+		}
+	}
+
+	return ret
+}
+
+func corsSetHeaders(w http.ResponseWriter, rule *s3mgo.CorsRule, match, origin string,
+		preflight bool, hdrs []string) {
+	if match == "*" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.Methods, ", "))
+	if len(rule.Expose) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.Expose, ", "))
+	}
+
+	if preflight {
+		if len(hdrs) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(hdrs, ", "))
+		}
+		if rule.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAge))
+		}
+	}
+}
+
+/*
+ * Sets the CORS headers by the bucket rules and completely answers the
+ * preflight. Returns false when the request is not a cross-origin one or
+ * the bucket has no rules, the caller then decides what to do with it.
+ */
+func s3CorsApply(ctx context.Context, w http.ResponseWriter, r *http.Request, bname string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || bname == "" {
+		return false
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil || len(b.Cors) == 0 {
+		return false
+	}
+
+	var hdrs []string
+
+	method := r.Method
+	preflight := (method == http.MethodOptions)
+	if preflight {
+		method = r.Header.Get("Access-Control-Request-Method")
+		hdrs = corsRequestHeaders(r)
+		w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	} else {
+		w.Header().Add("Vary", "Origin")
+	}
+
+	rule, match := corsFindRule(b.Cors, origin, method, hdrs)
+	if rule != nil {
+		corsSetHeaders(w, rule, match, origin, preflight, hdrs)
+	}
+
+	if preflight {
+		if rule == nil {
+			HTTPRespError(w, S3ErrCORSResponse, "This CORS request is not allowed")
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	return true
+}
+
+/*
+ * Preflights carry no credentials, the only hint on whose bucket is
+ * asked about is the access key in the presigned URL. The signature
+ * is not checked, the iam is only used to find the bucket. Bucket
+ * names are per-account, so preflights for header-signed requests
+ * can't be tied to the bucket and get the service-wide headers (see
+ * docs/swifty-lib.txt).
+ */
+func corsPreflightAuthorize(ctx context.Context, r *http.Request) bool {
+	var actx AuthContext
+
+	if actx.parseCredential(r.URL.Query().Get(AWSQueryCredential)) != nil {
+		return false
+	}
+
+	akey, err := LookupAccessKey(ctx, actx.AccessKey)
+	if err != nil || akey.Expired() {
+		return false
+	}
+
+	iam, err := s3IamFind(ctx, akey)
+	if err != nil {
+		return false
+	}
+
+	ctxAuthorize(ctx, iam)
+	return true
+}
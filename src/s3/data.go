@@ -9,63 +9,103 @@ import (
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"crypto/md5"
+	"io/ioutil"
 	"context"
-	"errors"
 	"time"
+	"math"
 	"fmt"
 	"io"
 	"swifty/s3/mgo"
 )
 
-func ReadChunks(ctx context.Context, part *s3mgo.ObjectPart) ([]byte, error) {
-	var res []byte
+type mongoData struct { }
 
-	if part.Data != nil {
-		return part.Data, nil
-	}
+func (md *mongoData) Write(ctx context.Context, part *s3mgo.ObjectPart, data *ChunkReader) (string, error) {
+	csum, err := writeHashed(data, func(chd []byte) error {
+		chunk := &s3mgo.DataChunk {
+			ObjID:	bson.NewObjectId(),
+			Bytes:	chd,
+		}
 
-	if len(part.Chunks) == 0 {
-		return radosReadObject(part.BCookie, part.OCookie, uint64(part.Size), 0)
+		err := dbS3Insert(ctx, chunk)
+		if err != nil {
+			return err
+		}
+
+		part.Chunks = append(part.Chunks, chunk.ObjID)
+		return nil
+	})
+	if err != nil && len(part.Chunks) != 0 {
+		md.Delete(ctx, part)
+		part.Chunks = nil
 	}
 
-	for _, cid := range part.Chunks {
+	return csum, err
+}
+
+func mongoFetchChunk(ctx context.Context) s3mgo.ChunkFetchFn {
+	return func(id bson.ObjectId) (*s3mgo.DataChunk, error) {
 		var ch s3mgo.DataChunk
 
-		err := dbS3FindOne(ctx, bson.M{"_id": cid}, &ch)
+		err := dbS3FindOne(ctx, bson.M{"_id": id}, &ch)
 		if err != nil {
 			return nil, err
 		}
 
-		res = append(res, ch.Bytes...)
+		return &ch, nil
 	}
+}
 
-	return res, nil
+func (_ *mongoData) ReadTo(ctx context.Context, part *s3mgo.ObjectPart, off, size int64, w io.Writer) (int64, error) {
+	return part.WriteRangeTo(w, off, size, mongoFetchChunk(ctx))
 }
 
-func IterChunks(ctx context.Context, part *s3mgo.ObjectPart, fn IterChunksFn) error {
-	if part.Data != nil {
-		return fn(&s3mgo.DataChunk{Bytes: part.Data})
-	}
+func (_ *mongoData) Delete(ctx context.Context, part *s3mgo.ObjectPart) error {
+	var err error
 
-	if len(part.Chunks) == 0 {
-		return errors.New("Rados cannot iter chunks yet")
+	for _, ch := range part.Chunks {
+		er := dbS3Remove(ctx, &s3mgo.DataChunk{ObjID: ch})
+		if er != nil {
+			err = er
+		}
 	}
 
-	for _, cid := range part.Chunks {
-		var ch s3mgo.DataChunk
+	return err
+}
 
-		err := dbS3FindOne(ctx, bson.M{"_id": cid}, &ch)
+func (md *mongoData) Copy(ctx context.Context, part *s3mgo.ObjectPart, source *s3mgo.ObjectPart) error {
+	var err error
+
+	fetch := mongoFetchChunk(ctx)
+
+	for _, cid := range source.Chunks {
+		var ch *s3mgo.DataChunk
+
+		ch, err = fetch(cid)
 		if err != nil {
-			return err
+			break
 		}
 
-		err = fn(&ch)
+		/* XXX -- do the COW XXX */
+		ch.ObjID = bson.NewObjectId()
+		err = dbS3Insert(ctx, ch)
 		if err != nil {
-			return err
+			break
 		}
+
+		part.Chunks = append(part.Chunks, ch.ObjID)
 	}
 
-	return nil
+	if err != nil && len(part.Chunks) != 0 {
+		md.Delete(ctx, part)
+		part.Chunks = nil
+	}
+
+	return err
+}
+
+func (md *mongoData) Stat(ctx context.Context, part *s3mgo.ObjectPart) (int64, error) {
+	return md.ReadTo(ctx, part, 0, math.MaxInt64, ioutil.Discard)
 }
 
 type ChunkReader struct {
@@ -74,6 +114,29 @@ type ChunkReader struct {
 	r	io.Reader
 }
 
+/* Reads no more than size bytes from the underlying request body */
+func (cr *ChunkReader)Read(p []byte) (int, error) {
+	if cr.read >= cr.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > cr.size - cr.read {
+		p = p[:cr.size - cr.read]
+	}
+
+	n, err := cr.r.Read(p)
+	cr.read += int64(n)
+	return n, err
+}
+
+/* Checks the signed payload (if any) once all the data is read */
+func (cr *ChunkReader)verify() error {
+	if v, ok := cr.r.(bodyVerifier); ok {
+		return v.verify()
+	}
+
+	return nil
+}
+
 func (cr *ChunkReader)Next(max int64) ([]byte, error) {
 	if max > cr.size - cr.read {
 		max = cr.size - cr.read
@@ -105,109 +168,54 @@ func (cr *ChunkReader)Next(max int64) ([]byte, error) {
 }
 
 func WriteChunks(ctx context.Context, part *s3mgo.ObjectPart, data *ChunkReader) (string, error) {
-	var err error
-
-	if !radosDisabled && part.Size > S3MaxChunkSize {
-		return radosWriteObject(part.BCookie, part.OCookie, data, 0)
-	}
-
-	hasher := md5.New()
-
-	for {
-		chd, err := data.Next(S3MaxChunkSize)
-		if err != nil {
-			goto out
-		}
-		if chd == nil {
-			break
-		}
-
-		chunk := &s3mgo.DataChunk {
-			ObjID:	bson.NewObjectId(),
-			Bytes:	chd,
-		}
+	part.Backend = dataDriverFor(part.Size)
+	drv := dataDrivers[part.Backend]
 
-		hasher.Write(chunk.Bytes)
-
-		err = dbS3Insert(ctx, chunk)
-		if err != nil {
-			goto out
-		}
-
-		part.Chunks = append(part.Chunks, chunk.ObjID)
+	csum, err := drv.Write(ctx, part, data)
+	if err != nil {
+		return "", err
 	}
 
-	err = dbS3Update(ctx, bson.M{"_id": part.ObjID},
-			bson.M{ "$set": bson.M{ "chunks": part.Chunks }},
-			false, &s3mgo.ObjectPart{})
+	err = partSetData(ctx, part)
 	if err != nil {
-		goto out
+		drv.Delete(ctx, part)
+		return "", err
 	}
 
-	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
-
-out:
-	if len(part.Chunks) != 0 {
-		DeleteChunks(ctx, part)
-	}
-	return "", err
+	return csum, nil
 }
 
+/* The copy stays with the driver holding the source data */
 func CopyChunks(ctx context.Context, part *s3mgo.ObjectPart, source *s3mgo.ObjectPart) error {
-	var err error
-
-	if !radosDisabled && part.Size > S3MaxChunkSize {
-		return errors.New("Raos doesn't copy chunks")
+	drv, err := partDriver(source)
+	if err != nil {
+		return err
 	}
 
-	for _, cid := range source.Chunks {
-		var ch s3mgo.DataChunk
-
-		err := dbS3FindOne(ctx, bson.M{"_id": cid}, &ch)
-		if err != nil {
-			return err
-		}
+	part.Backend = partBackend(source)
 
-		/* XXX -- do the COW XXX */
-		ch.ObjID = bson.NewObjectId()
-		err = dbS3Insert(ctx, &ch)
-		if err != nil {
-			goto out
-		}
-
-		part.Chunks = append(part.Chunks, ch.ObjID)
+	err = drv.Copy(ctx, part, source)
+	if err != nil {
+		return err
 	}
 
-	err = dbS3Update(ctx, bson.M{"_id": part.ObjID},
-			bson.M{ "$set": bson.M{ "chunks": part.Chunks }},
-			false, &s3mgo.ObjectPart{})
+	err = partSetData(ctx, part)
 	if err != nil {
-		goto out
+		drv.Delete(ctx, part)
+		return err
 	}
 
 	return nil
-
-out:
-	if len(part.Chunks) != 0 {
-		DeleteChunks(ctx, part)
-	}
-	return err
 }
 
 func DeleteChunks(ctx context.Context, part *s3mgo.ObjectPart) error {
-	var err error
-
 	if part.Data != nil {
-		;
-	} else if len(part.Chunks) == 0 {
-		err = radosDeleteObject(part.BCookie, part.OCookie)
-	} else {
-		for _, ch := range part.Chunks {
-			er := dbS3Remove(ctx, &s3mgo.DataChunk{ObjID: ch})
-			if err != nil {
-				err = er
-			}
-		}
+		return nil
+	}
+
+	drv, err := partDriver(part)
+	if err == nil {
+		err = drv.Delete(ctx, part)
 	}
 	if err != nil {
 		log.Errorf("s3: %s/%s backend object data may stale",
@@ -420,6 +428,11 @@ func AddPart(ctx context.Context, refid bson.ObjectId, bucket_bid, object_bid st
 		}
 	}
 
+	if err = data.verify(); err != nil {
+		DeleteChunks(ctx, objp)
+		goto out
+	}
+
 	if err = dbS3SetState2(ctx, objp, S3StateActive, bson.M{"etag": csum}); err != nil {
 		DeleteChunks(ctx, objp)
 		goto out
@@ -472,21 +485,6 @@ func DeletePart(ctx context.Context, objp *s3mgo.ObjectPart) (error) {
 	return nil
 }
 
-func ReadParts(ctx context.Context, bucket *s3mgo.Bucket, ocookie string, objp []*s3mgo.ObjectPart) ([]byte, error) {
-	var res []byte
-
-	for _, od := range objp {
-		x, err := ReadChunks(ctx, od)
-		if err != nil {
-			return nil, err
-		}
-
-		res = append(res, x...)
-	}
-
-	return res, nil
-}
-
 func ResumParts(ctx context.Context, upload *S3Upload) (int64, string, error) {
 	var objp *s3mgo.ObjectPart
 	var pipe *mgo.Pipe
@@ -504,24 +502,10 @@ func ResumParts(ctx context.Context, upload *S3Upload) (int64, string, error) {
 			continue
 		}
 
-		if objp.Data != nil {
-			hasher.Write(objp.Data)
-		} else {
-			if len(objp.Chunks) == 0 {
-				/* XXX Too bad :( */
-				return 0, "", fmt.Errorf("Can't finish upload")
-			}
-
-			for _, cid := range objp.Chunks {
-				var ch s3mgo.DataChunk
-
-				err := dbS3FindOne(ctx, bson.M{"_id": cid}, &ch)
-				if err != nil {
-					return 0, "", err
-				}
-
-				hasher.Write(ch.Bytes)
-			}
+		_, err := partReadTo(ctx, objp, 0, objp.Size, hasher)
+		if err != nil {
+			iter.Close()
+			return 0, "", err
 		}
 		size += objp.Size
 	}
@@ -0,0 +1,125 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"context"
+
+	"swifty/s3/mgo"
+	"swifty/apis/s3"
+)
+
+const (
+	S3DeleteObjectsMax	= 1000
+)
+
+/*
+ * Deletes one key (or its version) of the multi-object request. Keys that
+ * do not exist are reported as deleted, just like the single key DELETE.
+ */
+func s3DeleteOne(ctx context.Context, bucket *s3mgo.Bucket, r *http.Request, key *swys3api.S3DeleteObject) (*swys3api.S3Deleted, *S3Error) {
+	var object *s3mgo.Object
+	var err error
+
+	if key.Key == "" {
+		return nil, &S3Error{ ErrorCode: S3ErrSwyInvalidObjectName }
+	}
+
+	res := &swys3api.S3Deleted{ Key: key.Key, VersionId: key.VersionId }
+
+	if key.VersionId != "" {
+		if e := s3CheckAccess(ctx, r, bucket, key.Key, S3P_DeleteObjectVersion); e != nil {
+			return nil, e
+		}
+
+		object, err = s3DeleteObjectVersion(ctx, bucket, key.Key, key.VersionId)
+		if err != nil {
+			return nil, &S3Error{ ErrorCode: S3ErrInternalError, Message: err.Error() }
+		}
+
+		if object != nil && object.DelMarker {
+			res.DeleteMarker = true
+			res.DeleteMarkerVersionId = key.VersionId
+		}
+	} else {
+		if e := s3CheckAccess(ctx, r, bucket, key.Key, S3P_DeleteObject); e != nil {
+			return nil, e
+		}
+
+		object, err = s3DeleteObject(ctx, bucket, key.Key)
+		if err != nil {
+			return nil, &S3Error{ ErrorCode: S3ErrInternalError, Message: err.Error() }
+		}
+
+		if object != nil && object.DelMarker {
+			res.DeleteMarker = true
+			res.DeleteMarkerVersionId = objVersionID(object)
+		}
+	}
+
+	return res, nil
+}
+
+func handleDeleteObjects(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	var req swys3api.S3Delete
+	var resp swys3api.S3DeleteResult
+
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrIncompleteBody }
+	}
+
+	if sum := r.Header.Get("Content-MD5"); sum != "" {
+		bsum := base64_decode(sum)
+		if len(bsum) != md5.Size {
+			return &S3Error{ ErrorCode: S3ErrInvalidDigest }
+		}
+		if hex.EncodeToString(bsum) != md5sum(body) {
+			return &S3Error{ ErrorCode: S3ErrBadDigest }
+		}
+	}
+
+	err = xml.Unmarshal(body, &req)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrMalformedXML }
+	}
+
+	if len(req.Object) == 0 || len(req.Object) > S3DeleteObjectsMax {
+		return &S3Error{ ErrorCode: S3ErrMalformedXML, Message: "Bad number of keys" }
+	}
+
+	for i, _ := range req.Object {
+		key := &req.Object[i]
+
+		deleted, e := s3DeleteOne(ctx, bucket, r, key)
+		if e != nil {
+			resp.Error = append(resp.Error, swys3api.S3DeleteError {
+				Key:		key.Key,
+				VersionId:	key.VersionId,
+				Code:		e.Code(),
+				Message:	e.Message,
+			})
+			continue
+		}
+
+		if !req.Quiet {
+			resp.Deleted = append(resp.Deleted, *deleted)
+		}
+	}
+
+	HTTPRespXML(w, &resp)
+	return nil
+}
@@ -125,6 +125,10 @@ const (
 	S3ErrValidationError				int = 94
 
 	S3ErrAuthorizationHeaderMalformed		int = 95
+	S3ErrNoSuchTagSet				int = 96
+	S3ErrInvalidTag					int = 97
+	S3ErrNoSuchCORSConfiguration			int = 98
+	S3ErrCORSResponse				int = 99
 
 	// Own error codes
 	S3ErrSwyInvalidObjectName			int = 1024
@@ -617,6 +621,30 @@ var s3RespErrorMapData = map[int]s3RespErrorMap {
 		ErrorCode:	"AuthorizationHeaderMalformed",
 	},
 
+	// There is no tag set associated with the bucket
+	S3ErrNoSuchTagSet: s3RespErrorMap {
+		HttpStatus:	http.StatusNotFound,
+		ErrorCode:	"NoSuchTagSet",
+	},
+
+	// The tag provided was not a valid tag
+	S3ErrInvalidTag: s3RespErrorMap {
+		HttpStatus:	http.StatusBadRequest,
+		ErrorCode:	"InvalidTag",
+	},
+
+	// The CORS configuration does not exist
+	S3ErrNoSuchCORSConfiguration: s3RespErrorMap {
+		HttpStatus:	http.StatusNotFound,
+		ErrorCode:	"NoSuchCORSConfiguration",
+	},
+
+	// The preflight request is not allowed by the bucket CORS rules
+	S3ErrCORSResponse: s3RespErrorMap {
+		HttpStatus:	http.StatusForbidden,
+		ErrorCode:	"CORSResponse",
+	},
+
 	// The specified object is not valid
 	S3ErrSwyInvalidObjectName: s3RespErrorMap {
 		HttpStatus:	http.StatusBadRequest,
@@ -624,6 +652,15 @@ var s3RespErrorMapData = map[int]s3RespErrorMap {
 	},
 }
 
+/* AWS error code name, for the places that report errors inline */
+func (e *S3Error) Code() string {
+	if m, ok := s3RespErrorMapData[e.ErrorCode]; ok {
+		return m.ErrorCode
+	}
+
+	return "InternalError"
+}
+
 func HTTPRespS3Error(w http.ResponseWriter, e *S3Error) {
 	if m, ok := s3RespErrorMapData[e.ErrorCode]; ok {
 		HTTPMarshalXMLAndWrite(w, m.HttpStatus,
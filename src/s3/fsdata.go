@@ -0,0 +1,159 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"swifty/s3/mgo"
+)
+
+/*
+ * Parts are kept as <root>/<bucket cookie>/<part id> files. The file
+ * is written under a temporary name, synced and then renamed, so the
+ * readers never see partial data.
+ */
+var fsDataRoot string
+
+type fsData struct { }
+
+func fsDataInit(path string) error {
+	if path == "" {
+		return errors.New("Path for fs data driver is not set")
+	}
+
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return err
+	}
+
+	fsDataRoot = path
+	return nil
+}
+
+func fsPath(part *s3mgo.ObjectPart) string {
+	return filepath.Join(fsDataRoot, part.BCookie, part.ObjID.Hex())
+}
+
+func fsSyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	d.Close()
+	return err
+}
+
+func (_ *fsData) Write(ctx context.Context, part *s3mgo.ObjectPart, data *ChunkReader) (string, error) {
+	path := fsPath(part)
+	dir := filepath.Dir(path)
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Errorf("fs: Can't create %s: %s", dir, err.Error())
+		return "", err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0600)
+	if err != nil {
+		log.Errorf("fs: Can't create %s: %s", tmp, err.Error())
+		return "", err
+	}
+
+	csum, err := writeHashed(data, func(chd []byte) error {
+		_, err := f.Write(chd)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		err = fsSyncDir(dir)
+	}
+
+	if err != nil {
+		log.Errorf("fs: Can't write %s: %s", path, err.Error())
+		os.Remove(tmp)
+		os.Remove(path)
+		return "", err
+	}
+
+	log.Debugf("fs: Wrote %s", path)
+	return csum, nil
+}
+
+func (_ *fsData) ReadTo(ctx context.Context, part *s3mgo.ObjectPart, off, size int64, w io.Writer) (int64, error) {
+	f, err := os.Open(fsPath(part))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(w, io.NewSectionReader(f, off, size))
+	if err != nil {
+		log.Errorf("fs: Can't read %s: %s", fsPath(part), err.Error())
+	}
+
+	return n, err
+}
+
+func (_ *fsData) Delete(ctx context.Context, part *s3mgo.ObjectPart) error {
+	err := os.Remove(fsPath(part))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("fs: Can't remove %s: %s", fsPath(part), err.Error())
+		return err
+	}
+
+	return nil
+}
+
+/* Files are never modified once written, so the copy is a hard link */
+func (fd *fsData) Copy(ctx context.Context, part *s3mgo.ObjectPart, source *s3mgo.ObjectPart) error {
+	path := fsPath(part)
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(fsPath(source), path)
+	if err == nil {
+		return fsSyncDir(filepath.Dir(path))
+	}
+
+	f, err := os.Open(fsPath(source))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fd.Write(ctx, part, &ChunkReader{size: source.Size, r: f})
+	return err
+}
+
+func (_ *fsData) Stat(ctx context.Context, part *s3mgo.ObjectPart) (int64, error) {
+	st, err := os.Stat(fsPath(part))
+	if err != nil {
+		return 0, err
+	}
+
+	return st.Size(), nil
+}
@@ -10,6 +10,7 @@ import (
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"swifty/s3/mgo"
+	"swifty/apis/s3"
 )
 
 const gcDefaultPeriod = uint32(10)
@@ -29,13 +30,25 @@ func gcInit(period uint32) error {
 	return nil
 }
 
+/*
+ * With versioning enabled all the versions stay, when it's suspended
+ * only the "null" one gets replaced, otherwise only the latest one
+ * is kept.
+ */
 func gcOldVersions(b *s3mgo.Bucket, key string, rover int64) {
+	if b.Versioning == swys3api.S3VersioningEnabled {
+		return
+	}
+
 	ctx, done := mkContext("GC.obj")
 	defer done(ctx)
 
 	var object s3mgo.Object
 
 	query := bson.M{ "bucket-id": b.ObjID, "state": S3StateActive, "key": key, "rover": bson.M {"$lt": rover}}
+	if b.Versioning == swys3api.S3VersioningSuspended {
+		query["versioned"] = bson.M{ "$ne": true }
+	}
 	pipe := dbS3Pipe(ctx, &object, []bson.M{{"$match": query}, {"$sort": bson.M{"key": 1, "rover": -1}}})
 	iter := pipe.Iter()
 
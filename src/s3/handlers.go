@@ -95,26 +95,30 @@ func handleListBuckets(ctx context.Context, w http.ResponseWriter, r *http.Reque
 }
 
 func handleListUploads(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxMayAccess(ctx, bname) {
-		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
 	}
-	if !ctxAllowed(ctx, S3P_ListBucketMultipartUploads) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_ListBucketMultipartUploads); e != nil {
+		return e
 	}
 
-	uploads, err := s3Uploads(ctx, bname)
-	if err != nil { return err }
+	uploads, e := s3Uploads(ctx, bucket)
+	if e != nil { return e }
 
 	HTTPRespXML(w, uploads)
 	return nil
 }
 
 func handleListObjects(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxMayAccess(ctx, bname) {
-		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
 	}
-	if !ctxAllowed(ctx, S3P_ListBucket) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_ListBucket); e != nil {
+		return e
 	}
 
 	var params *S3ListObjectsRP
@@ -141,13 +145,14 @@ func handleListObjects(ctx context.Context, bname string, w http.ResponseWriter,
 
 	params.Prefix = getURLValue(r, "prefix")
 	params.Delimiter = getURLValue(r, "delimiter")
+	params.UrlEncode = (getURLValue(r, "encoding-type") == "url")
 
 	if v, ok := getURLParam(r, "max-keys"); ok {
 		params.MaxKeys, _ = strconv.ParseInt(v, 10, 64)
 	}
 
-	objects, err := s3ListBucket(ctx, bname, params)
-	if err != nil { return err }
+	objects, e := s3ListBucket(ctx, bucket, params)
+	if e != nil { return e }
 
 	HTTPRespXML(w, objects)
 	return nil
@@ -184,16 +189,13 @@ func handleDeleteBucket(ctx context.Context, bname string, w http.ResponseWriter
 }
 
 func handleAccessBucket(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxMayAccess(ctx, bname) {
-		return &S3Error{ ErrorCode: S3ErrAccessDenied }
-	}
-	if !ctxAllowed(ctx, S3P_ListBucket) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
 	}
 
-	err := s3CheckAccess(ctx, bname, "")
-	if err != nil {
-		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_ListBucket); e != nil {
+		return e
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -227,20 +229,78 @@ func handleBucket(ctx context.Context, w http.ResponseWriter, r *http.Request) *
 		if _, ok := getURLParam(r, "website"); ok {
 			return handleGetWebsite(ctx, bname, w, r)
 		}
+		if _, ok := getURLParam(r, "versioning"); ok {
+			return handleGetVersioning(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "tagging"); ok {
+			return handleGetBucketTagging(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "lifecycle"); ok {
+			return handleGetLifecycle(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "policy"); ok {
+			return handleGetBucketPolicy(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "acl"); ok {
+			return handleGetBucketAcl(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "cors"); ok {
+			return handleGetBucketCors(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "versions"); ok {
+			apiCalls.WithLabelValues("v", "ls").Inc()
+			return handleListVersions(ctx, bname, w, r)
+		}
 		apiCalls.WithLabelValues("o", "ls").Inc()
 		return handleListObjects(ctx, bname, w, r)
 	case http.MethodPut:
 		if _, ok := getURLParam(r, "website"); ok {
 			return handlePutWebsite(ctx, bname, w, r)
 		}
+		if _, ok := getURLParam(r, "versioning"); ok {
+			return handlePutVersioning(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "tagging"); ok {
+			return handlePutBucketTagging(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "lifecycle"); ok {
+			return handlePutLifecycle(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "policy"); ok {
+			return handlePutBucketPolicy(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "acl"); ok {
+			return handlePutBucketAcl(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "cors"); ok {
+			return handlePutBucketCors(ctx, bname, w, r)
+		}
 		apiCalls.WithLabelValues("b", "put").Inc()
 		return handlePutBucket(ctx, bname, w, r)
 	case http.MethodDelete:
 		if _, ok := getURLParam(r, "website"); ok {
 			return handleDelWebsite(ctx, bname, w, r)
 		}
+		if _, ok := getURLParam(r, "tagging"); ok {
+			return handleDelBucketTagging(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "lifecycle"); ok {
+			return handleDelLifecycle(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "policy"); ok {
+			return handleDelBucketPolicy(ctx, bname, w, r)
+		}
+		if _, ok := getURLParam(r, "cors"); ok {
+			return handleDelBucketCors(ctx, bname, w, r)
+		}
 		apiCalls.WithLabelValues("b", "del").Inc()
 		return handleDeleteBucket(ctx, bname, w, r)
+	case http.MethodPost:
+		if _, ok := getURLParam(r, "delete"); ok {
+			apiCalls.WithLabelValues("o", "mdel").Inc()
+			return handleDeleteObjects(ctx, bname, w, r)
+		}
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
 	case http.MethodHead:
 		apiCalls.WithLabelValues("b", "acc").Inc()
 		return handleAccessBucket(ctx, bname, w, r)
@@ -251,11 +311,11 @@ func handleBucket(ctx context.Context, w http.ResponseWriter, r *http.Request) *
 	return nil
 }
 
-func handleUploadFini(ctx context.Context, uploadId string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+func handleUploadFini(ctx context.Context, uploadId, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
 	var complete swys3api.S3MpuFiniParts
 
-	if !ctxAllowed(ctx, S3P_PutObject) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_PutObject); e != nil {
+		return e
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
@@ -282,8 +342,8 @@ func handleUploadFini(ctx context.Context, uploadId string, bucket *s3mgo.Bucket
 }
 
 func handleUploadInit(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_PutObject) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_PutObject); e != nil {
+		return e
 	}
 
 
@@ -292,7 +352,12 @@ func handleUploadInit(ctx context.Context, oname string, bucket *s3mgo.Bucket, w
 		canned_acl = swys3api.S3BucketAclCannedPrivate
 	}
 
-	upload, err := s3UploadInit(ctx, bucket, oname, canned_acl)
+	props := &s3mgo.ObjectProps{ Key: oname, Acl: canned_acl }
+	if e := objPropsFromReq(r, props); e != nil {
+		return e
+	}
+
+	upload, err := s3UploadInit(ctx, bucket, props)
 	if err != nil {
 		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
 	}
@@ -308,8 +373,8 @@ func handleUploadInit(ctx context.Context, oname string, bucket *s3mgo.Bucket, w
 }
 
 func handleUploadListParts(ctx context.Context, uploadId, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_ListMultipartUploadParts) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_ListMultipartUploadParts); e != nil {
+		return e
 	}
 
 	resp, err := s3UploadList(ctx, bucket, oname, uploadId)
@@ -322,8 +387,8 @@ func handleUploadListParts(ctx context.Context, uploadId, oname string, bucket *
 }
 
 func handleUploadPart(ctx context.Context, uploadId, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_PutObject) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_PutObject); e != nil {
+		return e
 	}
 
 	var partno int
@@ -341,7 +406,7 @@ func handleUploadPart(ctx context.Context, uploadId, oname string, bucket *s3mgo
 
 	etag, err := s3UploadPart(ctx, bucket, oname, uploadId, partno, &ChunkReader{size: sz, r: r.Body})
 	if err != nil {
-		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+		return dataS3Error(err)
 	}
 	w.Header().Set("ETag", etag)
 
@@ -350,8 +415,8 @@ func handleUploadPart(ctx context.Context, uploadId, oname string, bucket *s3mgo
 }
 
 func handleUploadAbort(ctx context.Context, uploadId, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_AbortMultipartUpload) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_AbortMultipartUpload); e != nil {
+		return e
 	}
 
 	err := s3UploadAbort(ctx, bucket, oname, uploadId)
@@ -363,11 +428,15 @@ func handleUploadAbort(ctx context.Context, uploadId, oname string, bucket *s3mg
 	return nil
 }
 
-func handleGetObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_GetObject) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+func dataS3Error(err error) *S3Error {
+	if err == errBodyDigest {
+		return &S3Error{ ErrorCode: S3ErrBadDigest, Message: err.Error() }
 	}
 
+	return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+}
+
+func handleGetObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
 	var from, to int64
 	to = math.MaxInt64
 
@@ -380,15 +449,31 @@ func handleGetObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w
 		}
 	}
 
-	object, err := FindCurObject(ctx, bucket, oname)
-	if err != nil {
-		if err == mgo.ErrNotFound {
-			return &S3Error{ ErrorCode: S3ErrNoSuchKey }
+	var object *s3mgo.Object
+	var err error
+
+	if vid, ok := getURLParam(r, "versionId"); ok {
+		var e *S3Error
+
+		object, e = lookupObjectVersion(ctx, bucket, oname, vid, w, r)
+		if e != nil {
+			return e
+		}
+	} else {
+		if e := s3CheckAccess(ctx, r, bucket, oname, S3P_GetObject); e != nil {
+			return e
 		}
 
-		downloadErrors.WithLabelValues("db_obj").Inc()
-		log.Errorf("s3: Can't find object %s on %s: %s", oname, infoLong(bucket), err.Error())
-		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+		object, err = FindCurObject(ctx, bucket, oname)
+		if err != nil {
+			if err == mgo.ErrNotFound {
+				return &S3Error{ ErrorCode: S3ErrNoSuchKey }
+			}
+
+			downloadErrors.WithLabelValues("db_obj").Inc()
+			log.Errorf("s3: Can't find object %s on %s: %s", oname, infoLong(bucket), err.Error())
+			return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+		}
 	}
 
 	if from > object.Size {
@@ -408,10 +493,8 @@ func handleGetObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w
 		return &S3Error{ ErrorCode: S3ErrOperationAborted, Message: "Downloads are limited" }
 	}
 
-	if m := ctx.(*s3Context).mime; m != "" {
-		w.Header().Set("Content-Type", m)
-	}
-
+	objPropsToResp(ctx, w, object)
+	setVersionHeader(w, bucket, object)
 	w.Header().Set("ETag", object.ETag)
 	w.Header().Set("Content-Length", strconv.FormatInt(ds, 10))
 
@@ -421,49 +504,7 @@ func handleGetObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w
 		w.WriteHeader(c)
 	}
 
-	var downloaded int64
-	var rover int64
-
-	err = IterParts(ctx, object.ObjID, func(p *s3mgo.ObjectPart) error {
-		re := rover + p.Size
-		if re  < from {
-			rover = re
-			return nil
-		}
-
-		if rover >= to {
-			rover = re
-			return nil /* FIXME -- report "STOP" marker */
-		}
-
-		return IterChunks(ctx, p, func(ch *s3mgo.DataChunk) error {
-			re := rover + int64(len(ch.Bytes))
-			if re  < from {
-				rover = re
-				return nil
-			}
-			if rover >= to {
-				rover = re
-				return nil /* FIXME -- repot "STOP" marker */
-			}
-
-			s_off := 0
-			if from > rover {
-				s_off = int(from - rover)
-			}
-			e_off := len(ch.Bytes)
-			if to <= re {
-				e_off = int(to - rover)
-			}
-
-			w.Write(ch.Bytes[s_off:e_off])
-			downloaded += int64(e_off - s_off)
-			rover += int64(e_off)
-
-			return nil
-		})
-	})
-
+	downloaded, err := ReadData(ctx, object, from, ds, w)
 	if err != nil {
 		/*
 		 * Too late for download abort. Hope, that caller checks
@@ -505,20 +546,30 @@ func handleCopyObject(ctx context.Context, copy_source, oname string, bucket *s3
 		oname_source = v[1]
 	}
 
-	if !ctxMayAccess(ctx, bname_source) {
-		return &S3Error{ ErrorCode: S3ErrAccessDenied }
-	}
-
 	bucket_source, err = FindBucket(ctx, bname_source)
 	if err != nil {
 		return &S3Error{ ErrorCode: S3ErrInvalidBucketName }
 	}
 
-	object, err = CopyObject(ctx, bucket, oname, canned_acl, bucket_source, oname_source)
+	if e := s3CheckAccess(ctx, r, bucket_source, oname_source, S3P_GetObject); e != nil {
+		return e
+	}
+
+	props := &s3mgo.ObjectProps{ Key: oname, Acl: canned_acl }
+	replMeta := (r.Header.Get("x-amz-metadata-directive") == "REPLACE")
+	replTags := (r.Header.Get("x-amz-tagging-directive") == "REPLACE")
+	if replMeta || replTags {
+		if e := objPropsFromReq(r, props); e != nil {
+			return e
+		}
+	}
+
+	object, err = CopyObject(ctx, bucket, props, bucket_source, oname_source, replMeta, replTags)
 	if err != nil {
 		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
 	}
 
+	setVersionHeader(w, bucket, object)
 	HTTPRespXML(w, &swys3api.CopyObjectResult{
 		ETag:		object.ETag,
 		LastModified:	object.CreationTime,
@@ -527,8 +578,8 @@ func handleCopyObject(ctx context.Context, copy_source, oname string, bucket *s3
 }
 
 func handlePutObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_PutObject) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_PutObject); e != nil {
+		return e
 	}
 
 	copy_source := r.Header.Get("X-Amz-Copy-Source")
@@ -551,48 +602,77 @@ func handlePutObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w
 		return &S3Error{ ErrorCode: S3ErrMissingContentLength, Message: "content-length header missing" }
 	}
 
+	props := &s3mgo.ObjectProps{ Key: oname, Acl: canned_acl }
+	if e := objPropsFromReq(r, props); e != nil {
+		return e
+	}
+
 	cr := &ChunkReader{size: sz, r: r.Body}
 
-	o, err := AddObject(ctx, bucket, oname, canned_acl, cr)
+	o, err := AddObject(ctx, bucket, props, cr)
 	if err != nil {
-		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+		return dataS3Error(err)
 	}
 
 	if cr.read != sz {
 		log.Debugf("Saved %d, want %d bytes", cr.read, sz)
-		s3DeleteObject(ctx, bucket, oname)
+		DropObject(ctx, bucket, o)
 		return &S3Error{ ErrorCode: S3ErrIncompleteBody, Message: "trimmed body" }
 	}
 
+	setVersionHeader(w, bucket, o)
 	w.Header().Set("ETag", o.ETag)
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
 
 func handleDeleteObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_DeleteObject) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_DeleteObject); e != nil {
+		return e
 	}
 
-	err := s3DeleteObject(ctx, bucket, oname)
+	marker, err := s3DeleteObject(ctx, bucket, oname)
 	if err != nil {
 		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
 	}
 
+	if marker != nil {
+		setVersionHeader(w, bucket, marker)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
 
-func handleAccessObject(ctx context.Context, bname, oname string, w http.ResponseWriter, r *http.Request) *S3Error {
-	if !ctxAllowed(ctx, S3P_GetObject) {
-		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
-	}
+func handleAccessObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+	var object *s3mgo.Object
+	var err error
 
-	err := s3CheckAccess(ctx, bname, oname)
-	if err != nil {
-		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+	if vid, ok := getURLParam(r, "versionId"); ok {
+		var e *S3Error
+
+		object, e = lookupObjectVersion(ctx, bucket, oname, vid, w, r)
+		if e != nil {
+			return e
+		}
+	} else {
+		if e := s3CheckAccess(ctx, r, bucket, oname, S3P_GetObject); e != nil {
+			return e
+		}
+
+		object, err = FindCurObject(ctx, bucket, oname)
+		if err != nil {
+			if err == mgo.ErrNotFound {
+				return &S3Error{ ErrorCode: S3ErrNoSuchKey }
+			}
+			return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+		}
 	}
 
+	objPropsToResp(ctx, w, object)
+	setVersionHeader(w, bucket, object)
+	w.Header().Set("ETag", object.ETag)
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
@@ -617,20 +697,16 @@ func handleObject(ctx context.Context, w http.ResponseWriter, r *http.Request, b
 		return &S3Error{ ErrorCode: S3ErrSwyInvalidObjectName }
 	}
 
-	if !ctxMayAccess(ctx, bname) {
-		goto e_access
-	}
-
 	bucket, err = FindBucket(ctx, bname)
 	if err != nil {
-		return &S3Error{ ErrorCode: S3ErrInvalidBucketName }
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
 	}
 
 	switch r.Method {
 	case http.MethodPost:
 		if uploadId, ok := getURLParam(r, "uploadId"); ok {
 			apiCalls.WithLabelValues("u", "fin").Inc()
-			return handleUploadFini(ctx, uploadId, bucket, w, r)
+			return handleUploadFini(ctx, uploadId, oname, bucket, w, r)
 		} else if _, ok := getURLParam(r, "uploads"); ok {
 			apiCalls.WithLabelValues("u", "ini").Inc()
 			return handleUploadInit(ctx, oname, bucket, w, r)
@@ -641,6 +717,12 @@ func handleObject(ctx context.Context, w http.ResponseWriter, r *http.Request, b
 			apiCalls.WithLabelValues("u", "lp").Inc()
 			return handleUploadListParts(ctx, uploadId, oname, bucket, w, r)
 		}
+		if _, ok := getURLParam(r, "tagging"); ok {
+			return handleGetObjectTagging(ctx, oname, bucket, w, r)
+		}
+		if _, ok := getURLParam(r, "acl"); ok {
+			return handleGetObjectAcl(ctx, oname, bucket, w, r)
+		}
 		apiCalls.WithLabelValues("o", "get").Inc()
 		return handleGetObject(ctx, oname, bucket, w, r)
 	case http.MethodPut:
@@ -648,6 +730,12 @@ func handleObject(ctx context.Context, w http.ResponseWriter, r *http.Request, b
 			apiCalls.WithLabelValues("u", "put").Inc()
 			return handleUploadPart(ctx, uploadId, oname, bucket, w, r)
 		}
+		if _, ok := getURLParam(r, "tagging"); ok {
+			return handlePutObjectTagging(ctx, oname, bucket, w, r)
+		}
+		if _, ok := getURLParam(r, "acl"); ok {
+			return handlePutObjectAcl(ctx, oname, bucket, w, r)
+		}
 		apiCalls.WithLabelValues("o", "put").Inc()
 		return handlePutObject(ctx, oname, bucket, w, r)
 	case http.MethodDelete:
@@ -655,19 +743,23 @@ func handleObject(ctx context.Context, w http.ResponseWriter, r *http.Request, b
 			apiCalls.WithLabelValues("u", "del").Inc()
 			return handleUploadAbort(ctx, uploadId, oname, bucket, w, r)
 		}
+		if _, ok := getURLParam(r, "tagging"); ok {
+			return handleDelObjectTagging(ctx, oname, bucket, w, r)
+		}
+		if vid, ok := getURLParam(r, "versionId"); ok {
+			apiCalls.WithLabelValues("v", "del").Inc()
+			return handleDeleteObjectVersion(ctx, vid, oname, bucket, w, r)
+		}
 		apiCalls.WithLabelValues("o", "del").Inc()
 		return handleDeleteObject(ctx, oname, bucket, w, r)
 	case http.MethodHead:
 		apiCalls.WithLabelValues("o", "acc").Inc()
-		return handleAccessObject(ctx, bname, oname, w, r)
+		return handleAccessObject(ctx, oname, bucket, w, r)
 	default:
 		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
 	}
 
 	w.WriteHeader(http.StatusOK)
 	return nil
-
-e_access:
-	return &S3Error{ ErrorCode: S3ErrAccessDenied }
 }
 
@@ -12,6 +12,7 @@ import (
 	"errors"
 	"context"
 	"crypto/rand"
+	"sync"
 	"fmt"
 
 	"swifty/common"
@@ -157,6 +158,49 @@ func FindBuckets(ctx context.Context) ([]s3mgo.Bucket, error) {
 	return res, nil
 }
 
+/*
+ * Presigned URL carries its own expiration, so the key behind it only
+ * has to outlive the URL. Keep one key per namespace/bucket and mint a
+ * new one (with some spare lifetime) only when the cached one would
+ * expire before the URL does.
+ */
+const S3PresignKeySpare = 3600
+
+var presignKeys = make(map[string]*s3mgo.AccessKey)
+var presignLock sync.Mutex
+
+func getPresignKey(ctx context.Context, namespace, bname string, expires uint32) (*s3mgo.AccessKey, error) {
+	id := namespace + "/" + bname
+	now := current_timestamp()
+
+	presignLock.Lock()
+	defer presignLock.Unlock()
+
+	if akey, ok := presignKeys[id]; ok {
+		if akey.ExpirationTimestamp >= now + int64(expires) {
+			/* Might have been removed behind our back */
+			if _, err := dbLookupAccessKey(ctx, akey.AccessKeyID); err == nil {
+				return akey, nil
+			}
+		}
+		delete(presignKeys, id)
+	}
+
+	akey, err := genNewAccessKey(ctx, namespace, bname, expires + S3PresignKeySpare)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, ak := range presignKeys {
+		if ak.ExpirationTimestamp < now {
+			delete(presignKeys, k)
+		}
+	}
+	presignKeys[id] = akey
+
+	return akey, nil
+}
+
 func s3DecryptAccessKeySecret(akey *s3mgo.AccessKey) string {
 	sec, err := xh.DecryptString(s3SecKey, akey.AccessKeySecret)
 	if err != nil {
@@ -0,0 +1,596 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"swifty/apis/s3"
+	"swifty/s3/mgo"
+	"swifty/common/xrest/sysctl"
+)
+
+/*
+ * Lifecycle rules are kept on the bucket and are applied by the
+ * executor that periodically walks the buckets having any. Objects
+ * are removed the same way clients do it, so the versioning state
+ * and the accounting are respected.
+ */
+
+const (
+	S3LifecycleRulesMax	= 1000
+	S3LifecycleIDMax	= 255
+	S3LifecycleKeysBatch	= 256
+)
+
+var lcPeriod time.Duration = time.Hour
+
+func init() {
+	sysctl.AddTimeSysctl("lifecycle_period", &lcPeriod)
+}
+
+func lcTags(tags []swys3api.S3Tag) []s3mgo.Tag {
+	var ret []s3mgo.Tag
+
+	for _, t := range tags {
+		ret = append(ret, s3mgo.Tag{ Key: t.Key, Value: t.Value })
+	}
+
+	return ret
+}
+
+func lcRuleFromXML(xr *swys3api.S3LifecycleRule) (*s3mgo.LifecycleRule, error) {
+	rule := &s3mgo.LifecycleRule{ ID: xr.ID }
+
+	if len(xr.ID) > S3LifecycleIDMax {
+		return nil, errors.New("Rule ID is too long")
+	}
+
+	switch xr.Status {
+	case "Enabled":
+		rule.Enabled = true
+	case "Disabled":
+		;
+	default:
+		return nil, errors.New("Bad rule status")
+	}
+
+	if f := xr.Filter; f != nil {
+		if xr.Prefix != nil {
+			return nil, errors.New("Both Prefix and Filter are given")
+		}
+
+		rule.Prefix = f.Prefix
+		if f.Tag != nil {
+			rule.Tags = lcTags([]swys3api.S3Tag{*f.Tag})
+		}
+		if f.And != nil {
+			if f.Prefix != "" || f.Tag != nil {
+				return nil, errors.New("Filter And is mixed with other conditions")
+			}
+			rule.Prefix = f.And.Prefix
+			rule.Tags = lcTags(f.And.Tag)
+		}
+	} else if xr.Prefix != nil {
+		rule.Prefix = *xr.Prefix
+	}
+
+	if validateTags(rule.Tags, S3ObjectTagsMax) != nil {
+		return nil, errors.New("Bad filter tags")
+	}
+
+	if e := xr.Expiration; e != nil {
+		n := 0
+		if e.Days != 0 { n++ }
+		if e.Date != "" { n++ }
+		if e.ExpiredObjectDeleteMarker { n++ }
+		if n != 1 {
+			return nil, errors.New("Expiration needs exactly one of Days, Date or ExpiredObjectDeleteMarker")
+		}
+
+		if e.Days < 0 {
+			return nil, errors.New("Bad expiration days")
+		}
+		if e.Date != "" {
+			if _, err := time.Parse(time.RFC3339, e.Date); err != nil {
+				return nil, errors.New("Bad expiration date")
+			}
+		}
+		if e.ExpiredObjectDeleteMarker && len(rule.Tags) > 0 {
+			return nil, errors.New("ExpiredObjectDeleteMarker cannot be used with tags")
+		}
+
+		rule.ExpDays = e.Days
+		rule.ExpDate = e.Date
+		rule.ExpMarkers = e.ExpiredObjectDeleteMarker
+	}
+
+	if ne := xr.NoncurrentExpiration; ne != nil {
+		if ne.NoncurrentDays <= 0 {
+			return nil, errors.New("Bad noncurrent days")
+		}
+		rule.NoncurDays = ne.NoncurrentDays
+	}
+
+	if am := xr.AbortIncompleteMpu; am != nil {
+		if am.DaysAfterInitiation <= 0 {
+			return nil, errors.New("Bad days after initiation")
+		}
+		if len(rule.Tags) > 0 {
+			return nil, errors.New("AbortIncompleteMultipartUpload cannot be used with tags")
+		}
+		rule.AbortMpuDays = am.DaysAfterInitiation
+	}
+
+	if xr.Expiration == nil && xr.NoncurrentExpiration == nil && xr.AbortIncompleteMpu == nil {
+		return nil, errors.New("Rule has no actions")
+	}
+
+	return rule, nil
+}
+
+func lcRuleToXML(rule *s3mgo.LifecycleRule) swys3api.S3LifecycleRule {
+	xr := swys3api.S3LifecycleRule{ ID: rule.ID, Status: "Disabled" }
+
+	if rule.Enabled {
+		xr.Status = "Enabled"
+	}
+
+	xr.Filter = &swys3api.S3LifecycleFilter{}
+	if len(rule.Tags) == 0 {
+		xr.Filter.Prefix = rule.Prefix
+	} else {
+		and := &swys3api.S3LifecycleAnd{ Prefix: rule.Prefix }
+		for _, t := range rule.Tags {
+			and.Tag = append(and.Tag, swys3api.S3Tag{ Key: t.Key, Value: t.Value })
+		}
+		xr.Filter.And = and
+	}
+
+	if rule.ExpDays != 0 || rule.ExpDate != "" || rule.ExpMarkers {
+		xr.Expiration = &swys3api.S3LifecycleExpiration {
+			Days:				rule.ExpDays,
+			Date:				rule.ExpDate,
+			ExpiredObjectDeleteMarker:	rule.ExpMarkers,
+		}
+	}
+
+	if rule.NoncurDays != 0 {
+		xr.NoncurrentExpiration = &swys3api.S3NoncurrentExpiration{ NoncurrentDays: rule.NoncurDays }
+	}
+
+	if rule.AbortMpuDays != 0 {
+		xr.AbortIncompleteMpu = &swys3api.S3AbortIncompleteMpu{ DaysAfterInitiation: rule.AbortMpuDays }
+	}
+
+	return xr
+}
+
+func s3SetLifecycle(ctx context.Context, bucket *s3mgo.Bucket, rules []s3mgo.LifecycleRule) error {
+	var update bson.M
+
+	if len(rules) > 0 {
+		update = bson.M{ "$set": bson.M{ "lifecycle": rules } }
+	} else {
+		update = bson.M{ "$unset": bson.M{ "lifecycle": "" } }
+	}
+
+	return dbS3Update(ctx, bson.M{ "state": S3StateActive }, update, true, bucket)
+}
+
+func handleGetLifecycle(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_GetLifecycleConfiguration) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if len(b.Lifecycle) == 0 {
+		return &S3Error{ ErrorCode: S3ErrNoSuchLifecycleConfiguration }
+	}
+
+	var resp swys3api.S3LifecycleConfig
+	for i := range b.Lifecycle {
+		resp.Rule = append(resp.Rule, lcRuleToXML(&b.Lifecycle[i]))
+	}
+
+	HTTPRespXML(w, &resp)
+	return nil
+}
+
+func handlePutLifecycle(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_PutLifecycleConfiguration) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrIncompleteBody }
+	}
+
+	var cfg swys3api.S3LifecycleConfig
+
+	err = xml.Unmarshal(body, &cfg)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrMalformedXML }
+	}
+
+	if len(cfg.Rule) == 0 || len(cfg.Rule) > S3LifecycleRulesMax {
+		return &S3Error{ ErrorCode: S3ErrMalformedXML, Message: "Bad number of rules" }
+	}
+
+	var rules []s3mgo.LifecycleRule
+	ids := make(map[string]bool)
+
+	for i := range cfg.Rule {
+		rule, err := lcRuleFromXML(&cfg.Rule[i])
+		if err != nil {
+			return &S3Error{ ErrorCode: S3ErrInvalidArgument, Message: err.Error() }
+		}
+
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				return &S3Error{ ErrorCode: S3ErrInvalidArgument, Message: "Duplicate rule ID " + rule.ID }
+			}
+			ids[rule.ID] = true
+		}
+
+		rules = append(rules, *rule)
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	err = s3SetLifecycle(ctx, b, rules)
+	if err != nil {
+		log.Errorf("s3: Can't set lifecycle on %s: %s", infoLong(b), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleDelLifecycle(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_PutLifecycleConfiguration) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	err = s3SetLifecycle(ctx, b, nil)
+	if err != nil {
+		log.Errorf("s3: Can't drop lifecycle on %s: %s", infoLong(b), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func lcAged(created string, days int, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		return false
+	}
+
+	return t.Add(time.Duration(days) * 24 * time.Hour).Before(now)
+}
+
+func lcTagsMatch(rule *s3mgo.LifecycleRule, o *s3mgo.Object) bool {
+	for _, rt := range rule.Tags {
+		found := false
+		for _, t := range o.TagSet {
+			if t.Key == rt.Key && t.Value == rt.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func lcExpired(rule *s3mgo.LifecycleRule, o *s3mgo.Object, now time.Time) bool {
+	if rule.ExpDays != 0 {
+		return lcAged(o.CreationTime, rule.ExpDays, now)
+	}
+
+	if rule.ExpDate != "" {
+		t, err := time.Parse(time.RFC3339, rule.ExpDate)
+		return err == nil && t.Before(now)
+	}
+
+	return false
+}
+
+func lcNotify(b *s3mgo.Bucket, ns string, o *s3mgo.Object) {
+	if ns != "" && b.BasicNotify != nil && b.BasicNotify.Delete > 0 {
+		s3NotifyNs(ns, b, o, "delete")
+	}
+}
+
+func lcExpireObject(ctx context.Context, b *s3mgo.Bucket, ns string, o *s3mgo.Object) error {
+	if b.Versioning == "" {
+		err := DropObject(ctx, b, o)
+		if err == nil {
+			lcNotify(b, ns, o)
+		}
+		return err
+	}
+
+	m, err := s3AddDeleteMarker(ctx, b, o.Key)
+	if err == nil {
+		lcNotify(b, ns, m)
+	}
+	return err
+}
+
+func lcAbortUploads(ctx context.Context, b *s3mgo.Bucket, rule *s3mgo.LifecycleRule, now time.Time) {
+	var uploads []S3Upload
+
+	query := bson.M{ "bucket-id": b.ObjID, "state": S3StateActive }
+	if rule.Prefix != "" {
+		query["key"] = bson.M{ "$regex": "^" + regexp.QuoteMeta(rule.Prefix) }
+	}
+
+	err := dbS3FindAll(ctx, query, &uploads)
+	if err != nil {
+		return
+	}
+
+	for i := range uploads {
+		u := &uploads[i]
+
+		if !lcAged(u.CreationTime, rule.AbortMpuDays, now) {
+			continue
+		}
+
+		if u.dbLock(ctx) != nil {
+			continue
+		}
+
+		err = s3UploadRemoveLocked(ctx, b, u, true)
+		if err != nil {
+			log.Errorf("s3: Can't abort %s by lifecycle: %s", infoLong(u), err.Error())
+			u.dbUnlock(ctx)
+			continue
+		}
+
+		lcActions.WithLabelValues("abort").Inc()
+	}
+}
+
+/*
+ * The versions of the key come newest first. The current one expires
+ * by age or date, the noncurrent ones by the time passed since a newer
+ * version replaced them, and a lone delete marker is just dropped.
+ */
+func lcKeyActions(rule *s3mgo.LifecycleRule, vers []s3mgo.Object, now time.Time,
+		expire, drop *[]*s3mgo.Object) {
+	top := &vers[0]
+
+	if top.DelMarker {
+		if rule.ExpMarkers && len(vers) == 1 {
+			*drop = append(*drop, top)
+		}
+	} else if lcTagsMatch(rule, top) && lcExpired(rule, top, now) {
+		*expire = append(*expire, top)
+	}
+
+	if rule.NoncurDays == 0 {
+		return
+	}
+
+	for i := 1; i < len(vers); i++ {
+		if !lcAged(vers[i-1].CreationTime, rule.NoncurDays, now) {
+			continue
+		}
+		if !vers[i].DelMarker && !lcTagsMatch(rule, &vers[i]) {
+			continue
+		}
+
+		*drop = append(*drop, &vers[i])
+	}
+}
+
+/*
+ * Keys are walked in batches in key order, each batch is acted upon
+ * before the next one is read. The expiration puts delete markers on
+ * the keys already passed, so they are not met again.
+ */
+func lcNextKeys(ctx context.Context, b *s3mgo.Bucket, prefix, after string) ([]string, error) {
+	var res []struct { Key string `bson:"_id"` }
+	var keys []string
+
+	kq := bson.M{ "$gt": after }
+	if prefix != "" {
+		kq["$regex"] = "^" + regexp.QuoteMeta(prefix)
+	}
+
+	query := bson.M{ "bucket-id": b.ObjID, "state": S3StateActive, "key": kq }
+	err := dbS3Pipe(ctx, &[]s3mgo.Object{}, []bson.M{
+			{"$match": query},
+			{"$group": bson.M{"_id": "$key"}},
+			{"$sort": bson.M{"_id": 1}},
+			{"$limit": S3LifecycleKeysBatch},
+		}).All(&res)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range res {
+		keys = append(keys, r.Key)
+	}
+
+	return keys, nil
+}
+
+func lcApplyBatch(ctx context.Context, b *s3mgo.Bucket, ns string, rule *s3mgo.LifecycleRule,
+		keys []string, now time.Time) error {
+	var expire, drop []*s3mgo.Object
+	var objects []s3mgo.Object
+
+	query := bson.M{ "bucket-id": b.ObjID, "state": S3StateActive, "key": bson.M{ "$in": keys } }
+	err := dbS3Pipe(ctx, &objects, []bson.M{{"$match": query}, {"$sort": bson.M{"key": 1, "rover": -1}}}).All(&objects)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(objects); {
+		j := i + 1
+		for j < len(objects) && objects[j].Key == objects[i].Key {
+			j++
+		}
+		lcKeyActions(rule, objects[i:j], now, &expire, &drop)
+		i = j
+	}
+
+	for _, o := range expire {
+		err := lcExpireObject(ctx, b, ns, o)
+		if err != nil {
+			log.Errorf("s3: Can't expire %s by lifecycle: %s", infoLong(o), err.Error())
+			continue
+		}
+		lcActions.WithLabelValues("expire").Inc()
+	}
+
+	for _, o := range drop {
+		err := DropObject(ctx, b, o)
+		if err != nil {
+			log.Errorf("s3: Can't drop %s by lifecycle: %s", infoLong(o), err.Error())
+			continue
+		}
+		if !o.DelMarker {
+			lcNotify(b, ns, o)
+		}
+		lcActions.WithLabelValues("drop").Inc()
+	}
+
+	return nil
+}
+
+func lcApplyRule(ctx context.Context, b *s3mgo.Bucket, ns string, rule *s3mgo.LifecycleRule, now time.Time) {
+	var last string
+
+	if rule.AbortMpuDays != 0 {
+		lcAbortUploads(ctx, b, rule, now)
+	}
+
+	if rule.ExpDays == 0 && rule.ExpDate == "" && !rule.ExpMarkers && rule.NoncurDays == 0 {
+		return
+	}
+
+	for {
+		keys, err := lcNextKeys(ctx, b, rule.Prefix, last)
+		if err != nil {
+			log.Errorf("s3: Can't list keys of %s for lifecycle: %s", infoLong(b), err.Error())
+			return
+		}
+		if len(keys) == 0 {
+			return
+		}
+
+		err = lcApplyBatch(ctx, b, ns, rule, keys, now)
+		if err != nil {
+			log.Errorf("s3: Can't apply lifecycle to %s: %s", infoLong(b), err.Error())
+			return
+		}
+
+		last = keys[len(keys) - 1]
+	}
+}
+
+/*
+ * Buckets only keep the hash of the namespace, and notifications
+ * need the namespace itself, so map the hashes back via accounts
+ */
+func lcNamespaces(ctx context.Context) map[string]string {
+	var accounts []s3mgo.Account
+
+	ret := make(map[string]string)
+	err := dbS3FindAll(ctx, bson.M{ "state": S3StateActive }, &accounts)
+	if err != nil {
+		log.Errorf("s3: Can't list accounts for lifecycle: %s", err.Error())
+		return ret
+	}
+
+	for i := range accounts {
+		ret[accounts[i].NamespaceID()] = accounts[i].Namespace
+	}
+
+	return ret
+}
+
+func lcRun(ctx context.Context) {
+	var buckets []s3mgo.Bucket
+	var nss map[string]string
+
+	query := bson.M{ "state": S3StateActive, "lifecycle": bson.M{ "$exists": true } }
+	err := dbS3FindAll(ctx, query, &buckets)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for i := range buckets {
+		var ns string
+
+		b := &buckets[i]
+		if b.BasicNotify != nil {
+			if nss == nil {
+				nss = lcNamespaces(ctx)
+			}
+			ns = nss[b.NamespaceID]
+		}
+
+		for j := range b.Lifecycle {
+			if b.Lifecycle[j].Enabled {
+				lcApplyRule(ctx, b, ns, &b.Lifecycle[j], now)
+			}
+		}
+	}
+}
+
+func lcInit() error {
+	go func() {
+		for {
+			ctx, done := mkContext("LC")
+			lcRun(ctx)
+			done(ctx)
+			time.Sleep(lcPeriod)
+		}
+	}()
+
+	return nil
+}
@@ -38,6 +38,11 @@ type YAMLConfCeph struct {
 	ConfigPath	string			`yaml:"config-path"`
 }
 
+type YAMLConfStorage struct {
+	Driver		string			`yaml:"driver,omitempty"`
+	FsPath		string			`yaml:"fs-path,omitempty"`
+}
+
 type YAMLConfDaemon struct {
 	Addr		string			`yaml:"address"`
 	AdminPort	string			`yaml:"admport"`
@@ -56,6 +61,7 @@ type YAMLConf struct {
 	DB		string			`yaml:"db"`
 	Daemon		YAMLConfDaemon		`yaml:"daemon"`
 	Ceph		YAMLConfCeph		`yaml:"ceph"`
+	Storage		YAMLConfStorage		`yaml:"storage"`
 	SecKey		string			`yaml:"secretskey"`
 	Notify		YAMLConfNotify		`yaml:"notify"`
 	Mimes		string			`yaml:"mime-types"`
@@ -241,14 +247,32 @@ func handleS3API(cb func(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 		logRequest(r)
 
-		if xhttp.HandleCORS(w, r, CORS_Methods, CORS_Headers) { return }
+		/*
+		 * Buckets without own CORS rules (and the requests we cannot
+		 * tell the bucket for) get the service-wide fixed header set
+		 */
+		bname := mux.Vars(r)["BucketName"]
+		if r.Method == http.MethodOptions {
+			if !corsPreflightAuthorize(ctx, r) {
+				log.Debugf("s3: Preflight for %s without presigned key, bucket rules not applied", bname)
+				xhttp.HandleCORS(w, r, CORS_Methods, CORS_Headers)
+			} else if !s3CorsApply(ctx, w, r, bname) {
+				xhttp.HandleCORS(w, r, CORS_Methods, CORS_Headers)
+			}
+			return
+		}
 
 		code, err := s3Authorize(ctx, r)
 		if err != nil {
+			xhttp.SetCORS(w, CORS_Methods, CORS_Headers)
 			HTTPRespError(w, code, err.Error())
 			return
 		}
 
+		if !s3CorsApply(ctx, w, r, bname) {
+			xhttp.SetCORS(w, CORS_Methods, CORS_Headers)
+		}
+
 		if e := cb(ctx, w, r); e != nil {
 			HTTPRespS3Error(w, e)
 		}
@@ -282,6 +306,7 @@ func makeAdminURL(clienturl, admport string) string {
 
 func main() {
 	var config_path string
+	var migrate string
 	var showVersion bool
 	var err error
 
@@ -294,6 +319,10 @@ func main() {
 			"no-rados",
 				false,
 				"disable rados")
+	flag.StringVar(&migrate,
+			"migrate-data",
+				"",
+				"move object data between drivers (from:to) and exit")
 	flag.BoolVar(&showVersion,
 			"version",
 				false,
@@ -364,6 +393,7 @@ func main() {
 		} else {
 			ret += "yes"
 		}
+		ret += ", data:" + dataDriverName
 
 		return ret
 	})
@@ -381,7 +411,7 @@ func main() {
 
 	// Web server operations
 	rwebsrv := mux.NewRouter()
-	rwebsrv.Methods("GET", "HEAD").HandlerFunc(handleWebReq)
+	rwebsrv.Methods("GET", "HEAD", "OPTIONS").HandlerFunc(handleWebReq)
 	if conf.Daemon.WebPort == "" {
 		conf.Daemon.WebPort = "8080"
 	}
@@ -389,6 +419,7 @@ func main() {
 	// Admin operations
 	radminsrv := mux.NewRouter()
 	radminsrv.Handle("/v1/api/keys",		handleAdmin(handleKeys)).Methods("POST", "DELETE")
+	radminsrv.Handle("/v1/api/presign",		handleAdmin(handlePresign)).Methods("POST")
 	radminsrv.Handle("/v1/api/notify",		handleAdmin(handleNotify)).Methods("POST", "DELETE")
 	radminsrv.Handle("/v1/api/stats/{ns}",		handleAdmin(handleStats)).Methods("GET")
 	radminsrv.Handle("/v1/api/stats/{ns}/limits",	handleAdmin(handleLimits)).Methods("PUT")
@@ -409,6 +440,28 @@ func main() {
 				err.Error())
 	}
 
+	err = dataInit(&conf.Storage)
+	if err != nil {
+		log.Fatalf("Can't setup data driver: %s", err.Error())
+	}
+
+	if migrate != "" {
+		drvs := strings.SplitN(migrate, ":", 2)
+		if len(drvs) != 2 {
+			log.Fatalf("Bad migration spec %s, want from:to", migrate)
+		}
+
+		err = dataMigrate(ctx, drvs[0], drvs[1])
+		if err != nil {
+			log.Fatalf("Data migration failed: %s", err.Error())
+		}
+
+		done(ctx)
+		radosFini()
+		dbDisconnect()
+		return
+	}
+
 	err = notifyInit(&conf.Notify)
 	if err != nil {
 		log.Fatalf("Can't setup notifications: %s", err.Error())
@@ -424,6 +477,11 @@ func main() {
 		log.Fatalf("Can't setup garbage collector: %s", err.Error())
 	}
 
+	err = lcInit()
+	if err != nil {
+		log.Fatalf("Can't setup lifecycle executor: %s", err.Error())
+	}
+
 	err = PrometheusInit(&conf)
 	if err != nil {
 		log.Fatalf("Can't setup prometheus: %s", err.Error())
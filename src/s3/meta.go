@@ -0,0 +1,389 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"swifty/apis/s3"
+	"swifty/s3/mgo"
+)
+
+const (
+	S3MetaPrefix		= "X-Amz-Meta-"
+	S3MetaMaxSize		= 2048
+	S3ObjectTagsMax		= 10
+	S3BucketTagsMax		= 50
+	S3TagKeyMax		= 128
+	S3TagValueMax		= 256
+)
+
+/*
+ * User metadata and the system headers are kept as given at put
+ * time and are reported back as is on GET and HEAD.
+ */
+func objPropsFromReq(r *http.Request, props *s3mgo.ObjectProps) *S3Error {
+	var size int
+
+	for k, v := range r.Header {
+		if !strings.HasPrefix(k, S3MetaPrefix) || len(v) == 0 {
+			continue
+		}
+
+		name := strings.ToLower(k[len(S3MetaPrefix):])
+		val := strings.Join(v, ",")
+		size += len(name) + len(val)
+		props.Meta = append(props.Meta, s3mgo.Tag{ Key: name, Value: val })
+	}
+
+	if size > S3MetaMaxSize {
+		return &S3Error{ ErrorCode: S3ErrMetadataTooLarge }
+	}
+
+	h := s3mgo.ObjectHeaders {
+		ContentType:		r.Header.Get("Content-Type"),
+		CacheControl:		r.Header.Get("Cache-Control"),
+		ContentDisposition:	r.Header.Get("Content-Disposition"),
+		ContentEncoding:	r.Header.Get("Content-Encoding"),
+		ContentLanguage:	r.Header.Get("Content-Language"),
+		Expires:		r.Header.Get("Expires"),
+	}
+
+	if h != (s3mgo.ObjectHeaders{}) {
+		props.Headers = &h
+	}
+
+	if t := r.Header.Get("x-amz-tagging"); t != "" {
+		tags, err := parseTagsHeader(t)
+		if err != nil {
+			return err
+		}
+
+		props.TagSet = tags
+	}
+
+	return nil
+}
+
+func parseTagsHeader(val string) ([]s3mgo.Tag, *S3Error) {
+	var tags []s3mgo.Tag
+
+	q, err := url.ParseQuery(val)
+	if err != nil {
+		return nil, &S3Error{ ErrorCode: S3ErrInvalidTag, Message: "Bad x-amz-tagging value" }
+	}
+
+	for k, v := range q {
+		if len(v) != 1 {
+			return nil, &S3Error{ ErrorCode: S3ErrInvalidTag, Message: "Duplicate tag " + k }
+		}
+		tags = append(tags, s3mgo.Tag{ Key: k, Value: v[0] })
+	}
+
+	return tags, validateTags(tags, S3ObjectTagsMax)
+}
+
+func validateTags(tags []s3mgo.Tag, max int) *S3Error {
+	if len(tags) > max {
+		return &S3Error{ ErrorCode: S3ErrInvalidTag, Message: "Too many tags" }
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		if t.Key == "" || len(t.Key) > S3TagKeyMax || len(t.Value) > S3TagValueMax {
+			return &S3Error{ ErrorCode: S3ErrInvalidTag, Message: "Bad tag " + t.Key }
+		}
+		if seen[t.Key] {
+			return &S3Error{ ErrorCode: S3ErrInvalidTag, Message: "Duplicate tag " + t.Key }
+		}
+		seen[t.Key] = true
+	}
+
+	return nil
+}
+
+func isGenericMime(ct string) bool {
+	return ct == "" || ct == "binary/octet-stream" || ct == "application/octet-stream"
+}
+
+/*
+ * Website mode guesses the type by the file extension, but the one set
+ * by the uploader wins unless it's the generic default SDKs put.
+ */
+func objContentType(ctx context.Context, o *s3mgo.Object) string {
+	var ct string
+
+	if o.Headers != nil {
+		ct = o.Headers.ContentType
+	}
+
+	if m := ctx.(*s3Context).mime; m != "" && isGenericMime(ct) {
+		ct = m
+	}
+
+	return ct
+}
+
+func objPropsToResp(ctx context.Context, w http.ResponseWriter, o *s3mgo.Object) {
+	hdr := w.Header()
+
+	if ct := objContentType(ctx, o); ct != "" {
+		hdr.Set("Content-Type", ct)
+	}
+
+	if h := o.Headers; h != nil {
+		if h.CacheControl != "" {
+			hdr.Set("Cache-Control", h.CacheControl)
+		}
+		if h.ContentDisposition != "" {
+			hdr.Set("Content-Disposition", h.ContentDisposition)
+		}
+		if h.ContentEncoding != "" {
+			hdr.Set("Content-Encoding", h.ContentEncoding)
+		}
+		if h.ContentLanguage != "" {
+			hdr.Set("Content-Language", h.ContentLanguage)
+		}
+		if h.Expires != "" {
+			hdr.Set("Expires", h.Expires)
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, o.CreationTime); err == nil {
+		hdr.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+
+	for _, m := range o.Meta {
+		hdr.Set(S3MetaPrefix + m.Key, m.Value)
+	}
+
+	if len(o.TagSet) > 0 {
+		hdr.Set("x-amz-tagging-count", strconv.Itoa(len(o.TagSet)))
+	}
+}
+
+func tagsToXML(tags []s3mgo.Tag) *swys3api.S3Tagging {
+	var res swys3api.S3Tagging
+
+	res.TagSet.Tag = []swys3api.S3Tag{}
+	for _, t := range tags {
+		res.TagSet.Tag = append(res.TagSet.Tag, swys3api.S3Tag{ Key: t.Key, Value: t.Value })
+	}
+
+	return &res
+}
+
+func tagsFromReq(r *http.Request, max int) ([]s3mgo.Tag, *S3Error) {
+	var cfg swys3api.S3Tagging
+	var tags []s3mgo.Tag
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, &S3Error{ ErrorCode: S3ErrIncompleteBody }
+	}
+
+	err = xml.Unmarshal(body, &cfg)
+	if err != nil {
+		return nil, &S3Error{ ErrorCode: S3ErrMalformedXML }
+	}
+
+	for _, t := range cfg.TagSet.Tag {
+		tags = append(tags, s3mgo.Tag{ Key: t.Key, Value: t.Value })
+	}
+
+	return tags, validateTags(tags, max)
+}
+
+func s3SetTags(ctx context.Context, o interface{}, tags []s3mgo.Tag) error {
+	var update bson.M
+
+	if len(tags) > 0 {
+		update = bson.M{ "$set": bson.M{ "tags": tags } }
+	} else {
+		update = bson.M{ "$unset": bson.M{ "tags": "" } }
+	}
+
+	return dbS3Update(ctx, bson.M{ "state": S3StateActive }, update, true, o)
+}
+
+func findReqObject(ctx context.Context, oname string, bucket *s3mgo.Bucket, r *http.Request, verperm int) (*s3mgo.Object, *S3Error) {
+	var object *s3mgo.Object
+	var err error
+
+	if vid, ok := getURLParam(r, "versionId"); ok {
+		object, err = FindObjectVersion(ctx, bucket, oname, vid)
+		if err == nil || err == mgo.ErrNotFound {
+			var version *s3mgo.Object
+			if err == nil {
+				version = object
+			}
+			if e := s3CheckVersionAccess(ctx, r, bucket, oname, version, verperm); e != nil {
+				return nil, e
+			}
+		}
+		if err == nil && object.DelMarker {
+			return nil, &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+		}
+		if err == mgo.ErrNotFound {
+			return nil, &S3Error{ ErrorCode: S3ErrNoSuchVersion }
+		}
+	} else {
+		object, err = FindCurObject(ctx, bucket, oname)
+		if err == mgo.ErrNotFound {
+			return nil, &S3Error{ ErrorCode: S3ErrNoSuchKey }
+		}
+	}
+
+	if err != nil {
+		log.Errorf("s3: Can't find object %s on %s: %s", oname, infoLong(bucket), err.Error())
+		return nil, &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	return object, nil
+}
+
+func handleGetObjectTagging(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_GetObjectTagging); e != nil {
+		return e
+	}
+
+	object, e := findReqObject(ctx, oname, bucket, r, S3P_GetObjectVersionTagging)
+	if e != nil {
+		return e
+	}
+
+	setVersionHeader(w, bucket, object)
+	HTTPRespXML(w, tagsToXML(object.TagSet))
+	return nil
+}
+
+func handlePutObjectTagging(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_PutObjectTagging); e != nil {
+		return e
+	}
+
+	tags, e := tagsFromReq(r, S3ObjectTagsMax)
+	if e != nil {
+		return e
+	}
+
+	object, e := findReqObject(ctx, oname, bucket, r, S3P_PutObjectVersionTagging)
+	if e != nil {
+		return e
+	}
+
+	err := s3SetTags(ctx, object, tags)
+	if err != nil {
+		log.Errorf("s3: Can't tag %s: %s", infoLong(object), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	setVersionHeader(w, bucket, object)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleDelObjectTagging(ctx context.Context, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_DeleteObjectTagging); e != nil {
+		return e
+	}
+
+	object, e := findReqObject(ctx, oname, bucket, r, S3P_DeleteObjectVersionTagging)
+	if e != nil {
+		return e
+	}
+
+	err := s3SetTags(ctx, object, nil)
+	if err != nil {
+		log.Errorf("s3: Can't untag %s: %s", infoLong(object), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	setVersionHeader(w, bucket, object)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func handleGetBucketTagging(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_GetBucketTagging) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if len(b.TagSet) == 0 {
+		return &S3Error{ ErrorCode: S3ErrNoSuchTagSet }
+	}
+
+	HTTPRespXML(w, tagsToXML(b.TagSet))
+	return nil
+}
+
+func handlePutBucketTagging(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_PutBucketTagging) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	tags, e := tagsFromReq(r, S3BucketTagsMax)
+	if e != nil {
+		return e
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	err = s3SetTags(ctx, b, tags)
+	if err != nil {
+		log.Errorf("s3: Can't tag %s: %s", infoLong(b), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func handleDelBucketTagging(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_PutBucketTagging) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	err = s3SetTags(ctx, b, nil)
+	if err != nil {
+		log.Errorf("s3: Can't untag %s: %s", infoLong(b), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
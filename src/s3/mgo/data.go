@@ -0,0 +1,72 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package s3mgo
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"io"
+)
+
+type ChunkFetchFn func(id bson.ObjectId) (*DataChunk, error)
+
+/*
+ * Streams size bytes of the part data starting at off into w. Only
+ * the inline data and the mongo chunks are handled here, chunks are
+ * fetched one by one so at most one of them is kept in memory.
+ */
+func (part *ObjectPart) WriteRangeTo(w io.Writer, off, size int64, fetch ChunkFetchFn) (int64, error) {
+	var written, rover int64
+
+	if part.Data != nil {
+		if off >= int64(len(part.Data)) {
+			return 0, nil
+		}
+
+		end := off + size
+		if end > int64(len(part.Data)) {
+			end = int64(len(part.Data))
+		}
+
+		n, err := w.Write(part.Data[off:end])
+		return int64(n), err
+	}
+
+	for _, cid := range part.Chunks {
+		if rover >= off + size {
+			break
+		}
+
+		ch, err := fetch(cid)
+		if err != nil {
+			return written, err
+		}
+
+		cs := rover
+		ce := rover + int64(len(ch.Bytes))
+		rover = ce
+
+		if ce <= off {
+			continue
+		}
+
+		from := int64(0)
+		if off > cs {
+			from = off - cs
+		}
+		to := ce - cs
+		if off + size < ce {
+			to = off + size - cs
+		}
+
+		n, err := w.Write(ch.Bytes[from:to])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
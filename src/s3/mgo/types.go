@@ -106,11 +106,46 @@ type Tag struct {
 	Value				string		`bson:"value,omitempty"`
 }
 
+/*
+ * Grantee is either the IAM's AWS ID or one of the
+ * predefined groups, see GranteeAllUsers et al
+ */
+type Grant struct {
+	Grantee				string		`bson:"grantee"`
+	Perm				string		`bson:"perm"`
+}
+
+const (
+	GranteeAllUsers			= "group:all"
+	GranteeAuthUsers		= "group:auth"
+)
+
 type BucketEncrypt struct {
 	Algo				string		`bson:"algo"`
 	MasterKeyID			string		`bson:"algo,omitempty"`
 }
 
+type LifecycleRule struct {
+	ID				string		`bson:"id,omitempty"`
+	Enabled				bool		`bson:"enabled"`
+	Prefix				string		`bson:"prefix,omitempty"`
+	Tags				[]Tag		`bson:"tags,omitempty"`
+	ExpDays				int		`bson:"exp-days,omitempty"`
+	ExpDate				string		`bson:"exp-date,omitempty"`
+	ExpMarkers			bool		`bson:"exp-markers,omitempty"`
+	NoncurDays			int		`bson:"noncur-days,omitempty"`
+	AbortMpuDays			int		`bson:"abort-mpu-days,omitempty"`
+}
+
+type CorsRule struct {
+	ID				string		`bson:"id,omitempty"`
+	Origins				[]string	`bson:"origins"`
+	Methods				[]string	`bson:"methods"`
+	Headers				[]string	`bson:"headers,omitempty"`
+	Expose				[]string	`bson:"expose,omitempty"`
+	MaxAge				int		`bson:"max-age,omitempty"`
+}
+
 type Bucket struct {
 	ObjID				bson.ObjectId	`bson:"_id,omitempty"`
 	BCookie				string		`bson:"bcookie,omitempty"`
@@ -120,20 +155,21 @@ type Bucket struct {
 
 	NamespaceID			string		`bson:"nsid,omitempty"`
 	CreationTime			string		`bson:"creation-time,omitempty"`
+	Versioning			string		`bson:"versioning,omitempty"`
+	TagSet				[]Tag		`bson:"tags,omitempty"`
+	Lifecycle			[]LifecycleRule	`bson:"lifecycle,omitempty"`
+	Policy				string		`bson:"policy,omitempty"`
+	Grants				[]Grant		`bson:"grants,omitempty"`
+	Cors				[]CorsRule	`bson:"cors,omitempty"`
 
 	// Todo
-	Versioning			bool		`bson:"versioning,omitempty"`
-	TagSet				[]Tag		`bson:"tags,omitempty"`
 	Encrypt				BucketEncrypt	`bson:"encrypt,omitempty"`
 	Location			string		`bson:"location,omitempty"`
-	Policy				string		`bson:"policy,omitempty"`
 	Logging				bool		`bson:"logging,omitempty"`
-	Lifecycle			string		`bson:"lifecycle,omitempty"`
 	RequestPayment			string		`bson:"request-payment,omitempty"`
 
 	// Not supported props
 	// analytics
-	// cors
 	// metrics
 	// replication
 	// website
@@ -153,14 +189,25 @@ type Bucket struct {
 	MaxBytes			int64		`bson:"max-bytes"`
 }
 
+type ObjectHeaders struct {
+	ContentType			string		`bson:"content-type,omitempty"`
+	CacheControl			string		`bson:"cache-control,omitempty"`
+	ContentDisposition		string		`bson:"content-disposition,omitempty"`
+	ContentEncoding			string		`bson:"content-encoding,omitempty"`
+	ContentLanguage			string		`bson:"content-language,omitempty"`
+	Expires				string		`bson:"expires,omitempty"`
+}
+
 type ObjectProps struct {
 	CreationTime			string		`bson:"creation-time,omitempty"`
 	Acl				string		`bson:"acl,omitempty"`
 	Key				string		`bson:"key"`
-
-	// Todo
 	Meta				[]Tag		`bson:"meta,omitempty"`
+	Headers				*ObjectHeaders	`bson:"headers,omitempty"`
 	TagSet				[]Tag		`bson:"tags,omitempty"`
+	Grants				[]Grant		`bson:"grants,omitempty"`
+
+	// Todo
 	Policy				string		`bson:"policy,omitempty"`
 
 	// Not supported props
@@ -180,6 +227,8 @@ type Object struct {
 	Rover				int64		`bson:"rover"`
 	Size				int64		`bson:"size"`
 	ETag				string		`bson:"etag"`
+	Versioned			bool		`bson:"versioned,omitempty"`
+	DelMarker			bool		`bson:"del-marker,omitempty"`
 
 	ObjectProps					`bson:",inline"`
 }
@@ -198,6 +247,7 @@ type ObjectPart struct {
 	Part				uint		`bson:"part"`
 	ETag				string		`bson:"etag"`
 	Data				[]byte		`bson:"data,omitempty"`
+	Backend				string		`bson:"backend,omitempty"`
 	Chunks				[]bson.ObjectId	`bson:"chunks"`
 }
 
@@ -70,8 +70,12 @@ func s3Notify(ctx context.Context, bucket *s3mgo.Bucket, object *s3mgo.Object, o
 	account, err := s3AccountLookup(ctx)
 	if err != nil { return }
 
+	s3NotifyNs(account.Namespace, bucket, object, op)
+}
+
+func s3NotifyNs(namespace string, bucket *s3mgo.Bucket, object *s3mgo.Object, op string) {
 	data, err := json.Marshal(&swys3api.Event{
-			Namespace: account.Namespace,
+			Namespace: namespace,
 			Bucket: bucket.Name,
 			Object: object.Key,
 			Op: op,
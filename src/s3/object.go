@@ -9,7 +9,9 @@ import (
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"context"
+	"errors"
 	"time"
+	"io"
 
 	"swifty/apis/s3"
 	"swifty/s3/mgo"
@@ -86,6 +88,10 @@ func FindCurObject(ctx context.Context, bucket *s3mgo.Bucket, oname string) (*s3
 		return nil, err
 	}
 
+	if res.DelMarker {
+		return nil, mgo.ErrNotFound
+	}
+
 	return &res,nil
 }
 
@@ -108,6 +114,7 @@ func createObjectPre(ctx context.Context, bucket *s3mgo.Bucket, o *s3mgo.Object)
 	o.State = S3StateNone
 	o.ObjectProps.CreationTime = time.Now().Format(time.RFC3339)
 	o.Version = 1
+	o.Versioned = (bucket.Versioning == swys3api.S3VersioningEnabled)
 	o.BucketObjID = bucket.ObjID
 	o.OCookie = bucket.OCookie(o.ObjectProps.Key, 1)
 
@@ -152,11 +159,7 @@ func UploadToObject(ctx context.Context, bucket *s3mgo.Bucket, upload *S3Upload)
 		 */
 		ObjID:		upload.ObjID,
 		Size:		size,
-		ObjectProps: s3mgo.ObjectProps {
-			Key:		upload.Key,
-			Acl:		upload.Acl,
-		},
-
+		ObjectProps:	upload.ObjectProps,
 	}
 
 	err = createObjectPre(ctx, bucket, object)
@@ -181,8 +184,12 @@ out:
 	return nil, err
 }
 
-func CopyObject(ctx context.Context, bucket *s3mgo.Bucket, oname string,
-		acl string, bucket_source *s3mgo.Bucket, oname_source string) (*s3mgo.Object, error) {
+/*
+ * Metadata and tags are taken from the source unless the caller
+ * asks to replace them with what's in props.
+ */
+func CopyObject(ctx context.Context, bucket *s3mgo.Bucket, props *s3mgo.ObjectProps,
+		bucket_source *s3mgo.Bucket, oname_source string, replMeta, replTags bool) (*s3mgo.Object, error) {
 	var source *s3mgo.Object
 	var err error
 
@@ -192,18 +199,22 @@ func CopyObject(ctx context.Context, bucket *s3mgo.Bucket, oname string,
 			return nil, err
 		}
 		log.Errorf("s3: Can't find object %s on %s: %s",
-				oname, infoLong(bucket), err.Error())
+				props.Key, infoLong(bucket), err.Error())
 		return nil, err
 	}
 
+	if !replMeta {
+		props.Meta = source.Meta
+		props.Headers = source.Headers
+	}
+	if !replTags {
+		props.TagSet = source.TagSet
+	}
 
 	object := &s3mgo.Object {
 		ObjID:		bson.NewObjectId(),
 		Size:		source.Size,
-		ObjectProps: s3mgo.ObjectProps {
-			Key:		oname,
-			Acl:		acl,
-		},
+		ObjectProps:	*props,
 	}
 
 	err = createObjectPre(ctx, bucket, object)
@@ -238,18 +249,15 @@ out:
 	return nil, err
 }
 
-func AddObject(ctx context.Context, bucket *s3mgo.Bucket, oname string,
-		acl string, data *ChunkReader) (*s3mgo.Object, error) {
+func AddObject(ctx context.Context, bucket *s3mgo.Bucket, props *s3mgo.ObjectProps,
+		data *ChunkReader) (*s3mgo.Object, error) {
 	var objp *s3mgo.ObjectPart
 	var err error
 
 	object := &s3mgo.Object {
 		ObjID:		bson.NewObjectId(),
 		Size:		data.size,
-		ObjectProps: s3mgo.ObjectProps {
-			Key:		oname,
-			Acl:		acl,
-		},
+		ObjectProps:	*props,
 	}
 
 	err = createObjectPre(ctx, bucket, object)
@@ -281,18 +289,39 @@ out:
 	return nil, err
 }
 
-func s3DeleteObject(ctx context.Context, bucket *s3mgo.Bucket, oname string) error {
+/*
+ * On a bucket with versioning ever turned on the delete doesn't remove
+ * anything, but puts a delete marker on top of the key. The marker is
+ * returned back so that the caller can report its version.
+ */
+func s3DeleteObject(ctx context.Context, bucket *s3mgo.Bucket, oname string) (*s3mgo.Object, error) {
 	var object *s3mgo.Object
 	var err error
 
+	if bucket.Versioning != "" {
+		object, err = s3AddDeleteMarker(ctx, bucket, oname)
+		if err != nil {
+			log.Errorf("s3: Can't put delete marker for %s on %s: %s",
+				oname, infoLong(bucket), err.Error())
+			return nil, err
+		}
+
+		if bucket.BasicNotify != nil && bucket.BasicNotify.Delete > 0 {
+			s3Notify(ctx, bucket, object, "delete")
+		}
+
+		log.Debugf("s3: Marked deleted %s", infoLong(object))
+		return object, nil
+	}
+
 	object, err = FindCurObject(ctx, bucket, oname)
 	if err != nil {
 		if err == mgo.ErrNotFound {
-			return nil
+			return nil, nil
 		}
 		log.Errorf("s3: Can't find object %s on %s: %s",
 			oname, infoLong(bucket), err.Error())
-		return err
+		return nil, err
 	}
 
 	err = DropObject(ctx, bucket, object)
@@ -300,7 +329,7 @@ func s3DeleteObject(ctx context.Context, bucket *s3mgo.Bucket, oname string) err
 		if err == mgo.ErrNotFound {
 			err = nil
 		}
-		return err
+		return nil, err
 	}
 
 	if bucket.BasicNotify != nil && bucket.BasicNotify.Delete > 0 {
@@ -308,7 +337,7 @@ func s3DeleteObject(ctx context.Context, bucket *s3mgo.Bucket, oname string) err
 	}
 
 	log.Debugf("s3: Deleted %s", infoLong(object))
-	return nil
+	return nil, nil
 }
 
 func DropObject(ctx context.Context, bucket *s3mgo.Bucket, object *s3mgo.Object) error {
@@ -317,9 +346,11 @@ func DropObject(ctx context.Context, bucket *s3mgo.Bucket, object *s3mgo.Object)
 		return err
 	}
 
-	err = DeleteParts(ctx, object)
-	if err != nil {
-		return err
+	if !object.DelMarker {
+		err = DeleteParts(ctx, object)
+		if err != nil {
+			return err
+		}
 	}
 
 	err = unacctObj(ctx, bucket, object.Size, false)
@@ -335,76 +366,46 @@ func DropObject(ctx context.Context, bucket *s3mgo.Bucket, object *s3mgo.Object)
 	return nil
 }
 
-func ReadData(ctx context.Context, bucket *s3mgo.Bucket, object *s3mgo.Object) ([]byte, error) {
-	var objp []*s3mgo.ObjectPart
-	var res []byte
-	var err error
+type IterPartsFn func(*s3mgo.ObjectPart) error
 
-	objp, err = PartsFindForRead(ctx, object.ObjID)
-	if err != nil {
-		if err != mgo.ErrNotFound {
-			log.Errorf("s3: Can't find object data %s: %s",
-				infoLong(object), err.Error())
-			return nil, err
-		}
-		return nil, err
-	}
+var errIterStop = errors.New("iteration stopped")
 
-	/* FIXME -- push io.Writer and write data into it, do not carry bytes over */
-	res, err = ReadParts(ctx, bucket, object.OCookie, objp)
-	if err != nil {
-		return nil, err
-	}
-
-	log.Debugf("s3: Read %s", infoLong(object))
-	return res, err
-}
+/*
+ * Streams the [off, off + size) range of the object data into w. Parts
+ * are walked in order, only the overlapping ones are read and no more
+ * than a single chunk of data is kept in memory at a time.
+ */
+func ReadData(ctx context.Context, object *s3mgo.Object, off, size int64, w io.Writer) (int64, error) {
+	var written, rover int64
 
-func ReadObject(ctx context.Context, bucket *s3mgo.Bucket, oname string, part, version int) ([]byte, error) {
-	var object *s3mgo.Object
-	var err error
+	err := IterParts(ctx, object.ObjID, func(p *s3mgo.ObjectPart) error {
+		ps := rover
+		pe := rover + p.Size
+		rover = pe
 
-	object, err = FindCurObject(ctx, bucket, oname)
-	if err != nil {
-		if err == mgo.ErrNotFound {
-			return nil, err
+		if pe <= off {
+			return nil
 		}
-		log.Errorf("s3: Can't find object %s on %s: %s",
-				oname, infoLong(bucket), err.Error())
-		return nil, err
-	}
-
-	return ReadData(ctx, bucket, object)
-}
-
-
-type IterChunksFn func(*s3mgo.DataChunk) error
-type IterPartsFn func(*s3mgo.ObjectPart) error
-
-func ObjectIterChunks(ctx context.Context, bucket *s3mgo.Bucket, oname string,
-		part, version int, fn IterChunksFn) error {
-	var object *s3mgo.Object
-	var err error
-
-	object, err = FindCurObject(ctx, bucket, oname)
-	if err != nil {
-		if err != mgo.ErrNotFound {
-			log.Errorf("s3: Can't find object %s on %s: %s",
-					oname, infoLong(bucket), err.Error())
+		if ps >= off + size {
+			return errIterStop
 		}
-		return err
-	}
 
-	err = IterParts(ctx, object.ObjID, func(p *s3mgo.ObjectPart) error {
-		return IterChunks(ctx, p, fn)
-	})
-
-	if err != nil {
-		if err != mgo.ErrNotFound {
-			log.Errorf("s3: Can't find object data %s: %s", infoLong(object), err.Error())
+		from := int64(0)
+		if off > ps {
+			from = off - ps
 		}
+		to := p.Size
+		if off + size < pe {
+			to = off + size - ps
+		}
+
+		n, err := partReadTo(ctx, p, from, to - from, w)
+		written += n
 		return err
+	})
+	if err == errIterStop {
+		err = nil
 	}
 
-	return nil
+	return written, err
 }
@@ -20,10 +20,10 @@ const (
 
 var (
 	/*
-	 * targets:        [b]ucket, [o]bject, [u]ploads
-	 * actions: ls      +         +         +
+	 * targets:        [b]ucket, [o]bject, [u]ploads, [v]ersions
+	 * actions: ls      +         +         +          +
 	 *          put     +         +         +
-	 *          del     +         +         +
+	 *          del     +         +         +          +
 	 *          acc     +         +
 	 *            ?               get       ini fin lp
 	 */
@@ -59,6 +59,14 @@ var (
 		[]string { "reason" },
 	)
 
+	lcActions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swys3_lifecycle_actions",
+			Help: "Number of objects and uploads removed by lifecycle rules",
+		},
+		[]string { "action" },
+	)
+
 	fsckReqs = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "swys3_fsck_reqs",
@@ -72,6 +80,7 @@ func PrometheusInit(conf *YAMLConf) error {
 	prometheus.MustRegister(ioSize)
 	prometheus.MustRegister(fsckReqs)
 	prometheus.MustRegister(downloadErrors)
+	prometheus.MustRegister(lcActions)
 
 	r := mux.NewRouter()
 	r.Handle("/metrics", promhttp.Handler())
@@ -10,7 +10,12 @@ import (
 	"github.com/ceph/go-ceph/rados"
 
 	"encoding/json"
+	"context"
+	"errors"
+	"io"
 	"fmt"
+
+	"swifty/s3/mgo"
 )
 
 var radosConn *rados.Conn
@@ -128,41 +133,35 @@ func radosWriteObject(pool, oname string, data *ChunkReader, offset uint64) (str
 	var err error
 
 	if radosDisabled {
-		return "", nil
+		return "", errors.New("Rados is disabled")
 	}
 
 	ioctx, err = radosConn.OpenIOContext(pool)
 	if err != nil {
 		log.Errorf("rados: Can't open context for pool %s object %s: %s",
 				pool, oname, err.Error())
+		return "", err
 	}
 
-	for {
-		bts, err := data.Next(S3MaxChunkSize)
-		if err != nil {
-			ioctx.Destroy()
-			return "", err
-		}
-
-		if bts == nil {
-			break
-		}
-
-		err = ioctx.Write(oname, bts, offset)
+	csum, err := writeHashed(data, func(bts []byte) error {
+		err := ioctx.Write(oname, bts, offset)
 		if err != nil {
 			log.Errorf("rados: Can't write object for pool %s object %s offset %d: %s",
 				pool, oname, offset, err.Error())
-			ioctx.Destroy()
-			return "", err
+			return err
 		}
 
 		offset += uint64(len(bts))
+		return nil
+	})
+
+	ioctx.Destroy()
+	if err != nil {
+		return "", err
 	}
 
 	log.Debugf("rados: Wrote pool %s object %s", pool, oname)
-
-	ioctx.Destroy()
-	return "", nil /* FIXME -- md5sub */
+	return csum, nil
 }
 
 // FIXME: We can read up to int value at once
@@ -196,7 +195,108 @@ func radosReadObject(pool, oname string, size uint64, offset uint64) ([]byte, er
 			pool, oname, n, offset)
 
 	ioctx.Destroy()
-	return data, nil
+	return data[:n], nil
+}
+
+func radosStatObject(pool, oname string) (uint64, error) {
+	var ioctx *rados.IOContext
+	var err error
+
+	if radosDisabled {
+		return 0, errors.New("Rados is disabled")
+	}
+
+	ioctx, err = radosConn.OpenIOContext(pool)
+	if err != nil {
+		log.Errorf("rados: Can't open context for pool %s object %s: %s",
+				pool, oname, err.Error())
+		return 0, err
+	}
+
+	st, err := ioctx.Stat(oname)
+	ioctx.Destroy()
+	if err != nil {
+		log.Errorf("rados: Can't stat object from pool %s object %s: %s",
+				pool, oname, err.Error())
+		return 0, err
+	}
+
+	return st.Size, nil
+}
+
+/*
+ * Legacy parts are named after the object cookie, which is the same
+ * for all the object versions, so new ones get the part ID appended.
+ */
+type radosData struct { }
+
+func radosName(part *s3mgo.ObjectPart) string {
+	if part.Backend == "" {
+		return part.OCookie
+	}
+	return part.OCookie + "." + part.ObjID.Hex()
+}
+
+func (_ *radosData) Write(ctx context.Context, part *s3mgo.ObjectPart, data *ChunkReader) (string, error) {
+	return radosWriteObject(part.BCookie, radosName(part), data, 0)
+}
+
+/* Ranged reads by max_chunk_size pieces, so memory use does not depend on the part size */
+func (_ *radosData) ReadTo(ctx context.Context, part *s3mgo.ObjectPart, off, size int64, w io.Writer) (int64, error) {
+	var written int64
+
+	if radosDisabled {
+		return 0, errors.New("Rados is disabled")
+	}
+
+	if off + size > part.Size {
+		size = part.Size - off
+	}
+
+	for written < size {
+		bsz := size - written
+		if bsz > S3MaxChunkSize {
+			bsz = S3MaxChunkSize
+		}
+
+		data, err := radosReadObject(part.BCookie, radosName(part), uint64(bsz), uint64(off + written))
+		if err != nil {
+			return written, err
+		}
+		if len(data) == 0 {
+			return written, fmt.Errorf("Object %s is short, %d bytes at %d",
+					radosName(part), written, off)
+		}
+
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (_ *radosData) Delete(ctx context.Context, part *s3mgo.ObjectPart) error {
+	return radosDeleteObject(part.BCookie, radosName(part))
+}
+
+func (rd *radosData) Copy(ctx context.Context, part *s3mgo.ObjectPart, source *s3mgo.ObjectPart) error {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := rd.ReadTo(ctx, source, 0, source.Size, pw)
+		pw.CloseWithError(err)
+	}()
+
+	_, err := rd.Write(ctx, part, &ChunkReader{size: source.Size, r: pr})
+	pr.Close()
+	return err
+}
+
+func (_ *radosData) Stat(ctx context.Context, part *s3mgo.ObjectPart) (int64, error) {
+	size, err := radosStatObject(part.BCookie, radosName(part))
+	return int64(size), err
 }
 
 func radosDeleteObject(pool, oname string) error {
@@ -211,6 +311,7 @@ func radosDeleteObject(pool, oname string) error {
 	if err != nil {
 		log.Errorf("rados: Can't open context for pool %s object %s: %s",
 				pool, oname, err.Error())
+		return err
 	}
 
 	err = ioctx.Delete(oname)
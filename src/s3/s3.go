@@ -60,11 +60,6 @@ func s3AuthorizeAdmin(ctx context.Context, r *http.Request) (*s3mgo.AccessKey, e
 	return LookupAccessKey(ctx, access_key)
 }
 
-func s3CheckAccess(ctx context.Context, bname, oname string) error {
-	// FIXME Implement lookup and ACL, for now just allow
-	return nil
-}
-
 func requestFsck() {
 	fsckReqs.Inc()
 }
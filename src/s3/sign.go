@@ -11,14 +11,17 @@ import (
 	"crypto/hmac"
 	"context"
 	"io/ioutil"
+	"hash"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"regexp"
 	"errors"
 	"sort"
-	"bytes"
 	"fmt"
+	"time"
+	"strconv"
 	"swifty/s3/mgo"
 )
 
@@ -30,6 +33,21 @@ const (
 	AWS4ServiceS3		= "s3"
 	AWS4ServiceCW		= "monitoring"
 	AWS4Request		= "aws4_request"
+	AWS4Region		= "internal"
+	AWS4TimeFormat		= "20060102T150405Z"
+	AWS4DateFormat		= "20060102"
+)
+
+// Query string (presigned URL) authentication parameters
+const (
+	AWSQueryAlgorithm	= "X-Amz-Algorithm"
+	AWSQueryCredential	= "X-Amz-Credential"
+	AWSQueryDate		= "X-Amz-Date"
+	AWSQueryExpires		= "X-Amz-Expires"
+	AWSQuerySignedHeaders	= "X-Amz-SignedHeaders"
+	AWSQuerySignature	= "X-Amz-Signature"
+
+	AWSPresignExpiresMax	= 7 * 24 * 3600
 )
 
 type AuthContext struct {
@@ -41,6 +59,10 @@ type AuthContext struct {
 	SignedHeaders		[]string
 	Signature		string
 
+	// From the query string, when presigned
+	Presigned		bool
+	Expires			int
+
 	// Building from headers
 	ContentSha256		string
 	LongTimeStamp		string
@@ -146,23 +168,120 @@ func (actx *AuthContext)ParseV4Authorization(authHeader string) error {
 	return nil
 }
 
+func (actx *AuthContext)parseCredential(cred string) error {
+	creds := strings.Split(cred, "/")
+	if len(creds) < 5 {
+		return fmt.Errorf("s3: Wrong credential %s", cred)
+	}
+
+	actx.AccessKey		= creds[0]
+	actx.ShortTimeStamp	= creds[1]
+	actx.Region		= creds[2]
+	actx.Service		= creds[3]
+	if creds[3] != AWS4ServiceS3 || creds[4] != AWS4Request {
+		return fmt.Errorf("s3: Wrong request type %s", cred)
+	}
+
+	return nil
+}
+
+func (actx *AuthContext)ParseV4Query(q url.Values) (int, error) {
+	var err error
+
+	if q.Get(AWSQueryAlgorithm) != AWSAuthV4HeaderPrefix {
+		return S3ErrInvalidArgument, errors.New("Only AWS4-HMAC-SHA256 is supported")
+	}
+
+	err = actx.parseCredential(q.Get(AWSQueryCredential))
+	if err != nil {
+		return S3ErrInvalidArgument, err
+	}
+
+	actx.Presigned = true
+	actx.Signature = q.Get(AWSQuerySignature)
+	actx.LongTimeStamp = q.Get(AWSQueryDate)
+
+	sh := q.Get(AWSQuerySignedHeaders)
+	if sh == "" {
+		return S3ErrInvalidArgument, errors.New("s3: No signed headers")
+	}
+	actx.SignedHeaders = strings.Split(sh, ";")
+	sort.Strings(actx.SignedHeaders)
+
+	actx.Expires, err = strconv.Atoi(q.Get(AWSQueryExpires))
+	if err != nil || actx.Expires <= 0 || actx.Expires > AWSPresignExpiresMax {
+		return S3ErrInvalidArgument, errors.New("s3: Bad expiration value")
+	}
+
+	signed, err := time.Parse(AWS4TimeFormat, actx.LongTimeStamp)
+	if err != nil {
+		return S3ErrInvalidArgument, errors.New("s3: Bad date value")
+	}
+
+	if time.Now().After(signed.Add(time.Duration(actx.Expires) * time.Second)) {
+		return S3ErrAccessDenied, errors.New("Request has expired")
+	}
+
+	/* Presigned URLs never sign the payload */
+	actx.ContentSha256 = AWSUnsignedPayload
+	return 0, nil
+}
+
 func (actx *AuthContext)ParseV2Authorization(authHeader string) (int, error) {
 	return S3ErrInvalidRequest,
 			errors.New("The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.")
 }
 
+var errBodyDigest = errors.New("Payload does not match x-amz-content-sha256")
+
+type bodyVerifier interface {
+	verify() error
+}
+
+/*
+ * The signature covers the declared payload hash, the payload itself
+ * is hashed while it streams into the handler and is checked when
+ * the body ends. Readers that stop at the content length don't see
+ * the EOF, so they call verify() before committing what they've read.
+ */
+type sha256Body struct {
+	io.ReadCloser
+	h		hash.Hash
+	want		string
+}
+
+func (sb *sha256Body)Read(p []byte) (int, error) {
+	n, err := sb.ReadCloser.Read(p)
+	sb.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(sb.h.Sum(nil)) != sb.want {
+		err = errBodyDigest
+	}
+	return n, err
+}
+
+func (sb *sha256Body)verify() error {
+	_, err := io.Copy(ioutil.Discard, sb)
+	return err
+}
+
 func (actx *AuthContext) BuildBodyDigest(r *http.Request) (error) {
 	if actx.ContentSha256 == AWSUnsignedPayload {
 		actx.BodyDigest = AWSUnsignedPayload
 	} else if r.Body == nil {
 		actx.BodyDigest = AWSEmptyStringSHA256
+	} else if actx.ContentSha256 == "" {
+		if r.ContentLength != 0 {
+			return errors.New("Missing x-amz-content-sha256")
+		}
+		actx.BodyDigest = AWSEmptyStringSHA256
 	} else {
-		buf, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return err
+		sum, err := hex.DecodeString(actx.ContentSha256)
+		if err != nil || len(sum) != sha256.Size {
+			return errors.New("Bad x-amz-content-sha256")
 		}
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
-		actx.BodyDigest = hex.EncodeToString(makeSha256(buf))
+
+		actx.BodyDigest = actx.ContentSha256
+		r.Body = &sha256Body{ReadCloser: r.Body, h: sha256.New(), want: actx.ContentSha256}
 	}
 	return nil
 }
@@ -172,6 +291,15 @@ func queryEncode(uri string) string {
 	return strings.TrimSpace(uri)
 }
 
+/* Each path segment is encoded, slashes are kept as is */
+func pathEncode(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		segs[i] = queryEncode(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
 func genCanonicalHeader(r *http.Request, key string) string {
 	name := strings.TrimSpace(strings.ToLower(key))
 	value := strings.TrimSpace(r.Header.Get(http.CanonicalHeaderKey(key)))
@@ -193,11 +321,14 @@ func (actx *AuthContext) BuildCanonicalString(r *http.Request) {
 	members = append(members, r.Method)
 
 	// CanonicalURI
-	members = append(members, r.URL.Path)
+	members = append(members, pathEncode(r.URL.Path))
 
 	// CanonicalQueryString
 	q := r.URL.Query()
 	for k, _ := range q {
+		if actx.Presigned && k == AWSQuerySignature {
+			continue
+		}
 		keys = append(keys, k)
 	}
 
@@ -266,7 +397,6 @@ func (actx *AuthContext) BuildSignature() {
 }
 
 func s3VerifyAuthorizationHeaders(ctx context.Context, r *http.Request, authHeader string) (*s3mgo.AccessKey, int, error) {
-	var akey *s3mgo.AccessKey
 	var actx AuthContext
 
 	code, err := actx.ParseAuthorization(authHeader)
@@ -275,15 +405,32 @@ func s3VerifyAuthorizationHeaders(ctx context.Context, r *http.Request, authHead
 		return nil, code, err
 	}
 
-	akey, err = LookupAccessKey(ctx, actx.AccessKey)
+	actx.LongTimeStamp = getHeader(r, "X-Amz-Date")
+	actx.ContentSha256 = getHeader(r, "X-Amz-Content-Sha256")
+
+	return s3VerifySignature(ctx, r, &actx)
+}
+
+func s3VerifyAuthorizationQuery(ctx context.Context, r *http.Request) (*s3mgo.AccessKey, int, error) {
+	var actx AuthContext
+
+	code, err := actx.ParseV4Query(r.URL.Query())
+	if err != nil {
+		log.Error(err.Error())
+		return nil, code, err
+	}
+
+	return s3VerifySignature(ctx, r, &actx)
+}
+
+func s3VerifySignature(ctx context.Context, r *http.Request, actx *AuthContext) (*s3mgo.AccessKey, int, error) {
+	akey, err := LookupAccessKey(ctx, actx.AccessKey)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, S3ErrAccessDenied, err
 	}
 
 	actx.BuildSigningKey(s3DecryptAccessKeySecret(akey))
-	actx.LongTimeStamp = getHeader(r, "X-Amz-Date")
-	actx.ContentSha256 = getHeader(r, "X-Amz-Content-Sha256")
 
 	err = actx.BuildBodyDigest(r)
 	if err != nil {
@@ -295,7 +442,7 @@ func s3VerifyAuthorizationHeaders(ctx context.Context, r *http.Request, authHead
 	actx.BuildStringToSign()
 	actx.BuildSignature()
 
-	log.Debugf("s3: s3VerifyAuthorizationHeaders: %s %s",
+	log.Debugf("s3: s3VerifySignature: %s %s",
 		actx.Signature, actx.BuiltSignature)
 	if actx.Signature == actx.BuiltSignature {
 		return akey, 0, nil
@@ -309,8 +456,61 @@ func s3AuthorizeUser(ctx context.Context, r *http.Request) (*s3mgo.AccessKey, in
 
 	authHeader = getHeader(r, "Authorization")
 	if authHeader == "" {
+		if r.URL.Query().Get(AWSQuerySignature) != "" {
+			return s3VerifyAuthorizationQuery(ctx, r)
+		}
 		return nil, 0, nil
 	}
 
 	return s3VerifyAuthorizationHeaders(ctx, r, authHeader)
 }
+
+/*
+ * Makes the URL that lets anyone holding it do the method on the
+ * object with the given key until it expires
+ */
+func s3Presign(akey *s3mgo.AccessKey, endpoint, method, bucket, object string, expires int) (string, error) {
+	if expires <= 0 || expires > AWSPresignExpiresMax {
+		return "", errors.New("Bad expiration value")
+	}
+
+	r, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	r.URL.Path = strings.TrimRight(r.URL.Path, "/") + "/" + bucket + "/" + object
+	r.URL.RawPath = pathEncode(r.URL.Path)
+
+	now := time.Now().UTC()
+	actx := &AuthContext {
+		AccessKey:	akey.AccessKeyID,
+		ShortTimeStamp:	now.Format(AWS4DateFormat),
+		LongTimeStamp:	now.Format(AWS4TimeFormat),
+		Region:		AWS4Region,
+		Service:	AWS4ServiceS3,
+		SignedHeaders:	[]string{"host"},
+		Presigned:	true,
+		Expires:	expires,
+		BodyDigest:	AWSUnsignedPayload,
+	}
+
+	q := r.URL.Query()
+	q.Set(AWSQueryAlgorithm, AWSAuthV4HeaderPrefix)
+	q.Set(AWSQueryCredential, strings.Join([]string{actx.AccessKey, actx.ShortTimeStamp,
+				actx.Region, actx.Service, AWS4Request}, "/"))
+	q.Set(AWSQueryDate, actx.LongTimeStamp)
+	q.Set(AWSQueryExpires, strconv.Itoa(expires))
+	q.Set(AWSQuerySignedHeaders, "host")
+	r.URL.RawQuery = q.Encode()
+
+	actx.BuildSigningKey(s3DecryptAccessKeySecret(akey))
+	actx.BuildCanonicalString(r)
+	actx.BuildStringToSign()
+	actx.BuildSignature()
+
+	q.Set(AWSQuerySignature, actx.BuiltSignature)
+	r.URL.RawQuery = q.Encode()
+
+	return r.URL.String(), nil
+}
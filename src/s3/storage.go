@@ -0,0 +1,294 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"crypto/md5"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"swifty/s3/mgo"
+)
+
+/*
+ * Object parts not small enough to be kept inline are stored by one
+ * of the data drivers. The part remembers which one, parts written
+ * before drivers were introduced have it empty and are told apart by
+ * having or not having the mongo chunks.
+ */
+type S3DataDriver interface {
+	/* Stores the data and returns its md5, the part is not updated in DB */
+	Write(ctx context.Context, part *s3mgo.ObjectPart, data *ChunkReader) (string, error)
+	/* Streams the [off, off + size) range into w, returns the number of bytes written */
+	ReadTo(ctx context.Context, part *s3mgo.ObjectPart, off, size int64, w io.Writer) (int64, error)
+	Delete(ctx context.Context, part *s3mgo.ObjectPart) error
+	Copy(ctx context.Context, part *s3mgo.ObjectPart, source *s3mgo.ObjectPart) error
+	Stat(ctx context.Context, part *s3mgo.ObjectPart) (int64, error)
+}
+
+const (
+	S3DataMongo		= "mongo"
+	S3DataRados		= "rados"
+	S3DataFs		= "fs"
+)
+
+var dataDrivers = map[string]S3DataDriver {
+	S3DataMongo:	&mongoData{},
+	S3DataRados:	&radosData{},
+	S3DataFs:	&fsData{},
+}
+
+var dataDriverName string
+
+/*
+ * The fs driver is set up whenever its path is configured, not only
+ * when it's the current one, parts written by it earlier (and the
+ * migration) need it too.
+ */
+func dataInit(conf *YAMLConfStorage) error {
+	if conf.FsPath != "" {
+		err := fsDataInit(conf.FsPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	dataDriverName = conf.Driver
+	if dataDriverName == "" {
+		if radosDisabled {
+			dataDriverName = S3DataMongo
+		} else {
+			dataDriverName = S3DataRados
+		}
+	}
+
+	switch dataDriverName {
+	case S3DataMongo:
+		;
+	case S3DataRados:
+		if radosDisabled {
+			return errors.New("Rados driver is configured, but rados is disabled")
+		}
+	case S3DataFs:
+		if fsDataRoot == "" {
+			return errors.New("Fs driver is configured, but storage.fs-path is not set")
+		}
+	default:
+		return errors.New("Unknown data driver " + dataDriverName)
+	}
+
+	log.Debugf("s3: Using %s data driver", dataDriverName)
+	return nil
+}
+
+func partBackend(part *s3mgo.ObjectPart) string {
+	if part.Backend != "" {
+		return part.Backend
+	}
+	if len(part.Chunks) != 0 {
+		return S3DataMongo
+	}
+	return S3DataRados
+}
+
+func dataDriverReady(name string) error {
+	switch name {
+	case S3DataRados:
+		if radosDisabled {
+			return errors.New("Rados is disabled")
+		}
+	case S3DataFs:
+		if fsDataRoot == "" {
+			return errors.New("Fs driver path (storage.fs-path) is not set")
+		}
+	}
+
+	return nil
+}
+
+func partDriver(part *s3mgo.ObjectPart) (S3DataDriver, error) {
+	drv, ok := dataDrivers[partBackend(part)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown data driver %s on %s", partBackend(part), infoLong(part))
+	}
+
+	err := dataDriverReady(partBackend(part))
+	if err != nil {
+		return nil, err
+	}
+
+	return drv, nil
+}
+
+/*
+ * Rados has always been taking only the parts that do not fit
+ * into a single mongo chunk, others take everything non-inline.
+ */
+func dataDriverFor(size int64) string {
+	if dataDriverName == S3DataRados && size <= S3MaxChunkSize {
+		return S3DataMongo
+	}
+
+	return dataDriverName
+}
+
+func partReadTo(ctx context.Context, part *s3mgo.ObjectPart, off, size int64, w io.Writer) (int64, error) {
+	if part.Data != nil {
+		return part.WriteRangeTo(w, off, size, nil)
+	}
+
+	drv, err := partDriver(part)
+	if err != nil {
+		return 0, err
+	}
+
+	return drv.ReadTo(ctx, part, off, size, w)
+}
+
+func partSetData(ctx context.Context, part *s3mgo.ObjectPart) error {
+	return dbS3Update(ctx, bson.M{"_id": part.ObjID},
+			bson.M{ "$set": bson.M{ "backend": part.Backend, "chunks": part.Chunks }},
+			false, &s3mgo.ObjectPart{})
+}
+
+/*
+ * Moves the data of all the parts kept by one driver to another one.
+ * The data is verified against the part size and etag before the part
+ * is switched to the new driver and the old data is removed.
+ */
+func dataMigrate(ctx context.Context, from, to string) error {
+	var part s3mgo.ObjectPart
+	var moved, failed int
+
+	src, ok := dataDrivers[from]
+	if !ok {
+		return errors.New("Unknown data driver " + from)
+	}
+
+	dst, ok := dataDrivers[to]
+	if !ok {
+		return errors.New("Unknown data driver " + to)
+	}
+
+	if from == to {
+		return errors.New("Nothing to migrate")
+	}
+
+	for _, name := range []string{from, to} {
+		err := dataDriverReady(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	iter := dbS3IterAllSorted(ctx, bson.M{"state": S3StateActive, "data": bson.M{"$exists": false}}, "_id", &part)
+	defer iter.Close()
+
+	for iter.Next(&part) {
+		if partBackend(&part) != from {
+			continue
+		}
+
+		err := dataMovePart(ctx, &part, src, dst, to)
+		if err != nil {
+			log.Errorf("s3: Can't migrate %s: %s", infoLong(&part), err.Error())
+			failed++
+			continue
+		}
+
+		moved++
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	log.Infof("s3: Migrated %d parts from %s to %s, %d failed", moved, from, to, failed)
+	if failed != 0 {
+		return fmt.Errorf("%d parts failed to migrate", failed)
+	}
+
+	return nil
+}
+
+func dataMovePart(ctx context.Context, part *s3mgo.ObjectPart, src, dst S3DataDriver, to string) error {
+	npart := *part
+	npart.Backend = to
+	npart.Chunks = nil
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := src.ReadTo(ctx, part, 0, part.Size, pw)
+		pw.CloseWithError(err)
+	}()
+
+	csum, err := dst.Write(ctx, &npart, &ChunkReader{size: part.Size, r: pr})
+	pr.Close()
+	if err != nil {
+		return err
+	}
+
+	size, err := dst.Stat(ctx, &npart)
+	if err == nil && size != part.Size {
+		err = fmt.Errorf("Size mismatch %d != %d", size, part.Size)
+	}
+	if err == nil && part.ETag != "" && csum != part.ETag {
+		err = fmt.Errorf("Checksum mismatch %s != %s", csum, part.ETag)
+	}
+	if err == nil {
+		err = partSetData(ctx, &npart)
+	}
+	if err != nil {
+		dst.Delete(ctx, &npart)
+		return err
+	}
+
+	err = src.Delete(ctx, part)
+	if err != nil {
+		log.Errorf("s3: Old data of %s may stale", infoLong(part))
+	}
+
+	log.Debugf("s3: Migrated %s to %s", infoLong(part), to)
+	return nil
+}
+
+/*
+ * Feeds the data to fn by pieces of max_chunk_size. The buffer is reused,
+ * so fn should not keep the slice after it returns.
+ */
+func writeHashed(data *ChunkReader, fn func([]byte) error) (string, error) {
+	hasher := md5.New()
+
+	bsz := data.size - data.read
+	if bsz > S3MaxChunkSize {
+		bsz = S3MaxChunkSize
+	}
+	buf := make([]byte, bsz)
+
+	for bsz > 0 {
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			hasher.Write(buf[:n])
+
+			ferr := fn(buf[:n])
+			if ferr != nil {
+				return "", ferr
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
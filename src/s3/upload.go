@@ -217,22 +217,20 @@ func s3UploadRemoveLocked(ctx context.Context, bucket *s3mgo.Bucket, upload *S3U
 	return nil
 }
 
-func s3UploadInit(ctx context.Context, bucket *s3mgo.Bucket, oname, acl string) (*S3Upload, error) {
+func s3UploadInit(ctx context.Context, bucket *s3mgo.Bucket, props *s3mgo.ObjectProps) (*S3Upload, error) {
 	var err error
 
+	props.CreationTime = time.Now().Format(time.RFC3339)
+
 	upload := &S3Upload{
 		ObjID:		bson.NewObjectId(),
 		IamObjID:	ctxIam(ctx).ObjID,
 		State:		S3StateActive,
 
-		ObjectProps: s3mgo.ObjectProps {
-			Key:		oname,
-			Acl:		acl,
-			CreationTime:	time.Now().Format(time.RFC3339),
-		},
+		ObjectProps:	*props,
 
 		BucketObjID:	bucket.ObjID,
-		UploadID:	bucket.UploadUID(oname),
+		UploadID:	bucket.UploadUID(props.Key),
 	}
 
 	if err = dbS3Insert(ctx, upload); err != nil {
@@ -319,20 +317,11 @@ func s3UploadFini(ctx context.Context, bucket *s3mgo.Bucket, uid string,
 	return &res, nil
 }
 
-func s3Uploads(ctx context.Context, bname string) (*swys3api.S3MpuList,  *S3Error) {
+func s3Uploads(ctx context.Context, bucket *s3mgo.Bucket) (*swys3api.S3MpuList,  *S3Error) {
 	var res swys3api.S3MpuList
-	var bucket *s3mgo.Bucket
 	var uploads []S3Upload
 	var err error
 
-	bucket, err = FindBucket(ctx, bname)
-	if err != nil {
-		if err == mgo.ErrNotFound {
-			return nil, &S3Error{ ErrorCode: S3ErrNoSuchBucket }
-		}
-		return nil, &S3Error{ ErrorCode: S3ErrInternalError }
-	}
-
 	res.Bucket		= bucket.Name
 	res.MaxUploads		= 1000
 	res.IsTruncated		= false
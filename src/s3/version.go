@@ -0,0 +1,402 @@
+/*
+ * © 2018 SwiftyCloud OÜ. All rights reserved.
+ * Info: info@swifty.cloud
+ */
+
+package main
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"context"
+	"strconv"
+	"strings"
+	"regexp"
+	"sort"
+
+	"swifty/apis/s3"
+	"swifty/s3/mgo"
+)
+
+/*
+ * Versions are the objects with the same key stacked by rover, the
+ * top one being the current. Objects put while versioning is enabled
+ * are identified by their IDs, all the others are the "null" version.
+ */
+
+type S3ListVersionsRP struct {
+	Delimiter		string
+	MaxKeys			int64
+	Prefix			string
+	KeyMarker		string
+	VersionIdMarker		string
+}
+
+func (params *S3ListVersionsRP) Validate() (bool) {
+	re := regexp.MustCompile(S3ObjectName_Letter)
+
+	if params.Delimiter != "" { if !re.MatchString(params.Delimiter) { return false } }
+	if params.Prefix != "" { if !re.MatchString(params.Prefix) { return false } }
+	if params.KeyMarker != "" { if !re.MatchString(params.KeyMarker) { return false } }
+
+	if params.VersionIdMarker != "" && params.KeyMarker == "" { return false }
+	if len(params.Delimiter) > 1 { return false }
+
+	if params.MaxKeys <= 0 {
+		params.MaxKeys = S3StorageDefaultListObjects
+	} else if params.MaxKeys > S3StorageMaxObjects {
+		return false
+	}
+
+	return true
+}
+
+func objVersionID(o *s3mgo.Object) string {
+	if o.Versioned {
+		return o.ObjID.Hex()
+	}
+	return swys3api.S3VersionNull
+}
+
+func setVersionHeader(w http.ResponseWriter, bucket *s3mgo.Bucket, o *s3mgo.Object) {
+	if bucket.Versioning != "" {
+		w.Header().Set("x-amz-version-id", objVersionID(o))
+	}
+	if o.DelMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
+}
+
+func FindObjectVersion(ctx context.Context, bucket *s3mgo.Bucket, oname, vid string) (*s3mgo.Object, error) {
+	var res s3mgo.Object
+
+	query := bson.M{ "ocookie": bucket.OCookie(oname, 1), "state": S3StateActive }
+	if vid == swys3api.S3VersionNull {
+		query["versioned"] = bson.M{ "$ne": true }
+	} else if bson.IsObjectIdHex(vid) {
+		query["_id"] = bson.ObjectIdHex(vid)
+	} else {
+		return nil, mgo.ErrNotFound
+	}
+
+	err := dbS3FindOneTop(ctx, query, "-rover", &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func s3AddDeleteMarker(ctx context.Context, bucket *s3mgo.Bucket, oname string) (*s3mgo.Object, error) {
+	object := &s3mgo.Object {
+		ObjID:		bson.NewObjectId(),
+		DelMarker:	true,
+		ObjectProps: s3mgo.ObjectProps {
+			Key:		oname,
+		},
+	}
+
+	err := createObjectPre(ctx, bucket, object)
+	if err != nil {
+		return nil, err
+	}
+
+	err = Activate(ctx, bucket, object, "")
+	if err != nil {
+		unacctObj(ctx, bucket, 0, true)
+		dbS3Remove(ctx, object)
+		return nil, err
+	}
+
+	return object, nil
+}
+
+/* Removes the version for real, be it an object or a delete marker */
+func s3DeleteObjectVersion(ctx context.Context, bucket *s3mgo.Bucket, oname, vid string) (*s3mgo.Object, error) {
+	object, err := FindObjectVersion(ctx, bucket, oname, vid)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, nil
+		}
+		log.Errorf("s3: Can't find object %s/%s on %s: %s",
+			oname, vid, infoLong(bucket), err.Error())
+		return nil, err
+	}
+
+	err = DropObject(ctx, bucket, object)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			err = nil
+		}
+		return nil, err
+	}
+
+	if !object.DelMarker && bucket.BasicNotify != nil && bucket.BasicNotify.Delete > 0 {
+		s3Notify(ctx, bucket, object, "delete")
+	}
+
+	log.Debugf("s3: Deleted version %s", infoLong(object))
+	return object, nil
+}
+
+func s3SetVersioning(ctx context.Context, bucket *s3mgo.Bucket, status string) error {
+	update := bson.M{ "$set": bson.M{ "versioning": status } }
+	return dbS3Update(ctx, bson.M{ "state": S3StateActive }, update, true, bucket)
+}
+
+func s3ListVersions(ctx context.Context, bucket *s3mgo.Bucket, params *S3ListVersionsRP) (*swys3api.S3VersionList, *S3Error) {
+	var prefixes_map map[string]bool
+	var list swys3api.S3VersionList
+	var object s3mgo.Object
+	var count int64
+	var pkey string
+
+	if params.Validate() == false {
+		return nil, &S3Error{ ErrorCode: S3ErrInvalidArgument }
+	}
+
+	list.Name		= bucket.Name
+	list.Prefix		= params.Prefix
+	list.KeyMarker		= params.KeyMarker
+	list.VersionIdMarker	= params.VersionIdMarker
+	list.MaxKeys		= params.MaxKeys
+	list.Delimiter		= params.Delimiter
+
+	iam := ctxIam(ctx)
+	owner := swys3api.S3Owner{ DisplayName: iam.User, ID: iam.AwsID }
+
+	query := bson.M{ "bucket-id": bucket.ObjID, "state": S3StateActive}
+	if params.Prefix != "" {
+		query["key"] = bson.M{ "$regex": "^" + regexp.QuoteMeta(params.Prefix), }
+	}
+
+	prefixes_map = make(map[string]bool)
+	skip := (params.KeyMarker != "")
+
+	pipe := dbS3Pipe(ctx, &object, []bson.M{{"$match": query}, {"$sort": bson.M{"key": 1, "rover": -1}}})
+	iter := pipe.Iter()
+	for iter.Next(&object) {
+		latest := (object.Key != pkey)
+		pkey = object.Key
+		vid := objVersionID(&object)
+
+		if skip {
+			if object.Key < params.KeyMarker {
+				continue
+			}
+			if object.Key == params.KeyMarker {
+				if params.VersionIdMarker != "" && vid == params.VersionIdMarker {
+					skip = false
+				}
+				continue
+			}
+			skip = false
+		}
+
+		if params.Delimiter != "" {
+			len_pfx := len(params.Prefix)
+			pos := strings.Index(object.Key[len_pfx:], params.Delimiter)
+			if pos >= 0 {
+				prefixes_map[object.Key[:len_pfx+pos+len(params.Delimiter)]] = true
+				continue
+			}
+		}
+
+		if object.DelMarker {
+			list.DeleteMarker = append(list.DeleteMarker, swys3api.S3DeleteMarker {
+				Key:		object.Key,
+				VersionId:	vid,
+				IsLatest:	latest,
+				LastModified:	object.CreationTime,
+				Owner:		owner,
+			})
+		} else {
+			list.Version = append(list.Version, swys3api.S3ObjectVersion {
+				Key:		object.Key,
+				VersionId:	vid,
+				IsLatest:	latest,
+				LastModified:	object.CreationTime,
+				ETag:		object.ETag,
+				Size:		object.Size,
+				StorageClass:	swys3api.S3StorageClassStandard,
+				Owner:		owner,
+			})
+		}
+
+		count++
+		if count >= list.MaxKeys {
+			list.IsTruncated = true
+			list.NextKeyMarker = object.Key
+			list.NextVersionIdMarker = vid
+			break
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Errorf("s3: Can't list versions on %s: %s", infoLong(bucket), err.Error())
+		return nil, &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	keys := []string{ }
+	for k, _ := range prefixes_map {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		list.CommonPrefixes = append(list.CommonPrefixes,
+			swys3api.S3Prefix {
+				Prefix: k,
+			})
+	}
+
+	return &list, nil
+}
+
+func handleGetVersioning(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_GetBucketVersioning) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	HTTPRespXML(w, &swys3api.S3VersioningConfig{ Status: b.Versioning })
+	return nil
+}
+
+func handlePutVersioning(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	if !ctxMayAccess(ctx, bname) {
+		return &S3Error{ ErrorCode: S3ErrAccessDenied }
+	}
+	if !ctxAllowed(ctx, S3P_PutBucketVersioning) {
+		return &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrIncompleteBody }
+	}
+
+	var cfg swys3api.S3VersioningConfig
+
+	err = xml.Unmarshal(body, &cfg)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrMissingRequestBodyError }
+	}
+
+	switch cfg.Status {
+	case swys3api.S3VersioningEnabled, swys3api.S3VersioningSuspended:
+		;
+	default:
+		return &S3Error{ ErrorCode: S3ErrIllegalVersioningConfigurationException }
+	}
+
+	if cfg.MfaDelete == "Enabled" {
+		return &S3Error{
+			ErrorCode: S3ErrIllegalVersioningConfigurationException,
+			Message: "MFA delete is not supported",
+		}
+	}
+
+	b, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	err = s3SetVersioning(ctx, b, cfg.Status)
+	if err != nil {
+		log.Errorf("s3: Can't set versioning on %s: %s", infoLong(b), err.Error())
+		return &S3Error{ ErrorCode: S3ErrInternalError }
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func handleListVersions(ctx context.Context, bname string, w http.ResponseWriter, r *http.Request) *S3Error {
+	bucket, err := FindBucket(ctx, bname)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrNoSuchBucket }
+	}
+
+	if e := s3CheckAccess(ctx, r, bucket, "", S3P_ListBucketVersions); e != nil {
+		return e
+	}
+
+	params := &S3ListVersionsRP {
+		Prefix:			getURLValue(r, "prefix"),
+		Delimiter:		getURLValue(r, "delimiter"),
+		KeyMarker:		getURLValue(r, "key-marker"),
+		VersionIdMarker:	getURLValue(r, "version-id-marker"),
+	}
+
+	if v, ok := getURLParam(r, "max-keys"); ok {
+		params.MaxKeys, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	versions, e := s3ListVersions(ctx, bucket, params)
+	if e != nil { return e }
+
+	HTTPRespXML(w, versions)
+	return nil
+}
+
+/*
+ * Looks up the exact version for GET and HEAD. Asking for a delete
+ * marker is not allowed, the client only learns it hit one.
+ */
+func lookupObjectVersion(ctx context.Context, bucket *s3mgo.Bucket, oname, vid string, w http.ResponseWriter, r *http.Request) (*s3mgo.Object, *S3Error) {
+	object, err := FindObjectVersion(ctx, bucket, oname, vid)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			if e := s3CheckAccess(ctx, r, bucket, oname, S3P_GetObjectVersion); e != nil {
+				return nil, e
+			}
+			return nil, &S3Error{ ErrorCode: S3ErrNoSuchVersion }
+		}
+
+		log.Errorf("s3: Can't find object %s/%s on %s: %s", oname, vid, infoLong(bucket), err.Error())
+		return nil, &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+	}
+
+	if e := s3CheckVersionAccess(ctx, r, bucket, oname, object, S3P_GetObjectVersion); e != nil {
+		return nil, e
+	}
+
+	if object.DelMarker {
+		setVersionHeader(w, bucket, object)
+		return nil, &S3Error{ ErrorCode: S3ErrMethodNotAllowed }
+	}
+
+	return object, nil
+}
+
+func handleDeleteObjectVersion(ctx context.Context, vid, oname string, bucket *s3mgo.Bucket, w http.ResponseWriter, r *http.Request) *S3Error {
+	if e := s3CheckAccess(ctx, r, bucket, oname, S3P_DeleteObjectVersion); e != nil {
+		return e
+	}
+
+	object, err := s3DeleteObjectVersion(ctx, bucket, oname, vid)
+	if err != nil {
+		return &S3Error{ ErrorCode: S3ErrInvalidRequest, Message: err.Error() }
+	}
+
+	if object != nil {
+		w.Header().Set("x-amz-version-id", vid)
+		if object.DelMarker {
+			w.Header().Set("x-amz-delete-marker", "true")
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -7,6 +7,7 @@ package main
 
 import (
 	"context"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"path/filepath"
 	"net/http"
@@ -132,30 +133,51 @@ func handleWebReq(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	/*
+	 * Without the website config the request is anonymous and
+	 * only gets what bucket policy and ACLs grant to everyone
+	 */
+	iam := &s3mgo.Iam {
+		State:		S3StateActive,
+		AccountObjID:	account.ObjID, /* FIXME -- cache account object here
+						* to speed-up the s3AccountLookup()
+						*/
+	}
+
 	var ws S3Website
 	query = bson.M{ "bcookie": account.BCookie(aux[0]), "state": S3StateActive }
 	err = dbS3FindOne(ctx, query, &ws)
-	if err != nil {
-		http.Error(w, "", http.StatusNotFound)
+	website := (err == nil)
+	if website {
+		iam.Policy = *getWebPolicy(aux[0])
+	} else if err != mgo.ErrNotFound {
+		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
 
-	iam := &s3mgo.Iam {
-		State:		S3StateActive,
-		AccountObjID:	account.ObjID, /* FIXME -- cache account object here
-						* to speed-up the s3AccountLookup()
-						*/
-		Policy:		*getWebPolicy(aux[0]),
+	ctxAuthorize(ctx, iam)
+
+	if r.Method == http.MethodOptions {
+		if !s3CorsApply(ctx, w, r, aux[0]) {
+			http.Error(w, "", http.StatusForbidden)
+		}
+		return
 	}
 
+	s3CorsApply(ctx, w, r, aux[0])
+
 	oname := r.URL.Path[1:]
-	if oname == "" {
-		oname = ws.index()
-	} else {
-		if strings.HasSuffix(oname, "/") {
+	if website {
+		if oname == "" {
+			oname = ws.index()
+		} else if strings.HasSuffix(oname, "/") {
 			oname += ws.index()
 		}
 	}
+	if oname == "" {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
 
 	ext := filepath.Ext(oname)
 	if ext != "" {
@@ -167,9 +189,13 @@ func handleWebReq(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	ctxAuthorize(ctx, iam)
 	serr := handleObject(ctx, w, r, aux[0], oname)
 	if serr != nil {
+		if serr.ErrorCode == S3ErrAccessDenied {
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+
 		if serr.ErrorCode != S3ErrNoSuchKey {
 			http.Error(w, serr.Message, http.StatusInternalServerError)
 			return
@@ -12,6 +12,7 @@ import (
 	"encoding/csv"
 	"io/ioutil"
 	"net/http"
+	nurl "net/url"
 	"strings"
 	"strconv"
 	"errors"
@@ -656,6 +657,32 @@ func function_info(args []string, opts [16]string) {
 		fmt.Printf("Rate:        %d:%d\n", ifo.Size.Rate, ifo.Size.Burst)
 	}
 	fmt.Printf("Memory:      %dMi\n", ifo.Size.Memory)
+	if ifo.Size.Idle != 0 {
+		fmt.Printf("Idle:        %s to scale to zero\n", (time.Duration(ifo.Size.Idle) * time.Second).String())
+	}
+	if ifo.Size.Warm != 0 {
+		wu := ""
+		if ifo.Size.Warmup {
+			wu = " (with warmup)"
+		}
+		fmt.Printf("Warm:        %d pods%s\n", ifo.Size.Warm, wu)
+	}
+	if ifo.Size.MaxConc != 0 {
+		fmt.Printf("Concurrency: %d calls max\n", ifo.Size.MaxConc)
+	}
+	if ifo.Retry != nil {
+		fmt.Printf("Retry:       %d attempts, %d-%dms backoff", ifo.Retry.Attempts, ifo.Retry.Backoff, ifo.Retry.MaxBackoff)
+		if len(ifo.Retry.Codes) != 0 {
+			fmt.Printf(", codes %v", ifo.Retry.Codes)
+		}
+		fmt.Printf("\n")
+	}
+	if len(ifo.Aliases) != 0 {
+		fmt.Printf("Aliases:\n")
+		for a, v := range ifo.Aliases {
+			fmt.Printf("\t%s -> %s\n", a, v)
+		}
+	}
 	fmt.Printf("Called:      %d\n", ifo.Stats[0].Called)
 	if ifo.Stats[0].Called != 0 {
 		lc, _ := time.Parse(time.RFC1123Z, ifo.Stats[0].LastCall)
@@ -665,6 +692,9 @@ func function_info(args []string, opts [16]string) {
 		fmt.Printf("Time:        %d (avg %d) usec\n", ifo.Stats[0].Time, ifo.Stats[0].Time / ifo.Stats[0].Called)
 		fmt.Printf("GBS:         %f\n", ifo.Stats[0].GBS)
 	}
+	if cs := ifo.Stats[0].ColdStarts; cs != 0 {
+		fmt.Printf("Cold starts: %d (avg %d) usec\n", cs, ifo.Stats[0].ColdTime / cs)
+	}
 
 	if b := ifo.Stats[0].BytesOut; b != 0 {
 		fmt.Printf("Bytes sent:  %s\n", formatBytes(b))
@@ -732,6 +762,9 @@ func function_minfo(args []string, opts [16]string) {
 	if len(ifo.BR) != 0 {
 		fmt.Printf("BR: %d:%d -> %d\n", ifo.BR[0], ifo.BR[1], ifo.BR[2])
 	}
+	if ifo.Conc != nil {
+		fmt.Printf("Conc: %d running, %d queued, %d throttled\n", ifo.Conc.InFlight, ifo.Conc.Queued, ifo.Conc.Throttled)
+	}
 	if len(ifo.Hosts) != 0 {
 		fmt.Printf("PODs at %s\n", strings.Join(ifo.Hosts, " "))
 	}
@@ -862,6 +895,65 @@ func parse_rate(val string) (uint, uint) {
 	return uint(rate), uint(burst)
 }
 
+/* Parses attempts[:backoff[:max_backoff]] and comma-separated codes */
+func parse_idle(val string) uint {
+	if val == "0" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		fatal(fmt.Errorf("Bad idle value %s: %s", val, err.Error()))
+	}
+
+	return uint(d / time.Second)
+}
+
+/* Parses number[:warmup] */
+func parse_warm(val string) (uint, bool) {
+	x := strings.SplitN(val, ":", 2)
+	n, err := strconv.ParseUint(x[0], 10, 32)
+	if err != nil {
+		fatal(fmt.Errorf("Bad warm value %s: %s", val, err.Error()))
+	}
+
+	if len(x) == 2 && x[1] != "warmup" {
+		fatal(fmt.Errorf("Bad warm value %s, only :warmup can follow", val))
+	}
+
+	return uint(n), len(x) == 2
+}
+
+func parse_retry(val, codes string) *swyapi.FunctionRetry {
+	var rp swyapi.FunctionRetry
+
+	rl := strings.Split(val, ":")
+	vals := []*uint{&rp.Attempts, &rp.Backoff, &rp.MaxBackoff}
+	for i, v := range rl {
+		if i >= len(vals) {
+			fatal(fmt.Errorf("Bad retry value %s", val))
+		}
+
+		x, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			fatal(fmt.Errorf("Bad retry value %s: %s", v, err.Error()))
+		}
+		*vals[i] = uint(x)
+	}
+
+	if codes != "" {
+		for _, c := range strings.Split(codes, ",") {
+			x, err := strconv.Atoi(c)
+			if err != nil {
+				fatal(fmt.Errorf("Bad retry code %s: %s", c, err.Error()))
+			}
+			rp.Codes = append(rp.Codes, x)
+		}
+	}
+
+	return &rp
+}
+
 func isURL(s string) bool {
 	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
 }
@@ -940,6 +1032,18 @@ func function_add(args []string, opts [16]string) {
 		req.Size.Rate, req.Size.Burst = parse_rate(opts[5])
 	}
 
+	if opts[11] != "" {
+		req.Size.Idle = parse_idle(opts[11])
+	}
+
+	if opts[12] != "" {
+		req.Size.Warm, req.Size.Warmup = parse_warm(opts[12])
+	}
+
+	if opts[13] != "" {
+		req.Size.MaxConc = parse_uint("conc", opts[13])
+	}
+
 	if opts[6] != "" {
 		req.UserData = opts[6]
 	}
@@ -948,6 +1052,10 @@ func function_add(args []string, opts [16]string) {
 		req.AuthCtx = opts[8]
 	}
 
+	if opts[9] != "" {
+		req.Retry = parse_retry(opts[9], opts[10])
+	}
+
 	var fi swyapi.FunctionInfo
 	swyclient.Functions().Add(req, &fi)
 	fmt.Printf("Function %s created\n", fi.Id)
@@ -994,6 +1102,18 @@ func run_function(args []string, opts [16]string) {
 		rq.Method = &opts[1]
 	}
 
+	if opts[2] != "" || opts[3] != "" {
+		var inv swyapi.FunctionInvocation
+
+		rurl := "functions/" + args[0] + "/run?async=1"
+		if opts[3] != "" {
+			rurl += "&webhook=" + nurl.QueryEscape(opts[3])
+		}
+		swyclient.Req1("POST", rurl, http.StatusAccepted, rq, &inv)
+		fmt.Printf("Invocation %s %s\n", inv.Id, inv.Status)
+		return
+	}
+
 	swyclient.Req1("POST", "functions/" + args[0] + "/run", http.StatusOK, rq, &rres)
 
 	fmt.Printf("returned: %s\n", rres.Return)
@@ -1001,6 +1121,34 @@ func run_function(args []string, opts [16]string) {
 	fmt.Fprintf(os.Stderr, "%s", rres.Stderr)
 }
 
+func invocation_info(args []string, opts [16]string) {
+	if opts[0] != "" {
+		swyclient.Del("invocations/" + args[0], http.StatusOK)
+		return
+	}
+
+	var inv swyapi.FunctionInvocation
+	swyclient.Get("invocations/" + args[0], http.StatusOK, &inv)
+
+	fmt.Printf("Function:    %s\n", inv.Function)
+	fmt.Printf("Status:      %s\n", inv.Status)
+	fmt.Printf("Created:     %s\n", inv.Created)
+	if inv.Finished == "" {
+		return
+	}
+
+	fmt.Printf("Finished:    %s (%s)\n", inv.Finished,
+			(time.Duration(inv.Duration) * time.Microsecond).String())
+	if inv.Error != "" {
+		fmt.Printf("Error:       %s\n", inv.Error)
+		return
+	}
+	fmt.Printf("Code:        %d\n", inv.Code)
+	fmt.Printf("returned: %s\n", inv.Return)
+	fmt.Printf("%s", inv.Stdout)
+	fmt.Fprintf(os.Stderr, "%s", inv.Stderr)
+}
+
 func function_update(args []string, opts [16]string) {
 	fid, _ := swyclient.Functions().Resolve(curProj, args[0])
 
@@ -1054,8 +1202,9 @@ func function_update(args []string, opts [16]string) {
 		swyclient.Functions().Set(fid, "authctx", ac)
 	}
 
-	if opts[1] != "" || opts[2] != "" {
+	if opts[1] != "" || opts[2] != "" || opts[13] != "" || opts[14] != "" || opts[15] != "" {
 		sz := swyapi.FunctionSize{}
+		swyclient.Functions().Prop(fid, "size", &sz)
 
 		if opts[1] != "" {
 			x, err := strconv.ParseUint(opts[1], 10, 32)
@@ -1067,6 +1216,15 @@ func function_update(args []string, opts [16]string) {
 		if opts[2] != "" {
 			sz.Rate, sz.Burst = parse_rate(opts[2])
 		}
+		if opts[13] != "" {
+			sz.Idle = parse_idle(opts[13])
+		}
+		if opts[14] != "" {
+			sz.Warm, sz.Warmup = parse_warm(opts[14])
+		}
+		if opts[15] != "" {
+			sz.MaxConc = parse_uint("conc", opts[15])
+		}
 
 		swyclient.Functions().Set(fid, "size", &sz)
 	}
@@ -1076,6 +1234,10 @@ func function_update(args []string, opts [16]string) {
 		swyclient.Functions().Set(fid, "env", envs)
 	}
 
+	if opts[11] != "" {
+		swyclient.Functions().Set(fid, "retry", parse_retry(opts[11], opts[12]))
+	}
+
 }
 
 func function_del(args []string, opts [16]string) {
@@ -1106,6 +1268,7 @@ func event_add(args []string, opts [16]string) {
 	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
 	e := swyapi.FunctionEvent {
 		Name: args[1],
+		Alias: opts[5],
 	}
 
 	switch args[2] {
@@ -1113,6 +1276,14 @@ func event_add(args []string, opts [16]string) {
 		e.Cron = &swyapi.FunctionEventCron {
 			Tab: opts[0],
 			Args: split_args_string(opts[1]),
+			TZ: opts[6],
+			Overlap: opts[7],
+		}
+		if opts[8] != "" {
+			e.Cron.Jitter = parse_msec("jitter", opts[8])
+		}
+		if opts[9] != "" {
+			e.Cron.Catchup = parse_uint("catchup", opts[9])
 		}
 	case "s3":
 		e.S3 = &swyapi.FunctionEventS3 {
@@ -1123,6 +1294,26 @@ func event_add(args []string, opts [16]string) {
 		e.WS = &swyapi.FunctionEventWebsock {
 			MwName: opts[0],
 		}
+	case "mq":
+		e.MQ = &swyapi.FunctionEventMQ {
+			MwName: opts[0],
+			Queue: opts[1],
+			DLQ: opts[4],
+		}
+		if opts[2] != "" {
+			x, err := strconv.ParseUint(opts[2], 10, 32)
+			if err != nil {
+				fatal(fmt.Errorf("Bad prefetch value %s: %s", opts[2], err.Error()))
+			}
+			e.MQ.Prefetch = uint(x)
+		}
+		if opts[3] != "" {
+			x, err := strconv.ParseUint(opts[3], 10, 32)
+			if err != nil {
+				fatal(fmt.Errorf("Bad concurrency value %s: %s", opts[3], err.Error()))
+			}
+			e.MQ.Concurrency = uint(x)
+		}
 	case "url":
 		e.URL = "auto"
 	}
@@ -1142,14 +1333,40 @@ func event_info(args []string, opts [16]string) {
 		fmt.Printf("Name:          %s\n", e.Name)
 	}
 	fmt.Printf("Source:        %s\n", e.Source)
+	if e.Alias != "" {
+		fmt.Printf("Calls alias:   %s\n", e.Alias)
+	}
 	if e.Cron != nil {
 		fmt.Printf("Tab:           %s\n", e.Cron.Tab)
+		if e.Cron.TZ != "" {
+			fmt.Printf("Time zone:     %s\n", e.Cron.TZ)
+		}
 		fmt.Printf("Args:          %s\n", make_args_string(e.Cron.Args))
+		fmt.Printf("Overlap:       %s\n", e.Cron.Overlap)
+		if e.Cron.Jitter != 0 {
+			fmt.Printf("Jitter:        %s\n", time.Duration(e.Cron.Jitter) * time.Millisecond)
+		}
+		if e.Cron.Catchup != 0 {
+			fmt.Printf("Catch up:      %d runs\n", e.Cron.Catchup)
+		}
+		if e.Cron.Last != "" {
+			fmt.Printf("Last run:      %s (%s)\n", e.Cron.Last, e.Cron.Result)
+		}
+		fmt.Printf("Next run:      %s\n", e.Cron.Next)
 	}
 	if e.S3 != nil {
 		fmt.Printf("Bucket:        %s\n", e.S3.Bucket)
 		fmt.Printf("Ops:           %s\n", e.S3.Ops)
 	}
+	if e.MQ != nil {
+		fmt.Printf("Mware:         %s\n", e.MQ.MwName)
+		fmt.Printf("Queue:         %s\n", e.MQ.Queue)
+		fmt.Printf("Prefetch:      %d\n", e.MQ.Prefetch)
+		fmt.Printf("Concurrency:   %d\n", e.MQ.Concurrency)
+		if e.MQ.DLQ != "" {
+			fmt.Printf("Dead letters:  %s\n", e.MQ.DLQ)
+		}
+	}
 	if e.URL != "" {
 		fmt.Printf("URL:           %s\n", e.URL)
 	}
@@ -1205,6 +1422,296 @@ func function_logs(args []string, opts [16]string) {
 	}
 }
 
+func function_canary(args []string, opts [16]string) {
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	curl := "functions/" + args[0] + "/canary"
+
+	var ci swyapi.FunctionCanary
+	mkreq := func() *swyapi.FunctionCanary {
+		rq := &swyapi.FunctionCanary{}
+		if opts[1] != "" {
+			w, err := strconv.Atoi(opts[1])
+			if err != nil || w < 0 {
+				fatal(fmt.Errorf("Bad weight value %s", opts[1]))
+			}
+			rq.Weight = uint(w)
+		}
+		rq.Sticky = opts[2]
+		if opts[3] != "" {
+			/* calls:max_errors[:promote] */
+			x := strings.Split(opts[3], ":")
+			if len(x) < 2 {
+				fatal(fmt.Errorf("Bad auto value %s", opts[3]))
+			}
+			c, err := strconv.Atoi(x[0])
+			if err != nil || c <= 0 {
+				fatal(fmt.Errorf("Bad calls number %s", x[0]))
+			}
+			e, err := strconv.Atoi(x[1])
+			if err != nil || e < 0 {
+				fatal(fmt.Errorf("Bad errors percentage %s", x[1]))
+			}
+			rq.Auto = &swyapi.FunctionCanaryAuto{Calls: uint(c), MaxErrors: uint(e)}
+			rq.Auto.Promote = (len(x) > 2 && x[2] == "promote")
+		}
+		return rq
+	}
+
+	switch args[1] {
+	case "info":
+		swyclient.Get(curl, http.StatusOK, &ci)
+	case "start":
+		if opts[0] == "" {
+			fatal(fmt.Errorf("Canary needs -src"))
+		}
+		rq := mkreq()
+		rq.Sources = &swyapi.FunctionSources{}
+		getSrc(opts[0], rq.Sources)
+		swyclient.Req1("POST", curl, http.StatusOK, rq, &ci)
+	case "set":
+		swyclient.Req1("PUT", curl, http.StatusOK, mkreq(), &ci)
+	case "promote":
+		swyclient.Req1("POST", curl + "/promote", http.StatusOK, nil, nil)
+		return
+	case "rollback":
+		swyclient.Del(curl, http.StatusOK)
+		return
+	default:
+		fatal(fmt.Errorf("Unknown canary action %s", args[1]))
+	}
+
+	fmt.Printf("Version:     %s\n", ci.Version)
+	fmt.Printf("Weight:      %d%%\n", ci.Weight)
+	if ci.Sticky != "" {
+		fmt.Printf("Sticky by:   %s\n", ci.Sticky)
+	}
+	if ci.Auto != nil {
+		act := "keep"
+		if ci.Auto.Promote {
+			act = "promote"
+		}
+		fmt.Printf("Auto:        after %d calls rollback if >%d%% errors, else %s\n",
+				ci.Auto.Calls, ci.Auto.MaxErrors, act)
+	}
+	if ci.Stats != nil {
+		fmt.Printf("Called:      %d (%d errors)\n", ci.Stats.Called, ci.Stats.Errors)
+	}
+}
+
+func function_versions(args []string, opts [16]string) {
+	var res []swyapi.FunctionVersion
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Get("functions/" + args[0] + "/versions", http.StatusOK, &res)
+
+	for _, v := range res {
+		fmt.Printf("%-12s%36s  %s\n", v.Version, v.Published, strings.Join(v.Aliases, ","))
+	}
+}
+
+func function_version_publish(args []string, opts [16]string) {
+	var vi swyapi.FunctionVersion
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Req1("POST", "functions/" + args[0] + "/versions", http.StatusOK,
+			&swyapi.FunctionVersion{Version: opts[0]}, &vi)
+	fmt.Printf("Version %s published\n", vi.Version)
+}
+
+func function_version_del(args []string, opts [16]string) {
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Del("functions/" + args[0] + "/versions/" + args[1], http.StatusOK)
+}
+
+func function_aliases(args []string, opts [16]string) {
+	var res []swyapi.FunctionAlias
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Get("functions/" + args[0] + "/aliases", http.StatusOK, &res)
+
+	for _, a := range res {
+		fmt.Printf("%-20s -> %s\n", a.Name, a.Version)
+	}
+}
+
+func function_alias_set(args []string, opts [16]string) {
+	var res []swyapi.FunctionAlias
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	curl := "functions/" + args[0] + "/aliases"
+	swyclient.Get(curl, http.StatusOK, &res)
+
+	al := swyapi.FunctionAlias{Name: args[1], Version: args[2]}
+	for _, a := range res {
+		if a.Name == args[1] {
+			swyclient.Req1("PUT", curl + "/" + args[1], http.StatusOK, &al, nil)
+			return
+		}
+	}
+
+	swyclient.Req1("POST", curl, http.StatusOK, &al, nil)
+}
+
+func function_alias_del(args []string, opts [16]string) {
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Del("functions/" + args[0] + "/aliases/" + args[1], http.StatusOK)
+}
+
+func parse_uint(what, val string) uint {
+	x, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		fatal(fmt.Errorf("Bad %s value %s: %s", what, val, err.Error()))
+	}
+
+	return uint(x)
+}
+
+func parse_msec(what, val string) uint {
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		fatal(fmt.Errorf("Bad %s value %s: %s", what, val, err.Error()))
+	}
+
+	return uint(d / time.Millisecond)
+}
+
+func function_scaling(args []string, opts [16]string) {
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+
+	if opts[0] != "" || opts[1] != "" || opts[2] != "" || opts[3] != "" || opts[4] != "" {
+		sz := swyapi.FunctionSize{}
+		swyclient.Functions().Prop(args[0], "size", &sz)
+
+		if opts[0] == "-" {
+			sz.Scale = nil
+		} else {
+			if sz.Scale == nil {
+				sz.Scale = &swyapi.FunctionScaling{}
+			}
+			if opts[0] != "" {
+				sz.Scale.Target = parse_uint("target", opts[0])
+			}
+			if opts[1] != "" {
+				sz.Scale.Min = parse_uint("min", opts[1])
+			}
+			if opts[2] != "" {
+				sz.Scale.Max = parse_uint("max", opts[2])
+			}
+			if opts[3] != "" {
+				sz.Scale.UpWindow = parse_msec("upw", opts[3])
+			}
+			if opts[4] != "" {
+				sz.Scale.DownWindow = parse_msec("downw", opts[4])
+			}
+		}
+
+		swyclient.Functions().Set(args[0], "size", &sz)
+		return
+	}
+
+	var sz swyapi.FunctionSize
+	swyclient.Functions().Prop(args[0], "size", &sz)
+	if sc := sz.Scale; sc != nil {
+		fmt.Printf("Target:      %d calls in flight per pod\n", sc.Target)
+		fmt.Printf("Replicas:    %d-%d\n", sc.Min, sc.Max)
+		fmt.Printf("Windows:     up %s, down %s\n",
+				(time.Duration(sc.UpWindow) * time.Millisecond).String(),
+				(time.Duration(sc.DownWindow) * time.Millisecond).String())
+	} else {
+		fmt.Printf("Default scaling\n")
+	}
+
+	var st swyapi.FunctionStatsResp
+	swyclient.Get("functions/" + args[0] + "/stats", http.StatusOK, &st)
+	if sc := st.Scaling; sc != nil {
+		fmt.Printf("In flight:   %d\n", sc.InFlight)
+		fmt.Printf("Goal:        %d\n", sc.Goal)
+		fmt.Printf("Running:     %d\n", sc.Replicas)
+		if len(sc.Decisions) != 0 {
+			fmt.Printf("Decisions:\n")
+			for _, d := range sc.Decisions {
+				fmt.Printf("\t%s: %d -> %d (%s)\n", d.Ts, d.From, d.To, d.Why)
+			}
+		}
+	}
+	if cc := st.Concurrency; cc != nil {
+		fmt.Printf("Concurrency: %d running, %d queued, %d throttled\n", cc.InFlight, cc.Queued, cc.Throttled)
+	}
+}
+
+func function_dlq_list(args []string, opts [16]string) {
+	var res []swyapi.FunctionDLQEntry
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Get("functions/" + args[0] + "/dlq", http.StatusOK, &res)
+
+	for _, de := range res {
+		ev := de.Event
+		if de.Alias != "" {
+			ev += "@" + de.Alias
+		}
+		fmt.Printf("%s %36s%12s (%d attempts): %s\n", de.Id, de.Ts, ev, de.Attempts, de.Reason)
+		if de.Args != nil && len(de.Args.Args) != 0 {
+			fmt.Printf("\t%s\n", make_args_string(de.Args.Args))
+		}
+	}
+}
+
+func function_dlq_replay(args []string, opts [16]string) {
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+
+	fa := []string{}
+	if opts[0] != "" {
+		fa = append(fa, "id=" + opts[0])
+	}
+
+	swyclient.Req1("POST", url("functions/" + args[0] + "/dlq/replay", fa), http.StatusOK, nil, nil)
+}
+
+func function_dlq_purge(args []string, opts [16]string) {
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+
+	fa := []string{}
+	if opts[0] != "" {
+		fa = append(fa, "id=" + opts[0])
+	}
+
+	swyclient.Del(url("functions/" + args[0] + "/dlq", fa), http.StatusOK)
+}
+
+func function_delayed_list(args []string, opts [16]string) {
+	var res []swyapi.FunctionDelayed
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Get("functions/" + args[0] + "/delayed", http.StatusOK, &res)
+
+	for _, dc := range res {
+		fn := "-"
+		if dc.Alias != "" {
+			fn = "@" + dc.Alias
+		}
+		fmt.Printf("%s %36s %8s %s %s\n", dc.Id, dc.At, dc.Status, fn, make_args_string(dc.Args))
+	}
+}
+
+func function_delayed_add(args []string, opts [16]string) {
+	var dc swyapi.FunctionDelayed
+
+	rq := &swyapi.FunctionDelayed{Alias: opts[2]}
+
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	if len(args) > 1 {
+		rq.Args = split_args_string(args[1])
+	}
+
+	if opts[0] != "" {
+		rq.Delay = parse_msec("delay", opts[0])
+	}
+	rq.At = opts[1]
+
+	swyclient.Req1("POST", "functions/" + args[0] + "/delayed", http.StatusOK, rq, &dc)
+	fmt.Printf("Delayed call %s at %s\n", dc.Id, dc.At)
+}
+
+func function_delayed_del(args []string, opts [16]string) {
+	args[0], _ = swyclient.Functions().Resolve(curProj, args[0])
+	swyclient.Del("functions/" + args[0] + "/delayed/" + args[1], http.StatusOK)
+}
+
 func url(url string, args []string) string {
 	if len(args) != 0 {
 		url += "?" + strings.Join(args, "&")
@@ -1430,6 +1937,139 @@ func router_del(args []string, opts [16]string) {
 	swyclient.Routers().Del(args[0])
 }
 
+func parse_workflow_file(fname string, def interface{}) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		fatal(err)
+	}
+	/* JSON is YAML too */
+	err = yaml.Unmarshal(data, def)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func workflow_list(args []string, opts [16]string) {
+	var wfs []swyapi.WorkflowInfo
+	ua := []string{}
+	if curProj != "" {
+		ua = append(ua, "project=" + curProj)
+	}
+	swyclient.Workflows().List(ua, &wfs)
+	for _, wf := range wfs {
+		fmt.Printf("%s %12s (%s)\n", wf.Id, wf.Name, strings.Join(wf.Labels, ","))
+	}
+}
+
+func workflow_add(args []string, opts [16]string) {
+	if opts[0] == "" {
+		fatal(fmt.Errorf("No definition file"))
+	}
+
+	var wa swyapi.WorkflowAdd
+	parse_workflow_file(opts[0], &wa)
+	wa.Name = args[0]
+	wa.Project = curProj
+
+	var wi swyapi.WorkflowInfo
+	swyclient.Workflows().Add(&wa, &wi)
+	fmt.Printf("Workflow %s created\n", wi.Id)
+}
+
+func show_workflow_def(def *swyapi.WorkflowDef, pfx string) {
+	fmt.Printf("%sStart:    %s\n", pfx, def.Start)
+	for n, st := range def.States {
+		fmt.Printf("%s  %-16s %-8s", pfx, n, st.Type)
+		if st.Function != "" {
+			fmt.Printf(" %s", st.Function)
+		}
+		if st.Next != "" {
+			fmt.Printf(" -> %s", st.Next)
+		}
+		if st.Catch != "" {
+			fmt.Printf(" (catch -> %s)", st.Catch)
+		}
+		fmt.Printf("\n")
+		for _, b := range st.Branches {
+			show_workflow_def(b, pfx + "    ")
+		}
+		if st.Iterator != nil {
+			show_workflow_def(st.Iterator, pfx + "    ")
+		}
+	}
+}
+
+func workflow_info(args []string, opts [16]string) {
+	args[0], _ = swyclient.Workflows().Resolve(curProj, args[0])
+	var wi swyapi.WorkflowInfo
+	swyclient.Get("workflows/" + args[0] + "?details=1", http.StatusOK, &wi)
+	fmt.Printf("Name:     %s\n", wi.Name)
+	if wi.Def != nil {
+		show_workflow_def(wi.Def, "")
+	}
+}
+
+func workflow_upd(args []string, opts [16]string) {
+	args[0], _ = swyclient.Workflows().Resolve(curProj, args[0])
+	if opts[0] != "" {
+		var def swyapi.WorkflowDef
+		parse_workflow_file(opts[0], &def)
+		swyclient.Workflows().Set(args[0], "", &def)
+	}
+}
+
+func workflow_del(args []string, opts [16]string) {
+	args[0], _ = swyclient.Workflows().Resolve(curProj, args[0])
+	swyclient.Workflows().Del(args[0])
+}
+
+func workflow_run(args []string, opts [16]string) {
+	args[0], _ = swyclient.Workflows().Resolve(curProj, args[0])
+	var ri swyapi.WorkflowRunInfo
+	swyclient.WorkflowRuns(args[0]).Add(&swyapi.WorkflowRunStart{Input: opts[0]}, &ri)
+	fmt.Printf("Run %s started\n", ri.Id)
+}
+
+func workflow_run_list(args []string, opts [16]string) {
+	args[0], _ = swyclient.Workflows().Resolve(curProj, args[0])
+	var res []swyapi.WorkflowRunInfo
+	swyclient.WorkflowRuns(args[0]).List([]string{}, &res)
+	for _, ri := range res {
+		fmt.Printf("%s %10s %36s %s\n", ri.Id, ri.Status, ri.Started, ri.State)
+	}
+}
+
+func workflow_run_info(args []string, opts [16]string) {
+	args[0], _ = swyclient.Workflows().Resolve(curProj, args[0])
+	var ri swyapi.WorkflowRunInfo
+	swyclient.WorkflowRuns(args[0]).Get(args[1], &ri)
+	fmt.Printf("Status:   %s\n", ri.Status)
+	fmt.Printf("Started:  %s\n", ri.Started)
+	if ri.Finished != "" {
+		fmt.Printf("Finished: %s\n", ri.Finished)
+	}
+	if ri.State != "" {
+		fmt.Printf("State:    %s\n", ri.State)
+	}
+	if ri.Output != "" {
+		fmt.Printf("Output:   %s\n", ri.Output)
+	}
+	if ri.Error != "" {
+		fmt.Printf("Error:    %s\n", ri.Error)
+	}
+	if len(ri.History) != 0 {
+		fmt.Printf("History:\n")
+		for _, h := range ri.History {
+			fmt.Printf("  %s %-24s %-10s %s\n", h.Ts, h.State, h.Event, h.Info)
+		}
+	}
+}
+
+func workflow_run_del(args []string, opts [16]string) {
+	args[0], _ = swyclient.Workflows().Resolve(curProj, args[0])
+	swyclient.WorkflowRuns(args[0]).Del(args[1])
+}
+
 func repo_list(args []string, opts [16]string) {
 	var ris []*swyapi.RepoInfo
 	ua := []string{}
@@ -1698,6 +2338,22 @@ func s3_access(args []string, opts [16]string) {
 	fmt.Printf("AccID:   %s\n", creds.AccID)
 }
 
+func s3_presign(args []string, opts [16]string) {
+	ps := swyapi.S3Presign {
+		Bucket:		args[0],
+		Object:		args[1],
+		Method:		opts[0],
+		Expires:	uint32(parse_uint("life", opts[1])),
+	}
+
+	var res swyapi.S3PresignedURL
+
+	swyclient.Req1("POST", "s3/presign", http.StatusOK, &ps, &res)
+
+	fmt.Printf("URL:     %s\n", res.URL)
+	fmt.Printf("Expires: %s\n", res.Expires)
+}
+
 func languages(args []string, opts [16]string) {
 	var ls []string
 	swyclient.Req1("GET", "info/langs", http.StatusOK, nil, &ls)
@@ -1902,6 +2558,7 @@ const (
 	CMD_PS string		= "ps"
 
 	CMD_RUN string		= "run"
+	CMD_INV string		= "inv"
 
 	CMD_FL string		= "fl"
 	CMD_FT string		= "ft"
@@ -1915,6 +2572,20 @@ const (
 	CMD_FON string		= "fon"
 	CMD_FOFF string		= "foff"
 	CMD_FW string		= "fw"
+	CMD_FDL string		= "fdl"
+	CMD_FDR string		= "fdr"
+	CMD_FDP string		= "fdp"
+	CMD_FSL string		= "fsl"
+	CMD_FSA string		= "fsa"
+	CMD_FSD string		= "fsd"
+	CMD_FCAN string		= "fcan"
+	CMD_FVL string		= "fvl"
+	CMD_FVP string		= "fvp"
+	CMD_FVD string		= "fvd"
+	CMD_FAL string		= "fal"
+	CMD_FAS string		= "fas"
+	CMD_FAD string		= "fad"
+	CMD_FSC string		= "fsc"
 
 	CMD_EL string		= "el"
 	CMD_EI string		= "ei"
@@ -1927,6 +2598,7 @@ const (
 	CMD_MD string		= "md"
 
 	CMD_S3ACC string	= "s3acc"
+	CMD_S3PS string		= "s3ps"
 	CMD_AUTH string		= "auth"
 
 	CMD_DL string		= "dl"
@@ -1940,6 +2612,16 @@ const (
 	CMD_RTU string		= "rtu"
 	CMD_RTD string		= "rtd"
 
+	CMD_WFL string		= "wfl"
+	CMD_WFI string		= "wfi"
+	CMD_WFA string		= "wfa"
+	CMD_WFU string		= "wfu"
+	CMD_WFD string		= "wfd"
+	CMD_WFR string		= "wfr"
+	CMD_WFRL string		= "wfrl"
+	CMD_WFRI string		= "wfri"
+	CMD_WFRD string		= "wfrd"
+
 	CMD_RL string		= "rl"
 	CMD_RI string		= "ri"
 	CMD_RA string		= "ra"
@@ -1991,6 +2673,7 @@ var cmdOrder = []string {
 	CMD_PS,
 
 	CMD_RUN,
+	CMD_INV,
 
 	CMD_FL,
 	CMD_FI,
@@ -2004,6 +2687,20 @@ var cmdOrder = []string {
 	CMD_FLOG,
 	CMD_FCOD,
 	CMD_FT,
+	CMD_FDL,
+	CMD_FDR,
+	CMD_FDP,
+	CMD_FSL,
+	CMD_FSA,
+	CMD_FSD,
+	CMD_FCAN,
+	CMD_FVL,
+	CMD_FVP,
+	CMD_FVD,
+	CMD_FAL,
+	CMD_FAS,
+	CMD_FAD,
+	CMD_FSC,
 
 	CMD_EL,
 	CMD_EI,
@@ -2016,6 +2713,7 @@ var cmdOrder = []string {
 	CMD_MD,
 
 	CMD_S3ACC,
+	CMD_S3PS,
 	CMD_AUTH,
 
 	CMD_DL,
@@ -2029,6 +2727,16 @@ var cmdOrder = []string {
 	CMD_RTU,
 	CMD_RTD,
 
+	CMD_WFL,
+	CMD_WFI,
+	CMD_WFA,
+	CMD_WFU,
+	CMD_WFD,
+	CMD_WFR,
+	CMD_WFRL,
+	CMD_WFRI,
+	CMD_WFRD,
+
 	CMD_RL,
 	CMD_RI,
 	CMD_RA,
@@ -2125,11 +2833,26 @@ var cmdMap = map[string]*cmdDesc {
 	CMD_FD:		&cmdDesc{ help: "Del function",		call: function_del,	wp: true },
 	CMD_FU:		&cmdDesc{ help: "Update function",	call: function_update,	wp: true },
 	CMD_RUN:	&cmdDesc{ help: "Run function code",	call: run_function,	wp: true },
+	CMD_INV:	&cmdDesc{ help: "Show async invocation",	call: invocation_info,	  },
 	CMD_FLOG:	&cmdDesc{ help: "Show fn logs",		call: function_logs,	wp: true },
 	CMD_FCOD:	&cmdDesc{ help: "Show fn code",		call: function_code,	wp: true },
 	CMD_FON:	&cmdDesc{ help: "Activate fn",		call: function_on,	wp: true },
 	CMD_FOFF:	&cmdDesc{ help: "Deactivate fn",	call: function_off,	wp: true },
 	CMD_FW:		&cmdDesc{ help: "Wait something on fn",	call: function_wait,	wp: true },
+	CMD_FDL:	&cmdDesc{ help: "List fn dead letters",	call: function_dlq_list,	wp: true },
+	CMD_FDR:	&cmdDesc{ help: "Replay fn dead letters",	call: function_dlq_replay,	wp: true },
+	CMD_FDP:	&cmdDesc{ help: "Purge fn dead letters",	call: function_dlq_purge,	wp: true },
+	CMD_FSL:	&cmdDesc{ help: "List fn delayed calls",	call: function_delayed_list,	wp: true },
+	CMD_FSA:	&cmdDesc{ help: "Delay fn call",	call: function_delayed_add,	wp: true },
+	CMD_FSD:	&cmdDesc{ help: "Cancel fn delayed call",	call: function_delayed_del,	wp: true },
+	CMD_FCAN:	&cmdDesc{ help: "Manage fn canary",	call: function_canary,	wp: true },
+	CMD_FVL:	&cmdDesc{ help: "List fn published versions",	call: function_versions,	wp: true },
+	CMD_FVP:	&cmdDesc{ help: "Publish fn version",	call: function_version_publish,	wp: true },
+	CMD_FVD:	&cmdDesc{ help: "Unpublish fn version",	call: function_version_del,	wp: true },
+	CMD_FAL:	&cmdDesc{ help: "List fn aliases",	call: function_aliases,	wp: true },
+	CMD_FAS:	&cmdDesc{ help: "Set fn alias",		call: function_alias_set,	wp: true },
+	CMD_FAD:	&cmdDesc{ help: "Del fn alias",		call: function_alias_del,	wp: true },
+	CMD_FSC:	&cmdDesc{ help: "Show/set fn autoscaling",	call: function_scaling,	wp: true },
 
 	CMD_EL:		&cmdDesc{ help: "List fn triggers",	call: event_list,	wp: true },
 	CMD_EA:		&cmdDesc{ help: "Add fn trigger",	call: event_add,	wp: true },
@@ -2153,6 +2876,16 @@ var cmdMap = map[string]*cmdDesc {
 	CMD_RTU:	&cmdDesc{ help: "Update router",	call: router_upd,	wp: true },
 	CMD_RTD:	&cmdDesc{ help: "Del router",		call: router_del,	wp: true },
 
+	CMD_WFL:	&cmdDesc{ help: "List workflows",	call: workflow_list,	wp: true },
+	CMD_WFI:	&cmdDesc{ help: "Show workflow info",	call: workflow_info,	wp: true },
+	CMD_WFA:	&cmdDesc{ help: "Add workflow",		call: workflow_add,	wp: true },
+	CMD_WFU:	&cmdDesc{ help: "Update workflow",	call: workflow_upd,	wp: true },
+	CMD_WFD:	&cmdDesc{ help: "Del workflow",		call: workflow_del,	wp: true },
+	CMD_WFR:	&cmdDesc{ help: "Run workflow",		call: workflow_run,	wp: true },
+	CMD_WFRL:	&cmdDesc{ help: "List workflow runs",	call: workflow_run_list, wp: true },
+	CMD_WFRI:	&cmdDesc{ help: "Show workflow run",	call: workflow_run_info, wp: true },
+	CMD_WFRD:	&cmdDesc{ help: "Del workflow run",	call: workflow_run_del,	wp: true },
+
 	CMD_RL:		&cmdDesc{ help: "List repositories",	call: repo_list		},
 	CMD_RI:		&cmdDesc{ help: "Show repo info",	call: repo_info		},
 	CMD_RA:		&cmdDesc{ help: "Add repo",		call: repo_add		},
@@ -2193,6 +2926,7 @@ var cmdMap = map[string]*cmdDesc {
 	CMD_TD:		&cmdDesc{ help: "Del plan",		call: tplan_del,	adm: true },
 
 	CMD_S3ACC:	&cmdDesc{ help: "Get S3 access",	call: s3_access		},
+	CMD_S3PS:	&cmdDesc{ help: "Presign S3 URL",	call: s3_presign	},
 
 	CMD_LANGS:	&cmdDesc{ help: "Show supported languages",	call: languages		},
 	CMD_MTYPES:	&cmdDesc{ help: "Show supported mwares",	call: mware_types	},
@@ -2256,9 +2990,18 @@ func main() {
 	cmdMap[CMD_FA].opts.StringVar(&opts[6], "data", "", "Any text associated with fn")
 	cmdMap[CMD_FA].opts.StringVar(&opts[7], "env", "", "Colon-separated list of env vars")
 	cmdMap[CMD_FA].opts.StringVar(&opts[8], "auth", "", "ID of auth mware to verify the call")
+	cmdMap[CMD_FA].opts.StringVar(&opts[9], "retry", "", "Retry bg calls (attempts[:backoff[:max_backoff]])")
+	cmdMap[CMD_FA].opts.StringVar(&opts[10], "rcodes", "", "Return codes to retry, comma-separated")
+	cmdMap[CMD_FA].opts.StringVar(&opts[11], "idle", "", "Scale to zero after being idle that long (e.g. 10m)")
+	cmdMap[CMD_FA].opts.StringVar(&opts[12], "warm", "", "Pods to keep running (number[:warmup])")
+	cmdMap[CMD_FA].opts.StringVar(&opts[13], "conc", "", "Max calls running at the same time")
 	setupCommonCmd(CMD_RUN, "NAME", "ARG=VAL,...")
 	cmdMap[CMD_RUN].opts.StringVar(&opts[0], "src", "", "Run a custom source in it")
 	cmdMap[CMD_RUN].opts.StringVar(&opts[1], "method", "", "Run method")
+	cmdMap[CMD_RUN].opts.StringVar(&opts[2], "async", "", "Run asynchronously (any value)")
+	cmdMap[CMD_RUN].opts.StringVar(&opts[3], "webhook", "", "URL to POST async result to")
+	setupCommonCmd(CMD_INV, "ID")
+	cmdMap[CMD_INV].opts.StringVar(&opts[0], "del", "", "Remove the invocation (any value)")
 	setupCommonCmd(CMD_FU, "NAME")
 	cmdMap[CMD_FU].opts.StringVar(&opts[0], "src", "", "Source file")
 	cmdMap[CMD_FU].opts.StringVar(&opts[1], "tmo", "", "Timeout")
@@ -2269,6 +3012,11 @@ func main() {
 	cmdMap[CMD_FU].opts.StringVar(&opts[8], "s3b", "", "Bucket to use, +/- to add/remove")
 	cmdMap[CMD_FU].opts.StringVar(&opts[9], "acc", "", "Accounts to use, +/- to add/remove")
 	cmdMap[CMD_FU].opts.StringVar(&opts[10], "env", "", "Colon-separated list of env vars")
+	cmdMap[CMD_FU].opts.StringVar(&opts[11], "retry", "", "Retry bg calls (attempts[:backoff[:max_backoff]], 0 for off)")
+	cmdMap[CMD_FU].opts.StringVar(&opts[12], "rcodes", "", "Return codes to retry, comma-separated")
+	cmdMap[CMD_FU].opts.StringVar(&opts[13], "idle", "", "Scale to zero after being idle that long (0 for never)")
+	cmdMap[CMD_FU].opts.StringVar(&opts[14], "warm", "", "Pods to keep running (number[:warmup], 0 for none)")
+	cmdMap[CMD_FU].opts.StringVar(&opts[15], "conc", "", "Max calls running at the same time (0 for unlimited)")
 	setupCommonCmd(CMD_FD, "NAME")
 	setupCommonCmd(CMD_FLOG, "NAME")
 	cmdMap[CMD_FLOG].opts.StringVar(&opts[0], "last", "", "Last N 'duration' period")
@@ -2279,6 +3027,35 @@ func main() {
 	setupCommonCmd(CMD_FW, "NAME")
 	cmdMap[CMD_FW].opts.StringVar(&opts[0], "version", "", "Version")
 	cmdMap[CMD_FW].opts.StringVar(&opts[1], "tmo", "", "Timeout")
+	setupCommonCmd(CMD_FDL, "NAME")
+	setupCommonCmd(CMD_FDR, "NAME")
+	cmdMap[CMD_FDR].opts.StringVar(&opts[0], "id", "", "Replay only this entry")
+	setupCommonCmd(CMD_FDP, "NAME")
+	cmdMap[CMD_FDP].opts.StringVar(&opts[0], "id", "", "Purge only this entry")
+	setupCommonCmd(CMD_FSL, "NAME")
+	setupCommonCmd(CMD_FSA, "NAME", "ARG=VAL,...")
+	cmdMap[CMD_FSA].opts.StringVar(&opts[0], "in", "", "Delay (duration)")
+	cmdMap[CMD_FSA].opts.StringVar(&opts[1], "at", "", "Time (RFC3339 or RFC1123Z)")
+	cmdMap[CMD_FSA].opts.StringVar(&opts[2], "alias", "", "Call this alias")
+	setupCommonCmd(CMD_FSD, "NAME", "ID")
+	setupCommonCmd(CMD_FCAN, "NAME", "ACTION")
+	cmdMap[CMD_FCAN].opts.StringVar(&opts[0], "src", "", "Canary source file (start)")
+	cmdMap[CMD_FCAN].opts.StringVar(&opts[1], "w", "", "Percent of calls to canary")
+	cmdMap[CMD_FCAN].opts.StringVar(&opts[2], "sticky", "", "Bind calls by header:$name or claim:$name")
+	cmdMap[CMD_FCAN].opts.StringVar(&opts[3], "auto", "", "Auto decision (calls:max_errors[:promote])")
+	setupCommonCmd(CMD_FVL, "NAME")
+	setupCommonCmd(CMD_FVP, "NAME")
+	cmdMap[CMD_FVP].opts.StringVar(&opts[0], "ver", "", "Version to publish (current by default)")
+	setupCommonCmd(CMD_FVD, "NAME", "VERSION")
+	setupCommonCmd(CMD_FAL, "NAME")
+	setupCommonCmd(CMD_FAS, "NAME", "ALIAS", "VERSION")
+	setupCommonCmd(CMD_FAD, "NAME", "ALIAS")
+	setupCommonCmd(CMD_FSC, "NAME")
+	cmdMap[CMD_FSC].opts.StringVar(&opts[0], "target", "", "Calls in flight per pod (- for default scaling)")
+	cmdMap[CMD_FSC].opts.StringVar(&opts[1], "min", "", "Min replicas")
+	cmdMap[CMD_FSC].opts.StringVar(&opts[2], "max", "", "Max replicas")
+	cmdMap[CMD_FSC].opts.StringVar(&opts[3], "upw", "", "Scale up window (duration)")
+	cmdMap[CMD_FSC].opts.StringVar(&opts[4], "downw", "", "Scale down window (duration)")
 
 	setupCommonCmd(CMD_EL, "NAME")
 	setupCommonCmd(CMD_EA, "NAME", "ENAME", "SRC")
@@ -2287,6 +3064,16 @@ func main() {
 	cmdMap[CMD_EA].opts.StringVar(&opts[0], "buck", "", "S3 bucket")
 	cmdMap[CMD_EA].opts.StringVar(&opts[1], "ops", "", "S3 ops")
 	cmdMap[CMD_EA].opts.StringVar(&opts[0], "wsid", "", "Websock mware id")
+	cmdMap[CMD_EA].opts.StringVar(&opts[0], "mqid", "", "Rabbit mware name")
+	cmdMap[CMD_EA].opts.StringVar(&opts[1], "queue", "", "MQ queue name")
+	cmdMap[CMD_EA].opts.StringVar(&opts[2], "prefetch", "", "MQ prefetch count")
+	cmdMap[CMD_EA].opts.StringVar(&opts[3], "conc", "", "MQ concurrency")
+	cmdMap[CMD_EA].opts.StringVar(&opts[4], "dlq", "", "MQ dead-letter queue")
+	cmdMap[CMD_EA].opts.StringVar(&opts[5], "alias", "", "Call fn@alias")
+	cmdMap[CMD_EA].opts.StringVar(&opts[6], "tz", "", "Cron time zone (e.g. Europe/Tallinn)")
+	cmdMap[CMD_EA].opts.StringVar(&opts[7], "overlap", "", "Cron overlap policy (allow, skip, queue)")
+	cmdMap[CMD_EA].opts.StringVar(&opts[8], "jitter", "", "Cron max random delay (duration)")
+	cmdMap[CMD_EA].opts.StringVar(&opts[9], "catchup", "", "Cron runs missed on downtime to do")
 	setupCommonCmd(CMD_EI, "NAME", "ENAME")
 	setupCommonCmd(CMD_ED, "NAME", "ENAME")
 
@@ -2300,6 +3087,9 @@ func main() {
 
 	setupCommonCmd(CMD_S3ACC, "BUCKET")
 	cmdMap[CMD_S3ACC].opts.StringVar(&opts[0], "life", "60", "Lifetime (default 1 min)")
+	setupCommonCmd(CMD_S3PS, "BUCKET", "OBJECT")
+	cmdMap[CMD_S3PS].opts.StringVar(&opts[0], "method", "GET", "Method (GET, PUT or HEAD)")
+	cmdMap[CMD_S3PS].opts.StringVar(&opts[1], "life", "3600", "Lifetime in seconds")
 	setupCommonCmd(CMD_AUTH, "ACTION")
 	cmdMap[CMD_AUTH].opts.StringVar(&opts[0], "name", "", "Name for auth")
 
@@ -2319,6 +3109,19 @@ func main() {
 	cmdMap[CMD_RTU].opts.StringVar(&opts[0], "table", "", "New table to set")
 	setupCommonCmd(CMD_RTD, "NAME")
 
+	setupCommonCmd(CMD_WFL)
+	setupCommonCmd(CMD_WFI, "NAME")
+	setupCommonCmd(CMD_WFA, "NAME")
+	cmdMap[CMD_WFA].opts.StringVar(&opts[0], "src", "", "Definition file (YAML or JSON)")
+	setupCommonCmd(CMD_WFU, "NAME")
+	cmdMap[CMD_WFU].opts.StringVar(&opts[0], "src", "", "New definition file")
+	setupCommonCmd(CMD_WFD, "NAME")
+	setupCommonCmd(CMD_WFR, "NAME")
+	cmdMap[CMD_WFR].opts.StringVar(&opts[0], "input", "", "Input JSON")
+	setupCommonCmd(CMD_WFRL, "NAME")
+	setupCommonCmd(CMD_WFRI, "NAME", "RUNID")
+	setupCommonCmd(CMD_WFRD, "NAME", "RUNID")
+
 	setupCommonCmd(CMD_RL)
 	cmdMap[CMD_RL].opts.StringVar(&opts[0], "acc", "", "Account ID")
 	cmdMap[CMD_RL].opts.StringVar(&opts[1], "at", "", "Attach status")
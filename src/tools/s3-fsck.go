@@ -6,6 +6,7 @@
 package main
 
 import (
+	"crypto/md5"
 	"fmt"
 	"flag"
 	"time"
@@ -268,6 +269,38 @@ func checkObjects() error {
 
 var pchunks map[string]*s3mgo.ObjectPart
 
+func fetchChunk(id bson.ObjectId) (*s3mgo.DataChunk, error) {
+	var ch s3mgo.DataChunk
+
+	err := session.DB(DBName).C(DBColS3DataChunks).Find(bson.M{"_id": id}).One(&ch)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ch, nil
+}
+
+/* Only the data kept in mongo is checked, other drivers' parts are skipped */
+func checkPartData(p *s3mgo.ObjectPart) string {
+	if p.Data == nil && (len(p.Chunks) == 0 || (p.Backend != "" && p.Backend != "mongo")) {
+		return "(data skipped)"
+	}
+
+	hasher := md5.New()
+	n, err := p.WriteRangeTo(hasher, 0, p.Size, fetchChunk)
+	if err != nil {
+		return "!!! data: " + err.Error()
+	}
+	if n != p.Size {
+		return fmt.Sprintf("!!! data: size %d want %d", n, p.Size)
+	}
+	if csum := fmt.Sprintf("%x", hasher.Sum(nil)); p.ETag != "" && csum != p.ETag {
+		return fmt.Sprintf("!!! data: etag %s want %s", csum, p.ETag)
+	}
+
+	return ""
+}
+
 func checkParts() error {
 	var pts []*s3mgo.ObjectPart
 
@@ -300,7 +333,7 @@ func checkParts() error {
 		}
 
 
-		st := ""
+		st := checkPartData(p)
 
 		for _, ci := range(p.Chunks) {
 			pchunks[ci.Hex()] = p
@@ -21,6 +21,7 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 )
@@ -189,3 +190,30 @@ func S3BucketProt(bname, prot string) (*s3.S3, error) {
 
 	return s3.New(ses), nil
 }
+
+/*
+ * Makes the URL that lets anyone GET, PUT or HEAD the object in
+ * the attached bucket without the keys, e.g. for uploads straight
+ * from the browser or mobile app
+ */
+func S3PresignURL(bname, object, method string, expires time.Duration) (string, error) {
+	var req *request.Request
+
+	svc, err := S3Bucket(bname)
+	if err != nil {
+		return "", err
+	}
+
+	switch method {
+	case "GET":
+		req, _ = svc.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(bname), Key: aws.String(object)})
+	case "PUT":
+		req, _ = svc.PutObjectRequest(&s3.PutObjectInput{Bucket: aws.String(bname), Key: aws.String(object)})
+	case "HEAD":
+		req, _ = svc.HeadObjectRequest(&s3.HeadObjectInput{Bucket: aws.String(bname), Key: aws.String(object)})
+	default:
+		return "", errors.New("Bad method")
+	}
+
+	return req.Presign(expires)
+}
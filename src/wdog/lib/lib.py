@@ -6,6 +6,10 @@
 import pymysql.cursors
 from pymongo import MongoClient
 import os
+import hmac
+import hashlib
+import datetime
+from urllib.parse import quote, urlsplit
 
 def mwareName(mwn):
     return mwn.upper().replace(".", "")
@@ -51,3 +55,67 @@ def MongoDatabase(mwname):
         _swiftyMongoClients[mwname] = clnt
 
     return clnt[dbname]
+
+#
+# Delayed calls of fns from the same project. The resb is the
+# response dict the Main returns, delay is in seconds and at is
+# a datetime object.
+#
+def _delayCall(resb, dc):
+    then = resb.setdefault("then", {})
+    then.setdefault("delayed", []).append(dc)
+
+def CallAfter(resb, name, args, delay):
+    _delayCall(resb, { "name": name, "args": args, "delay": int(delay * 1000) })
+
+def CallAt(resb, name, args, at):
+    _delayCall(resb, { "name": name, "args": args, "at": at.astimezone().isoformat() })
+
+#
+# Presigned (AWS SigV4 query string) URL for the object in the
+# attached bucket, method is GET, PUT or HEAD, expires in seconds
+#
+def _hmac(key, msg):
+    return hmac.new(key, msg.encode('utf-8'), hashlib.sha256).digest()
+
+def S3PresignURL(bname, obj, method = "GET", expires = 3600):
+    if method not in ("GET", "PUT", "HEAD"):
+        raise Exception("Bad method")
+
+    bn = mwareName(bname)
+    addr = os.getenv('MWARE_S3' + bn + '_ADDR')
+    akey = os.getenv('MWARE_S3' + bn + '_KEY')
+    asec = os.getenv('MWARE_S3' + bn + '_SECRET')
+    if addr == None or akey == None or asec == None:
+        raise Exception("No bucket attached")
+
+    if not addr.startswith("http"):
+        addr = "https://" + addr
+    host = urlsplit(addr).netloc
+
+    now = datetime.datetime.utcnow()
+    ts = now.strftime('%Y%m%dT%H%M%SZ')
+    ds = now.strftime('%Y%m%d')
+    scope = '/'.join([ds, "internal", "s3", "aws4_request"])
+
+    path = '/' + bname + '/' + obj
+    q = {
+        'X-Amz-Algorithm': 'AWS4-HMAC-SHA256',
+        'X-Amz-Credential': akey + '/' + scope,
+        'X-Amz-Date': ts,
+        'X-Amz-Expires': str(int(expires)),
+        'X-Amz-SignedHeaders': 'host',
+    }
+    qs = '&'.join(quote(k, safe='') + '=' + quote(q[k], safe='') for k in sorted(q))
+
+    creq = '\n'.join([method, path, qs, 'host:' + host, '', 'host', 'UNSIGNED-PAYLOAD'])
+    sts = '\n'.join(['AWS4-HMAC-SHA256', ts, scope,
+            hashlib.sha256(creq.encode('utf-8')).hexdigest()])
+
+    k = _hmac(('AWS4' + asec).encode('utf-8'), ds)
+    k = _hmac(k, "internal")
+    k = _hmac(k, "s3")
+    k = _hmac(k, "aws4_request")
+    sig = hmac.new(k, sts.encode('utf-8'), hashlib.sha256).hexdigest()
+
+    return addr + quote(path) + '?' + qs + '&X-Amz-Signature=' + sig
@@ -17,6 +17,7 @@ import (
 	"sync"
 	"syscall"
 	"io/ioutil"
+	"encoding/json"
 	"os"
 
 	"swifty/common"
@@ -152,9 +153,38 @@ func handleTry(lang string, tmous int64, w http.ResponseWriter, r *http.Request)
 	glock.Unlock()
 }
 
+type streamer struct {
+	w	http.ResponseWriter
+	enc	*json.Encoder
+}
+
+func (s *streamer)chunk(out *RunnerRes) error {
+	if s.enc == nil {
+		s.w.Header().Set("Content-Type", swyapi.WdogStreamType)
+		s.w.WriteHeader(http.StatusOK)
+		s.enc = json.NewEncoder(s.w)
+	}
+
+	err := s.enc.Encode(&swyapi.WdogFunctionRunChunk{Data: out.Chunk, CType: out.CType})
+	if err != nil {
+		return err
+	}
+
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
 func handleRun(runner *Runner, w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	var result *swyapi.WdogFunctionRunResult
+	var rq struct {
+		Stream	bool	`json:"stream"`
+	}
+	var sink chunkSink
+	var st *streamer
 
 	code := http.StatusBadRequest
 	body, err := ioutil.ReadAll(r.Body)
@@ -162,10 +192,16 @@ func handleRun(runner *Runner, w http.ResponseWriter, r *http.Request) {
 		goto out
 	}
 
+	json.Unmarshal(body, &rq)
+	if rq.Stream {
+		st = &streamer{w: w}
+		sink = st.chunk
+	}
+
 	code = http.StatusInternalServerError
 	runner.lock.Lock()
 	if runner.ready {
-		result, err = doRun(runner, body)
+		result, err = doRun(runner, body, sink)
 		if err != nil || result.Code < 0 {
 			runner.restart(runner)
 		}
@@ -173,6 +209,16 @@ func handleRun(runner *Runner, w http.ResponseWriter, r *http.Request) {
 		err = errors.New("Runner not ready")
 	}
 	runner.lock.Unlock()
+
+	if st != nil && st.enc != nil {
+		/* Headers are sent already, the result goes last */
+		if err != nil {
+			result = &swyapi.WdogFunctionRunResult{Code: -code, Return: err.Error()}
+		}
+		st.enc.Encode(&swyapi.WdogFunctionRunChunk{Res: result})
+		return
+	}
+
 	if err != nil {
 		goto out
 	}
@@ -32,10 +32,25 @@ type RunnerRes struct {
 	Ret	string
 	/* List of actions to be taken after the funciton is called */
 	Then	json.RawMessage
+	/* Response (or chunk) content type, headers and binary body */
+	CType	string
+	Headers	map[string]string
+	Raw	[]byte
+	Cookies	[]*swyapi.FunctionCookie
+	/* Streamed piece of output, more messages follow */
+	More	bool
+	Chunk	string
 }
 
-func doRun(runner *Runner, body []byte) (*swyapi.WdogFunctionRunResult, error) {
+/*
+ * Chunks are fed into the sink as they arrive. With nil sink
+ * they are glued together and returned as the result.
+ */
+type chunkSink func(*RunnerRes) error
+
+func doRun(runner *Runner, body []byte, sink chunkSink) (*swyapi.WdogFunctionRunResult, error) {
 	var err error
+	var glued, gluedCT string
 
 	start := time.Now()
 	err = runner.q.SendBytes(body)
@@ -46,13 +61,38 @@ func doRun(runner *Runner, body []byte) (*swyapi.WdogFunctionRunResult, error) {
 	}
 
 	var out RunnerRes
-	err = runner.q.Recv(&out)
+	for {
+		out = RunnerRes{}
+		err = runner.q.Recv(&out)
+		if err != nil || !out.More {
+			break
+		}
+
+		if sink == nil {
+			if glued == "" {
+				gluedCT = out.CType
+			}
+			glued += out.Chunk
+			continue
+		}
+
+		err = sink(&out)
+		if err != nil {
+			/* Caller is gone, but the runner is to be drained */
+			log.Errorf("Can't stream chunk: %s", err.Error())
+			sink = func(*RunnerRes) error { return nil }
+		}
+	}
 
 	ret := &swyapi.WdogFunctionRunResult{
 		Stdout: readLines(runner.fin),
 		Stderr: readLines(runner.fine),
 		Time: uint(time.Since(start) / time.Microsecond),
 		Then: out.Then,
+		CType: out.CType,
+		Headers: out.Headers,
+		Raw: out.Raw,
+		Cookies: out.Cookies,
 	}
 
 	if err == nil {
@@ -62,6 +102,12 @@ func doRun(runner *Runner, body []byte) (*swyapi.WdogFunctionRunResult, error) {
 			ret.Code = -http.StatusInternalServerError
 		}
 		ret.Return = out.Ret
+		if glued != "" && (ret.Return == "" || ret.Return == "null") {
+			ret.Return = glued
+			if ret.CType == "" {
+				ret.CType = gluedCT
+			}
+		}
 	} else {
 		switch {
 		case err == io.EOF:
@@ -10,11 +10,34 @@ using System.Web.Script.Serialization;
 using XStream;
 
 public class Request {
+	public string Event;
 	public Dictionary<string, string> Args;
+	public string Content;
+	public string Body;
+	public string Method;
+	public string Path;
+	public Dictionary<string, string> Headers;
+	public Dictionary<string, List<string>> Query;
+	public Dictionary<string, string> Cookies;
+	public string Remote;
+	public string ReqId;
+}
+
+public class Cookie {
+	public string name;
+	public string value;
+	public string path;
+	public string domain;
+	public int max_age;
+	public bool secure;
+	public bool http_only;
 }
 
 public class Response {
 	public int status;
+	public string content;
+	public Dictionary<string, string> headers;
+	public List<Cookie> cookies;
 	// The "then" thing is here
 }
 
@@ -22,6 +45,9 @@ class RunnerRes {
 	public int res;
 	public string ret;
 	public int status;
+	public string ctype;
+	public Dictionary<string, string> headers;
+	public List<Cookie> cookies;
 }
 
 class FR
@@ -48,8 +74,12 @@ class FR
 				res.res = 0;
 				res.ret = serializer.Serialize(result.Item1);
 
-				if (result.Item2 != null)
+				if (result.Item2 != null) {
 					res.status = result.Item2.status;
+					res.ctype = result.Item2.content;
+					res.headers = result.Item2.headers;
+					res.cookies = result.Item2.cookies;
+				}
 			} catch {
 				res.res = 1;
 				res.ret = "Exception";
@@ -7,6 +7,8 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"time"
 	"encoding/json"
 	"xqueue"
 )
@@ -19,18 +21,50 @@ type Request struct {
 	Claims		map[string]interface{}	`json:"claims,omitempty"` // JWT
 	Method		string			`json:"method,omitempty"`
 	Path		string			`json:"path,omitempty"`
+	Raw		[]byte			`json:"raw,omitempty"`
+	Form		map[string]string	`json:"form,omitempty"`
+	Files		[]*File			`json:"files,omitempty"`
+	Headers		map[string]string	`json:"headers,omitempty"`
+	Query		map[string][]string	`json:"query,omitempty"`
+	Cookies		map[string]string	`json:"cookies,omitempty"`
+	Remote		string			`json:"remote,omitempty"`
+	ReqId		string			`json:"reqid,omitempty"`
 
 	B		*Body			`json:"-"`
+	q		*xqueue.Queue
 }
 
+type File struct {
+	Field		string			`json:"field"`
+	Name		string			`json:"name"`
+	ContentType	string			`json:"content,omitempty"`
+	Data		[]byte			`json:"data"`
+}
+
+type Cookie struct {
+	Name		string			`json:"name"`
+	Value		string			`json:"value"`
+	Path		string			`json:"path,omitempty"`
+	Domain		string			`json:"domain,omitempty"`
+	MaxAge		int			`json:"max_age,omitempty"`
+	Secure		bool			`json:"secure,omitempty"`
+	HttpOnly	bool			`json:"http_only,omitempty"`
+}
+
+/* Non-nil Body is sent as is instead of the JSON-ed result */
 type Response struct {
-	Status	int
-	Then	*Then
+	Status		int
+	Then		*Then
+	ContentType	string
+	Headers		map[string]string
+	Cookies		[]*Cookie
+	Body		[]byte
 }
 
 /* FIXME -- import from APIs */
 type Then struct {
 	Call		*ThenCall		`json:"call,omitempty"`
+	Delayed		[]*ThenDelayed		`json:"delayed,omitempty"`
 }
 
 type ThenCall struct {
@@ -38,12 +72,62 @@ type ThenCall struct {
 	Args		map[string]string	`json:"args"`
 }
 
+type ThenDelayed struct {
+	Name		string			`json:"name"`
+	Args		map[string]string	`json:"args,omitempty"`
+	At		string			`json:"at,omitempty"`
+	Delay		uint			`json:"delay,omitempty"`
+}
+
+/* Makes gate call fn (of the same project) after the given delay */
+func (r *Response)CallAfter(name string, args map[string]string, d time.Duration) {
+	r.delay(&ThenDelayed{Name: name, Args: args, Delay: uint(d / time.Millisecond)})
+}
+
+/* Makes gate call fn (of the same project) at the given time */
+func (r *Response)CallAt(name string, args map[string]string, t time.Time) {
+	r.delay(&ThenDelayed{Name: name, Args: args, At: t.Format(time.RFC3339)})
+}
+
+func (r *Response)delay(td *ThenDelayed) {
+	if r.Then == nil {
+		r.Then = &Then{}
+	}
+	r.Then.Delayed = append(r.Then.Delayed, td)
+}
+
 /* FIXME -- share with wdog/runner.go */
 type RunnerRes struct {
 	Res	int
 	Ret	string
 	Status	int
 	Then	*Then
+	CType	string			`json:",omitempty"`
+	Headers	map[string]string	`json:",omitempty"`
+	Raw	[]byte			`json:",omitempty"`
+	Cookies	[]*Cookie		`json:",omitempty"`
+	More	bool			`json:",omitempty"`
+	Chunk	string			`json:",omitempty"`
+}
+
+/*
+ * Sends a piece of response right away, the caller gets it before
+ * Main returns (if it can accept streams at all)
+ */
+func (req *Request)Stream(data, ctype string) error {
+	return req.q.Send(&RunnerRes{More: true, Chunk: data, CType: ctype})
+}
+
+func (req *Request)SSE(name, data string) error {
+	ev := ""
+	if name != "" {
+		ev += "event: " + name + "\n"
+	}
+	for _, l := range strings.Split(data, "\n") {
+		ev += "data: " + l + "\n"
+	}
+
+	return req.Stream(ev + "\n", "text/event-stream")
 }
 
 func use(resp *Response) {}
@@ -65,6 +149,8 @@ func main() {
 			return
 		}
 
+		req.q = q
+
 		if req.ContentType == "application/json" {
 			var b Body
 
@@ -88,6 +174,10 @@ func main() {
 		if resp != nil {
 			out.Status = resp.Status
 			out.Then = resp.Then
+			out.CType = resp.ContentType
+			out.Headers = resp.Headers
+			out.Raw = resp.Body
+			out.Cookies = resp.Cookies
 		}
 
 		err = q.Send(out)
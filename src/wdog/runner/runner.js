@@ -49,6 +49,9 @@ for (;;) {
 		res = { res: 0, ret: JSON.stringify(ret) }
 		if (resp != null) {
 			res.status = resp.status
+			res.ctype = resp.content
+			res.headers = resp.headers
+			res.cookies = resp.cookies
 		}
 	} catch (err) {
 		res = { res: 0, ret: "Exception" }
@@ -8,6 +8,7 @@ import sys
 import socket
 import json
 import importlib.util
+import base64
 import time
 import traceback
 
@@ -38,11 +39,31 @@ def readmsg(sk):
             return data
 
 def sendmsg(sk, msg):
+    # Receiver detects the last packet by its size being less
+    # than the chunk one, so the exact multiple gets a zero tail
+    if len(msg) % 1024 == 0:
+        msg += b'\0'
     while len(msg) > 0:
         s = msg[:1024]
         q.send(s)
         msg = msg[1024:]
 
+# Sends a piece of response right away, the caller gets it
+# before Main returns (if it can accept streams at all)
+def stream(data, ctype = None):
+    msg = { "more": True, "chunk": data }
+    if ctype != None:
+        msg["ctype"] = ctype
+    sendmsg(q, json.dumps(msg).encode('utf-8'))
+
+def sse(data, name = None):
+    ev = ""
+    if name != None:
+        ev += "event: %s\n" % name
+    for l in str(data).split("\n"):
+        ev += "data: %s\n" % l
+    stream(ev + "\n", "text/event-stream")
+
 
 while True:
     data = readmsg(q)
@@ -51,7 +72,13 @@ while True:
         rq = json.loads(data)
         if not "content" in rq:
             rq["content"] = "text/plain"
+        if "raw" in rq:
+            rq["raw"] = base64.b64decode(rq["raw"])
+        for f in rq.get("files", []):
+            f["data"] = base64.b64decode(f["data"])
         req = type('request', (object,), rq)
+        req.stream = staticmethod(stream)
+        req.sse = staticmethod(sse)
         try:
             if req.content == "application/json":
                 try:
@@ -73,6 +100,20 @@ while True:
                         res["then"] = resb["then"]
                 except:
                     pass
+                try:
+                    if "content" in resb:
+                        res["ctype"] = resb["content"]
+                    if "headers" in resb:
+                        res["headers"] = resb["headers"]
+                    if "cookies" in resb:
+                        res["cookies"] = resb["cookies"]
+                    if "body" in resb:
+                        b = resb["body"]
+                        if isinstance(b, str):
+                            b = b.encode('utf-8')
+                        res["raw"] = base64.b64encode(b).decode('ascii')
+                except:
+                    pass
         except:
             print("Exception running FN:")
             traceback.print_exc()
@@ -13,6 +13,9 @@ def CallMain(req)
 	ret = {:res => 0, :ret => JSON.generate(res)}
 	if resp != nil
 		ret["status"] = resp["status"].to_i
+		ret["ctype"] = resp["content"] if resp["content"]
+		ret["headers"] = resp["headers"] if resp["headers"]
+		ret["cookies"] = resp["cookies"] if resp["cookies"]
 	end
 	return JSON.generate(ret)
 end
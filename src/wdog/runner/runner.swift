@@ -18,16 +18,37 @@ struct Request: Codable {
 	var claims: [String:String]?
 	var request: String?
 	var path: String?
+	var headers: [String:String]?
+	var query: [String:[String]]?
+	var cookies: [String:String]?
+	var remote: String?
+	var reqid: String?
+}
+
+struct Cookie: Codable {
+	var name: String
+	var value: String
+	var path: String?
+	var domain: String?
+	var max_age: Int?
+	var secure: Bool?
+	var http_only: Bool?
 }
 
 struct Result: Codable {
 	var res: Int
 	var ret: String
 	var status: Int
+	var ctype: String?
+	var headers: [String:String]?
+	var cookies: [Cookie]?
 }
 
 struct Response {
 	var status: Int
+	var content: String? = nil
+	var headers: [String:String]? = nil
+	var cookies: [Cookie]? = nil
 	// The "then" thing is here
 }
 
@@ -45,7 +66,8 @@ func save(obj: Encodable, resp: Response?) -> Data {
 	}
 
 	let jstr = String(data: try! JSONEncoder().encode(EncWrap(o:obj)), encoding: .utf8)!
-	let result = Result(res: 0, ret: jstr, status: resp?.status ?? 0)
+	let result = Result(res: 0, ret: jstr, status: resp?.status ?? 0,
+			ctype: resp?.content, headers: resp?.headers, cookies: resp?.cookies)
 	return try! JSONEncoder().encode(result)
 }
 
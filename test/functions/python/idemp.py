@@ -0,0 +1,3 @@
+import uuid
+def Main(req):
+    return {"token": uuid.uuid4().hex}, None
@@ -0,0 +1,66 @@
+import json
+from s3lib import *
+
+#
+# Checks how bucket policies and ACLs grant access to a key that
+# can't reach the bucket by its own policy. The other key should be
+# of the same namespace, but limited to some other bucket.
+#
+
+parser = mkParser("S3 policy and ACL test")
+parser.add_argument('--other-access-key', dest = 'other_access_key', required = True,
+                    help = 'access key limited to another bucket')
+parser.add_argument('--other-secret-key', dest = 'other_secret_key', required = True,
+                    help = 'secret key limited to another bucket')
+args = parser.parse_args()
+
+s3 = mkClient(args)
+other = mkClient(args, args.other_access_key, args.other_secret_key)
+bname = args.bucket_name
+
+def policy(stmts):
+    s3.put_bucket_policy(Bucket = bname, Policy = json.dumps({
+        "Version": "2012-10-17",
+        "Statement": stmts,
+    }))
+
+def stmt(effect, action, res):
+    return {
+        "Effect": effect,
+        "Principal": { "AWS": "*" },
+        "Action": action,
+        "Resource": "arn:aws:s3:::%s/%s" % (bname, res),
+    }
+
+print("Creating bucket %s" % bname)
+s3.create_bucket(Bucket = bname)
+s3.put_object(Bucket = bname, Key = 'pub/a', Body = 'a')
+s3.put_object(Bucket = bname, Key = 'priv/b', Body = 'b')
+
+checkError("no grants, no access", 'AccessDenied',
+           other.get_object, Bucket = bname, Key = 'pub/a')
+
+policy([ stmt("Allow", "s3:GetObject", "pub/*") ])
+check("policy allows by prefix",
+      other.get_object(Bucket = bname, Key = 'pub/a')['Body'].read() == b'a')
+checkError("policy doesn't allow other prefix", 'AccessDenied',
+           other.get_object, Bucket = bname, Key = 'priv/b')
+checkError("policy doesn't allow other action", 'AccessDenied',
+           other.put_object, Bucket = bname, Key = 'pub/c', Body = 'c')
+
+s3.put_object_acl(Bucket = bname, Key = 'priv/b', ACL = 'public-read')
+check("object ACL allows read",
+      other.get_object(Bucket = bname, Key = 'priv/b')['Body'].read() == b'b')
+
+policy([ stmt("Allow", "s3:GetObject", "pub/*"), stmt("Deny", "s3:GetObject", "priv/*") ])
+checkError("explicit deny wins over ACL", 'AccessDenied',
+           other.get_object, Bucket = bname, Key = 'priv/b')
+
+s3.delete_bucket_policy(Bucket = bname)
+check("ACL works again without policy",
+      other.get_object(Bucket = bname, Key = 'priv/b')['Body'].read() == b'b')
+checkError("no policy, no grant", 'AccessDenied',
+           other.get_object, Bucket = bname, Key = 'pub/a')
+
+dropBucket(s3, bname)
+print("==================[ PASS ]=====================")
@@ -0,0 +1,87 @@
+import urllib.request
+import urllib.error
+from s3lib import *
+
+#
+# Checks bucket CORS rules matching. Preflights are sent to presigned
+# URLs, since s3 tells the bucket of a preflight only by the key in
+# the URL (see docs/swifty-lib.txt).
+#
+
+parser = mkParser("S3 CORS test")
+args = parser.parse_args()
+
+s3 = mkClient(args)
+bname = args.bucket_name
+
+rules = [
+    {
+        'AllowedOrigins': [ 'http://*.example.com' ],
+        'AllowedMethods': [ 'GET', 'HEAD' ],
+        'AllowedHeaders': [ 'x-amz-*' ],
+        'ExposeHeaders': [ 'ETag' ],
+        'MaxAgeSeconds': 600,
+    },
+    {
+        'AllowedOrigins': [ '*' ],
+        'AllowedMethods': [ 'PUT' ],
+    },
+]
+
+def request(url, method, hdrs):
+    rq = urllib.request.Request(url, method = method, headers = hdrs)
+    try:
+        with urllib.request.urlopen(rq) as resp:
+            return resp.status, resp.headers
+    except urllib.error.HTTPError as e:
+        return e.code, e.headers
+
+def preflight(origin, method, hdrs = None):
+    h = { 'Origin': origin, 'Access-Control-Request-Method': method }
+    if hdrs:
+        h['Access-Control-Request-Headers'] = hdrs
+    return request(url, 'OPTIONS', h)
+
+print("Creating bucket %s" % bname)
+s3.create_bucket(Bucket = bname)
+s3.put_object(Bucket = bname, Key = 'obj', Body = 'x')
+url = s3.generate_presigned_url('get_object', Params = { 'Bucket': bname, 'Key': 'obj' }, ExpiresIn = 600)
+
+s3.put_bucket_cors(Bucket = bname, CORSConfiguration = { 'CORSRules': rules })
+got = s3.get_bucket_cors(Bucket = bname)['CORSRules']
+check("rules read back", [ r['AllowedOrigins'] for r in got ] == [ r['AllowedOrigins'] for r in rules ])
+
+st, h = preflight('http://app.example.com', 'GET', 'X-Amz-Date')
+check("preflight by origin wildcard", st == 200 and
+      h.get('Access-Control-Allow-Origin') == 'http://app.example.com' and
+      h.get('Access-Control-Allow-Credentials') == 'true' and
+      h.get('Access-Control-Max-Age') == '600' and
+      h.get('Access-Control-Allow-Headers') == 'x-amz-date')
+
+st, h = preflight('http://example.org', 'GET')
+check("preflight from alien origin", st == 403 and not h.get('Access-Control-Allow-Origin'))
+
+st, h = preflight('http://app.example.com', 'GET', 'x-other')
+check("preflight with alien header", st == 403)
+
+st, h = preflight('http://example.org', 'PUT')
+check("preflight by any origin", st == 200 and h.get('Access-Control-Allow-Origin') == '*' and
+      not h.get('Access-Control-Allow-Credentials'))
+
+st, h = preflight('http://app.example.com', 'DELETE')
+check("preflight with alien method", st == 403)
+
+st, h = request(url, 'GET', { 'Origin': 'http://app.example.com' })
+check("request gets the headers", st == 200 and
+      h.get('Access-Control-Allow-Origin') == 'http://app.example.com' and
+      h.get('Access-Control-Expose-Headers') == 'ETag')
+
+st, h = request(url, 'GET', { 'Origin': 'http://example.org' })
+check("alien request goes without headers", st == 200 and not h.get('Access-Control-Allow-Origin'))
+
+s3.delete_bucket_cors(Bucket = bname)
+checkError("rules are removed", 'NoSuchCORSConfiguration',
+           s3.get_bucket_cors, Bucket = bname)
+
+dropBucket(s3, bname)
+print("==================[ PASS ]=====================")
@@ -0,0 +1,90 @@
+import urllib.request
+import urllib.error
+import urllib.parse
+import json
+import time
+from s3lib import *
+
+#
+# Checks that presigned URLs work for keys that need escaping, that
+# tampered and expired ones are refused. With the admin address the
+# URLs minted by s3 itself are checked as well.
+#
+
+parser = mkParser("S3 presigned URLs test")
+parser.add_argument('--admin-url', dest = 'admin_url',
+                    help = 'S3 admin address (opt)')
+parser.add_argument('--admin-token', dest = 'admin_token',
+                    help = 'S3 admin token (opt)')
+parser.add_argument('--namespace', dest = 'namespace',
+                    help = 'namespace the keys belong to (opt)')
+args = parser.parse_args()
+
+s3 = mkClient(args)
+bname = args.bucket_name
+
+def fetch(url, method = 'GET', data = None):
+    rq = urllib.request.Request(url, method = method, data = data)
+    try:
+        with urllib.request.urlopen(rq) as resp:
+            return resp.status, resp.read()
+    except urllib.error.HTTPError as e:
+        return e.code, e.read()
+
+def presign(method, key, expires = 60):
+    return s3.generate_presigned_url(method, Params = { 'Bucket': bname, 'Key': key }, ExpiresIn = expires)
+
+def s3presign(method, key, expires = 60):
+    rq = urllib.request.Request(args.admin_url.rstrip('/') + '/v1/api/presign', method = 'POST',
+            data = json.dumps({
+                'namespace': args.namespace,
+                'bucket': bname,
+                'object': key,
+                'method': method,
+                'expires': expires,
+                'endpoint': args.endpoint_url,
+            }).encode('utf-8'),
+            headers = { 'X-SwyS3-Token': args.admin_token, 'Content-Type': 'application/json' })
+    with urllib.request.urlopen(rq) as resp:
+        return json.loads(resp.read())['url']
+
+def credential(url):
+    q = urllib.parse.parse_qs(urllib.parse.urlparse(url).query)
+    return q['X-Amz-Credential'][0].split('/')[0]
+
+keys = [ 'plain', 'dir/with space', 'dir/q?mark', 'dir/hash#tag', 'dir/per%cent', 'dir/plus+sign', 'dir/utf-ключ' ]
+
+print("Creating bucket %s" % bname)
+s3.create_bucket(Bucket = bname)
+
+for k in keys:
+    body = genRandomData(32).encode('utf-8')
+    st, _ = fetch(presign('put_object', k), method = 'PUT', data = body)
+    check("presigned PUT of [%s]" % k, st == 200)
+    check("object [%s] is there" % k,
+          s3.get_object(Bucket = bname, Key = k)['Body'].read() == body)
+    st, data = fetch(presign('get_object', k))
+    check("presigned GET of [%s]" % k, st == 200 and data == body)
+
+url = presign('get_object', 'plain')
+st, _ = fetch(url.replace('plain', 'other', 1))
+check("URL for other key is refused", st == 403)
+st, _ = fetch(url.replace('X-Amz-Signature=', 'X-Amz-Signature=0', 1))
+check("tampered signature is refused", st == 403)
+
+url = presign('get_object', 'plain', expires = 1)
+time.sleep(2)
+st, _ = fetch(url)
+check("expired URL is refused", st == 403)
+
+if args.admin_url:
+    for k in keys:
+        st, data = fetch(s3presign('GET', k))
+        check("s3-minted GET of [%s]" % k, st == 200 and
+              data == s3.get_object(Bucket = bname, Key = k)['Body'].read())
+
+    check("s3-minted URLs share the key",
+          credential(s3presign('GET', 'plain', 60)) == credential(s3presign('HEAD', 'plain', 120)))
+
+dropBucket(s3, bname)
+print("==================[ PASS ]=====================")
@@ -0,0 +1,69 @@
+from s3lib import *
+
+#
+# Checks the versions listing: prefix (with regex special chars in),
+# delimiter, delete markers, latest flags and paging by markers.
+#
+
+parser = mkParser("S3 versions test")
+args = parser.parse_args()
+
+s3 = mkClient(args)
+bname = args.bucket_name
+
+def vlist(**kwargs):
+    ret = s3.list_object_versions(Bucket = bname, **kwargs)
+    return ret.get('Versions', []), ret.get('DeleteMarkers', []), ret
+
+print("Creating versioned bucket %s" % bname)
+s3.create_bucket(Bucket = bname)
+s3.put_bucket_versioning(Bucket = bname, VersioningConfiguration = { 'Status': 'Enabled' })
+
+bodies = [ genRandomData(16).encode('utf-8') for _ in range(3) ]
+vids = []
+for b in bodies:
+    vids.append(s3.put_object(Bucket = bname, Key = 'a.b', Body = b)['VersionId'])
+check("versions have different ids", len(set(vids)) == len(vids))
+
+s3.put_object(Bucket = bname, Key = 'axb', Body = 'x')
+s3.put_object(Bucket = bname, Key = 'dir/x', Body = 'x')
+s3.put_object(Bucket = bname, Key = 'dir/y', Body = 'y')
+
+dm = s3.delete_object(Bucket = bname, Key = 'a.b')
+check("delete puts a marker", dm.get('DeleteMarker') == True and dm.get('VersionId'))
+
+vers, dms, _ = vlist(Prefix = 'a.b')
+check("prefix is not a regex", all(v['Key'] == 'a.b' for v in vers + dms))
+check("all versions listed", sorted(v['VersionId'] for v in vers) == sorted(vids))
+check("marker is listed", [ d['VersionId'] for d in dms ] == [ dm['VersionId'] ])
+check("marker is the latest", dms[0]['IsLatest'] and not any(v['IsLatest'] for v in vers))
+check("versions go newest first", [ v['VersionId'] for v in vers ] == vids[::-1])
+
+checkError("deleted object is not found", 'NoSuchKey',
+           s3.get_object, Bucket = bname, Key = 'a.b')
+check("old version is readable",
+      s3.get_object(Bucket = bname, Key = 'a.b', VersionId = vids[0])['Body'].read() == bodies[0])
+
+vers, dms, ret = vlist(Delimiter = '/')
+check("delimiter folds keys", [ p['Prefix'] for p in ret.get('CommonPrefixes', []) ] == [ 'dir/' ])
+check("folded keys are not listed", not any(v['Key'].startswith('dir/') for v in vers + dms))
+
+vers, dms, _ = vlist()
+full = sorted((v['Key'], v['VersionId']) for v in vers + dms)
+paged = []
+kw = { 'MaxKeys': 2 }
+while True:
+    vers, dms, ret = vlist(**kw)
+    paged += [ (v['Key'], v['VersionId']) for v in vers + dms ]
+    if not ret.get('IsTruncated'):
+        break
+    kw['KeyMarker'] = ret['NextKeyMarker']
+    kw['VersionIdMarker'] = ret['NextVersionIdMarker']
+check("paging lists everything once", sorted(paged) == full)
+
+s3.delete_object(Bucket = bname, Key = 'a.b', VersionId = dm['VersionId'])
+check("removing the marker brings the object back",
+      s3.get_object(Bucket = bname, Key = 'a.b')['Body'].read() == bodies[-1])
+
+dropBucket(s3, bname)
+print("==================[ PASS ]=====================")
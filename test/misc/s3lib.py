@@ -0,0 +1,76 @@
+import argparse
+import botocore
+import boto3
+import random
+import string
+import sys
+
+def genRandomData(len):
+    return ''.join(random.SystemRandom().choice(string.ascii_uppercase + string.digits) for _ in range(len))
+
+def genBucketName():
+    return genRandomData(6).lower()
+
+def genObjectName():
+    return genRandomData(10)
+
+def mkParser(desc):
+    parser = argparse.ArgumentParser(desc)
+    parser.add_argument('--access-key', dest = 'access_key',
+                        default = '6DLA43X797XL2I42IJ33',
+                        help = 'access key')
+    parser.add_argument('--secret-key', dest = 'secret_key',
+                        default = 'AJwz9vZpdnz6T5TqEDQOEFos6wxxCnW0qwLQeDcB',
+                        help = 'secret key')
+    parser.add_argument('--endpoint-url', dest = 'endpoint_url',
+                        default = 'http://192.168.122.197:8787/',
+                        help = 'S3 service address')
+    parser.add_argument('--bucket-name', dest = 'bucket_name',
+                        default = genBucketName(),
+                        help = 'bucket name to use')
+    return parser
+
+def mkClient(args, access_key = None, secret_key = None):
+    access_key = access_key or args.access_key
+    secret_key = secret_key or args.secret_key
+
+    print("Connecting to endpoint %s with keys %s / %s" %
+          (args.endpoint_url, access_key, secret_key))
+
+    return boto3.session.Session().client(service_name = 's3',
+                                          aws_access_key_id = access_key,
+                                          aws_secret_access_key = secret_key,
+                                          endpoint_url = args.endpoint_url,
+                                          config = botocore.config.Config(s3 = {'addressing_style': 'path'}))
+
+def check(what, ok):
+    if ok:
+        print("PASS: %s" % what)
+    else:
+        print("FAIL: %s" % what)
+        sys.exit(1)
+
+def checkError(what, code, fn, *args, **kwargs):
+    try:
+        fn(*args, **kwargs)
+    except botocore.exceptions.ClientError as e:
+        err = e.response['Error']['Code']
+        if err != code:
+            print("\twant %s have %s" % (code, err))
+        check(what, err == code)
+        return
+
+    print("\twant %s have success" % code)
+    check(what, False)
+
+def dropBucket(s3, bname):
+    if s3.get_bucket_versioning(Bucket = bname).get('Status'):
+        vers = s3.list_object_versions(Bucket = bname)
+        for v in vers.get('Versions', []) + vers.get('DeleteMarkers', []):
+            s3.delete_object(Bucket = bname, Key = v['Key'], VersionId = v['VersionId'])
+    else:
+        objs = s3.list_objects(Bucket = bname)
+        for o in objs.get('Contents', []):
+            s3.delete_object(Bucket = bname, Key = o['Key'])
+
+    s3.delete_bucket(Bucket = bname)
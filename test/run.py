@@ -7,6 +7,7 @@ import time
 import random
 import string
 import argparse
+import threading
 
 def randstr():
 	return ''.join(random.choice(string.ascii_letters) for _ in range(0,8))
@@ -29,7 +30,7 @@ def list_fn():
 	fns = swyrun2([ 'ls' ])
 	return [ i.split()[0].strip() for i in fns[1:] ]
 
-def add_fn(name, lang, mw = [], evt = "url", tmo = None):
+def add_fn(name, lang, mw = [], evt = "url", tmo = None, conc = None):
 	cmd = [ "add", name, "-lang", lang,
 		"-src", "test/functions/" + lang + "/" + name + lext[lang],
 		"-event", evt ]
@@ -37,6 +38,8 @@ def add_fn(name, lang, mw = [], evt = "url", tmo = None):
 		cmd += [ "-mw", ",".join(mw) ]
 	if tmo:
 		cmd += [ "-tmo", "%d" % tmo ]
+	if conc:
+		cmd += [ "-conc", "%d" % conc ]
 	swyrun(cmd)
 
 	return _wait_fn(name, "0")
@@ -81,6 +84,13 @@ def run_fn(inf, args):
 	rv = resp.read()
 	return json.loads(rv)
 
+def call_fn(inf, args, hdrs = {}):
+	url = inf['URL'].split('/', 3)
+	conn = http.client.HTTPConnection(url[2])
+	conn.request('POST', '/' + url[3] + '?' + '&'.join([x[0]+'='+x[1] for x in args.items()]), headers = hdrs)
+	resp = conn.getresponse()
+	return resp.status, resp.getheader('Idempotent-Replayed'), resp.read()
+
 def del_fn(inf):
 	swyrun([ "logs", inf['name'] ])
 	swyrun([ "del", inf['name'] ])
@@ -273,6 +283,48 @@ def timeout(lang, opts):
 		del_fn(inf)
 	return ok
 
+def concurrency(lang, opts):
+	ok = False
+	rets = [ None, None ]
+	inf = add_fn("timeout", lang, tmo = 4000, conc = 1)
+
+	def call(i):
+		rets[i] = run_fn(inf, { 'tmo': '1500' })
+
+	start = time.time()
+	thrs = [ threading.Thread(target = call, args = (i,)) for i in range(len(rets)) ]
+	for t in thrs:
+		t.start()
+	for t in thrs:
+		t.join()
+	took = time.time() - start
+	print(rets, took)
+
+	# With one call at a time the second one waits for the first
+	if rets == [ 'slept:1500', 'slept:1500' ] and took >= 3.0:
+		ok = True
+	if not opts['keep']:
+		del_fn(inf)
+	return ok
+
+def idempotency(lang, opts):
+	ok = False
+	key = randstr()
+	inf = add_fn("idemp", lang)
+	ret1 = call_fn(inf, {}, { 'Idempotency-Key': key })
+	ret2 = call_fn(inf, {}, { 'Idempotency-Key': key })
+	ret3 = call_fn(inf, {}, { 'Idempotency-Key': randstr() })
+	ret4 = call_fn(inf, { 'x': 'y' }, { 'Idempotency-Key': key })
+	print(ret1, ret2, ret3, ret4)
+	if ret1[0] == 200 and ret1[1] is None:
+		if ret2[0] == 200 and ret2[1] == 'true' and ret2[2] == ret1[2]:
+			if ret3[0] == 200 and ret3[1] is None and ret3[2] != ret1[2]:
+				if ret4[0] == 422: # Same key, other request
+					ok = True
+	if not opts['keep']:
+		del_fn(inf)
+	return ok
+
 def checkempty(lang, opts):
 	fns = list_fn()
 	print(fns)
@@ -287,6 +339,8 @@ tests = [
 	(s3,		["python"]),
 	(s3notify,	["python"]),
 	(timeout,	["python", "golang"]),
+	(concurrency,	["python"]),
+	(idempotency,	["python"]),
 	(checkempty,	[""]),
 ]
 